@@ -0,0 +1,415 @@
+package hub
+
+import (
+	"cmp"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gomlx/go-huggingface/internal/files"
+	"github.com/pkg/errors"
+)
+
+// RepoUsage reports the disk usage of one repository in the cache. See CacheUsage.
+type RepoUsage struct {
+	Type RepoType
+	ID   string
+
+	// Dir is the cache directory of the repository.
+	Dir string
+
+	// SizeBytes is the total size of the repository blobs: blobs shared by several revisions are counted once.
+	SizeBytes int64
+
+	// LastModified is the most recent modification time of the repository files, used as an approximation of
+	// its last use (access times are not reliably available).
+	LastModified time.Time
+
+	// Revisions cached for the repository, sorted from the most recently modified.
+	Revisions []*RevisionUsage
+}
+
+// RevisionUsage reports the disk usage of one revision (snapshot) of a repository in the cache.
+type RevisionUsage struct {
+	CommitHash string
+
+	// Refs pointing to this revision, e.g. "main".
+	Refs []string
+
+	// NumFiles in the snapshot.
+	NumFiles int
+
+	// SizeBytes is the total size of the files of the snapshot, including blobs shared with other revisions.
+	SizeBytes int64
+
+	// LastModified is the most recent modification time of the snapshot directories (updated when files are
+	// added) and files.
+	LastModified time.Time
+
+	// blobs used by this revision, by their path.
+	blobs []string
+}
+
+// CacheUsage reports the disk usage of each repository in cacheDir, sorted from the most recently modified.
+// If cacheDir is empty, DefaultCacheDir is used.
+//
+// Only directories following the shared HuggingFace cache structure (e.g. "models--google--gemma-2-2b-it") are
+// reported.
+func CacheUsage(cacheDir string) ([]*RepoUsage, error) {
+	if cacheDir == "" {
+		cacheDir = DefaultCacheDir()
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to read cache directory %q", cacheDir)
+	}
+	var usages []*RepoUsage
+	for _, entry := range entries {
+		repoType, id, ok := parseFlatFolderName(entry.Name())
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		usage, err := repoCacheUsage(filepath.Join(cacheDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		usage.Type, usage.ID = repoType, id
+		usages = append(usages, usage)
+	}
+	slices.SortFunc(usages, func(a, b *RepoUsage) int {
+		return cmp.Or(b.LastModified.Compare(a.LastModified), cmp.Compare(a.Dir, b.Dir))
+	})
+	return usages, nil
+}
+
+// parseFlatFolderName is the inverse of Repo.flatFolderName.
+func parseFlatFolderName(name string) (repoType RepoType, id string, ok bool) {
+	parts := strings.Split(name, RepoIdSeparator)
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	repoType = RepoType(parts[0])
+	switch repoType {
+	case RepoTypeModel, RepoTypeDataset, RepoTypeSpace:
+	default:
+		return "", "", false
+	}
+	return repoType, strings.Join(parts[1:], "/"), true
+}
+
+// repoCacheUsage collects the usage of the repository cached in dir.
+func repoCacheUsage(dir string) (*RepoUsage, error) {
+	usage := &RepoUsage{Dir: dir}
+
+	// Blobs.
+	blobSizes := make(map[string]int64)
+	blobsDir, err := filepath.Abs(filepath.Join(dir, "blobs"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve blobs directory of %q", dir)
+	}
+	if resolved, err := filepath.EvalSymlinks(blobsDir); err == nil {
+		blobsDir = resolved // So it matches the resolved links of the snapshots.
+	}
+	blobEntries, err := os.ReadDir(blobsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrapf(err, "failed to read blobs directory %q", blobsDir)
+	}
+	for _, entry := range blobEntries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		blobPath := filepath.Join(blobsDir, entry.Name())
+		blobSizes[blobPath] = info.Size()
+		usage.SizeBytes += info.Size()
+		if info.ModTime().After(usage.LastModified) {
+			usage.LastModified = info.ModTime()
+		}
+	}
+
+	// Refs: they may be nested, e.g. "refs/pr/1".
+	refsByCommit := make(map[string][]string)
+	refsDir := filepath.Join(dir, "refs")
+	_ = filepath.WalkDir(refsDir, func(refPath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		contents, err := os.ReadFile(refPath)
+		if err != nil {
+			return nil
+		}
+		ref, _ := filepath.Rel(refsDir, refPath)
+		commit := strings.TrimSpace(string(contents))
+		refsByCommit[commit] = append(refsByCommit[commit], filepath.ToSlash(ref))
+		return nil
+	})
+
+	// Snapshots.
+	snapshotsDir := filepath.Join(dir, "snapshots")
+	snapshotEntries, err := os.ReadDir(snapshotsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrapf(err, "failed to read snapshots directory %q", snapshotsDir)
+	}
+	for _, entry := range snapshotEntries {
+		if !entry.IsDir() {
+			continue
+		}
+		revision := &RevisionUsage{CommitHash: entry.Name(), Refs: refsByCommit[entry.Name()]}
+		slices.Sort(revision.Refs)
+		err := filepath.WalkDir(filepath.Join(snapshotsDir, entry.Name()), func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			linkInfo, err := os.Lstat(filePath)
+			if err != nil {
+				return err
+			}
+			if linkInfo.Mode()&os.ModeSymlink == 0 && linkInfo.ModTime().After(revision.LastModified) {
+				// The modification time of the directories is updated when files are added, so it reflects when
+				// the revision was last downloaded to.
+				revision.LastModified = linkInfo.ModTime()
+			}
+			if d.IsDir() {
+				return nil
+			}
+			revision.NumFiles++
+			if linkInfo.Mode()&os.ModeSymlink == 0 {
+				// Plain file stored directly in the snapshot.
+				revision.SizeBytes += linkInfo.Size()
+				usage.SizeBytes += linkInfo.Size()
+				return nil
+			}
+			blobPath, err := filepath.EvalSymlinks(filePath)
+			if err != nil {
+				// Dangling link: the blob is missing.
+				return nil
+			}
+			blobPath, _ = filepath.Abs(blobPath)
+			if size, found := blobSizes[blobPath]; found {
+				revision.SizeBytes += size
+				revision.blobs = append(revision.blobs, blobPath)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to scan snapshot %q", entry.Name())
+		}
+		if revision.LastModified.After(usage.LastModified) {
+			usage.LastModified = revision.LastModified
+		}
+		usage.Revisions = append(usage.Revisions, revision)
+	}
+	slices.SortFunc(usage.Revisions, func(a, b *RevisionUsage) int {
+		return cmp.Or(b.LastModified.Compare(a.LastModified), cmp.Compare(a.CommitHash, b.CommitHash))
+	})
+	return usage, nil
+}
+
+// CacheGCPolicy configures which cached revisions CacheGC deletes. The zero value deletes nothing.
+type CacheGCPolicy struct {
+	// MaxAge, if > 0, deletes revisions not modified for longer than MaxAge.
+	MaxAge time.Duration
+
+	// MaxSizeBytes, if > 0, deletes the least recently modified revisions until the total cache size is
+	// at most MaxSizeBytes.
+	MaxSizeBytes int64
+
+	// Keep lists repository IDs (e.g. "google/gemma-2-2b-it") that are never deleted.
+	Keep []string
+
+	// DryRun reports what would be deleted, without deleting anything.
+	DryRun bool
+}
+
+// CacheGCReport lists what CacheGC deleted (or would delete, in a dry run).
+type CacheGCReport struct {
+	// Deleted revisions, in the format "<repo id>@<commit hash>".
+	Deleted []string
+
+	// FreedBytes is the size of the blobs and files deleted.
+	FreedBytes int64
+}
+
+// CacheGC deletes cached revisions of repositories in cacheDir, from the least recently modified (an approximation
+// of least recently used), according to policy. If cacheDir is empty, DefaultCacheDir is used.
+//
+// Deleting a revision removes its snapshot, the refs pointing to it and the blobs no other revision of the
+// repository uses. Repositories without any revision left are removed entirely.
+//
+// Repositories with a download in progress (a locked ".lock" file) are skipped, but it should still not be run
+// concurrently with programs starting downloads to the same cache.
+func CacheGC(cacheDir string, policy CacheGCPolicy) (*CacheGCReport, error) {
+	usages, err := CacheUsage(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		repo     *RepoUsage
+		revision *RevisionUsage
+	}
+	var candidates []candidate
+	var totalSize int64
+	for _, usage := range usages {
+		totalSize += usage.SizeBytes
+		if slices.Contains(policy.Keep, usage.ID) || downloadInProgress(usage.Dir) {
+			continue
+		}
+		for _, revision := range usage.Revisions {
+			candidates = append(candidates, candidate{usage, revision})
+		}
+	}
+	// Least recently modified first.
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return a.revision.LastModified.Compare(b.revision.LastModified)
+	})
+
+	report := &CacheGCReport{}
+	now := time.Now()
+	for _, c := range candidates {
+		expired := policy.MaxAge > 0 && now.Sub(c.revision.LastModified) > policy.MaxAge
+		oversized := policy.MaxSizeBytes > 0 && totalSize > policy.MaxSizeBytes
+		if !expired && !oversized {
+			continue
+		}
+		freed, err := deleteCachedRevision(c.repo, c.revision, policy.DryRun)
+		if err != nil {
+			return report, err
+		}
+		report.Deleted = append(report.Deleted, c.repo.ID+"@"+c.revision.CommitHash)
+		report.FreedBytes += freed
+		totalSize -= freed
+	}
+	return report, nil
+}
+
+// deleteCachedRevision removes the revision from the repository cache (and from repo.Revisions), and returns
+// the number of bytes freed.
+func deleteCachedRevision(repo *RepoUsage, revision *RevisionUsage, dryRun bool) (int64, error) {
+	repo.Revisions = slices.DeleteFunc(repo.Revisions, func(r *RevisionUsage) bool { return r == revision })
+
+	// Blobs still used by other revisions.
+	inUse := make(map[string]bool)
+	for _, other := range repo.Revisions {
+		for _, blob := range other.blobs {
+			inUse[blob] = true
+		}
+	}
+	var freed int64
+	var toRemove []string
+	for _, blob := range revision.blobs {
+		if inUse[blob] {
+			continue
+		}
+		inUse[blob] = true // Don't count it twice.
+		if info, err := os.Stat(blob); err == nil {
+			freed += info.Size()
+		}
+		toRemove = append(toRemove, blob)
+	}
+	snapshotDir := filepath.Join(repo.Dir, "snapshots", revision.CommitHash)
+	_ = filepath.WalkDir(snapshotDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				freed += info.Size()
+			}
+		}
+		return nil
+	})
+	if len(repo.Revisions) == 0 {
+		// Nothing left, the whole repository is removed, including unreferenced blobs.
+		freed = repo.SizeBytes
+	}
+	repo.SizeBytes -= freed
+	if dryRun {
+		return freed, nil
+	}
+
+	if len(repo.Revisions) == 0 {
+		if err := os.RemoveAll(repo.Dir); err != nil {
+			return 0, errors.Wrapf(err, "failed to remove cached repository %q", repo.Dir)
+		}
+		return freed, nil
+	}
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return 0, errors.Wrapf(err, "failed to remove snapshot %q", snapshotDir)
+	}
+	for _, ref := range revision.Refs {
+		refPath := filepath.Join(repo.Dir, "refs", filepath.FromSlash(ref))
+		if err := os.Remove(refPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, errors.Wrapf(err, "failed to remove ref %q", refPath)
+		}
+	}
+	for _, blob := range toRemove {
+		if err := os.Remove(blob); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, errors.Wrapf(err, "failed to remove blob %q", blob)
+		}
+	}
+	return freed, nil
+}
+
+// downloadInProgress returns whether any file of the repository cached in dir is being downloaded, that is,
+// whether it has a locked ".lock" file (see downloader.LockedDownload).
+func downloadInProgress(dir string) bool {
+	var inProgress bool
+	_ = filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(filePath, ".lock") {
+			return nil
+		}
+		if files.IsLocked(filePath) {
+			inProgress = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return inProgress
+}
+
+// PruneCache deletes the cached revisions of the repositories in the default cache directory (see
+// DefaultCacheDir) not modified within olderThan, and returns the number of bytes freed.
+//
+// The modification time is used as an approximation of the last use, since access times are not reliably
+// available. Repositories with a download in progress are skipped. See CacheGC for more options.
+func PruneCache(olderThan time.Duration) (freedBytes int64, err error) {
+	if olderThan <= 0 {
+		return 0, errors.Errorf("PruneCache requires a positive olderThan duration, got %s", olderThan)
+	}
+	report, err := CacheGC("", CacheGCPolicy{MaxAge: olderThan})
+	if report != nil {
+		freedBytes = report.FreedBytes
+	}
+	return freedBytes, err
+}
+
+// ClearCache removes all the cached files of the repository (all revisions) from the cache directory.
+// The repository info is downloaded again when needed. If Verbosity > 0, it logs the number of bytes freed.
+//
+// It returns an error if a download of the repository is in progress.
+func (r *Repo) ClearCache() error {
+	dir := filepath.Join(r.cacheDir, r.flatFolderName())
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if downloadInProgress(dir) {
+		return errors.Errorf("can't clear the cache of repository %q: download in progress", r.ID)
+	}
+	usage, err := repoCacheUsage(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "failed to remove cached repository %q", dir)
+	}
+	r.info = nil
+	r.revisionHashRefreshed = false
+	if r.Verbosity > 0 {
+		log.Printf("Cleared cache of %q: %d bytes freed", r.ID, usage.SizeBytes)
+	}
+	return nil
+}
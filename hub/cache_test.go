@@ -0,0 +1,135 @@
+package hub
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCachedRevision creates a revision of a repository in the cache structure, with the given files
+// (name -> contents), with its directories modification time set to modTime.
+func writeCachedRevision(t *testing.T, repoDir, commit, ref string, files map[string]string, modTime time.Time) {
+	for name, contents := range files {
+		blobPath := filepath.Join(repoDir, "blobs", "etag-"+contents)
+		require.NoError(t, os.MkdirAll(filepath.Dir(blobPath), 0o755))
+		require.NoError(t, os.WriteFile(blobPath, []byte(contents), 0o644))
+		require.NoError(t, os.Chtimes(blobPath, modTime, modTime))
+		snapshotPath := filepath.Join(repoDir, "snapshots", commit, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(snapshotPath), 0o755))
+		require.NoError(t, createSymLink(snapshotPath, blobPath))
+	}
+	snapshotDir := filepath.Join(repoDir, "snapshots", commit)
+	require.NoError(t, filepath.WalkDir(snapshotDir, func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			err = os.Chtimes(p, modTime, modTime)
+		}
+		return err
+	}))
+	if ref != "" {
+		refPath := filepath.Join(repoDir, "refs", ref)
+		require.NoError(t, os.MkdirAll(filepath.Dir(refPath), 0o755))
+		require.NoError(t, os.WriteFile(refPath, []byte(commit), 0o644))
+	}
+}
+
+func TestCacheUsageAndGC(t *testing.T) {
+	cacheDir := t.TempDir()
+	now := time.Now()
+	modelDir := filepath.Join(cacheDir, "models--org--model")
+	writeCachedRevision(t, modelDir, "old", "", map[string]string{"config.json": "shared", "model.bin": "0123456789"}, now.Add(-48*time.Hour))
+	writeCachedRevision(t, modelDir, "new", "main", map[string]string{"config.json": "shared", "model.bin": "abcdefghijklmnopqrst"}, now)
+	datasetDir := filepath.Join(cacheDir, "datasets--org--data")
+	writeCachedRevision(t, datasetDir, "v1", "main", map[string]string{"data/train.csv": "a,b,c"}, now.Add(-24*time.Hour))
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "not-a-repo"), 0o755))
+
+	usages, err := CacheUsage(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, usages, 2)
+	model, dataset := usages[0], usages[1]
+	assert.Equal(t, RepoTypeModel, model.Type)
+	assert.Equal(t, "org/model", model.ID)
+	assert.Equal(t, int64(6+10+20), model.SizeBytes)
+	require.Len(t, model.Revisions, 2)
+	assert.Equal(t, "new", model.Revisions[0].CommitHash)
+	assert.Equal(t, []string{"main"}, model.Revisions[0].Refs)
+	assert.Equal(t, 2, model.Revisions[0].NumFiles)
+	assert.Equal(t, int64(26), model.Revisions[0].SizeBytes)
+	assert.Equal(t, RepoTypeDataset, dataset.Type)
+	assert.Equal(t, "org/data", dataset.ID)
+	assert.Equal(t, int64(5), dataset.SizeBytes)
+
+	// Dry run doesn't delete anything.
+	report, err := CacheGC(cacheDir, CacheGCPolicy{MaxAge: 36 * time.Hour, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/model@old"}, report.Deleted)
+	assert.Equal(t, int64(10), report.FreedBytes) // The "shared" blob is still used by the "new" revision.
+	assert.DirExists(t, filepath.Join(modelDir, "snapshots", "old"))
+
+	// Delete old revisions.
+	report, err = CacheGC(cacheDir, CacheGCPolicy{MaxAge: 36 * time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/model@old"}, report.Deleted)
+	assert.NoDirExists(t, filepath.Join(modelDir, "snapshots", "old"))
+	assert.NoFileExists(t, filepath.Join(modelDir, "blobs", "etag-0123456789"))
+	assert.FileExists(t, filepath.Join(modelDir, "blobs", "etag-shared"))
+
+	// Size budget: keeping the model, the dataset is removed.
+	report, err = CacheGC(cacheDir, CacheGCPolicy{MaxSizeBytes: 30, Keep: []string{"org/model"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/data@v1"}, report.Deleted)
+	assert.Equal(t, int64(5), report.FreedBytes)
+	assert.NoDirExists(t, datasetDir)
+	assert.DirExists(t, modelDir)
+}
+
+func TestClearAndPruneCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("HF_HUB_CACHE", cacheDir)
+	old := time.Now().Add(-48 * time.Hour)
+	modelDir := filepath.Join(cacheDir, "models--org--model")
+	writeCachedRevision(t, modelDir, "v1", "main", map[string]string{"model.bin": "0123456789"}, old)
+	otherDir := filepath.Join(cacheDir, "models--org--other")
+	writeCachedRevision(t, otherDir, "v1", "main", map[string]string{"model.bin": "abcde"}, old)
+
+	// Download in progress in "org/model": it is not touched.
+	fileLock := flock.New(filepath.Join(modelDir, "blobs", "etag-new.lock"))
+	require.NoError(t, fileLock.Lock())
+	repo := New("org/model").WithCacheDir(cacheDir)
+	repo.Verbosity = 0
+	require.Error(t, repo.ClearCache())
+	freed, err := PruneCache(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), freed)
+	assert.NoDirExists(t, filepath.Join(otherDir, "snapshots", "v1"))
+	assert.DirExists(t, filepath.Join(modelDir, "snapshots", "v1"))
+
+	// Once the download finished, the repository can be cleared.
+	require.NoError(t, fileLock.Unlock())
+	require.NoError(t, repo.ClearCache())
+	assert.NoDirExists(t, modelDir)
+	require.NoError(t, repo.ClearCache()) // No-op if not in cache.
+
+	_, err = PruneCache(0)
+	require.Error(t, err)
+}
+
+func TestDefaultCacheDir(t *testing.T) {
+	for _, key := range []string{"HF_HUB_CACHE", "HUGGINGFACE_HUB_CACHE", "HF_HOME", "XDG_CACHE_HOME"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("HOME", "/home/user")
+	assert.Equal(t, "/home/user/.cache/huggingface/hub", DefaultCacheDir())
+	t.Setenv("XDG_CACHE_HOME", "/xdg")
+	assert.Equal(t, "/xdg/huggingface/hub", DefaultCacheDir())
+	t.Setenv("HF_HOME", "/hf_home/")
+	assert.Equal(t, "/hf_home/hub", DefaultCacheDir())
+	t.Setenv("HUGGINGFACE_HUB_CACHE", "/old_hub_cache")
+	assert.Equal(t, "/old_hub_cache", DefaultCacheDir())
+	t.Setenv("HF_HUB_CACHE", "/hub_cache")
+	assert.Equal(t, "/hub_cache", DefaultCacheDir())
+}
@@ -3,7 +3,9 @@ package hub
 import (
 	"context"
 	"fmt"
+	"io"
 	"iter"
+	"log"
 	"net/http"
 	"os"
 	"path"
@@ -14,6 +16,7 @@ import (
 	"time"
 
 	"github.com/gomlx/compute/support/humanize"
+	"github.com/gomlx/go-huggingface/internal/downloader"
 	"github.com/gomlx/go-huggingface/internal/files"
 	"github.com/pkg/errors"
 )
@@ -44,6 +47,35 @@ func (r *Repo) IterFileNames() iter.Seq2[string, error] {
 	}
 }
 
+// IterFileNamesMatching iterates over the file names stored in the repo that match any of the glob patterns
+// (e.g. "*.gguf" or "onnx/*.onnx").
+//
+// Patterns without a "/" are also matched against the base name of the files, so "*.json" matches
+// "onnx/config.json". See path.Match for the syntax of the patterns.
+// Like IterFileNames, it doesn't trigger the downloading of the repo, only of the repo info.
+func (r *Repo) IterFileNamesMatching(patterns ...string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				yield("", errors.Wrapf(err, "invalid file name pattern %q", pattern))
+				return
+			}
+		}
+		for fileName, err := range r.IterFileNames() {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if !matchesAnyPattern(fileName, patterns) {
+				continue
+			}
+			if !yield(fileName, nil) {
+				return
+			}
+		}
+	}
+}
+
 // IterFileInfos iterate over the FileInfo of the files stored in the repo.
 // It doesn't trigger the downloading of the repo, only of the repo info.
 func (r *Repo) IterFileInfos() iter.Seq2[*FileInfo, error] {
@@ -121,6 +153,9 @@ func cleanRelativeFilePath(repoFileName string) string {
 // DownloadFiles downloads the repository files (the names returned by repo.IterFileNames), and return the path to the
 // downloaded files in the cache structure.
 //
+// Downloaded files are verified against the size and hash (ETag) reported by HuggingFace Hub, and removed if they
+// don't match, returning an error matching ErrIntegrity. Use VerifyFiles to re-validate files already in cache.
+//
 // The returned downloadPaths can be read, but shouldn't be modified, since there may be other programs using the same
 // files.
 func (r *Repo) DownloadFiles(repoFiles ...string) (downloadedPaths []string, err error) {
@@ -129,6 +164,12 @@ func (r *Repo) DownloadFiles(repoFiles ...string) (downloadedPaths []string, err
 
 // DownloadFilesCtx is like DownloadFiles but accepts a context for cancellation support.
 func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downloadedPaths []string, err error) {
+	return r.downloadFiles(ctx, nil, repoFiles...)
+}
+
+// downloadFiles implements DownloadFilesCtx. If progressCallback is not nil, it is called with the progress of
+// each file being downloaded (not for files already in cache).
+func (r *Repo) downloadFiles(ctx context.Context, progressCallback downloader.ProgressCallback, repoFiles ...string) (downloadedPaths []string, err error) {
 	if len(repoFiles) == 0 {
 		return nil, nil
 	}
@@ -170,7 +211,7 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 	busyLoopPos := 0
 	lastPrintTime := time.Now()
 
-	// Print downloading progress.
+	// Print downloading progress: it must be called with downloadingMu locked.
 	ratePrintFn := func() {
 		if firstError == nil {
 			fmt.Printf("\rDownloaded %d/%d files %c %s downloaded    ",
@@ -197,11 +238,6 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 	// Loop over each file to download.
 	var wg sync.WaitGroup
 	for idxFile, repoFileName := range repoFiles {
-		fileURL, err := r.FileURL(repoFileName)
-		if err != nil {
-			return nil, err
-		}
-
 		// Join the path parts of fileName using the current OS separator.
 		relativeFilePath := cleanRelativeFilePath(repoFileName)
 		if relativeFilePath == "." {
@@ -213,6 +249,13 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 			// File already downloaded, skip.
 			continue
 		}
+		if r.offline {
+			return nil, errors.Wrapf(ErrOffline, "file %q of repository %q", repoFileName, r.ID)
+		}
+		fileURL, err := r.FileURL(repoFileName)
+		if err != nil {
+			return nil, err
+		}
 
 		// Create directory for this individual file.
 		dir, _ := path.Split(snapshotPath)
@@ -244,8 +287,16 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 
 			// blobPath: download only if it has already been downloaded.
 			blobPath := path.Join(repoCacheDir, "blobs", etag)
+			if !files.Exists(blobPath) && r.sharedBlobs {
+				if _, err := linkSharedBlob(r.cacheDir, etag, blobPath); err != nil {
+					reportErrorFn(errors.WithMessagef(err, "while reusing blob for %q from repository %q", repoFileName, r.ID))
+					return
+				}
+			}
 			if !files.Exists(blobPath) {
+				downloadingMu.Lock()
 				requireDownload++ // This file require download.
+				downloadingMu.Unlock()
 				err := r.GetDownloadManager().LockedDownload(ctx, fileURL, blobPath, false, func(downloadedBytes, totalBytes int64) {
 					// Execute at every report of download.
 					downloadingMu.Lock()
@@ -254,6 +305,13 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 					newDownloaded := uint64(downloadedBytes) - lastReportedBytes
 					allFilesDownloaded += newDownloaded
 					perFileDownloaded[idxFile] = uint64(downloadedBytes)
+					if progressCallback != nil {
+						if totalBytes <= 0 {
+							// Use the size reported by the header, if the download doesn't know it.
+							totalBytes = int64(metadata.Size)
+						}
+						progressCallback(downloadedBytes, totalBytes)
+					}
 					if r.Verbosity > 0 && time.Since(lastPrintTime) > time.Second {
 						ratePrintFn()
 					}
@@ -262,12 +320,20 @@ func (r *Repo) DownloadFilesCtx(ctx context.Context, repoFiles ...string) (downl
 					reportErrorFn(err)
 					return
 				}
+				if err := verifyFile(blobPath, int64(metadata.Size), etag); err != nil {
+					// Don't leave a corrupted (e.g. truncated) file in the cache.
+					_ = os.Remove(blobPath)
+					reportErrorFn(errors.WithMessagef(err, "while downloading %q from repository %q", repoFileName, r.ID))
+					return
+				}
 
 				// Done, print out progress.
+				downloadingMu.Lock()
 				numDownloadedFiles++
 				if r.Verbosity > 0 {
 					ratePrintFn()
 				}
+				downloadingMu.Unlock()
 			}
 
 			// Link blob file to snapshot.
@@ -308,6 +374,154 @@ func (r *Repo) DownloadFileCtx(ctx context.Context, file string) (downloadedPath
 	return res[0], nil
 }
 
+// DownloadFileWithProgress is like DownloadFile, but calls progressCallback as the file is downloaded, with the
+// number of bytes downloaded so far and the total size of the file (0 if not known).
+// It allows rendering a progress bar for large files.
+//
+// If the file is already in the cache, progressCallback is not called.
+// DownloadFile is the same as DownloadFileWithProgress with a nil progressCallback.
+func (r *Repo) DownloadFileWithProgress(file string, progressCallback downloader.ProgressCallback) (downloadedPath string, err error) {
+	return r.DownloadFileWithProgressCtx(context.Background(), file, progressCallback)
+}
+
+// DownloadFileWithProgressCtx is like DownloadFileWithProgress but accepts a context for cancellation support.
+func (r *Repo) DownloadFileWithProgressCtx(ctx context.Context, file string, progressCallback downloader.ProgressCallback) (downloadedPath string, err error) {
+	res, err := r.downloadFiles(ctx, progressCallback, file)
+	if err != nil {
+		return "", err
+	}
+	return res[0], nil
+}
+
+// DownloadFileTo downloads the repository file (or uses the copy already in cache) and copies it to destPath,
+// overwriting it if it already exists.
+//
+// Contrary to DownloadFile, the caller controls where the file is placed (e.g. when building a deployment bundle),
+// and the copy is owned by the caller, so it can be freely modified.
+//
+// The copy is written to destPath+".tmp" and atomically moved to destPath, under a destPath+".lock" file lock, so
+// concurrent calls with the same destPath are safe.
+func (r *Repo) DownloadFileTo(file, destPath string) error {
+	return r.DownloadFileToCtx(context.Background(), file, destPath)
+}
+
+// DownloadFileToCtx is like DownloadFileTo but accepts a context for cancellation support.
+func (r *Repo) DownloadFileToCtx(ctx context.Context, file, destPath string) error {
+	if destPath == "" {
+		return errors.Errorf("empty destination path for %q", file)
+	}
+	cachedPath, err := r.DownloadFileCtx(ctx, file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(destPath), DefaultDirCreationPerm); err != nil {
+		return errors.Wrapf(err, "failed to create directory for %q", destPath)
+	}
+	lockPath := destPath + ".lock"
+	var copyErr error
+	errLock := files.ExecOnFileLock(lockPath, func() {
+		defer func() {
+			err := os.Remove(lockPath)
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: error removing lock file %q: %+v", lockPath, err)
+			}
+		}()
+		copyErr = copyFile(cachedPath, destPath)
+	})
+	if copyErr != nil {
+		return errors.WithMessagef(copyErr, "while copying %q from repository %q", file, r.ID)
+	}
+	if errLock != nil {
+		return errors.WithMessagef(errLock, "while copying %q from repository %q", file, r.ID)
+	}
+	return nil
+}
+
+// copyFile copies srcPath (following symbolic links) to dstPath+".tmp", and then atomically moves it to dstPath.
+func copyFile(srcPath, dstPath string) (err error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", srcPath)
+	}
+	defer func() { _ = src.Close() }()
+
+	tmpPath := dstPath + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %q", tmpPath)
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	if _, err = io.Copy(dst, src); err != nil {
+		return errors.Wrapf(err, "failed to copy %q to %q", srcPath, tmpPath)
+	}
+	if err = dst.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %q", tmpPath)
+	}
+	if err = os.Rename(tmpPath, dstPath); err != nil {
+		return errors.Wrapf(err, "failed to move %q to %q", tmpPath, dstPath)
+	}
+	return nil
+}
+
+// DownloadFileRange downloads only the bytes [start, end) of the repository file, using an HTTP Range request.
+//
+// It allows fetching part of a large file (e.g.: one tensor of a multi-GB safetensors shard) without downloading
+// the rest. Nothing is stored in the cache, but if the file has already been downloaded, the range is read from the
+// local copy instead.
+func (r *Repo) DownloadFileRange(file string, start, end int64) ([]byte, error) {
+	return r.DownloadFileRangeCtx(context.Background(), file, start, end)
+}
+
+// DownloadFileRangeCtx is like DownloadFileRange but accepts a context for cancellation support.
+func (r *Repo) DownloadFileRangeCtx(ctx context.Context, file string, start, end int64) ([]byte, error) {
+	if start < 0 || end < start {
+		return nil, errors.Errorf("invalid byte range [%d, %d) for %q", start, end, file)
+	}
+	relativeFilePath := cleanRelativeFilePath(file)
+	if relativeFilePath == "." {
+		return nil, errors.Errorf("invalid file name %q", file)
+	}
+	snapshotDir, err := r.repoSnapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	snapshotPath := path.Join(snapshotDir, relativeFilePath)
+	if files.Exists(snapshotPath) {
+		return readFileRange(snapshotPath, start, end)
+	}
+	if r.offline {
+		return nil, errors.Wrapf(ErrOffline, "file %q of repository %q", file, r.ID)
+	}
+	fileURL, err := r.FileURL(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.GetDownloadManager().DownloadRange(ctx, fileURL, start, end)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "while downloading range of %q from repository %q", file, r.ID)
+	}
+	return data, nil
+}
+
+// readFileRange reads the bytes [start, end) of a local file.
+func readFileRange(filePath string, start, end int64) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %q", filePath)
+	}
+	defer func() { _ = f.Close() }()
+	data := make([]byte, end-start)
+	if _, err := f.ReadAt(data, start); err != nil {
+		return nil, errors.Wrapf(err, "failed to read range [%d, %d) of %q", start, end, filePath)
+	}
+	return data, nil
+}
+
 // fileMetadata used by HuggingFace Hub.
 type fileMetadata struct {
 	CommitHash, ETag, Location string
@@ -343,6 +557,40 @@ func removeQuotes(str string) string {
 	return strings.TrimRight(strings.TrimLeft(str, "\""), "\"")
 }
 
+// linkSharedBlob searches the blobs directories of all repositories in cacheDir for a blob named etag, and if one
+// is found, links it to blobPath. It returns whether a blob was found and linked.
+//
+// It tries a hard-link first, and falls back to a symbolic link. Since the downloader atomically moves
+// blobs into place when completed, any blob found is complete.
+func linkSharedBlob(cacheDir, etag, blobPath string) (bool, error) {
+	if etag == "" || strings.ContainsAny(etag, `/\*?[`) {
+		return false, nil
+	}
+	candidates, err := filepath.Glob(path.Join(cacheDir, "*", "blobs", etag))
+	if err != nil {
+		return false, errors.Wrapf(err, "while searching for shared blob %q", etag)
+	}
+	for _, candidate := range candidates {
+		if candidate == blobPath {
+			continue
+		}
+		if info, err := os.Stat(candidate); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(blobPath), DefaultDirCreationPerm); err != nil {
+			return false, errors.Wrapf(err, "while creating blobs directory for %q", blobPath)
+		}
+		if err := os.Link(candidate, blobPath); err == nil {
+			return true, nil
+		}
+		if err := createSymLink(blobPath, candidate); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
 // createSymlink creates a symbolic link named dst pointing to src, using a relative path if possible.
 // It removes previous link/file if it already exists.
 //
@@ -374,3 +622,119 @@ func createSymLink(dst, src string) error {
 	}
 	return nil
 }
+
+// ErrNoModelArtifact is matched (with errors.Is) by any NoModelArtifactError.
+var ErrNoModelArtifact = errors.New("no loadable model artifact found")
+
+// NoModelArtifactError is returned by the model loaders (e.g.: gguf and safetensors) when the repository has no
+// file in any of the formats they can load.
+//
+// Use errors.Is(err, ErrNoModelArtifact) to check for it regardless of the loader, or errors.As to get the
+// formats searched for.
+type NoModelArtifactError struct {
+	RepoID string
+
+	// Formats searched for, e.g.: ".gguf" or ".safetensors".
+	Formats []string
+}
+
+func (e *NoModelArtifactError) Error() string {
+	return fmt.Sprintf("%s in repository %q: searched for %s",
+		ErrNoModelArtifact, e.RepoID, strings.Join(e.Formats, ", "))
+}
+
+// Is implements the errors.Is interface, matching ErrNoModelArtifact.
+func (e *NoModelArtifactError) Is(target error) bool {
+	return target == ErrNoModelArtifact
+}
+
+// FileEntry describes one file of the repository, see Repo.ListFiles.
+type FileEntry struct {
+	Name string
+
+	// Size of the file in bytes.
+	Size int64
+
+	// LFSSHA256 is the SHA-256 of the contents of files stored with Git LFS (usually the large ones), or "" for
+	// other files.
+	LFSSHA256 string
+}
+
+// ListFiles returns the name, size and LFS SHA-256 (if any) of every file in the repository, without downloading
+// them. E.g.: to check the total size of a model before downloading it, or to pick the smallest quantization
+// that fits.
+//
+// The sizes come from the repository info, so it must include the blobs information (the default, see
+// WithExtraBlobsInfo): otherwise the size of each file is fetched with an HTTP HEAD request.
+func (r *Repo) ListFiles() ([]FileEntry, error) {
+	return r.ListFilesCtx(context.Background())
+}
+
+// ListFilesCtx is like ListFiles but accepts a context for cancellation support.
+func (r *Repo) ListFilesCtx(ctx context.Context) ([]FileEntry, error) {
+	var entries []FileEntry
+	for fileInfo, err := range r.IterFileInfos() {
+		if err != nil {
+			return nil, err
+		}
+		entry := FileEntry{Name: fileInfo.Name, Size: fileInfo.Size}
+		if fileInfo.LFS != nil {
+			entry.LFSSHA256 = fileInfo.LFS.SHA256
+			entry.Size = max(entry.Size, fileInfo.LFS.Size)
+		}
+		if entry.Size <= 0 {
+			size, err := r.fetchFileSize(ctx, fileInfo.Name)
+			if err != nil {
+				return nil, err
+			}
+			entry.Size = size
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// FileSize returns the size in bytes of the repository file, without downloading it.
+//
+// It uses the repository info if it includes the blobs information (the default, see WithExtraBlobsInfo), and
+// otherwise it fetches the size with an HTTP HEAD request.
+func (r *Repo) FileSize(fileName string) (int64, error) {
+	return r.FileSizeCtx(context.Background(), fileName)
+}
+
+// FileSizeCtx is like FileSize but accepts a context for cancellation support.
+func (r *Repo) FileSizeCtx(ctx context.Context, fileName string) (int64, error) {
+	for fileInfo, err := range r.IterFileInfos() {
+		if err != nil {
+			return 0, err
+		}
+		if fileInfo.Name != fileName {
+			continue
+		}
+		size := fileInfo.Size
+		if fileInfo.LFS != nil {
+			size = max(size, fileInfo.LFS.Size)
+		}
+		if size > 0 {
+			return size, nil
+		}
+		return r.fetchFileSize(ctx, fileName)
+	}
+	return 0, errors.Errorf("file %q not found in repository %q", fileName, r.ID)
+}
+
+// fetchFileSize fetches the size of the file from the headers of an HTTP HEAD request.
+func (r *Repo) fetchFileSize(ctx context.Context, fileName string) (int64, error) {
+	if r.offline {
+		return 0, errors.Wrapf(ErrOffline, "size of file %q of repository %q", fileName, r.ID)
+	}
+	fileURL, err := r.FileURL(fileName)
+	if err != nil {
+		return 0, err
+	}
+	header, contentLength, err := r.GetDownloadManager().FetchHeader(ctx, fileURL)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "while fetching the size of %q from repository %q", fileName, r.ID)
+	}
+	return int64(extractFileMetadata(header, fileURL, contentLength).Size), nil
+}
@@ -1,11 +1,19 @@
 package hub
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCleanRelativeFilePath(t *testing.T) {
@@ -34,3 +42,385 @@ func TestCleanRelativeFilePath(t *testing.T) {
 		assert.Equal(t, expected, got)
 	}
 }
+
+func TestLinkSharedBlob(t *testing.T) {
+	cacheDir := t.TempDir()
+	etag := "403450e234d65943a7dcf7e05a771ce3c92faa84dd07db4ac20f592037a1e4bd"
+	otherBlob := filepath.Join(cacheDir, "models--other--model", "blobs", etag)
+	require.NoError(t, os.MkdirAll(filepath.Dir(otherBlob), 0755))
+	require.NoError(t, os.WriteFile(otherBlob, []byte("weights"), 0644))
+
+	blobPath := filepath.Join(cacheDir, "models--my--model", "blobs", etag)
+	found, err := linkSharedBlob(cacheDir, etag, blobPath)
+	require.NoError(t, err)
+	require.True(t, found)
+	contents, err := os.ReadFile(blobPath)
+	require.NoError(t, err)
+	assert.Equal(t, "weights", string(contents))
+
+	// Blob not in cache.
+	found, err = linkSharedBlob(cacheDir, "unknown", filepath.Join(cacheDir, "models--my--model", "blobs", "unknown"))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestDownloadFileRange(t *testing.T) {
+	content := []byte("0123456789")
+	var fileRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+		case "/org/model/resolve/abc123/weights.bin":
+			fileRequests++
+			http.ServeContent(w, req, "weights.bin", time.Time{}, bytes.NewReader(content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(cacheDir)
+	data, err := repo.DownloadFileRange("weights.bin", 2, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "2345", string(data))
+	assert.Equal(t, 1, fileRequests)
+
+	_, err = repo.DownloadFileRange("weights.bin", 6, 2)
+	require.Error(t, err)
+
+	// Once the file is in the cache, it is read from disk.
+	snapshotDir, err := repo.repoSnapshotsDir()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, "weights.bin"), []byte("abcdefghij"), 0644))
+	data, err = repo.DownloadFileRange("weights.bin", 2, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "cdef", string(data))
+	assert.Equal(t, 1, fileRequests)
+}
+
+func TestDownloadFileTo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	snapshotDir, err := repo.repoSnapshotsDir()
+	require.NoError(t, err)
+	cachedPath := filepath.Join(snapshotDir, "onnx", "model.onnx")
+	require.NoError(t, os.MkdirAll(filepath.Dir(cachedPath), 0755))
+	require.NoError(t, os.WriteFile(cachedPath, []byte("model contents"), 0644))
+
+	destPath := filepath.Join(t.TempDir(), "bundle", "model.onnx")
+	require.NoError(t, os.MkdirAll(filepath.Dir(destPath), 0755))
+	require.NoError(t, os.WriteFile(destPath, []byte("stale"), 0644))
+	require.NoError(t, repo.DownloadFileTo("onnx/model.onnx", destPath))
+	data, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	assert.Equal(t, "model contents", string(data))
+	assert.NoFileExists(t, destPath+".tmp")
+	assert.NoFileExists(t, destPath+".lock")
+
+	// The copy is owned by the caller: changing it doesn't affect the cache.
+	require.NoError(t, os.WriteFile(destPath, []byte("changed"), 0644))
+	data, err = os.ReadFile(cachedPath)
+	require.NoError(t, err)
+	assert.Equal(t, "model contents", string(data))
+
+	require.Error(t, repo.DownloadFileTo("onnx/model.onnx", ""))
+}
+
+func TestNoModelArtifactError(t *testing.T) {
+	var err error = &NoModelArtifactError{RepoID: "org/model", Formats: []string{".gguf", ".safetensors"}}
+	assert.ErrorIs(t, err, ErrNoModelArtifact)
+	assert.Equal(t, `no loadable model artifact found in repository "org/model": searched for .gguf, .safetensors`, err.Error())
+
+	wrapped := errors.WithMessage(err, "while loading")
+	assert.ErrorIs(t, wrapped, ErrNoModelArtifact)
+	var noArtifactErr *NoModelArtifactError
+	require.ErrorAs(t, wrapped, &noArtifactErr)
+	assert.Equal(t, []string{".gguf", ".safetensors"}, noArtifactErr.Formats)
+}
+
+func TestWithRevision(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+		case "/api/models/org/model/revision/def456":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "def456"}`))
+		case "/api/models/org/model/revision/refs/pr/1":
+			assert.Equal(t, "/api/models/org/model/revision/refs%2Fpr%2F1", req.URL.EscapedPath())
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "fed789"}`))
+		case "/org/model/resolve/abc123/config.json":
+			w.Header().Set("ETag", `"etag-main"`)
+			http.ServeContent(w, req, "config.json", time.Time{}, bytes.NewReader([]byte("main config")))
+		case "/org/model/resolve/def456/config.json":
+			w.Header().Set("ETag", `"etag-pinned"`)
+			http.ServeContent(w, req, "config.json", time.Time{}, bytes.NewReader([]byte("pinned config")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(cacheDir)
+	repo.Verbosity = 0
+	mainPath, err := repo.DownloadFile("config.json")
+	require.NoError(t, err)
+	contents, err := os.ReadFile(mainPath)
+	require.NoError(t, err)
+	assert.Equal(t, "main config", string(contents))
+	ref, err := os.ReadFile(filepath.Join(cacheDir, "models--org--model", "refs", "main"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", string(ref))
+
+	// Pinned revision: it is downloaded to its own snapshot.
+	repo.WithRevision("def456")
+	fileURL, err := repo.FileURL("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/org/model/resolve/def456/config.json", fileURL)
+	pinnedPath, err := repo.DownloadFile("config.json")
+	require.NoError(t, err)
+	assert.NotEqual(t, mainPath, pinnedPath)
+	assert.Contains(t, pinnedPath, filepath.Join("snapshots", "def456"))
+	contents, err = os.ReadFile(pinnedPath)
+	require.NoError(t, err)
+	assert.Equal(t, "pinned config", string(contents))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "models--org--model", "refs", "def456"))
+
+	// Revisions with slashes are escaped in the info URL.
+	repo.WithRevision("refs/pr/1")
+	require.NoError(t, repo.DownloadInfo(false))
+	assert.Equal(t, "fed789", repo.Info().CommitHash)
+}
+
+func TestWithRevision_Online(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping online test in short mode")
+	}
+	const commitHash = "c1899de289a04d12100db370d81485cdf75e47ca"
+	repo := New("Qwen/Qwen3-0.6B").WithRevision(commitHash).WithCacheDir(t.TempDir())
+	if _, err := repo.Exists(context.Background()); err != nil {
+		t.Skipf("HuggingFace Hub not reachable: %v", err)
+	}
+	fileURL, err := repo.FileURL("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, "https://huggingface.co/Qwen/Qwen3-0.6B/resolve/"+commitHash+"/config.json", fileURL)
+}
+
+func TestWithOffline(t *testing.T) {
+	var numRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		numRequests++
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [{"rfilename": "config.json"}, {"rfilename": "weights.bin"}]}`))
+		case "/org/model/resolve/abc123/config.json":
+			w.Header().Set("ETag", `"etag-config"`)
+			http.ServeContent(w, req, "config.json", time.Time{}, bytes.NewReader([]byte("config")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(cacheDir)
+	repo.Verbosity = 0
+	cachedPath, err := repo.DownloadFile("config.json")
+	require.NoError(t, err)
+
+	// Offline: only the cache is used.
+	numRequests = 0
+	repo = New("org/model").WithEndpoint(server.URL).WithCacheDir(cacheDir).WithOffline(true)
+	assert.True(t, repo.HasFile("weights.bin"))
+	var fileNames []string
+	for fileName, err := range repo.IterFileNames() {
+		require.NoError(t, err)
+		fileNames = append(fileNames, fileName)
+	}
+	assert.Equal(t, []string{"config.json", "weights.bin"}, fileNames)
+	downloadedPath, err := repo.DownloadFile("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, cachedPath, downloadedPath)
+	_, err = repo.DownloadFile("weights.bin")
+	require.ErrorIs(t, err, ErrOffline)
+	_, err = repo.DownloadFileRange("weights.bin", 0, 2)
+	require.ErrorIs(t, err, ErrOffline)
+	exists, err := repo.Exists(context.Background())
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Repository not in the cache.
+	other := New("org/other").WithEndpoint(server.URL).WithCacheDir(cacheDir).WithOffline(true)
+	assert.False(t, other.HasFile("config.json"))
+	require.ErrorIs(t, other.DownloadInfo(true), ErrOffline)
+	assert.Equal(t, 0, numRequests)
+
+	// The environment variable sets the default.
+	t.Setenv("HF_HUB_OFFLINE", "1")
+	assert.True(t, New("org/model").offline)
+}
+
+func TestDownloadFileWithProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 300_000) // 3MB: more than one read buffer.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+		case "/org/model/resolve/abc123/weights.bin":
+			w.Header().Set("ETag", `"etag-weights"`)
+			http.ServeContent(w, req, "weights.bin", time.Time{}, bytes.NewReader(content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	repo.Verbosity = 0
+	var numCalls int
+	var lastDownloaded, lastTotal int64
+	downloadedPath, err := repo.DownloadFileWithProgress("weights.bin", func(downloadedBytes, totalBytes int64) {
+		numCalls++
+		assert.GreaterOrEqual(t, downloadedBytes, lastDownloaded)
+		lastDownloaded, lastTotal = downloadedBytes, totalBytes
+	})
+	require.NoError(t, err)
+	assert.Greater(t, numCalls, 1)
+	assert.Equal(t, int64(len(content)), lastDownloaded)
+	assert.Equal(t, int64(len(content)), lastTotal)
+	data, err := os.ReadFile(downloadedPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// Already in cache: no progress reported.
+	numCalls = 0
+	_, err = repo.DownloadFileWithProgress("weights.bin", func(downloadedBytes, totalBytes int64) { numCalls++ })
+	require.NoError(t, err)
+	assert.Equal(t, 0, numCalls)
+}
+
+func TestIterFileNamesMatching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [
+				{"rfilename": "model-Q4_K_M.gguf"}, {"rfilename": "config.json"}, {"rfilename": "quants/model-Q8_0.gguf"},
+				{"rfilename": "model.safetensors.index.json"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	collect := func(patterns ...string) ([]string, error) {
+		var fileNames []string
+		for fileName, err := range repo.IterFileNamesMatching(patterns...) {
+			if err != nil {
+				return nil, err
+			}
+			fileNames = append(fileNames, fileName)
+		}
+		return fileNames, nil
+	}
+	fileNames, err := collect("*.gguf")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"model-Q4_K_M.gguf", "quants/model-Q8_0.gguf"}, fileNames)
+	fileNames, err = collect("quants/*", "*.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config.json", "quants/model-Q8_0.gguf", "model.safetensors.index.json"}, fileNames)
+	fileNames, err = collect()
+	require.NoError(t, err)
+	assert.Empty(t, fileNames)
+	_, err = collect("[invalid")
+	require.Error(t, err)
+}
+
+func TestListFilesAndFileSize(t *testing.T) {
+	var numHeadRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [
+				{"rfilename": "config.json", "size": 12},
+				{"rfilename": "model-Q4.gguf", "size": 4000, "lfs": {"sha256": "aaaa", "size": 4000, "pointerSize": 130}},
+				{"rfilename": "README.md"}]}`))
+		case "/org/model/resolve/abc123/README.md":
+			numHeadRequests++
+			w.Header().Set("ETag", `"etag-readme"`)
+			http.ServeContent(w, req, "README.md", time.Time{}, bytes.NewReader([]byte("# Model")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	entries, err := repo.ListFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []FileEntry{
+		{Name: "config.json", Size: 12},
+		{Name: "model-Q4.gguf", Size: 4000, LFSSHA256: "aaaa"},
+		{Name: "README.md", Size: 7},
+	}, entries)
+	assert.Equal(t, 1, numHeadRequests)
+
+	size, err := repo.FileSize("model-Q4.gguf")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4000), size)
+	size, err = repo.FileSize("README.md")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+	_, err = repo.FileSize("missing.bin")
+	require.Error(t, err)
+}
+
+func TestWithEndpoint(t *testing.T) {
+	var authHeaders []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+		case "/org/model/resolve/abc123/config.json":
+			w.Header().Set("ETag", `"etag-config"`)
+			http.ServeContent(w, req, "config.json", time.Time{}, bytes.NewReader([]byte("config")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mirror.Close()
+
+	repo := New("org/model").WithEndpoint(mirror.URL + "/").WithAuth("secret").WithCacheDir(t.TempDir())
+	repo.Verbosity = 0
+	fileURL, err := repo.FileURL("config.json")
+	require.NoError(t, err)
+	assert.Equal(t, mirror.URL+"/org/model/resolve/abc123/config.json", fileURL)
+	_, err = repo.DownloadFile("config.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, authHeaders)
+	for _, authHeader := range authHeaders {
+		assert.Equal(t, "Bearer secret", authHeader)
+	}
+
+	// Datasets have the repository type in the URL.
+	repo = New("org/model").WithType(RepoTypeDataset).WithEndpoint(mirror.URL).WithCacheDir(t.TempDir())
+	repo.info, repo.revisionHashRefreshed = &RepoInfo{CommitHash: "abc123"}, true
+	fileURL, err = repo.FileURL("data.parquet")
+	require.NoError(t, err)
+	assert.Equal(t, mirror.URL+"/datasets/org/model/resolve/abc123/data.parquet", fileURL)
+
+	t.Setenv("HF_ENDPOINT", "https://hf-mirror.com/")
+	assert.Equal(t, "https://hf-mirror.com", New("org/model").hfEndpoint)
+}
@@ -23,7 +23,8 @@
 // Environment variables:
 //
 // - HF_ENDPOINT: Where to connect to huggingface, default is https://huggingface.co
-// - XDG_CACHE_HOME: Cache directory, defaults to ${HOME}/.cache
+// - HF_HUB_OFFLINE: If set to "1", only use files already in the cache, see Repo.WithOffline.
+// - HF_HUB_CACHE (or HUGGINGFACE_HUB_CACHE), HF_HOME, XDG_CACHE_HOME: Cache directory, see DefaultCacheDir.
 package hub
 
 import (
@@ -34,6 +35,7 @@ import (
 	"strings"
 
 	"github.com/gomlx/go-huggingface"
+	"github.com/gomlx/go-huggingface/internal/files"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 )
@@ -69,6 +71,15 @@ const (
 	HeaderXLinkedSize = "X-Linked-Size"
 )
 
+// isEnvTrue returns whether the environment variable is set to a true value, e.g. "1" or "true".
+func isEnvTrue(key string) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
 func getEnvOr(key, defaultValue string) string {
 	v := os.Getenv(key)
 	if v == "" {
@@ -77,14 +88,24 @@ func getEnvOr(key, defaultValue string) string {
 	return v
 }
 
-// DefaultCacheDir for HuggingFace Hub, same used by the python library.
+// DefaultCacheDir for HuggingFace Hub, same used by the python library, so the cached files are shared.
 //
-// Its prefix is either `${XDG_CACHE_HOME}` if set, or `~/.cache` otherwise. Followed by `/huggingface/hub/`.
-// So typically: `~/.cache/huggingface/hub/`.
+// It follows the same environment variables, in order of precedence:
+//
+//   - `${HF_HUB_CACHE}`, or its deprecated name `${HUGGINGFACE_HUB_CACHE}`.
+//   - `${HF_HOME}/hub`.
+//   - `${XDG_CACHE_HOME}/huggingface/hub`.
+//   - `~/.cache/huggingface/hub`, the usual default.
 func DefaultCacheDir() string {
-	cacheDir := getEnvOr("XDG_CACHE_HOME", path.Join(os.Getenv("HOME"), ".cache"))
-	cacheDir = path.Join(cacheDir, "huggingface", "hub")
-	return cacheDir
+	cacheDir := getEnvOr("HF_HUB_CACHE", os.Getenv("HUGGINGFACE_HUB_CACHE"))
+	if cacheDir == "" {
+		hfHome := getEnvOr("HF_HOME", path.Join(getEnvOr("XDG_CACHE_HOME", path.Join(os.Getenv("HOME"), ".cache")), "huggingface"))
+		cacheDir = path.Join(hfHome, "hub")
+	}
+	if expanded, err := files.ReplaceTildeInDir(cacheDir); err == nil {
+		cacheDir = expanded
+	}
+	return path.Clean(cacheDir)
 }
 
 // DefaultHttpUserAgent returns a user agent to use with HuggingFace Hub API.
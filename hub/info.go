@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"time"
@@ -149,7 +151,7 @@ func (r *Repo) infoURL() string {
 	if r.extraBlobsInfo {
 		blobs = "?blobs=true"
 	}
-	return fmt.Sprintf("%s/api/%s/%s/revision/%s%s", r.hfEndpoint, r.repoType, r.ID, r.revision, blobs)
+	return fmt.Sprintf("%s/api/%s/%s/revision/%s%s", r.hfEndpoint, r.repoType, r.ID, url.PathEscape(r.revision), blobs)
 }
 
 // DownloadInfo about the model, if it hasn't yet.
@@ -157,6 +159,7 @@ func (r *Repo) infoURL() string {
 // It will attempt to use the "_info_.json" file in the cache directory first.
 //
 // If forceDownload is set to true, it ignores the current info or the cached one, and download it again from HuggingFace.
+// In offline mode (see WithOffline) forceDownload is ignored, and the cached info is used.
 //
 // See Repo.Info to access the Info directory.
 // Most users don't need to call this directly, instead use the various iterators.
@@ -170,14 +173,18 @@ func (r *Repo) DownloadInfo(forceDownload bool) error {
 	if err != nil {
 		return err
 	}
-	infoFilePath = path.Join(infoFilePath, "info")
-	if err = os.MkdirAll(infoFilePath, DefaultDirCreationPerm); err != nil {
-		return errors.Wrapf(err, "while creating info directory %q", infoFilePath)
+	// The revision may have slashes, e.g. "refs/pr/1".
+	infoFilePath = path.Join(infoFilePath, "info", cleanRelativeFilePath(r.revision))
+	if err = os.MkdirAll(path.Dir(infoFilePath), DefaultDirCreationPerm); err != nil {
+		return errors.Wrapf(err, "while creating info directory for %q", infoFilePath)
 	}
-	infoFilePath = path.Join(infoFilePath, r.revision)
 
 	// Download info file if needed.
-	if !files.Exists(infoFilePath) || forceDownload {
+	if r.offline {
+		if !files.Exists(infoFilePath) {
+			return errors.Wrapf(ErrOffline, "info for repository %q (revision %q)", r.ID, r.revision)
+		}
+	} else if !files.Exists(infoFilePath) || forceDownload {
 		err := r.GetDownloadManager().LockedDownload(context.Background(), r.infoURL(), infoFilePath, forceDownload, nil)
 		if err != nil {
 			return errors.WithMessagef(err, "failed to download repository info")
@@ -200,3 +207,86 @@ func (r *Repo) DownloadInfo(forceDownload bool) error {
 	r.info = newInfo
 	return nil
 }
+
+// RepoNotFoundError is returned by Repo.Exists if the repository (or the requested revision) doesn't exist.
+//
+// Notice that for private or gated repositories HuggingFace may report them as "not found" if no (or the wrong)
+// authentication token is given.
+type RepoNotFoundError struct {
+	RepoID     string
+	StatusCode int
+}
+
+func (e *RepoNotFoundError) Error() string {
+	return fmt.Sprintf("repository %q not found (status %d) -- if it is private, check the authentication token (see Repo.WithAuth)",
+		e.RepoID, e.StatusCode)
+}
+
+// RepoUnauthorizedError is returned by Repo.Exists if the repository exists, but it is not accessible with the
+// current authentication token.
+type RepoUnauthorizedError struct {
+	RepoID     string
+	StatusCode int
+}
+
+func (e *RepoUnauthorizedError) Error() string {
+	return fmt.Sprintf("not authorized to access repository %q (status %d) -- check the authentication token (see Repo.WithAuth)",
+		e.RepoID, e.StatusCode)
+}
+
+// RepoNetworkError is returned by Repo.Exists if the HuggingFace endpoint couldn't be reached, or it
+// returned an unexpected status.
+type RepoNetworkError struct {
+	RepoID string
+
+	// StatusCode is the HTTP status returned, or 0 if the request failed before getting a response.
+	StatusCode int
+
+	Err error
+}
+
+func (e *RepoNetworkError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("failed to reach repository %q: unexpected status %d", e.RepoID, e.StatusCode)
+	}
+	return fmt.Sprintf("failed to reach repository %q: %v", e.RepoID, e.Err)
+}
+
+func (e *RepoNetworkError) Unwrap() error { return e.Err }
+
+// Exists checks that the repository (at the configured revision) exists and is accessible with the current
+// authentication, by querying the HuggingFace info API. Nothing is downloaded to the cache.
+//
+// It returns true if the repository is accessible. Otherwise, it returns false and an error that can be
+// inspected with errors.As: *RepoNotFoundError, *RepoUnauthorizedError or *RepoNetworkError.
+//
+// In offline mode (see WithOffline), it reports whether the repository info is in the cache instead.
+func (r *Repo) Exists(ctx context.Context) (bool, error) {
+	if r.offline {
+		if err := r.DownloadInfo(false); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	infoURL := fmt.Sprintf("%s/api/%s/%s/revision/%s", r.hfEndpoint, r.repoType, r.ID, url.PathEscape(r.revision))
+	statusCode, header, err := r.GetDownloadManager().FetchStatus(ctx, infoURL)
+	if err != nil {
+		return false, errors.WithStack(&RepoNetworkError{RepoID: r.ID, Err: err})
+	}
+
+	switch {
+	case statusCode == http.StatusOK:
+		return true, nil
+	case statusCode == http.StatusNotFound,
+		header.Get("X-Error-Code") == "RepoNotFound",
+		header.Get("X-Error-Code") == "RevisionNotFound":
+		// HuggingFace answers 401 with "X-Error-Code: RepoNotFound" for unknown repositories when no
+		// authentication is given, to not leak the existence of private repositories.
+		return false, errors.WithStack(&RepoNotFoundError{RepoID: r.ID, StatusCode: statusCode})
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		return false, errors.WithStack(&RepoUnauthorizedError{RepoID: r.ID, StatusCode: statusCode})
+	default:
+		return false, errors.WithStack(&RepoNetworkError{
+			RepoID: r.ID, StatusCode: statusCode, Err: errors.New(http.StatusText(statusCode))})
+	}
+}
@@ -1,11 +1,16 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/gomlx/go-huggingface/internal/downloader"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -102,3 +107,69 @@ func TestRepoInfoLegacyModelID(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "legacy_model", info.ModelID)
 }
+
+func TestRepoExists(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		userAgent = req.Header.Get("User-Agent")
+		switch req.URL.Path {
+		case "/api/models/org/public/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/public"}`))
+		case "/api/models/org/private/revision/main":
+			if req.Header.Get("Authorization") == "Bearer secret" {
+				_, _ = w.Write([]byte(`{"id": "org/private"}`))
+				return
+			}
+			w.Header().Set("X-Error-Code", "RepoNotFound")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/models/org/gated/revision/main":
+			w.WriteHeader(http.StatusForbidden)
+		case "/api/models/org/broken/revision/main":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	exists, err := New("org/public").WithEndpoint(server.URL).Exists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = New("org/private").WithEndpoint(server.URL).WithAuth("secret").Exists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	var notFound *RepoNotFoundError
+	exists, err = New("org/private").WithEndpoint(server.URL).Exists(ctx)
+	assert.False(t, exists)
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, http.StatusUnauthorized, notFound.StatusCode)
+
+	exists, err = New("org/missing").WithEndpoint(server.URL).Exists(ctx)
+	assert.False(t, exists)
+	require.ErrorAs(t, err, &notFound)
+
+	var unauthorized *RepoUnauthorizedError
+	_, err = New("org/gated").WithEndpoint(server.URL).Exists(ctx)
+	require.ErrorAs(t, err, &unauthorized)
+
+	var networkErr *RepoNetworkError
+	_, err = New("org/broken").WithEndpoint(server.URL).Exists(ctx)
+	require.ErrorAs(t, err, &networkErr)
+	assert.Equal(t, http.StatusInternalServerError, networkErr.StatusCode)
+
+	// The request goes through the download manager, with its authentication and user agent.
+	manager := downloader.New().WithAuthToken("secret").WithUserAgent("test-agent")
+	exists, err = New("org/private").WithEndpoint(server.URL).WithDownloadManager(manager).Exists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "test-agent", userAgent)
+
+	url := server.URL
+	server.Close()
+	_, err = New("org/public").WithEndpoint(url).Exists(ctx)
+	require.ErrorAs(t, err, &networkErr)
+	assert.Zero(t, networkErr.StatusCode)
+}
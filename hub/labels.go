@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ModelConfigFile is the name of the transformers model configuration file.
+const ModelConfigFile = "config.json"
+
+// ParseLabels parses the "id2label" and "label2id" mappings of the contents of a model "config.json" file, used
+// by classification (and token classification, e.g. NER) models to map the logits to labels.
+//
+// If only one of the mappings is present, the other is derived from it.
+// It returns an error if the configuration has neither.
+func ParseLabels(data []byte) (id2label map[int]string, label2id map[string]int, err error) {
+	var config struct {
+		ID2Label map[string]string `json:"id2label"`
+		Label2ID map[string]int    `json:"label2id"`
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to parse %s", ModelConfigFile)
+	}
+	if len(config.ID2Label) == 0 && len(config.Label2ID) == 0 {
+		return nil, nil, errors.Errorf("%s has no \"id2label\" or \"label2id\" mappings", ModelConfigFile)
+	}
+
+	id2label = make(map[int]string, max(len(config.ID2Label), len(config.Label2ID)))
+	for key, label := range config.ID2Label {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid id %q in \"id2label\" of %s", key, ModelConfigFile)
+		}
+		id2label[id] = label
+	}
+	label2id = config.Label2ID
+	if len(label2id) == 0 {
+		label2id = make(map[string]int, len(id2label))
+		for id, label := range id2label {
+			label2id[label] = id
+		}
+	} else if len(id2label) == 0 {
+		for label, id := range label2id {
+			id2label[id] = label
+		}
+	}
+	return id2label, label2id, nil
+}
+
+// LoadLabels downloads (if needed) the model "config.json" and returns its "id2label" and "label2id" mappings.
+// See ParseLabels.
+//
+// Use them to convert the predictions of classification models to labels, e.g. with api.AlignLabels for
+// token classification.
+func (r *Repo) LoadLabels() (id2label map[int]string, label2id map[string]int, err error) {
+	data, err := r.readRepoFile(ModelConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	id2label, label2id, err = ParseLabels(data)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "repository %s", r)
+	}
+	return id2label, label2id, nil
+}
@@ -0,0 +1,33 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabels(t *testing.T) {
+	id2label, label2id, err := ParseLabels([]byte(`{
+  "architectures": ["BertForTokenClassification"],
+  "id2label": {"0": "O", "1": "B-PER", "2": "I-PER"},
+  "label2id": {"O": 0, "B-PER": 1, "I-PER": 2}
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{0: "O", 1: "B-PER", 2: "I-PER"}, id2label)
+	assert.Equal(t, map[string]int{"O": 0, "B-PER": 1, "I-PER": 2}, label2id)
+
+	// Missing mappings are derived from the other one.
+	id2label, label2id, err = ParseLabels([]byte(`{"id2label": {"0": "NEGATIVE", "1": "POSITIVE"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{0: "NEGATIVE", 1: "POSITIVE"}, id2label)
+	assert.Equal(t, map[string]int{"NEGATIVE": 0, "POSITIVE": 1}, label2id)
+	id2label, _, err = ParseLabels([]byte(`{"label2id": {"NEGATIVE": 0, "POSITIVE": 1}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[int]string{0: "NEGATIVE", 1: "POSITIVE"}, id2label)
+
+	_, _, err = ParseLabels([]byte(`{"hidden_size": 768}`))
+	assert.ErrorContains(t, err, "no \"id2label\"")
+	_, _, err = ParseLabels([]byte(`{"id2label": {"first": "O"}}`))
+	assert.ErrorContains(t, err, `invalid id "first"`)
+}
@@ -55,11 +55,17 @@ type Repo struct {
 	downloadManager *downloader.Manager
 
 	useProgressBar bool
+
+	// sharedBlobs enables reusing blobs already downloaded by other repositories in the same cache.
+	sharedBlobs bool
+
+	// offline disables any network access: only files already in the cache are used.
+	offline bool
 }
 
 // New creates a reference to a HuggingFace model given its id.
 //
-// It uses the default cache directory in ${XDG_CACHE_HOME} (if set) or `~/.cache`, in a format that is
+// It uses the default cache directory (see DefaultCacheDir, usually `~/.cache/huggingface/hub`), in a format that is
 // shared with huggingface-hub for python library. The cache is share across various programs, including Python
 // programs.
 // Use Repo.WithCacheDir to change it, or NewWithDir to use a plain directory structure, that is not shared across programs.
@@ -69,6 +75,9 @@ type Repo struct {
 // It defaults to being a RepoTypeModel repository. But you can change it with Repo.WithType.
 //
 // If authentication is needed, use Repo.WithAuth.
+//
+// If the environment variable HF_HUB_OFFLINE is set to "1" (or "true"), the Repo starts in offline mode,
+// see Repo.WithOffline.
 func New(id string) *Repo {
 	hfEndpoint := os.Getenv("HF_ENDPOINT")
 	if hfEndpoint == "" {
@@ -85,6 +94,7 @@ func New(id string) *Repo {
 		Verbosity:           1,
 		MaxParallelDownload: 20, // At most 20 parallel downloads.
 		extraBlobsInfo:      true,
+		offline:             isEnvTrue("HF_HUB_OFFLINE"),
 	}
 }
 
@@ -102,10 +112,13 @@ func (r *Repo) WithType(repoType RepoType) *Repo {
 	return r
 }
 
-// WithEndpoint sets the HuggingFace endpoint to use.
+// WithEndpoint sets the HuggingFace endpoint to use, e.g. a mirror like "https://hf-mirror.com".
 // Default is "https://huggingface.co" or, if set, the environment variable HF_ENDPOINT.
+//
+// All requests (the repository info and the files) are sent to the endpoint, including the authentication
+// token, if one is set (see WithAuth). So only use it with endpoints you trust with the token.
 func (r *Repo) WithEndpoint(endpoint string) *Repo {
-	r.hfEndpoint = endpoint
+	r.hfEndpoint = strings.TrimSuffix(endpoint, "/")
 	return r
 }
 
@@ -118,15 +131,25 @@ func (r *Repo) WithExtraBlobsInfo(extraBlobsInfo bool) *Repo {
 	return r
 }
 
-// WithRevision sets the revision to use for this Repo, defaults to "main", but can be set to a commit-hash value.
+// WithRevision sets the revision to use for this Repo, defaults to "main".
+//
+// It can be a branch, a tag, a pull-request ref (e.g. "refs/pr/1") or a commit-hash: pin a commit-hash for
+// reproducibility. The revision is resolved to its commit-hash, which is used to download the files
+// ("resolve/<commit-hash>/...") and as the cache snapshot directory, so different revisions don't collide.
+// Like huggingface_hub, the resolved commit-hash of a branch or tag is stored in the cache under "refs/<revision>".
 func (r *Repo) WithRevision(revision string) *Repo {
+	if revision != r.revision {
+		// Force the info (and the commit-hash) of the new revision to be loaded.
+		r.info = nil
+		r.revisionHashRefreshed = false
+	}
 	r.revision = revision
 	return r
 }
 
 // WithCacheDir sets the cacheDir to the given directory.
 //
-// The default is given by DefaultCacheDir: `${XDG_CACHE_HOME}/huggingface/hub` if set, or `~/.cache/huggingface/hub` otherwise.
+// The default is given by DefaultCacheDir: `${HF_HUB_CACHE}` or `${HF_HOME}/hub` if set, or `~/.cache/huggingface/hub` otherwise.
 func (r *Repo) WithCacheDir(cacheDir string) *Repo {
 	newCacheDir, err := files.ReplaceTildeInDir(cacheDir)
 	if err == nil {
@@ -137,6 +160,21 @@ func (r *Repo) WithCacheDir(cacheDir string) *Repo {
 	return r
 }
 
+// ErrOffline is matched (with errors.Is) by the errors returned in offline mode when a file (or the repository
+// info) is not in the cache. See Repo.WithOffline.
+var ErrOffline = errors.New("offline mode: not found in cache")
+
+// WithOffline configures the Repo to never access the network, and only use the files already in the cache.
+// Defaults to false, unless the environment variable HF_HUB_OFFLINE is set to "1" (or "true").
+//
+// In offline mode the repository info (used by HasFile, IterFileNames, etc.) is read from the cache, and
+// DownloadFile returns the cached path of the file if present, or an error matching ErrOffline otherwise.
+// The revision is not refreshed, so a branch (e.g. "main") resolves to the commit-hash cached last.
+func (r *Repo) WithOffline(offline bool) *Repo {
+	r.offline = offline
+	return r
+}
+
 // WithDownloadManager sets the downloader.Manager to use for download.
 // This is not needed, one will be created automatically if one is not set.
 // This is useful when downloading multiple Repos simultaneously, to coordinate limits by sharing the download manager.
@@ -151,6 +189,19 @@ func (r *Repo) WithProgressBar(useProgressBar bool) *Repo {
 	return r
 }
 
+// WithSharedBlobs configures whether to reuse identical files (blobs) already downloaded by other repositories
+// in the same cache directory, instead of downloading and storing them again. Defaults to false.
+//
+// Blobs are keyed by their ETag, which for HuggingFace Hub is the content hash (the LFS OID for large files),
+// so identical files across repos (e.g. fine-tunes sharing a tokenizer or base weights) are stored only once.
+// A reused blob is hard-linked into the repository "blobs" directory, which keeps the cache layout
+// compatible with huggingface_hub, and doesn't break if the other repository is deleted from the cache.
+// If hard-linking fails (e.g. the cache spans different file systems), a symbolic link is used instead.
+func (r *Repo) WithSharedBlobs(sharedBlobs bool) *Repo {
+	r.sharedBlobs = sharedBlobs
+	return r
+}
+
 // flatFolderName returns a serialized version of a hf.co repo name and type, safe for disk storage
 // as a single non-nested folder.
 //
@@ -198,15 +249,40 @@ func (r *Repo) FileURL(fileName string) (string, error) {
 //
 // repoCacheDir is returned by Repo.repoCacheDir().
 func (r *Repo) readCommitHashForRevision() (string, error) {
-	forceDownload := !r.revisionHashRefreshed
+	forceDownload := !r.revisionHashRefreshed && !r.offline
 	err := r.DownloadInfo(forceDownload)
 	if err != nil {
 		return "", err
 	}
+	if forceDownload {
+		if err = r.writeRevisionRef(r.info.CommitHash); err != nil {
+			return "", err
+		}
+	}
 	r.revisionHashRefreshed = true
 	return r.info.CommitHash, nil
 }
 
+// writeRevisionRef writes the commit-hash of the revision to "refs/<revision>", as huggingface_hub does.
+// Nothing is written if the revision is itself the commit-hash.
+func (r *Repo) writeRevisionRef(commitHash string) error {
+	if commitHash == "" || commitHash == r.revision {
+		return nil
+	}
+	cacheDir, err := r.repoCacheDir()
+	if err != nil {
+		return err
+	}
+	refPath := path.Join(cacheDir, "refs", cleanRelativeFilePath(r.revision))
+	if err = os.MkdirAll(path.Dir(refPath), DefaultDirCreationPerm); err != nil {
+		return errors.Wrapf(err, "while creating refs directory for %q", refPath)
+	}
+	if err = os.WriteFile(refPath, []byte(commitHash), DefaultFileCreationPerm); err != nil {
+		return errors.Wrapf(err, "while writing ref %q", refPath)
+	}
+	return nil
+}
+
 // repoSnapshotsDir returns the snapshots directory for this repo at its revision.
 func (r *Repo) repoSnapshotsDir() (string, error) {
 	cacheDir, err := r.repoCacheDir()
@@ -0,0 +1,192 @@
+package hub
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// Files and module types used by sentence-transformers (https://www.sbert.net) models.
+const (
+	SentenceTransformersModulesFile = "modules.json"
+	SentenceTransformersPoolingFile = "config.json" // Inside the pooling module directory, usually "1_Pooling".
+
+	SentenceTransformersTypePooling   = "sentence_transformers.models.Pooling"
+	SentenceTransformersTypeNormalize = "sentence_transformers.models.Normalize"
+	SentenceTransformersTypeDense     = "sentence_transformers.models.Dense"
+
+	// defaultPoolingPath is used if "modules.json" is missing, or it doesn't list a pooling module.
+	defaultPoolingPath = "1_Pooling"
+)
+
+// SentenceTransformersModule is one entry of the sentence-transformers "modules.json" file, describing one stage
+// of the embedding pipeline.
+type SentenceTransformersModule struct {
+	Idx  int    `json:"idx"`
+	Name string `json:"name"`
+
+	// Path of the module configuration directory, relative to the repository root. E.g.: "1_Pooling".
+	// It is "" for the transformer module, which is configured at the root.
+	Path string `json:"path"`
+
+	// Type is the python class of the module, e.g.: "sentence_transformers.models.Pooling".
+	Type string `json:"type"`
+}
+
+// PoolingConfig is the sentence-transformers pooling configuration, usually stored in "1_Pooling/config.json".
+type PoolingConfig struct {
+	WordEmbeddingDimension        int  `json:"word_embedding_dimension"`
+	PoolingModeCLSToken           bool `json:"pooling_mode_cls_token"`
+	PoolingModeMeanTokens         bool `json:"pooling_mode_mean_tokens"`
+	PoolingModeMaxTokens          bool `json:"pooling_mode_max_tokens"`
+	PoolingModeMeanSqrtLenTokens  bool `json:"pooling_mode_mean_sqrt_len_tokens"`
+	PoolingModeWeightedMeanTokens bool `json:"pooling_mode_weightedmean_tokens"`
+	PoolingModeLastToken          bool `json:"pooling_mode_lasttoken"`
+	IncludePrompt                 bool `json:"include_prompt"`
+}
+
+// Modes returns the enabled pooling modes, using the sentence-transformers names: "cls", "mean", "max",
+// "mean_sqrt_len_tokens", "weightedmean" and "lasttoken".
+//
+// Usually there is only one. If more than one is enabled, sentence-transformers concatenates the pooled
+// vectors in this order.
+func (c *PoolingConfig) Modes() []string {
+	var modes []string
+	for _, m := range []struct {
+		enabled bool
+		name    string
+	}{
+		{c.PoolingModeCLSToken, "cls"},
+		{c.PoolingModeMaxTokens, "max"},
+		{c.PoolingModeMeanTokens, "mean"},
+		{c.PoolingModeMeanSqrtLenTokens, "mean_sqrt_len_tokens"},
+		{c.PoolingModeWeightedMeanTokens, "weightedmean"},
+		{c.PoolingModeLastToken, "lasttoken"},
+	} {
+		if m.enabled {
+			modes = append(modes, m.name)
+		}
+	}
+	return modes
+}
+
+// SentenceTransformersConfig summarizes how a sentence-transformers model turns the transformer outputs into
+// sentence embeddings.
+type SentenceTransformersConfig struct {
+	// Modules as listed in "modules.json", or nil if the repository doesn't have one.
+	Modules []*SentenceTransformersModule
+
+	// Pooling configuration, or nil if the repository doesn't have one.
+	Pooling *PoolingConfig
+
+	// Normalize indicates the embeddings are L2-normalized after pooling (a Normalize module is present).
+	Normalize bool
+}
+
+// ParseSentenceTransformersModules parses the contents of a sentence-transformers "modules.json" file.
+func ParseSentenceTransformersModules(data []byte) ([]*SentenceTransformersModule, error) {
+	var modules []*SentenceTransformersModule
+	if err := json.Unmarshal(data, &modules); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", SentenceTransformersModulesFile)
+	}
+	return modules, nil
+}
+
+// ParsePoolingConfig parses the contents of a sentence-transformers pooling "config.json" file.
+func ParsePoolingConfig(data []byte) (*PoolingConfig, error) {
+	config := &PoolingConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse pooling config")
+	}
+	return config, nil
+}
+
+// SentenceTransformersModules downloads (if needed) and parses the repository "modules.json" file.
+func (r *Repo) SentenceTransformersModules() ([]*SentenceTransformersModule, error) {
+	data, err := r.readRepoFile(SentenceTransformersModulesFile)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSentenceTransformersModules(data)
+}
+
+// SentenceTransformersPooling downloads (if needed) and parses the pooling configuration.
+//
+// The pooling module directory is taken from "modules.json" if present, otherwise it defaults to "1_Pooling".
+func (r *Repo) SentenceTransformersPooling() (*PoolingConfig, error) {
+	poolingPath := defaultPoolingPath
+	if r.HasFile(SentenceTransformersModulesFile) {
+		modules, err := r.SentenceTransformersModules()
+		if err != nil {
+			return nil, err
+		}
+		if m := findModuleByType(modules, SentenceTransformersTypePooling); m != nil && m.Path != "" {
+			poolingPath = m.Path
+		}
+	}
+	data, err := r.readRepoFile(path.Join(poolingPath, SentenceTransformersPoolingFile))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePoolingConfig(data)
+}
+
+// SentenceTransformersConfig loads the sentence-transformers "modules.json" and pooling configuration,
+// if present in the repository.
+//
+// It returns an error if the repository has neither: it is likely not a sentence-transformers model.
+func (r *Repo) SentenceTransformersConfig() (*SentenceTransformersConfig, error) {
+	config := &SentenceTransformersConfig{}
+	poolingPath := defaultPoolingPath
+	if r.HasFile(SentenceTransformersModulesFile) {
+		modules, err := r.SentenceTransformersModules()
+		if err != nil {
+			return nil, err
+		}
+		config.Modules = modules
+		if m := findModuleByType(modules, SentenceTransformersTypePooling); m != nil && m.Path != "" {
+			poolingPath = m.Path
+		}
+		config.Normalize = findModuleByType(modules, SentenceTransformersTypeNormalize) != nil
+	}
+	poolingFile := path.Join(poolingPath, SentenceTransformersPoolingFile)
+	if r.HasFile(poolingFile) {
+		data, err := r.readRepoFile(poolingFile)
+		if err != nil {
+			return nil, err
+		}
+		if config.Pooling, err = ParsePoolingConfig(data); err != nil {
+			return nil, err
+		}
+	}
+	if config.Modules == nil && config.Pooling == nil {
+		return nil, errors.Errorf("repository %q has no sentence-transformers configuration (%s or %s)",
+			r.ID, SentenceTransformersModulesFile, poolingFile)
+	}
+	return config, nil
+}
+
+// findModuleByType returns the first module of the given type, or nil if there is none.
+func findModuleByType(modules []*SentenceTransformersModule, moduleType string) *SentenceTransformersModule {
+	for _, m := range modules {
+		if m.Type == moduleType {
+			return m
+		}
+	}
+	return nil
+}
+
+// readRepoFile downloads (if needed) and reads the contents of the given repository file.
+func (r *Repo) readRepoFile(fileName string) ([]byte, error) {
+	localPath, err := r.DownloadFile(fileName)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to download %q from %s", fileName, r)
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", localPath)
+	}
+	return data, nil
+}
@@ -0,0 +1,36 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSentenceTransformersConfig(t *testing.T) {
+	// Contents of sentence-transformers/all-MiniLM-L6-v2.
+	modules, err := ParseSentenceTransformersModules([]byte(`[
+  {"idx": 0, "name": "0", "path": "", "type": "sentence_transformers.models.Transformer"},
+  {"idx": 1, "name": "1", "path": "1_Pooling", "type": "sentence_transformers.models.Pooling"},
+  {"idx": 2, "name": "2", "path": "2_Normalize", "type": "sentence_transformers.models.Normalize"}
+]`))
+	require.NoError(t, err)
+	require.Len(t, modules, 3)
+	assert.Equal(t, "1_Pooling", findModuleByType(modules, SentenceTransformersTypePooling).Path)
+	assert.NotNil(t, findModuleByType(modules, SentenceTransformersTypeNormalize))
+	assert.Nil(t, findModuleByType(modules, SentenceTransformersTypeDense))
+
+	pooling, err := ParsePoolingConfig([]byte(`{
+  "word_embedding_dimension": 384,
+  "pooling_mode_cls_token": false,
+  "pooling_mode_mean_tokens": true,
+  "pooling_mode_max_tokens": false,
+  "pooling_mode_mean_sqrt_len_tokens": false
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, 384, pooling.WordEmbeddingDimension)
+	assert.Equal(t, []string{"mean"}, pooling.Modes())
+
+	_, err = ParsePoolingConfig([]byte(`[`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package hub
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotConfig holds the options of DownloadSnapshot.
+type snapshotConfig struct {
+	allowPatterns, ignorePatterns []string
+}
+
+// SnapshotOption configures Repo.DownloadSnapshot.
+type SnapshotOption func(*snapshotConfig)
+
+// SnapshotAllowPatterns limits the snapshot to the files matching any of the glob patterns (e.g. "*.safetensors",
+// "*.json"). See matchesAnyPattern for the pattern syntax. It can be given more than once.
+func SnapshotAllowPatterns(patterns ...string) SnapshotOption {
+	return func(c *snapshotConfig) {
+		c.allowPatterns = append(c.allowPatterns, patterns...)
+	}
+}
+
+// SnapshotIgnorePatterns excludes from the snapshot the files matching any of the glob patterns (e.g. "*.bin").
+// It takes precedence over SnapshotAllowPatterns. It can be given more than once.
+func SnapshotIgnorePatterns(patterns ...string) SnapshotOption {
+	return func(c *snapshotConfig) {
+		c.ignorePatterns = append(c.ignorePatterns, patterns...)
+	}
+}
+
+// DownloadSnapshot downloads all the files of the repository (or the ones selected by the options) and returns
+// the local snapshot directory, where the files are stored with the same structure as in the repository.
+//
+// Files are downloaded in parallel (see MaxParallelDownload), and the ones already in the cache are not
+// downloaded again. It mirrors huggingface_hub.snapshot_download.
+//
+// Example: download only the weights and the configuration files.
+//
+//	dir, err := repo.DownloadSnapshot(hub.SnapshotAllowPatterns("*.safetensors", "*.json"))
+func (r *Repo) DownloadSnapshot(opts ...SnapshotOption) (localDir string, err error) {
+	return r.DownloadSnapshotCtx(context.Background(), opts...)
+}
+
+// DownloadSnapshotCtx is like DownloadSnapshot but accepts a context for cancellation support.
+func (r *Repo) DownloadSnapshotCtx(ctx context.Context, opts ...SnapshotOption) (localDir string, err error) {
+	var config snapshotConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	for _, pattern := range append(config.allowPatterns, config.ignorePatterns...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", errors.Wrapf(err, "invalid snapshot pattern %q", pattern)
+		}
+	}
+	var repoFiles []string
+	for fileName, err := range r.IterFileNames() {
+		if err != nil {
+			return "", err
+		}
+		if len(config.allowPatterns) > 0 && !matchesAnyPattern(fileName, config.allowPatterns) {
+			continue
+		}
+		if matchesAnyPattern(fileName, config.ignorePatterns) {
+			continue
+		}
+		repoFiles = append(repoFiles, fileName)
+	}
+	if _, err := r.DownloadFilesCtx(ctx, repoFiles...); err != nil {
+		return "", err
+	}
+	return r.repoSnapshotsDir()
+}
+
+// matchesAnyPattern returns whether the repository fileName matches any of the glob patterns, using path.Match.
+//
+// Patterns without a "/" are also matched against the base name of the file, so "*.json" matches
+// "tokenizer.json" as well as "onnx/config.json". Invalid patterns never match.
+func matchesAnyPattern(fileName string, patterns []string) bool {
+	baseName := path.Base(fileName)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, fileName); matched {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, baseName); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadSnapshot(t *testing.T) {
+	repoFiles := []string{"config.json", "model.safetensors", "pytorch_model.bin", "onnx/config.json", "README.md"}
+	var numDownloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			var siblings []string
+			for _, f := range repoFiles {
+				siblings = append(siblings, `{"rfilename": "`+f+`"}`)
+			}
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [` + strings.Join(siblings, ",") + `]}`))
+			return
+		}
+		fileName, found := strings.CutPrefix(req.URL.Path, "/org/model/resolve/abc123/")
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == http.MethodGet {
+			numDownloads.Add(1)
+		}
+		w.Header().Set("ETag", `"etag-`+strings.ReplaceAll(fileName, "/", "-")+`"`)
+		http.ServeContent(w, req, fileName, time.Time{}, bytes.NewReader([]byte("contents of "+fileName)))
+	}))
+	defer server.Close()
+
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	repo.Verbosity = 0
+	dir, err := repo.DownloadSnapshot(SnapshotAllowPatterns("*.safetensors", "*.json"), SnapshotIgnorePatterns("onnx/*"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), numDownloads.Load())
+	for _, fileName := range []string{"config.json", "model.safetensors"} {
+		data, err := os.ReadFile(filepath.Join(dir, fileName))
+		require.NoError(t, err)
+		assert.Equal(t, "contents of "+fileName, string(data))
+	}
+	for _, fileName := range []string{"pytorch_model.bin", "onnx/config.json", "README.md"} {
+		assert.NoFileExists(t, filepath.Join(dir, fileName))
+	}
+
+	// The whole repository: files already in cache are not downloaded again.
+	dir2, err := repo.DownloadSnapshot()
+	require.NoError(t, err)
+	assert.Equal(t, dir, dir2)
+	assert.Equal(t, int32(len(repoFiles)), numDownloads.Load())
+	data, err := os.ReadFile(filepath.Join(dir, "onnx", "config.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "contents of onnx/config.json", string(data))
+
+	_, err = repo.DownloadSnapshot(SnapshotAllowPatterns("[invalid"))
+	require.Error(t, err)
+}
+
+func TestMatchesAnyPattern(t *testing.T) {
+	assert.True(t, matchesAnyPattern("tokenizer.json", []string{"*.json"}))
+	assert.True(t, matchesAnyPattern("onnx/config.json", []string{"*.json"}))
+	assert.True(t, matchesAnyPattern("onnx/config.json", []string{"onnx/*"}))
+	assert.False(t, matchesAnyPattern("other/config.json", []string{"onnx/*"}))
+	assert.False(t, matchesAnyPattern("model.bin", []string{"*.json", "*.safetensors"}))
+	assert.False(t, matchesAnyPattern("model.bin", nil))
+}
@@ -0,0 +1,136 @@
+package hub
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gomlx/go-huggingface/internal/files"
+	"github.com/pkg/errors"
+)
+
+// ErrIntegrity is matched (with errors.Is) by the errors returned when a downloaded (or cached) file doesn't match
+// the size or hash published by HuggingFace Hub.
+var ErrIntegrity = errors.New("file failed integrity check")
+
+// verifyFile checks that the file at filePath has the expected size (if > 0) and, if the etag is a content
+// hash, that it matches the etag.
+//
+// HuggingFace Hub ETags are the SHA-256 of the contents for LFS files (64 hex digits), and the Git blob
+// SHA-1 (40 hex digits) for other files. Other ETags are not verified.
+func verifyFile(filePath string, size int64, etag string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %q", filePath)
+	}
+	if size > 0 && info.Size() != size {
+		return errors.Wrapf(ErrIntegrity, "%q has %d bytes, expected %d", filePath, info.Size(), size)
+	}
+	var hasher hash.Hash
+	switch {
+	case isHexHash(etag, sha256.Size):
+		hasher = sha256.New()
+	case isHexHash(etag, sha1.Size):
+		hasher = sha1.New()
+		_, _ = fmt.Fprintf(hasher, "blob %d\x00", info.Size())
+	default:
+		return nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", filePath)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return errors.Wrapf(err, "failed to read %q", filePath)
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); got != strings.ToLower(etag) {
+		return errors.Wrapf(ErrIntegrity, "%q has hash %s, expected %s", filePath, got, etag)
+	}
+	return nil
+}
+
+// isHexHash returns whether s is the hex encoding of a hash with numBytes.
+func isHexHash(s string, numBytes int) bool {
+	if len(s) != 2*numBytes {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// VerifyFiles checks that the given repository files in the cache match the sizes and hashes listed in the
+// repository info (the LFS SHA-256 or the Git blob ID), and removes the ones that don't from the cache, so the
+// next DownloadFiles downloads them again. Files not in the cache are ignored.
+//
+// It returns an error matching ErrIntegrity (with errors.Is) listing the corrupted files, if any.
+// The info must include the blobs information, see WithExtraBlobsInfo.
+func (r *Repo) VerifyFiles(repoFiles ...string) error {
+	if err := r.DownloadInfo(false); err != nil {
+		return err
+	}
+	fileInfos := make(map[string]*FileInfo, len(r.info.Siblings))
+	for _, fileInfo := range r.info.Siblings {
+		fileInfos[fileInfo.Name] = fileInfo
+	}
+	snapshotDir, err := r.repoSnapshotsDir()
+	if err != nil {
+		return err
+	}
+	var corrupted []string
+	for _, repoFile := range repoFiles {
+		relativeFilePath := cleanRelativeFilePath(repoFile)
+		if relativeFilePath == "." {
+			return errors.Errorf("invalid file name %q", repoFile)
+		}
+		snapshotPath := path.Join(snapshotDir, relativeFilePath)
+		if !files.Exists(snapshotPath) {
+			continue
+		}
+		fileInfo, found := fileInfos[repoFile]
+		if !found {
+			return errors.Errorf("file %q not found in repository %q", repoFile, r.ID)
+		}
+		size, hash := fileInfo.Size, fileInfo.BlobID
+		if fileInfo.LFS != nil {
+			size, hash = fileInfo.LFS.Size, fileInfo.LFS.SHA256
+		}
+		err := verifyFile(snapshotPath, size, hash)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrIntegrity) {
+			return err
+		}
+		if err := removeCachedFile(snapshotPath); err != nil {
+			return err
+		}
+		corrupted = append(corrupted, repoFile)
+	}
+	if len(corrupted) > 0 {
+		return errors.Wrapf(ErrIntegrity, "corrupted files removed from the cache of repository %q: %q", r.ID, corrupted)
+	}
+	return nil
+}
+
+// removeCachedFile removes the snapshot link and the blob it points to.
+func removeCachedFile(snapshotPath string) error {
+	if blobPath, err := os.Readlink(snapshotPath); err == nil {
+		if !path.IsAbs(blobPath) {
+			blobPath = path.Join(path.Dir(snapshotPath), blobPath)
+		}
+		if err := os.Remove(blobPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "failed to remove corrupted blob %q", blobPath)
+		}
+	}
+	if err := os.Remove(snapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(err, "failed to remove corrupted file %q", snapshotPath)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyDownload(t *testing.T) {
+	content := []byte("model weights")
+	sum := sha256.Sum256(content)
+	sha := hex.EncodeToString(sum[:])
+	truncate := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = fmt.Fprintf(w, `{"id": "org/model", "sha": "abc123", "siblings": [
+				{"rfilename": "weights.bin", "size": %d, "lfs": {"sha256": %q, "size": %d}}]}`,
+				len(content), sha, len(content))
+		case "/org/model/resolve/abc123/weights.bin":
+			w.Header().Set("ETag", strconv.Quote(sha))
+			w.Header().Set(HeaderXLinkedSize, strconv.Itoa(len(content)))
+			served := content
+			if truncate && req.Method == http.MethodGet {
+				served = content[:5]
+			}
+			http.ServeContent(w, req, "weights.bin", time.Time{}, bytes.NewReader(served))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	repo := New("org/model").WithEndpoint(server.URL).WithCacheDir(cacheDir)
+	repo.Verbosity = 0
+	_, err := repo.DownloadFile("weights.bin")
+	require.ErrorIs(t, err, ErrIntegrity)
+	repoDir, err := repo.CacheDir()
+	require.NoError(t, err)
+	assert.NoFileExists(t, repoDir+"/blobs/"+sha)
+
+	truncate = false
+	downloadedPath, err := repo.DownloadFile("weights.bin")
+	require.NoError(t, err)
+	require.NoError(t, repo.VerifyFiles("weights.bin", "not_in_cache.bin"))
+
+	// Corrupt the cached file: VerifyFiles removes it, and it is downloaded again.
+	require.NoError(t, os.WriteFile(downloadedPath, []byte("model weighs!"), 0644))
+	require.ErrorIs(t, repo.VerifyFiles("weights.bin"), ErrIntegrity)
+	assert.NoFileExists(t, downloadedPath)
+	downloadedPath, err = repo.DownloadFile("weights.bin")
+	require.NoError(t, err)
+	data, err := os.ReadFile(downloadedPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestVerifyFile_GitBlobID(t *testing.T) {
+	filePath := t.TempDir() + "/config.json"
+	require.NoError(t, os.WriteFile(filePath, []byte("hello\n"), 0644))
+	// Git blob ID of "hello\n", as given by `git hash-object`.
+	require.NoError(t, verifyFile(filePath, 6, "ce013625030ba8dba906f756967f9e9ca394464a"))
+	require.ErrorIs(t, verifyFile(filePath, 6, "ce013625030ba8dba906f756967f9e9ca394464b"), ErrIntegrity)
+	require.ErrorIs(t, verifyFile(filePath, 7, ""), ErrIntegrity)
+	require.NoError(t, verifyFile(filePath, 0, "not-a-hash"))
+}
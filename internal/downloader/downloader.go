@@ -6,6 +6,7 @@ package downloader
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -81,7 +82,23 @@ func (m *Manager) setRequestHeader(req *http.Request) {
 // Note: this download files with a ".part" suffix (Part) first, and moves the file to filePath only after
 // the download has completed successfully. This way, if the download is interrupted, the
 // final file will not be present, and a re-run will download the file from scratch.
+// See ResumableDownload to resume interrupted downloads instead.
 func (m *Manager) Download(ctx context.Context, url string, filePath string, callback ProgressCallback) error {
+	return m.download(ctx, url, filePath, false, callback)
+}
+
+// ResumableDownload is like Download, but if the download is interrupted the ".part" file is kept, and the next
+// call resumes from where it stopped, using an HTTP Range request ("Range: bytes=<n>-").
+// If the server doesn't support range requests, it falls back to downloading the file from scratch.
+//
+// The caller must ensure the url content doesn't change between calls (e.g. by using a url to a fixed revision),
+// and that no one else is downloading to the same filePath (see LockedDownload).
+func (m *Manager) ResumableDownload(ctx context.Context, url string, filePath string, callback ProgressCallback) error {
+	return m.download(ctx, url, filePath, true, callback)
+}
+
+// download implements Download and ResumableDownload.
+func (m *Manager) download(ctx context.Context, url string, filePath string, resume bool, callback ProgressCallback) error {
 	m.semaphore.Acquire()
 	defer m.semaphore.Release()
 
@@ -101,8 +118,43 @@ func (m *Manager) Download(ctx context.Context, url string, filePath string, cal
 		return errors.Wrapf(err, "Failed to create the directory for the path: %q", path.Dir(filePath))
 	}
 	filePathPart := filePath + "." + Part
+
+	// Size of the partial download to resume from, if any.
+	var resumeFrom int64
+	if resume {
+		if info, err := os.Stat(filePathPart); err == nil && info.Mode().IsRegular() {
+			resumeFrom = info.Size()
+		}
+	}
+
+	resp, err := m.get(ctx, client, url, resumeFrom)
+	if err != nil {
+		return err
+	}
+	if resumeFrom > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The partial file is not a prefix of the current content (e.g. it is larger): start from scratch.
+		_ = resp.Body.Close()
+		resumeFrom = 0
+		if resp, err = m.get(ctx, client, url, resumeFrom); err != nil {
+			return err
+		}
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusPartialContent && (resumeFrom == 0 || !contentRangeStartsAt(resp, resumeFrom)) {
+		return errors.Errorf("unexpected range %q in response for %q", resp.Header.Get("Content-Range"), url)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return badStatusError(resp)
+	}
+
 	var file *os.File
-	file, err = os.Create(filePathPart)
+	if resp.StatusCode == http.StatusPartialContent {
+		file, err = os.OpenFile(filePathPart, os.O_WRONLY|os.O_APPEND, 0)
+	} else {
+		// The server doesn't support range requests (or there was nothing to resume): start from scratch.
+		resumeFrom = 0
+		file, err = os.Create(filePathPart)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "failed creating file %q", filePathPart)
 	}
@@ -111,46 +163,21 @@ func (m *Manager) Download(ctx context.Context, url string, filePath string, cal
 		if file != nil {
 			_ = file.Close()
 		}
-		if !downloadSuccess {
+		if !downloadSuccess && !resume {
 			_ = os.Remove(filePathPart)
 		}
 	}()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return errors.Wrapf(err, "failed creating request for %q", url)
+	contentLength := max(resp.ContentLength, 0) // -1 if unknown.
+	if contentLength > 0 {
+		contentLength += resumeFrom
 	}
-	m.setRequestHeader(req)
-	var resp *http.Response
-	resp, err = client.Do(req)
-	if err != nil {
-		return errors.Wrapf(err, "failed downloading %q", url)
-	}
-	if resp.StatusCode != http.StatusOK {
-		defer resp.Body.Close()
-		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		var jsonErr struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(bodyBytes, &jsonErr); err == nil && jsonErr.Error != "" {
-			return errors.Errorf("bad status code %d: %s", resp.StatusCode, jsonErr.Error)
-		}
-		if bodyStr := strings.TrimSpace(string(bodyBytes)); bodyStr != "" {
-			return errors.Errorf("bad status code %d: %s", resp.StatusCode, bodyStr)
-		}
-		if errMsg := resp.Header.Get("X-Error-Message"); errMsg != "" {
-			return errors.Errorf("bad status code %d: %q", resp.StatusCode, errMsg)
-		}
-		return errors.Errorf("bad status code %d: %s", resp.StatusCode, resp.Status)
-	}
-
-	contentLength := resp.ContentLength
 	if callback != nil {
-		callback(0, contentLength)
+		callback(resumeFrom, contentLength)
 	}
 	const maxBufferSize = 1 * 1024 * 1024
 	var buf [maxBufferSize]byte
-	downloadedBytes := int64(0)
+	downloadedBytes := resumeFrom
 	for {
 		if ctx.Err() != nil {
 			return CancellationError
@@ -160,7 +187,7 @@ func (m *Manager) Download(ctx context.Context, url string, filePath string, cal
 			if ctx.Err() != nil {
 				return CancellationError
 			}
-			return errors.Wrapf(err, "failed downloading %q", url)
+			return errors.Wrapf(readErr, "failed downloading %q", url)
 		}
 		if n > 0 {
 			wn, writeErr := file.Write(buf[:n])
@@ -172,22 +199,19 @@ func (m *Manager) Download(ctx context.Context, url string, filePath string, cal
 					url, filePathPart, n, wn)
 			}
 		}
-		if readErr == io.EOF {
-			break
-		}
 		downloadedBytes += int64(n)
-		if callback != nil {
+		if callback != nil && (n > 0 || readErr == io.EOF) {
 			callback(downloadedBytes, contentLength)
 		}
+		if readErr == io.EOF {
+			break
+		}
 	}
 	err = file.Close()
 	file = nil
 	if err != nil {
 		return errors.Wrapf(err, "failed closing file %q", filePathPart)
 	}
-	if err = resp.Body.Close(); err != nil {
-		return errors.Wrapf(err, "failed closing connection to %q", url)
-	}
 	if err = os.Rename(filePathPart, filePath); err != nil {
 		return errors.Wrapf(err, "failed moving %q to %q", filePathPart, filePath)
 	}
@@ -195,6 +219,129 @@ func (m *Manager) Download(ctx context.Context, url string, filePath string, cal
 	return nil
 }
 
+// get sends the GET request for url, with a "Range" header if resumeFrom > 0.
+func (m *Manager) get(ctx context.Context, client *http.Client, url string, resumeFrom int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating request for %q", url)
+	}
+	m.setRequestHeader(req)
+	if resumeFrom > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed downloading %q", url)
+	}
+	return resp, nil
+}
+
+// contentRangeStartsAt returns whether the "Content-Range" of a partial response starts at the given offset.
+func contentRangeStartsAt(resp *http.Response, start int64) bool {
+	var rangeStart int64
+	_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &rangeStart)
+	return err == nil && rangeStart == start
+}
+
+// badStatusError returns the error for an unexpected status, including the error message returned, if any.
+func badStatusError(resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var jsonErr struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(bodyBytes, &jsonErr); err == nil && jsonErr.Error != "" {
+		return errors.Errorf("bad status code %d: %s", resp.StatusCode, jsonErr.Error)
+	}
+	if bodyStr := strings.TrimSpace(string(bodyBytes)); bodyStr != "" {
+		return errors.Errorf("bad status code %d: %s", resp.StatusCode, bodyStr)
+	}
+	if errMsg := resp.Header.Get("X-Error-Message"); errMsg != "" {
+		return errors.Errorf("bad status code %d: %q", resp.StatusCode, errMsg)
+	}
+	return errors.Errorf("bad status code %d: %s", resp.StatusCode, resp.Status)
+}
+
+// DownloadRange downloads the bytes [start, end) of the given url, using an HTTP Range request, and returns them.
+// The server must support range requests (respond with "206 Partial Content"), otherwise it fails.
+//
+// This may lock if it reached the maximum number of parallel downloads.
+//
+// The context ctx can be used to interrupt the downloading.
+func (m *Manager) DownloadRange(ctx context.Context, url string, start, end int64) ([]byte, error) {
+	if start < 0 || end < start {
+		return nil, errors.Errorf("invalid byte range [%d, %d) for %q", start, end, url)
+	}
+	if start == end {
+		return []byte{}, nil
+	}
+
+	m.semaphore.Acquire()
+	defer m.semaphore.Release()
+
+	client := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			r.URL.Opaque = r.URL.Path
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed creating request for %q", url)
+	}
+	m.setRequestHeader(req)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, CancellationError
+		}
+		return nil, errors.Wrapf(err, "failed downloading range [%d, %d) of %q", start, end, url)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.Errorf("request for range [%d, %d) of %q failed: expected status %d (Partial Content), got %q",
+			start, end, url, http.StatusPartialContent, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, end-start))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, CancellationError
+		}
+		return nil, errors.Wrapf(err, "failed downloading range [%d, %d) of %q", start, end, url)
+	}
+	if int64(len(data)) != end-start {
+		return nil, errors.Errorf("range [%d, %d) of %q: got only %d bytes, wanted %d",
+			start, end, url, len(data), end-start)
+	}
+	return data, nil
+}
+
+// FetchStatus sends a GET request to url, and returns the status code and the header of the response, discarding
+// its body. It is used to check for the existence of resources, without downloading them to a file.
+//
+// Notice it may lock on the maximum number of parallel requests, so consider calling this on a separate goroutine.
+//
+// The context ctx can be used to interrupt the request.
+func (m *Manager) FetchStatus(ctx context.Context, url string) (statusCode int, header http.Header, err error) {
+	m.semaphore.Acquire()
+	defer m.semaphore.Release()
+
+	client := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			r.URL.Opaque = r.URL.Path
+			return nil
+		},
+	}
+	resp, err := m.get(ctx, client, url, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, resp.Header, nil
+}
+
 // FetchHeader fetches the header of a URL (using HTTP method "HEAD").
 //
 // Notice it may lock on the maximum number of parallel requests, so consider calling this on a separate goroutine.
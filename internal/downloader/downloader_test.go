@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
 	"net/http"
 	"net/http/httptest"
@@ -75,3 +76,105 @@ func TestDownload_Interrupted(t *testing.T) {
 	// Temporary .part file should NOT exist because it got cleaned up
 	assert.NoFileExists(t, targetFile+"."+Part)
 }
+
+func TestDownloadRange(t *testing.T) {
+	content := []byte("0123456789")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	manager := New()
+	data, err := manager.DownloadRange(context.Background(), server.URL, 3, 7)
+	require.NoError(t, err)
+	assert.Equal(t, "3456", string(data))
+
+	data, err = manager.DownloadRange(context.Background(), server.URL, 5, 5)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	_, err = manager.DownloadRange(context.Background(), server.URL, 7, 3)
+	require.Error(t, err)
+
+	// Servers that ignore the Range header are reported as errors.
+	noRangeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(content)
+	}))
+	defer noRangeServer.Close()
+	_, err = manager.DownloadRange(context.Background(), noRangeServer.URL, 3, 7)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Partial Content")
+}
+
+func TestResumableDownload(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	var supportsRange bool
+	var gotRange string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		if !supportsRange {
+			_, _ = w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	targetFile := filepath.Join(t.TempDir(), "file.bin")
+	manager := New()
+
+	// Resume from a partial download.
+	supportsRange = true
+	require.NoError(t, os.WriteFile(targetFile+"."+Part, content[:8], 0644))
+	var lastDownloaded, lastTotal int64
+	err := manager.ResumableDownload(context.Background(), server.URL, targetFile, func(downloadedBytes, totalBytes int64) {
+		lastDownloaded, lastTotal = downloadedBytes, totalBytes
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "bytes=8-", gotRange)
+	assert.Equal(t, int64(len(content)), lastDownloaded)
+	assert.Equal(t, int64(len(content)), lastTotal)
+	data, err := os.ReadFile(targetFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.NoFileExists(t, targetFile+"."+Part)
+
+	// Partial file larger than the content: the server can't satisfy the range, start from scratch.
+	require.NoError(t, os.WriteFile(targetFile+"."+Part, append(content, "extra"...), 0644))
+	require.NoError(t, manager.ResumableDownload(context.Background(), server.URL, targetFile, nil))
+	data, err = os.ReadFile(targetFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// Server without range support: the whole file is downloaded again.
+	supportsRange = false
+	require.NoError(t, os.WriteFile(targetFile+"."+Part, []byte("stale"), 0644))
+	require.NoError(t, manager.ResumableDownload(context.Background(), server.URL, targetFile, nil))
+	assert.Equal(t, "bytes=5-", gotRange)
+	data, err = os.ReadFile(targetFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestResumableDownload_InterruptedKeepsPart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		// Drop the connection before the whole content is sent.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	targetFile := filepath.Join(t.TempDir(), "file.bin")
+	err := New().ResumableDownload(context.Background(), server.URL, targetFile, nil)
+	require.Error(t, err)
+	assert.NoFileExists(t, targetFile)
+	data, err := os.ReadFile(targetFile + "." + Part)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", string(data))
+}
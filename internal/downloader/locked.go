@@ -14,9 +14,12 @@ import (
 //
 // If filePath exits and forceDownload is false, it is assumed to already have been correctly downloaded, and it will return immediately.
 //
-// It downloads the file to filePath+".tmp" and then atomically move it to filePath.
+// It downloads the file to filePath+".part" and then atomically move it to filePath.
+// If a previous download was interrupted, it is resumed from the ".part" file (see ResumableDownload), unless
+// forceDownload is set, in which case the download starts from scratch.
 //
 // It uses a temporary filePath+".lock" to coordinate multiple processes/programs trying to download the same file at the same time.
+// The ".part" file is only used while holding the lock, so resuming is safe with concurrent callers.
 func (m *Manager) LockedDownload(ctx context.Context, url, filePath string, forceDownload bool, progressCallback ProgressCallback) error {
 	if files.Exists(filePath) {
 		if !forceDownload {
@@ -55,7 +58,11 @@ func (m *Manager) LockedDownload(ctx context.Context, url, filePath string, forc
 			}
 		}()
 
-		mainErr = m.Download(ctx, url, filePath, progressCallback)
+		if forceDownload {
+			mainErr = m.Download(ctx, url, filePath, progressCallback)
+		} else {
+			mainErr = m.ResumableDownload(ctx, url, filePath, progressCallback)
+		}
 		if mainErr != nil {
 			mainErr = errors.WithMessagef(mainErr, "while downloading %q to %q", url, filePath)
 			return
@@ -53,3 +53,18 @@ func ExecOnFileLock(lockPath string, fn func()) (err error) {
 
 	return
 }
+
+// IsLocked returns whether lockPath is currently locked (see ExecOnFileLock) by this or another process.
+// It returns false if lockPath doesn't exist.
+func IsLocked(lockPath string) bool {
+	if !Exists(lockPath) {
+		return false
+	}
+	fileLock := flock.New(lockPath)
+	locked, err := fileLock.TryLock()
+	if err != nil || !locked {
+		return true
+	}
+	_ = fileLock.Unlock()
+	return false
+}
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"cmp"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"os"
 	"slices"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -19,6 +21,10 @@ const (
 	// Well-known GGUF metadata keys from the specification.
 	KeyGeneralArchitecture = "general.architecture"
 	KeyGeneralAlignment    = "general.alignment"
+
+	// Well-known tensor names used by llama.cpp.
+	TensorNameTokenEmbedding = "token_embd.weight"
+	TensorNameOutput         = "output.weight"
 )
 
 // Sanity limits to prevent excessive allocations from malicious files.
@@ -29,6 +35,31 @@ const (
 	maxTensorDims  = 8       // Maximum number of tensor dimensions.
 )
 
+// UnknownValueTypeError is returned (wrapped) by Open when a metadata value uses a type tag unknown to
+// this package -- e.g.: one added by a newer version of the GGUF specification.
+//
+// GGUF values are not length-prefixed, so a value of an unknown type can't be skipped, and the remainder
+// of the file can't be parsed. Use errors.As to retrieve it and identify the offending key.
+type UnknownValueTypeError struct {
+	// Key of the metadata value with the unknown type.
+	Key string
+
+	// Type is the unknown type tag.
+	Type uint32
+
+	// IsArrayElement is true if the unknown type is the element type of an array value.
+	IsArrayElement bool
+}
+
+// Error implements the error interface.
+func (e *UnknownValueTypeError) Error() string {
+	what := "value"
+	if e.IsArrayElement {
+		what = "array element"
+	}
+	return fmt.Sprintf("gguf: key %q has unknown %s type %d", e.Key, what, e.Type)
+}
+
 // File represents a parsed GGUF file. Create one with Open.
 type File struct {
 	// Version is the GGUF format version (2 or 3).
@@ -37,7 +68,7 @@ type File struct {
 	Alignment uint64
 	// KeyValues holds all metadata key-value pairs from the file header.
 	KeyValues []KeyValue
-	// TensorInfos holds parsed information about every tensor in the file.
+	// TensorInfos holds parsed information about every tensor in the file, sorted by offset.
 	TensorInfos []TensorInfo
 
 	kvByKey      map[string]*KeyValue
@@ -115,16 +146,16 @@ func Open(path string) (*File, error) {
 	for i := range file.KeyValues {
 		file.kvByKey[file.KeyValues[i].Key] = &file.KeyValues[i]
 	}
+	// Sort tensors by offset for optimal sequential I/O. It must be done before indexing them by name, since the
+	// index points into TensorInfos.
+	slices.SortFunc(file.TensorInfos, func(a, b TensorInfo) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
 	file.tensorByName = make(map[string]*TensorInfo, len(file.TensorInfos))
 	for i := range file.TensorInfos {
 		file.tensorByName[file.TensorInfos[i].Name] = &file.TensorInfos[i]
 	}
 
-	// Sort tensors by offset for optimal sequential I/O.
-	slices.SortFunc(file.TensorInfos, func(a, b TensorInfo) int {
-		return cmp.Compare(a.Offset, b.Offset)
-	})
-
 	// Compute aligned data offset.
 	file.Alignment = defaultAlignment
 	if kv, ok := file.GetKeyValue(KeyGeneralAlignment); ok {
@@ -158,6 +189,19 @@ func (f *File) GetKeyValue(key string) (KeyValue, bool) {
 	return *kv, true
 }
 
+// KeysWithPrefix returns the metadata keys starting with prefix (e.g. "general." or "quantize.imatrix."), in
+// the order they appear in the file. Unknown, non-standard keys are preserved as well, so this can be used to
+// discover them. An empty prefix returns all keys.
+func (f *File) KeysWithPrefix(prefix string) []string {
+	var keys []string
+	for _, kv := range f.KeyValues {
+		if strings.HasPrefix(kv.Key, prefix) {
+			keys = append(keys, kv.Key)
+		}
+	}
+	return keys
+}
+
 // GetTensorInfo looks up a tensor by name.
 func (f *File) GetTensorInfo(name string) (TensorInfo, bool) {
 	ti, ok := f.tensorByName[name]
@@ -186,6 +230,21 @@ func (f *File) ListTensorNames() []string {
 	return names
 }
 
+// OutputWeightName returns the name of the output projection (lm_head) tensor.
+//
+// Many GGUF files omit "output.weight" when it is tied to the token embeddings: in that case it returns
+// "token_embd.weight" and tied is true, so callers know to reuse (the transpose of) the embedding table.
+// If neither tensor is present it returns "" and false.
+func (f *File) OutputWeightName() (name string, tied bool) {
+	if _, found := f.tensorByName[TensorNameOutput]; found {
+		return TensorNameOutput, false
+	}
+	if _, found := f.tensorByName[TensorNameTokenEmbedding]; found {
+		return TensorNameTokenEmbedding, true
+	}
+	return "", false
+}
+
 // Binary reading helpers.
 
 // countingReader wraps an io.Reader and counts bytes read.
@@ -230,6 +289,10 @@ func readKeyValue(r io.Reader) (KeyValue, error) {
 
 	val, err := readValue(r, ggufValueType(typeTag))
 	if err != nil {
+		var unknownErr *UnknownValueTypeError
+		if errors.As(err, &unknownErr) {
+			unknownErr.Key = key
+		}
 		return KeyValue{}, errors.Wrapf(err, "read value for %q (type %d)", key, typeTag)
 	}
 
@@ -291,7 +354,7 @@ func readValue(r io.Reader, vtype ggufValueType) (Value, error) {
 	case valueTypeArray:
 		return readArray(r)
 	default:
-		return Value{}, errors.Errorf("unknown value type %d", vtype)
+		return Value{}, errors.WithStack(&UnknownValueTypeError{Type: uint32(vtype)})
 	}
 }
 
@@ -335,7 +398,7 @@ func readArray(r io.Reader) (Value, error) {
 	case valueTypeString:
 		return readStringArray(r, count)
 	default:
-		return Value{}, errors.Errorf("unsupported array element type %d", elemType)
+		return Value{}, errors.WithStack(&UnknownValueTypeError{Type: elemType, IsArrayElement: true})
 	}
 }
 
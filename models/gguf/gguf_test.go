@@ -45,6 +45,12 @@ func (b *ggufBuilder) writeKVUint32(key string, value uint32) {
 	b.writeUint32(value)
 }
 
+func (b *ggufBuilder) writeKVFloat32(key string, value float32) {
+	b.writeString(key)
+	b.writeUint32(uint32(valueTypeFloat32))
+	b.writeFloat32(value)
+}
+
 func (b *ggufBuilder) writeKVBool(key string, value bool) {
 	b.writeString(key)
 	b.writeUint32(uint32(valueTypeBool))
@@ -154,6 +160,32 @@ func TestOpenUnsupportedVersion(t *testing.T) {
 	assert.ErrorContains(t, err, "unsupported version")
 }
 
+func TestOpenUnknownValueType(t *testing.T) {
+	for _, isArray := range []bool{false, true} {
+		path := buildMinimalGGUF(t, 2, 0,
+			func(b *ggufBuilder) {
+				b.writeKVString("general.architecture", "llama")
+				b.writeString("future.key")
+				if isArray {
+					b.writeUint32(uint32(valueTypeArray))
+					b.writeUint32(99)
+					b.writeUint64(1)
+				} else {
+					b.writeUint32(99)
+				}
+			},
+			nil, nil)
+
+		_, err := Open(path)
+		require.Error(t, err)
+		var unknownErr *UnknownValueTypeError
+		require.ErrorAs(t, err, &unknownErr)
+		assert.Equal(t, "future.key", unknownErr.Key)
+		assert.Equal(t, uint32(99), unknownErr.Type)
+		assert.Equal(t, isArray, unknownErr.IsArrayElement)
+	}
+}
+
 func TestMetadataTypes(t *testing.T) {
 	path := buildMinimalGGUF(t, 4, 0,
 		func(b *ggufBuilder) {
@@ -188,6 +220,53 @@ func TestMetadataTypes(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestMetadataJSON(t *testing.T) {
+	path := buildMinimalGGUF(t, 4, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "llama")
+			b.writeKVUint32("llama.block_count", 32)
+			b.writeKVFloat32("llama.rope.freq_base", 10000)
+			b.writeString("tokenizer.ggml.token_type")
+			b.writeUint32(uint32(valueTypeArray))
+			b.writeUint32(uint32(valueTypeUint8))
+			b.writeUint64(3)
+			b.writeUint8(1)
+			b.writeUint8(3)
+			b.writeUint8(6)
+		},
+		nil, nil)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	data, err := f.MetadataJSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"general.architecture": {"type": "string", "value": "llama"},
+		"llama.block_count": {"type": "uint32", "value": 32},
+		"llama.rope.freq_base": {"type": "float32", "value": 10000},
+		"tokenizer.ggml.token_type": {"type": "array[uint8]", "value": [1, 3, 6]}
+	}`, string(data))
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	path := buildMinimalGGUF(t, 4, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "llama")
+			b.writeKVString("quantize.imatrix.file", "imatrix.dat")
+			b.writeKVUint32("quantize.imatrix.entries_count", 224)
+			b.writeKVString("general.name", "test")
+		},
+		nil, nil)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"quantize.imatrix.file", "quantize.imatrix.entries_count"},
+		f.KeysWithPrefix("quantize.imatrix."))
+	assert.Equal(t, []string{"general.architecture", "general.name"}, f.KeysWithPrefix("general."))
+	assert.Len(t, f.KeysWithPrefix(""), 4)
+	assert.Empty(t, f.KeysWithPrefix("tokenizer."))
+}
+
 func TestTensorInfoParsing(t *testing.T) {
 	// Create 2 F32 tensors: [3, 4] and [5].
 	// Tensor data: 12 floats (48 bytes) + 5 floats (20 bytes) = 68 bytes.
@@ -248,6 +327,33 @@ func TestListTensorNames(t *testing.T) {
 	assert.Contains(t, names, "b.weight")
 }
 
+func TestOutputWeightName(t *testing.T) {
+	build := func(names ...string) *File {
+		path := buildMinimalGGUF(t, 0, len(names), nil,
+			func(b *ggufBuilder) {
+				for i, name := range names {
+					b.writeTensorInfo(name, []uint64{4}, TensorTypeF32, uint64(i*16))
+				}
+			},
+			make([]byte, 16*len(names)))
+		f, err := Open(path)
+		require.NoError(t, err)
+		return f
+	}
+
+	name, tied := build(TensorNameTokenEmbedding, TensorNameOutput).OutputWeightName()
+	assert.Equal(t, TensorNameOutput, name)
+	assert.False(t, tied)
+
+	name, tied = build(TensorNameTokenEmbedding).OutputWeightName()
+	assert.Equal(t, TensorNameTokenEmbedding, name)
+	assert.True(t, tied)
+
+	name, tied = build("blk.0.attn_q.weight").OutputWeightName()
+	assert.Empty(t, name)
+	assert.False(t, tied)
+}
+
 func TestTensorTypeProperties(t *testing.T) {
 	tests := []struct {
 		tt        TensorType
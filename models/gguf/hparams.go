@@ -0,0 +1,177 @@
+package gguf
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Well-known architecture-specific GGUF metadata keys suffixes.
+// The full key is "<architecture>.<suffix>" (e.g. "llama.attention.head_count_kv"), see File.GetArchKeyValue.
+const (
+	ArchKeyContextLength                    = "context_length"
+	ArchKeyEmbeddingLength                  = "embedding_length"
+	ArchKeyBlockCount                       = "block_count"
+	ArchKeyFeedForwardLength                = "feed_forward_length"
+	ArchKeyFeedForwardActivation            = "feed_forward.act_fn" // Rarely present, see Hyperparameters.Activation.
+	ArchKeyExpertCount                      = "expert_count"
+	ArchKeyExpertUsedCount                  = "expert_used_count"
+	ArchKeyHeadCount                        = "attention.head_count"
+	ArchKeyHeadCountKV                      = "attention.head_count_kv"
+	ArchKeyKeyLength                        = "attention.key_length"
+	ArchKeyValueLength                      = "attention.value_length"
+	ArchKeyLayerNormEpsilon                 = "attention.layer_norm_epsilon"
+	ArchKeyLayerNormRMSEpsilon              = "attention.layer_norm_rms_epsilon"
+	ArchKeySlidingWindow                    = "attention.sliding_window"
+	ArchKeyRopeDimensionCount               = "rope.dimension_count"
+	ArchKeyRopeFreqBase                     = "rope.freq_base"
+	ArchKeyRopeScalingType                  = "rope.scaling.type"
+	ArchKeyRopeScalingFactor                = "rope.scaling.factor"
+	ArchKeyRopeScalingOriginalContextLength = "rope.scaling.original_context_length"
+)
+
+// activationByArchitecture maps architectures to the activation function of their feed-forward layers, using the
+// HuggingFace "hidden_act" names. GGUF files don't usually store it: it is implied by the architecture.
+var activationByArchitecture = map[string]string{
+	"llama":      "silu",
+	"mistral":    "silu",
+	"qwen2":      "silu",
+	"qwen3":      "silu",
+	"phi3":       "silu",
+	"command-r":  "silu",
+	"gemma":      "gelu_pytorch_tanh",
+	"gemma2":     "gelu_pytorch_tanh",
+	"gemma3":     "gelu_pytorch_tanh",
+	"starcoder2": "gelu_pytorch_tanh",
+	"gpt2":       "gelu_new",
+	"phi2":       "gelu_new",
+	"bert":       "gelu",
+	"nomic-bert": "silu",
+	"falcon":     "gelu",
+}
+
+// Hyperparameters holds the architecture hyperparameters of a model, read from the GGUF
+// "<architecture>.*" metadata.
+//
+// Fields are left as zero values if the corresponding key is not present, except where noted.
+type Hyperparameters struct {
+	Architecture      string
+	ContextLength     int
+	EmbeddingLength   int
+	BlockCount        int
+	FeedForwardLength int
+	ExpertCount       int
+	ExpertUsedCount   int
+
+	// HeadCount is the number of query attention heads.
+	HeadCount int
+
+	// HeadCountKV is the number of key/value heads. If not set it defaults to HeadCount (no grouped-query attention).
+	HeadCountKV int
+
+	// KeyLength and ValueLength are the per-head dimensions. If not set they default to EmbeddingLength / HeadCount.
+	KeyLength, ValueLength int
+
+	// LayerNormEpsilon is read from "attention.layer_norm_rms_epsilon" (and then UsesRMSNorm is true), or from
+	// "attention.layer_norm_epsilon".
+	LayerNormEpsilon float64
+	UsesRMSNorm      bool
+
+	// SlidingWindow is the size of the sliding window attention, or 0 if not used.
+	SlidingWindow int
+
+	RopeDimensionCount int
+	RopeFreqBase       float64
+
+	// RopeScalingType is the raw "rope.scaling.type" value, e.g. "linear" or "yarn", or "" if none.
+	RopeScalingType                  string
+	RopeScalingFactor                float64
+	RopeScalingOriginalContextLength int
+
+	// Activation is the feed-forward activation function, using the HuggingFace "hidden_act" names (e.g. "silu",
+	// "gelu_pytorch_tanh").
+	// GGUF files rarely store it (in "feed_forward.act_fn"), so usually it is implied by the architecture:
+	// llama, mistral, qwen2, qwen3, phi3 and command-r use "silu"; gemma, gemma2, gemma3 and starcoder2 use
+	// "gelu_pytorch_tanh"; gpt2 and phi2 use "gelu_new"; bert and falcon use "gelu".
+	// It is "" for other architectures.
+	Activation string
+}
+
+// GetArchKeyValue looks up the architecture-specific metadata key "<architecture>.<suffix>".
+// See the ArchKey* constants for well-known suffixes.
+func (f *File) GetArchKeyValue(suffix string) (KeyValue, bool) {
+	arch := f.Architecture()
+	if arch == "" {
+		return KeyValue{}, false
+	}
+	return f.GetKeyValue(arch + "." + suffix)
+}
+
+// Hyperparameters returns the architecture hyperparameters found in the metadata.
+// It returns an error if "general.architecture" is not set.
+func (f *File) Hyperparameters() (*Hyperparameters, error) {
+	arch := f.Architecture()
+	if arch == "" {
+		return nil, errors.Errorf("gguf: %q not set in %s", KeyGeneralArchitecture, f.path)
+	}
+	getInt := func(suffix string) int {
+		kv, _ := f.GetArchKeyValue(suffix)
+		return int(kv.Int64())
+	}
+	getFloat := func(suffix string) float64 {
+		kv, _ := f.GetArchKeyValue(suffix)
+		return kv.Float64()
+	}
+	getString := func(suffix string) string {
+		kv, _ := f.GetArchKeyValue(suffix)
+		return kv.String()
+	}
+
+	hp := &Hyperparameters{
+		Architecture:                     arch,
+		ContextLength:                    getInt(ArchKeyContextLength),
+		EmbeddingLength:                  getInt(ArchKeyEmbeddingLength),
+		BlockCount:                       getInt(ArchKeyBlockCount),
+		FeedForwardLength:                getInt(ArchKeyFeedForwardLength),
+		ExpertCount:                      getInt(ArchKeyExpertCount),
+		ExpertUsedCount:                  getInt(ArchKeyExpertUsedCount),
+		HeadCount:                        getInt(ArchKeyHeadCount),
+		HeadCountKV:                      getInt(ArchKeyHeadCountKV),
+		KeyLength:                        getInt(ArchKeyKeyLength),
+		ValueLength:                      getInt(ArchKeyValueLength),
+		SlidingWindow:                    getInt(ArchKeySlidingWindow),
+		RopeDimensionCount:               getInt(ArchKeyRopeDimensionCount),
+		RopeFreqBase:                     getFloat(ArchKeyRopeFreqBase),
+		RopeScalingType:                  getString(ArchKeyRopeScalingType),
+		RopeScalingFactor:                getFloat(ArchKeyRopeScalingFactor),
+		RopeScalingOriginalContextLength: getInt(ArchKeyRopeScalingOriginalContextLength),
+		Activation:                       getString(ArchKeyFeedForwardActivation),
+	}
+	if _, ok := f.GetArchKeyValue(ArchKeyLayerNormRMSEpsilon); ok {
+		hp.LayerNormEpsilon = getFloat(ArchKeyLayerNormRMSEpsilon)
+		hp.UsesRMSNorm = true
+	} else {
+		hp.LayerNormEpsilon = getFloat(ArchKeyLayerNormEpsilon)
+	}
+	if hp.HeadCountKV == 0 {
+		hp.HeadCountKV = hp.HeadCount
+	}
+	if hp.HeadCount > 0 {
+		if hp.KeyLength == 0 {
+			hp.KeyLength = hp.EmbeddingLength / hp.HeadCount
+		}
+		if hp.ValueLength == 0 {
+			hp.ValueLength = hp.EmbeddingLength / hp.HeadCount
+		}
+	}
+	if hp.Activation == "" {
+		hp.Activation = activationByArchitecture[arch]
+	}
+	return hp, nil
+}
+
+// Hyperparameters returns the architecture hyperparameters found in the metadata. See File.Hyperparameters.
+func (m *Model) Hyperparameters() (*Hyperparameters, error) {
+	if m.File == nil {
+		return nil, errors.Errorf("gguf: model not loaded, call Load() first")
+	}
+	return m.File.Hyperparameters()
+}
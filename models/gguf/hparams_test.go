@@ -0,0 +1,67 @@
+package gguf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperparameters(t *testing.T) {
+	path := buildMinimalGGUF(t, 8, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString(KeyGeneralArchitecture, "llama")
+			b.writeKVUint32("llama.embedding_length", 4096)
+			b.writeKVUint32("llama.block_count", 32)
+			b.writeKVUint32("llama.attention.head_count", 32)
+			b.writeKVUint32("llama.attention.head_count_kv", 8)
+			b.writeKVFloat32("llama.attention.layer_norm_rms_epsilon", 1e-5)
+			b.writeKVFloat32("llama.rope.freq_base", 500000)
+			b.writeKVString("llama.rope.scaling.type", "yarn")
+		},
+		nil, nil)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	hp, err := f.Hyperparameters()
+	require.NoError(t, err)
+
+	assert.Equal(t, "llama", hp.Architecture)
+	assert.Equal(t, 4096, hp.EmbeddingLength)
+	assert.Equal(t, 32, hp.BlockCount)
+	assert.Equal(t, 32, hp.HeadCount)
+	assert.Equal(t, 8, hp.HeadCountKV)
+	assert.Equal(t, 128, hp.KeyLength)
+	assert.Equal(t, 128, hp.ValueLength)
+	assert.True(t, hp.UsesRMSNorm)
+	assert.InDelta(t, 1e-5, hp.LayerNormEpsilon, 1e-9)
+	assert.Equal(t, 500000.0, hp.RopeFreqBase)
+	assert.Equal(t, "yarn", hp.RopeScalingType)
+	assert.Equal(t, "silu", hp.Activation)
+	assert.Zero(t, hp.ContextLength)
+
+	kv, ok := f.GetArchKeyValue(ArchKeyHeadCountKV)
+	require.True(t, ok)
+	assert.Equal(t, int64(8), kv.Int64())
+
+	// HeadCountKV defaults to HeadCount.
+	path = buildMinimalGGUF(t, 2, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString(KeyGeneralArchitecture, "gemma")
+			b.writeKVUint32("gemma.attention.head_count", 16)
+		},
+		nil, nil)
+	f, err = Open(path)
+	require.NoError(t, err)
+	hp, err = f.Hyperparameters()
+	require.NoError(t, err)
+	assert.Equal(t, 16, hp.HeadCountKV)
+	assert.Equal(t, "gelu_pytorch_tanh", hp.Activation)
+
+	// No architecture.
+	path = buildMinimalGGUF(t, 0, 0, nil, nil, nil)
+	f, err = Open(path)
+	require.NoError(t, err)
+	_, err = f.Hyperparameters()
+	assert.Error(t, err)
+}
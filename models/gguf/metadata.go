@@ -1,5 +1,13 @@
 package gguf
 
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
 // ggufValueType represents the type tag of a GGUF metadata value in the binary format.
 type ggufValueType uint32
 
@@ -227,3 +235,116 @@ func (v Value) Uint64s() []uint64 {
 		return nil
 	}
 }
+
+// TypeName returns the GGUF name of the value type (e.g. "uint32", "string"), or "array[<element type>]" for
+// arrays (e.g. "array[string]").
+func (v Value) TypeName() string {
+	switch v.data.(type) {
+	case uint8:
+		return "uint8"
+	case int8:
+		return "int8"
+	case uint16:
+		return "uint16"
+	case int16:
+		return "int16"
+	case uint32:
+		return "uint32"
+	case int32:
+		return "int32"
+	case uint64:
+		return "uint64"
+	case int64:
+		return "int64"
+	case float32:
+		return "float32"
+	case float64:
+		return "float64"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []uint8:
+		return "array[uint8]"
+	case []int8:
+		return "array[int8]"
+	case []uint16:
+		return "array[uint16]"
+	case []int16:
+		return "array[int16]"
+	case []uint32:
+		return "array[uint32]"
+	case []int32:
+		return "array[int32]"
+	case []uint64:
+		return "array[uint64]"
+	case []int64:
+		return "array[int64]"
+	case []float32:
+		return "array[float32]"
+	case []float64:
+		return "array[float64]"
+	case []bool:
+		return "array[bool]"
+	case []string:
+		return "array[string]"
+	default:
+		return "unknown"
+	}
+}
+
+// jsonValue converts the value to a form that can be marshaled to JSON: byte arrays are converted to
+// numbers (instead of base64), and non-finite floats to the strings "NaN", "+Inf" or "-Inf".
+func (v Value) jsonValue() any {
+	jsonFloat := func(f float64) any {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return f
+	}
+	switch data := v.data.(type) {
+	case float32:
+		return jsonFloat(float64(data))
+	case float64:
+		return jsonFloat(data)
+	case []uint8:
+		return v.Uint64s()
+	case []float32, []float64:
+		floats := v.Float64s()
+		values := make([]any, len(floats))
+		for i, f := range floats {
+			values[i] = jsonFloat(f)
+		}
+		return values
+	default:
+		return v.data
+	}
+}
+
+// MetadataJSON serializes all the metadata key-value pairs to an indented JSON object, mapping each key to an
+// object with its GGUF type (see Value.TypeName) and its value. E.g.:
+//
+//	{
+//	  "general.architecture": {
+//	    "type": "string",
+//	    "value": "llama"
+//	  },
+//	  ...
+//	}
+//
+// Keys are sorted, so the output can be diffed across models.
+func (f *File) MetadataJSON() ([]byte, error) {
+	type jsonKeyValue struct {
+		Type  string `json:"type"`
+		Value any    `json:"value"`
+	}
+	kvs := make(map[string]jsonKeyValue, len(f.KeyValues))
+	for _, kv := range f.KeyValues {
+		kvs[kv.Key] = jsonKeyValue{Type: kv.TypeName(), Value: kv.jsonValue()}
+	}
+	data, err := json.MarshalIndent(kvs, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "gguf: failed to serialize metadata of %s", f.path)
+	}
+	return data, nil
+}
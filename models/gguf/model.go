@@ -1,10 +1,10 @@
 package gguf
 
 import (
-	"path/filepath"
 	"sync"
 
 	"github.com/gomlx/compute"
+	"github.com/gomlx/compute/shapes"
 	"github.com/gomlx/go-huggingface/hub"
 	"github.com/gomlx/gomlx/core/tensors"
 	"github.com/pkg/errors"
@@ -48,6 +48,8 @@ func NewEmpty(repo *hub.Repo) *Model {
 }
 
 // Load downloads the first .gguf file from the repo and parses it.
+//
+// If the repository has no .gguf file, it returns a *hub.NoModelArtifactError.
 func (m *Model) Load() error {
 	if m.Repo == nil {
 		return errors.Errorf("gguf: repo is nil")
@@ -55,17 +57,15 @@ func (m *Model) Load() error {
 
 	// Find the first .gguf file in the repo.
 	var ggufFile string
-	for filename, err := range m.Repo.IterFileNames() {
+	for filename, err := range m.Repo.IterFileNamesMatching("*.gguf") {
 		if err != nil {
 			return errors.Wrapf(err, "gguf: list repo files")
 		}
-		if filepath.Ext(filename) == ".gguf" {
-			ggufFile = filename
-			break
-		}
+		ggufFile = filename
+		break
 	}
 	if ggufFile == "" {
-		return errors.Errorf("gguf: no .gguf file found in repository")
+		return &hub.NoModelArtifactError{RepoID: m.Repo.ID, Formats: []string{".gguf"}}
 	}
 
 	localPath, err := m.Repo.DownloadFile(ggufFile)
@@ -124,6 +124,30 @@ func (m *Model) GetKeyValue(key string) (KeyValue, bool) {
 	return m.File.GetKeyValue(key)
 }
 
+// KeysWithPrefix returns the metadata keys starting with prefix. See File.KeysWithPrefix.
+func (m *Model) KeysWithPrefix(prefix string) []string {
+	if m.File == nil {
+		return nil
+	}
+	return m.File.KeysWithPrefix(prefix)
+}
+
+// OutputWeightName returns the name of the output projection (lm_head) tensor. See File.OutputWeightName.
+func (m *Model) OutputWeightName() (name string, tied bool) {
+	if m.File == nil {
+		return "", false
+	}
+	return m.File.OutputWeightName()
+}
+
+// MetadataJSON serializes all the metadata key-value pairs to JSON. See File.MetadataJSON.
+func (m *Model) MetadataJSON() ([]byte, error) {
+	if m.File == nil {
+		return nil, errors.Errorf("gguf: model not loaded, call Load() first")
+	}
+	return m.File.MetadataJSON()
+}
+
 // Architecture returns the model architecture string.
 func (m *Model) Architecture() string {
 	if m.File == nil {
@@ -168,7 +192,7 @@ func (m *Model) IterTensors(backend compute.Backend) func(yield func(TensorAndNa
 			return
 		}
 
-		// TensorInfos are pre-sorted by offset in Open() for sequential I/O.
+		// TensorInfos are sorted by offset when the file is parsed, for sequential I/O.
 		for _, info := range m.File.TensorInfos {
 			t, err := reader.ReadTensor(backend, info.Name)
 			if err != nil {
@@ -182,6 +206,82 @@ func (m *Model) IterTensors(backend compute.Backend) func(yield func(TensorAndNa
 	}
 }
 
+// TensorChunk holds the rows [RowStart, RowEnd) of the first axis of a tensor, see Model.IterTensorsBudgeted.
+//
+// The chunk holds the whole tensor if RowStart == 0 and RowEnd == NumRows.
+type TensorChunk struct {
+	Name   string
+	Tensor *tensors.Tensor
+
+	RowStart, RowEnd int
+
+	// NumRows is the dimension of the first axis of the full tensor (1 for scalars).
+	NumRows int
+}
+
+// IterTensorsBudgeted iterates over all tensors sorted by offset (the order of File.TensorInfos), like IterTensors,
+// but it reads at most maxResidentBytes of (dequantized) tensor data at a time: tensors larger than that are yielded
+// in chunks of rows of their first axis, read with Reader.ReadTensorRows.
+//
+// Nothing is prefetched or accumulated: the next chunk is only read after the consumer returns from processing
+// the current one. So the budget is only honored if the consumer releases its references to each yielded tensor
+// (e.g.: after writing it to disk or moving it to a device) before continuing the iteration. This allows
+// processing models whose dequantized size is larger than the RAM available.
+//
+// It yields an error if a single row of a tensor (or a scalar) is larger than maxResidentBytes.
+func (m *Model) IterTensorsBudgeted(backend compute.Backend, maxResidentBytes int64) func(yield func(TensorChunk, error) bool) {
+	return func(yield func(TensorChunk, error) bool) {
+		if m.File == nil {
+			yield(TensorChunk{}, errors.Errorf("gguf: model not loaded, call Load() first"))
+			return
+		}
+		reader, err := m.getReader()
+		if err != nil {
+			yield(TensorChunk{}, err)
+			return
+		}
+
+		for _, info := range m.File.TensorInfos {
+			dtype, dims := info.GoMLXShape()
+			shape := shapes.Make(dtype, dims...)
+			numRows := 1
+			if len(dims) > 0 {
+				numRows = dims[0]
+			}
+			if int64(shape.ByteSize()) <= maxResidentBytes {
+				t, err := reader.ReadTensor(backend, info.Name)
+				if err != nil {
+					yield(TensorChunk{}, err)
+					return
+				}
+				if !yield(TensorChunk{Name: info.Name, Tensor: t, RowStart: 0, RowEnd: numRows, NumRows: numRows}, nil) {
+					return
+				}
+				continue
+			}
+
+			rowBytes := int64(shape.ByteSize()) / int64(max(numRows, 1))
+			if len(dims) == 0 || rowBytes > maxResidentBytes {
+				yield(TensorChunk{}, errors.Errorf("gguf: tensor %q (%s) can't be read within a budget of %d bytes: each row takes %d bytes",
+					info.Name, shape, maxResidentBytes, rowBytes))
+				return
+			}
+			rowsPerChunk := int(maxResidentBytes / rowBytes)
+			for start := 0; start < numRows; start += rowsPerChunk {
+				end := min(start+rowsPerChunk, numRows)
+				t, err := reader.ReadTensorRows(backend, info.Name, start, end)
+				if err != nil {
+					yield(TensorChunk{}, err)
+					return
+				}
+				if !yield(TensorChunk{Name: info.Name, Tensor: t, RowStart: start, RowEnd: end, NumRows: numRows}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
 // IterTensorsFromRepo creates a Model from a repo and iterates over all tensors.
 //
 // Tensors are loaded into the backend directly (e.g.: GPU, or a shared memory tensor on CPU, etc).
@@ -131,6 +131,83 @@ func (r *Reader) readQuantizedTensor(info TensorInfo, tensorOffset int64, output
 	return nil
 }
 
+// ReadTensorRows reads the rows [start, end) of the outermost dimension (the first GoMLX axis) of a tensor.
+// E.g.: a subset of the entries of a huge embedding table.
+//
+// The returned tensor has the same shape as the full tensor, except that the first axis has dimension end-start.
+// As with ReadTensor, quantized types are dequantized to Float32: only the blocks covering the requested rows are
+// read and dequantized, and then sub-sliced to the requested rows.
+func (r *Reader) ReadTensorRows(backend compute.Backend, tensorName string, start, end int) (*tensors.Tensor, error) {
+	info, ok := r.gguf.GetTensorInfo(tensorName)
+	if !ok {
+		return nil, errors.Errorf("gguf: tensor %q not found", tensorName)
+	}
+	dtype, dims := info.GoMLXShape()
+	if len(dims) == 0 {
+		return nil, errors.Errorf("gguf: tensor %q is a scalar, it has no rows to read", tensorName)
+	}
+	if start < 0 || end < start || end > dims[0] {
+		return nil, errors.Errorf("gguf: invalid rows range [%d, %d) for tensor %q with %d rows", start, end, tensorName, dims[0])
+	}
+	blockSize := info.Type.BlockSize()
+	typeSize := info.Type.TypeSize()
+	if blockSize == 0 || typeSize == 0 {
+		return nil, errors.Errorf("gguf: tensor %q has unsupported type %s", tensorName, info.Type)
+	}
+	rowElements := 1
+	for _, dim := range dims[1:] {
+		rowElements *= dim
+	}
+	dims[0] = end - start
+	shape := shapes.Make(dtype, dims...)
+	t, err := tensors.FromShapeForBackend(backend, 0, shape)
+	if err != nil {
+		return nil, errors.Wrapf(err, "gguf: failed to create tensor %q with shape %s", tensorName, shape)
+	}
+
+	// Range of blocks covering the requested elements.
+	firstElement, endElement := start*rowElements, end*rowElements
+	firstBlock := firstElement / blockSize
+	endBlock := (endElement + blockSize - 1) / blockSize
+	rawBuf := make([]byte, (endBlock-firstBlock)*typeSize)
+	rawOffset := r.gguf.DataOffset() + int64(info.Offset) + int64(firstBlock*typeSize)
+	n, err := r.file.ReadAt(rawBuf, rawOffset)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "gguf: read rows of tensor %q", tensorName)
+	}
+	if n != len(rawBuf) {
+		return nil, errors.Errorf("gguf: read rows of tensor %q: short read: got %d bytes, expected %d", tensorName, n, len(rawBuf))
+	}
+
+	if !info.Type.IsQuantized() {
+		// Native types have a block size of 1: the raw bytes are the requested rows.
+		t.MutableBytes(func(data []byte) {
+			copy(data, rawBuf)
+		})
+	} else {
+		dequant, err := getDequantFunc(info.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "gguf: tensor %q", tensorName)
+		}
+		blocksValues := make([]float32, (endBlock-firstBlock)*blockSize)
+		for b := range endBlock - firstBlock {
+			dequant(rawBuf[b*typeSize:(b+1)*typeSize], blocksValues[b*blockSize:(b+1)*blockSize])
+		}
+		skip := firstElement - firstBlock*blockSize
+		t.MutableFlatData(func(flatAny any) {
+			copy(flatAny.([]float32), blocksValues[skip:skip+endElement-firstElement])
+		})
+	}
+
+	if backend != nil {
+		err := t.ToDevice(backend, 0)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to move tensor %q (%s) to backend's device #0", tensorName, t.Shape())
+		}
+	}
+	return t, nil
+}
+
 // ReadTensorRaw reads the raw bytes for a tensor without dequantization.
 func (r *Reader) ReadTensorRaw(tensorName string) ([]byte, *TensorInfo, error) {
 	info, ok := r.gguf.GetTensorInfo(tensorName)
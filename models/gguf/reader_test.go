@@ -211,3 +211,157 @@ func TestReadMultipleTensors(t *testing.T) {
 		assert.InDelta(t, 11.0, v1, 0.01)
 	})
 }
+
+func TestReadTensorRows(t *testing.T) {
+	// F32 tensor with GoMLX shape [3, 2]: rows [1, 2], [3, 4], [5, 6].
+	f32Data := make([]byte, 24)
+	for i := range 6 {
+		binary.LittleEndian.PutUint32(f32Data[i*4:i*4+4], math.Float32bits(float32(i+1)))
+	}
+	// Q8_0 tensor with GoMLX shape [4, 16]: 64 elements, 2 blocks of 32 elements, so each block holds 2 rows.
+	// Scale = 1.0 and values = [0, 1, ..., 63].
+	q8Data := make([]byte, 2*34)
+	for b := range 2 {
+		binary.LittleEndian.PutUint16(q8Data[b*34:b*34+2], float32ToFloat16Bits(1.0))
+		for i := range 32 {
+			q8Data[b*34+2+i] = byte(b*32 + i)
+		}
+	}
+	tensorData := append(f32Data, make([]byte, 32-len(f32Data))...) // Align next tensor to 32 bytes.
+	tensorData = append(tensorData, q8Data...)
+
+	path := buildMinimalGGUF(t, 1, 2,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "test")
+		},
+		func(b *ggufBuilder) {
+			b.writeTensorInfo("f32", []uint64{2, 3}, TensorTypeF32, 0)
+			b.writeTensorInfo("q8", []uint64{16, 4}, TensorTypeQ8_0, 32)
+		},
+		tensorData)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	reader, err := NewReader(f)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	tensor, err := reader.ReadTensorRows(nil, "f32", 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 2}, tensor.Shape().Dimensions)
+	tensor.MutableFlatData(func(flatAny any) {
+		assert.Equal(t, []float32{3, 4, 5, 6}, flatAny.([]float32))
+	})
+
+	// Rows 1 to 3 span the two quantized blocks, each only partially.
+	tensor, err = reader.ReadTensorRows(nil, "q8", 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 16}, tensor.Shape().Dimensions)
+	tensor.MutableFlatData(func(flatAny any) {
+		got := flatAny.([]float32)
+		require.Len(t, got, 32)
+		for i, v := range got {
+			assert.InDelta(t, float32(16+i), v, 0.01, "Q8_0 rows index %d", i)
+		}
+	})
+
+	_, err = reader.ReadTensorRows(nil, "q8", 2, 5)
+	assert.ErrorContains(t, err, "invalid rows range")
+}
+
+func TestIterTensorsBudgeted(t *testing.T) {
+	// F32 tensor with GoMLX shape [3, 2] (24 bytes), and Q8_0 tensor with GoMLX shape [4, 16] (256 bytes
+	// dequantized, 64 bytes per row), with values [0, 1, ..., 63].
+	f32Data := make([]byte, 24)
+	for i := range 6 {
+		binary.LittleEndian.PutUint32(f32Data[i*4:i*4+4], math.Float32bits(float32(i+1)))
+	}
+	q8Data := make([]byte, 2*34)
+	for b := range 2 {
+		binary.LittleEndian.PutUint16(q8Data[b*34:b*34+2], float32ToFloat16Bits(1.0))
+		for i := range 32 {
+			q8Data[b*34+2+i] = byte(b*32 + i)
+		}
+	}
+	tensorData := append(f32Data, make([]byte, 32-len(f32Data))...) // Align next tensor to 32 bytes.
+	tensorData = append(tensorData, q8Data...)
+	path := buildMinimalGGUF(t, 1, 2,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "test")
+		},
+		func(b *ggufBuilder) {
+			b.writeTensorInfo("f32", []uint64{2, 3}, TensorTypeF32, 0)
+			b.writeTensorInfo("q8", []uint64{16, 4}, TensorTypeQ8_0, 32)
+		},
+		tensorData)
+	f, err := Open(path)
+	require.NoError(t, err)
+	m := &Model{File: f}
+	defer m.Close()
+
+	type chunkRange struct {
+		name                      string
+		rowStart, rowEnd, numRows int
+	}
+	var got []chunkRange
+	var q8Values []float32
+	for chunk, err := range m.IterTensorsBudgeted(nil, 130) {
+		require.NoError(t, err)
+		got = append(got, chunkRange{chunk.Name, chunk.RowStart, chunk.RowEnd, chunk.NumRows})
+		assert.LessOrEqual(t, int(chunk.Tensor.Shape().ByteSize()), 130)
+		if chunk.Name == "q8" {
+			chunk.Tensor.MutableFlatData(func(flatAny any) {
+				q8Values = append(q8Values, flatAny.([]float32)...)
+			})
+		}
+	}
+	// The f32 tensor fits whole, the q8 tensor is read 2 rows (128 bytes) at a time.
+	assert.Equal(t, []chunkRange{{"f32", 0, 3, 3}, {"q8", 0, 2, 4}, {"q8", 2, 4, 4}}, got)
+	require.Len(t, q8Values, 64)
+	for i, v := range q8Values {
+		assert.InDelta(t, float32(i), v, 0.01, "Q8_0 index %d", i)
+	}
+
+	// A budget smaller than a row of the q8 tensor fails.
+	var lastErr error
+	for _, err := range m.IterTensorsBudgeted(nil, 10) {
+		if err != nil {
+			lastErr = err
+		}
+	}
+	assert.ErrorContains(t, lastErr, `tensor "q8"`)
+}
+
+func TestIterTensorsBudgeted_OffsetOrder(t *testing.T) {
+	// The tensor infos are listed in the reverse order of their data.
+	tensorData := make([]byte, 64)
+	for i := range 16 {
+		binary.LittleEndian.PutUint32(tensorData[i*4:i*4+4], math.Float32bits(float32(i)))
+	}
+	path := buildMinimalGGUF(t, 1, 2,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "test")
+		},
+		func(b *ggufBuilder) {
+			b.writeTensorInfo("second", []uint64{8}, TensorTypeF32, 32)
+			b.writeTensorInfo("first", []uint64{8}, TensorTypeF32, 0)
+		},
+		tensorData)
+	f, err := Open(path)
+	require.NoError(t, err)
+	m := &Model{File: f}
+	defer m.Close()
+
+	var names []string
+	for chunk, err := range m.IterTensorsBudgeted(nil, 1024) {
+		require.NoError(t, err)
+		names = append(names, chunk.Name)
+	}
+	assert.Equal(t, []string{"first", "second"}, names)
+
+	// Lookups by name still find the right tensor.
+	info, found := f.GetTensorInfo("second")
+	require.True(t, found)
+	assert.Equal(t, "second", info.Name)
+	assert.Equal(t, uint64(32), info.Offset)
+}
@@ -0,0 +1,102 @@
+package gguf
+
+import (
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/pkg/errors"
+)
+
+// Well-known GGUF tokenizer metadata keys, as written by llama.cpp's conversion scripts.
+const (
+	KeyTokenizerModel        = "tokenizer.ggml.model"
+	KeyTokenizerTokens       = "tokenizer.ggml.tokens"
+	KeyTokenizerBOSID        = "tokenizer.ggml.bos_token_id"
+	KeyTokenizerEOSID        = "tokenizer.ggml.eos_token_id"
+	KeyTokenizerUnknownID    = "tokenizer.ggml.unknown_token_id"
+	KeyTokenizerSeparatorID  = "tokenizer.ggml.seperator_token_id" // Sic: the misspelling is part of the spec.
+	KeyTokenizerPaddingID    = "tokenizer.ggml.padding_token_id"
+	KeyTokenizerMaskID       = "tokenizer.ggml.mask_token_id"
+	KeyTokenizerCLSID        = "tokenizer.ggml.cls_token_id"
+	KeyTokenizerAddBOS       = "tokenizer.ggml.add_bos_token"
+	KeyTokenizerAddEOS       = "tokenizer.ggml.add_eos_token"
+	KeyTokenizerChatTemplate = "tokenizer.chat_template"
+)
+
+// tokenizerClassByGGMLModel maps the "tokenizer.ggml.model" value to the closest HuggingFace tokenizer class.
+var tokenizerClassByGGMLModel = map[string]string{
+	"llama": "LlamaTokenizer",
+	"gpt2":  "GPT2Tokenizer",
+	"bert":  "BertTokenizer",
+	"t5":    "T5Tokenizer",
+}
+
+// TokenizerConfig builds an api.Config (the equivalent of HuggingFace's tokenizer_config.json) from the
+// GGUF "tokenizer.*" metadata.
+//
+// Special tokens (bos, eos, unk, pad, sep, cls, mask) are resolved from their "tokenizer.ggml.*_token_id" keys
+// into the token strings of "tokenizer.ggml.tokens", and their ids are recorded in Config.AddedTokensDecoder,
+// so the same config-driven special-token resolution used for HuggingFace repos works for GGUF-only models.
+//
+// It returns an error if the file has no "tokenizer.ggml.tokens" or if a special token id is out of range.
+func (f *File) TokenizerConfig() (*api.Config, error) {
+	kv, ok := f.GetKeyValue(KeyTokenizerTokens)
+	if !ok {
+		return nil, errors.Errorf("gguf: no tokenizer metadata (%q) in %s", KeyTokenizerTokens, f.path)
+	}
+	tokens := kv.Strings()
+	config := &api.Config{
+		ConfigFile:         f.path,
+		AddedTokensDecoder: make(map[int]api.TokensDecoder),
+	}
+	if kv, ok := f.GetKeyValue(KeyTokenizerModel); ok {
+		config.TokenizerClass = tokenizerClassByGGMLModel[kv.String()]
+	}
+
+	specialTokens := []struct {
+		key   string
+		field *string
+	}{
+		{KeyTokenizerBOSID, &config.BosToken},
+		{KeyTokenizerEOSID, &config.EosToken},
+		{KeyTokenizerUnknownID, &config.UnkToken},
+		{KeyTokenizerSeparatorID, &config.SepToken},
+		{KeyTokenizerPaddingID, &config.PadToken},
+		{KeyTokenizerMaskID, &config.MaskToken},
+		{KeyTokenizerCLSID, &config.ClsToken},
+	}
+	for _, st := range specialTokens {
+		kv, ok := f.GetKeyValue(st.key)
+		if !ok {
+			continue
+		}
+		id := kv.Int64()
+		if id < 0 || id >= int64(len(tokens)) {
+			return nil, errors.Errorf("gguf: %s=%d is out of range for a vocabulary of %d tokens", st.key, id, len(tokens))
+		}
+		*st.field = tokens[id]
+		config.AddedTokensDecoder[int(id)] = api.TokensDecoder{Content: tokens[id], Special: true}
+	}
+
+	if kv, ok := f.GetKeyValue(KeyTokenizerAddBOS); ok {
+		config.AddBosToken = kv.Bool()
+	}
+	if kv, ok := f.GetKeyValue(KeyTokenizerAddEOS); ok {
+		config.AddEosToken = kv.Bool()
+	}
+	if kv, ok := f.GetKeyValue(KeyTokenizerChatTemplate); ok {
+		config.ChatTemplate = kv.String()
+	}
+	if arch := f.Architecture(); arch != "" {
+		if kv, ok := f.GetKeyValue(arch + ".context_length"); ok {
+			config.ModelMaxLength = float64(kv.Uint64())
+		}
+	}
+	return config, nil
+}
+
+// TokenizerConfig builds an api.Config from the GGUF tokenizer metadata. See File.TokenizerConfig.
+func (m *Model) TokenizerConfig() (*api.Config, error) {
+	if m.File == nil {
+		return nil, errors.Errorf("gguf: model not loaded, call Load() first")
+	}
+	return m.File.TokenizerConfig()
+}
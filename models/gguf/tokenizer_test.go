@@ -0,0 +1,65 @@
+package gguf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizerConfig(t *testing.T) {
+	path := buildMinimalGGUF(t, 8, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "llama")
+			b.writeKVUint32("llama.context_length", 4096)
+			b.writeKVString(KeyTokenizerModel, "llama")
+			b.writeKVStringArray(KeyTokenizerTokens, []string{"<unk>", "<s>", "</s>", "hello"})
+			b.writeKVUint32(KeyTokenizerUnknownID, 0)
+			b.writeKVUint32(KeyTokenizerBOSID, 1)
+			b.writeKVUint32(KeyTokenizerEOSID, 2)
+			b.writeKVBool(KeyTokenizerAddBOS, true)
+		},
+		nil, nil)
+
+	f, err := Open(path)
+	require.NoError(t, err)
+	config, err := f.TokenizerConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, "LlamaTokenizer", config.TokenizerClass)
+	assert.Equal(t, "<unk>", config.UnkToken)
+	assert.Equal(t, "<s>", config.BosToken)
+	assert.Equal(t, "</s>", config.EosToken)
+	assert.Empty(t, config.PadToken)
+	assert.True(t, config.AddBosToken)
+	assert.False(t, config.AddEosToken)
+	assert.Equal(t, 4096.0, config.ModelMaxLength)
+	require.Len(t, config.AddedTokensDecoder, 3)
+	assert.Equal(t, "</s>", config.AddedTokensDecoder[2].Content)
+	assert.True(t, config.AddedTokensDecoder[2].Special)
+}
+
+func TestTokenizerConfigErrors(t *testing.T) {
+	// No tokenizer metadata.
+	path := buildMinimalGGUF(t, 1, 0,
+		func(b *ggufBuilder) {
+			b.writeKVString("general.architecture", "llama")
+		},
+		nil, nil)
+	f, err := Open(path)
+	require.NoError(t, err)
+	_, err = f.TokenizerConfig()
+	assert.ErrorContains(t, err, "no tokenizer metadata")
+
+	// Special token id out of range.
+	path = buildMinimalGGUF(t, 2, 0,
+		func(b *ggufBuilder) {
+			b.writeKVStringArray(KeyTokenizerTokens, []string{"a", "b"})
+			b.writeKVUint32(KeyTokenizerEOSID, 7)
+		},
+		nil, nil)
+	f, err = Open(path)
+	require.NoError(t, err)
+	_, err = f.TokenizerConfig()
+	assert.ErrorContains(t, err, "out of range")
+}
@@ -0,0 +1,115 @@
+package safetensors
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/gomlx/compute"
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/dtypes/bfloat16"
+	"github.com/gomlx/compute/dtypes/float16"
+	"github.com/gomlx/compute/shapes"
+	"github.com/gomlx/gomlx/core/tensors"
+	"github.com/pkg/errors"
+)
+
+// ReadTensorAs reads a tensor by name from the file, converting it to the float dtype during the load
+// (e.g. BF16 or F16 weights to Float32), without intermediary tensors.
+//
+// Both the tensor dtype and dtype must be one of Float64, Float32, Float16 or BFloat16. If they are the same,
+// it is the same as ReadTensor.
+//
+// The tensor will be directly created on the given backend, if it is not nil.
+// Otherwise, it creates a local (on-host) tensor.
+func (mr *TensorReader) ReadTensorAs(backend compute.Backend, tensorName string, dtype dtypes.DType) (*tensors.Tensor, error) {
+	meta, ok := mr.Header.Tensors[tensorName]
+	if !ok {
+		return nil, errors.Errorf("tensor %s not found", tensorName)
+	}
+	if mr.mmapBuf == nil {
+		return nil, errors.New("file is not mmaped")
+	}
+	shape, err := meta.checkLayout(tensorName, mr.dataSize())
+	if err != nil {
+		return nil, err
+	}
+	if shape.DType == dtype {
+		return mr.ReadTensor(backend, tensorName)
+	}
+	decodeFn := decodeFloatFn(shape.DType)
+	encodeFn := encodeFloatFn(dtype)
+	if decodeFn == nil || encodeFn == nil {
+		return nil, errors.Errorf("can't convert tensor %q from %s to %s: only conversions between float dtypes "+
+			"(Float64, Float32, Float16 and BFloat16) are supported", tensorName, shape.DType, dtype)
+	}
+
+	tensorOffset := mr.dataOffset + meta.DataOffsets[0]
+	tensorEnd := mr.dataOffset + meta.DataOffsets[1]
+	src := mr.mmapBuf[tensorOffset:tensorEnd]
+	convertFn := func(dst []byte) {
+		srcSize, dstSize := shape.DType.Size(), dtype.Size()
+		for i := range shape.Size() {
+			encodeFn(dst[i*dstSize:], decodeFn(src[i*srcSize:]))
+		}
+	}
+	convertedShape := shapes.Make(dtype, shape.Dimensions...)
+	if backend == nil {
+		t := tensors.FromShape(convertedShape)
+		t.MutableBytes(convertFn)
+		return t, nil
+	}
+	converted := make([]byte, convertedShape.ByteSize())
+	convertFn(converted)
+	t, err := tensors.FromRaw(backend, 0, convertedShape, converted)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create tensor %q (%s) from bytes", tensorName, convertedShape)
+	}
+	return t, nil
+}
+
+// LoadSafetensorAs reads the tensor tensorName from the local .safetensors file into a local (on-host) tensor,
+// converting it to the float dtype. See TensorReader.ReadTensorAs.
+func LoadSafetensorAs(filename, tensorName string, dtype dtypes.DType) (*tensors.Tensor, error) {
+	reader, err := OpenTensorReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	return reader.ReadTensorAs(nil, tensorName, dtype)
+}
+
+// decodeFloatFn returns a function that decodes one little-endian value of the float dtype, or nil if dtype is
+// not a supported float.
+func decodeFloatFn(dtype dtypes.DType) func(src []byte) float64 {
+	switch dtype {
+	case dtypes.Float64:
+		return func(src []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(src)) }
+	case dtypes.Float32:
+		return func(src []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(src))) }
+	case dtypes.Float16:
+		return func(src []byte) float64 { return float16.FromBits(binary.LittleEndian.Uint16(src)).Float64() }
+	case dtypes.BFloat16:
+		return func(src []byte) float64 { return bfloat16.FromBits(binary.LittleEndian.Uint16(src)).Float64() }
+	}
+	return nil
+}
+
+// encodeFloatFn returns a function that encodes one value as the float dtype, in the host byte order (as stored
+// in tensors), or nil if dtype is not a supported float.
+func encodeFloatFn(dtype dtypes.DType) func(dst []byte, value float64) {
+	switch dtype {
+	case dtypes.Float64:
+		return func(dst []byte, value float64) { binary.NativeEndian.PutUint64(dst, math.Float64bits(value)) }
+	case dtypes.Float32:
+		return func(dst []byte, value float64) {
+			binary.NativeEndian.PutUint32(dst, math.Float32bits(float32(value)))
+		}
+	case dtypes.Float16:
+		return func(dst []byte, value float64) { binary.NativeEndian.PutUint16(dst, float16.FromFloat64(value).Bits()) }
+	case dtypes.BFloat16:
+		return func(dst []byte, value float64) {
+			binary.NativeEndian.PutUint16(dst, bfloat16.FromFloat64(value).Bits())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package safetensors
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/dtypes/bfloat16"
+	"github.com/gomlx/compute/dtypes/float16"
+	"github.com/gomlx/gomlx/core/tensors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSafetensorAs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	require.NoError(t, Write(path, map[string]*tensors.Tensor{
+		"f16":  tensors.FromValue([][]float16.Float16{float16.FromFloat32s(1, -2.5), float16.FromFloat32s(0.25, 1024)}),
+		"bf16": tensors.FromValue(bfloat16.FromFloat32s(3, -0.5)),
+		"f32":  tensors.FromValue([]float32{1.5, 2}),
+		"ids":  tensors.FromValue([]int32{1, 2}),
+	}, nil))
+
+	tensor, err := LoadSafetensorAs(path, "f16", dtypes.Float32)
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, -2.5}, {0.25, 1024}}, tensor.Value())
+
+	tensor, err = LoadSafetensorAs(path, "bf16", dtypes.Float32)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{3, -0.5}, tensor.Value())
+
+	tensor, err = LoadSafetensorAs(path, "bf16", dtypes.Float64)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, -0.5}, tensor.Value())
+
+	tensor, err = LoadSafetensorAs(path, "f32", dtypes.BFloat16)
+	require.NoError(t, err)
+	assert.Equal(t, bfloat16.FromFloat32s(1.5, 2), tensor.Value())
+
+	// Same dtype: no conversion.
+	tensor, err = LoadSafetensorAs(path, "f32", dtypes.Float32)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1.5, 2}, tensor.Value())
+
+	_, err = LoadSafetensorAs(path, "ids", dtypes.Float32)
+	assert.ErrorContains(t, err, "only conversions between float dtypes")
+	_, err = LoadSafetensorAs(path, "f16", dtypes.Int32)
+	assert.ErrorContains(t, err, "only conversions between float dtypes")
+	_, err = LoadSafetensorAs(path, "missing", dtypes.Float32)
+	assert.ErrorContains(t, err, "not found")
+}
@@ -1,10 +1,16 @@
 package safetensors
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/binary"
 	"encoding/json"
 	"io"
+	"maps"
+	"math"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/gomlx/compute/dtypes"
@@ -17,6 +23,35 @@ type Header struct {
 	Metadata map[string]interface{}     // Optional __metadata__ field
 }
 
+// TotalSize returns the "total_size" field of the header metadata: the size in bytes of the tensors data, as set
+// by some exporters (and by Model.Consolidate). It returns false if it is missing or not an integer.
+func (h *Header) TotalSize() (int64, bool) {
+	return metadataInt64(h.Metadata, "total_size")
+}
+
+// Format returns the "format" field of the header metadata, the framework that saved the file (e.g.: "pt", "tf",
+// "flax", "mlx"), or "" if missing.
+func (h *Header) Format() string {
+	format, _ := h.Metadata["format"].(string)
+	return format
+}
+
+// metadataInt64 returns the integer value of the metadata key, which may be stored as a JSON number or as a string
+// (safetensors headers only store strings).
+func metadataInt64(metadata map[string]any, key string) (int64, bool) {
+	switch value := metadata[key].(type) {
+	case float64:
+		if value != math.Trunc(value) {
+			return 0, false
+		}
+		return int64(value), true
+	case string:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
 // parseHeader reads and parses the header from a safetensors file.
 // Safetensor format:
 //
@@ -26,6 +61,9 @@ type Header struct {
 //
 // It returns the parsed header of the file, the offset of the actual data (same as the total header size)
 // and any error that may have occurred.
+//
+// The tensors "data_offsets" are always checked to be within the file (see Header.checkBounds), and if the Model
+// has strict validation enabled, also to be contiguous (see Header.Validate). The Model can be nil.
 func (m *Model) parseHeader(path string) (*Header, int64, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -33,13 +71,37 @@ func (m *Model) parseHeader(path string) (*Header, int64, error) {
 	}
 	defer f.Close()
 
+	header, dataOffset, err := readHeader(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to stat file %s", path)
+	}
+	validateFn := header.checkBounds
+	if m != nil && m.strictValidation {
+		validateFn = header.Validate
+	}
+	if err := validateFn(fi.Size() - dataOffset); err != nil {
+		return nil, 0, errors.WithMessagef(err, "invalid safetensors file %s", path)
+	}
+	return header, dataOffset, nil
+}
+
+// maxHeaderSize is a sanity check on the size of the JSON header.
+const maxHeaderSize = 100 * 1024 * 1024
+
+// readHeader reads and parses the header from the beginning of a safetensors file (see Model.parseHeader).
+// It returns the parsed header and the offset of the tensors data.
+func readHeader(f io.Reader) (*Header, int64, error) {
 	// Read header size (8 bytes, little-endian)
 	var headerSize uint64
 	if err := binary.Read(f, binary.LittleEndian, &headerSize); err != nil {
 		return nil, 0, errors.Wrap(err, "failed to read header size")
 	}
 
-	if headerSize > 100*1024*1024 { // Sanity check: 100MB max header
+	if headerSize > maxHeaderSize {
 		return nil, 0, errors.Errorf("header size too large: %d bytes", headerSize)
 	}
 
@@ -81,6 +143,111 @@ func (m *Model) parseHeader(path string) (*Header, int64, error) {
 	return header, dataOffset, nil
 }
 
+// remoteHeader is a header fetched with HTTP Range requests, see Model.fetchHeader.
+type remoteHeader struct {
+	header     *Header
+	dataOffset int64
+}
+
+// fetchHeader returns the header and the data offset of the repository file filename, downloading only
+// the header bytes (with HTTP Range requests), and caching the result.
+//
+// As in parseHeader, the tensors "data_offsets" are checked to be within the file, whose size is given by
+// the repository info (see hub.Repo.FileSize).
+func (m *Model) fetchHeader(filename string) (*Header, int64, error) {
+	if rh, ok := m.remoteHeaders[filename]; ok {
+		return rh.header, rh.dataOffset, nil
+	}
+	if m.Repo == nil {
+		return nil, 0, errors.New("Repo is nil, create a ModelSafetensor with NewModelSafetensor first")
+	}
+	sizeBytes, err := m.Repo.DownloadFileRange(filename, 0, 8)
+	if err != nil {
+		return nil, 0, errors.WithMessagef(err, "failed to fetch header size of %s", filename)
+	}
+	headerSize := binary.LittleEndian.Uint64(sizeBytes)
+	if headerSize > maxHeaderSize {
+		return nil, 0, errors.Errorf("header size of %s too large: %d bytes", filename, headerSize)
+	}
+	headerBytes, err := m.Repo.DownloadFileRange(filename, 8, 8+int64(headerSize))
+	if err != nil {
+		return nil, 0, errors.WithMessagef(err, "failed to fetch header of %s", filename)
+	}
+	header, dataOffset, err := readHeader(io.MultiReader(bytes.NewReader(sizeBytes), bytes.NewReader(headerBytes)))
+	if err != nil {
+		return nil, 0, errors.WithMessagef(err, "failed to parse header of %s", filename)
+	}
+	fileSize, err := m.Repo.FileSize(filename)
+	if err != nil {
+		return nil, 0, errors.WithMessagef(err, "failed to get the size of %s", filename)
+	}
+	validateFn := header.checkBounds
+	if m.strictValidation {
+		validateFn = header.Validate
+	}
+	if err := validateFn(fileSize - dataOffset); err != nil {
+		return nil, 0, errors.WithMessagef(err, "invalid safetensors file %s", filename)
+	}
+	if m.remoteHeaders == nil {
+		m.remoteHeaders = make(map[string]remoteHeader)
+	}
+	m.remoteHeaders[filename] = remoteHeader{header: header, dataOffset: dataOffset}
+	return header, dataOffset, nil
+}
+
+// Validate checks that the tensors "data_offsets" are consistent with a data section (the file contents after
+// the header) of dataSize bytes: each tensor must be within [0, dataSize), have the size implied by its dtype and
+// shape (if the dtype is known), and together the tensors must cover the data section contiguously, without
+// overlaps or gaps.
+//
+// It returns an error naming the first offending tensor, in data order.
+func (h *Header) Validate(dataSize int64) error {
+	names := make([]string, 0, len(h.Tensors))
+	for name := range h.Tensors {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(h.Tensors[a].DataOffsets[0], h.Tensors[b].DataOffsets[0]),
+			cmp.Compare(a, b))
+	})
+
+	var pos int64
+	for _, name := range names {
+		if err := h.Tensors[name].checkBounds(name, dataSize); err != nil {
+			return err
+		}
+		start, end := h.Tensors[name].DataOffsets[0], h.Tensors[name].DataOffsets[1]
+		if start < pos {
+			return errors.Errorf("tensor %q data_offsets [%d, %d] overlap previous tensor ending at %d", name, start, end, pos)
+		}
+		if start > pos {
+			return errors.Errorf("tensor %q data_offsets [%d, %d] leave a gap after previous tensor ending at %d", name, start, end, pos)
+		}
+		if shape, err := h.Tensors[name].GoMLXShape(); err == nil && shape.ByteSize() != end-start {
+			return errors.Errorf("tensor %q data_offsets [%d, %d] span %d bytes, but its shape %s requires %d bytes", name, start, end, end-start, shape, shape.ByteSize())
+		}
+		pos = end
+	}
+	if pos != dataSize {
+		return errors.Errorf("tensors data ends at %d, but the data size is %d", pos, dataSize)
+	}
+	return nil
+}
+
+// checkBounds checks that the tensors "data_offsets" are valid ranges (0 <= start <= end) within a data section
+// of dataSize bytes, so reading them can't fail. Unlike Validate, it accepts overlaps and gaps between tensors.
+//
+// It returns an error naming the first offending tensor, in name order.
+func (h *Header) checkBounds(dataSize int64) error {
+	for _, name := range slices.Sorted(maps.Keys(h.Tensors)) {
+		if err := h.Tensors[name].checkBounds(name, dataSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func dtypeToGoMLX(stDtype string) (dtypes.DType, error) {
 	dtype, found := dtypes.MapOfNames[strings.ToLower(stDtype)]
 	if !found {
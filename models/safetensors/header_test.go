@@ -1,7 +1,10 @@
 package safetensors
 
 import (
+	"bytes"
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gomlx/go-huggingface/hub"
@@ -69,3 +72,134 @@ func TestSafetensorDtypeToGoMLX(t *testing.T) {
 	_, err := dtypeToGoMLX("UNKNOWN")
 	assert.Error(t, err)
 }
+
+// writeTestSafetensors writes a safetensors file with the given JSON header and dataSize bytes of (zero) data.
+func writeTestSafetensors(t *testing.T, headerJSON string, dataSize int) string {
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint64(len(headerJSON))))
+	buf.WriteString(headerJSON)
+	buf.Write(make([]byte, dataSize))
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	return path
+}
+
+func TestHeaderValidate(t *testing.T) {
+	model := NewEmpty(nil).WithStrictValidation(true)
+
+	valid := `{"__metadata__":{"format":"pt"},` +
+		`"a":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+		`"b":{"dtype":"I8","shape":[2,2],"data_offsets":[8,12]}}`
+	_, _, err := model.parseHeader(writeTestSafetensors(t, valid, 12))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name, header string
+		dataSize     int
+		wantErr      string
+	}{
+		{
+			name: "overlap",
+			header: `{"a":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+				`"b":{"dtype":"F32","shape":[2],"data_offsets":[4,12]}}`,
+			dataSize: 12,
+			wantErr:  `tensor "b" data_offsets [4, 12] overlap`,
+		},
+		{
+			name:     "gap",
+			header:   `{"a":{"dtype":"F32","shape":[2],"data_offsets":[4,12]}}`,
+			dataSize: 12,
+			wantErr:  `tensor "a" data_offsets [4, 12] leave a gap`,
+		},
+		{
+			name:     "size mismatch",
+			header:   `{"a":{"dtype":"F32","shape":[3],"data_offsets":[0,8]}}`,
+			dataSize: 8,
+			wantErr:  `tensor "a" data_offsets [0, 8] span 8 bytes`,
+		},
+		{
+			name:     "trailing data",
+			header:   `{"a":{"dtype":"F32","shape":[2],"data_offsets":[0,8]}}`,
+			dataSize: 16,
+			wantErr:  "tensors data ends at 8, but the data size is 16",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestSafetensors(t, tt.header, tt.dataSize)
+			_, _, err := model.parseHeader(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+
+			// Without strict validation, the header is accepted as is.
+			_, _, err = NewEmpty(nil).parseHeader(path)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestHeaderCheckBounds tests that out-of-file or inverted data_offsets are always rejected when parsing, even
+// without strict validation.
+func TestHeaderCheckBounds(t *testing.T) {
+	tests := []struct {
+		name, header string
+		dataSize     int
+		wantErr      string
+	}{
+		{
+			name:     "beyond the file",
+			header:   `{"a":{"dtype":"F32","shape":[4],"data_offsets":[0,16]}}`,
+			dataSize: 8,
+			wantErr:  `tensor "a" data_offsets [0, 16] out of bounds for data size 8`,
+		},
+		{
+			name:     "negative",
+			header:   `{"a":{"dtype":"F32","shape":[2],"data_offsets":[-8,0]}}`,
+			dataSize: 8,
+			wantErr:  `tensor "a" data_offsets [-8, 0] out of bounds`,
+		},
+		{
+			name:     "inverted",
+			header:   `{"a":{"dtype":"F32","shape":[2],"data_offsets":[8,0]}}`,
+			dataSize: 8,
+			wantErr:  `tensor "a" data_offsets [8, 0] out of bounds`,
+		},
+		{
+			name: "second tensor beyond the file",
+			header: `{"a":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+				`"b":{"dtype":"F32","shape":[2],"data_offsets":[8,16]}}`,
+			dataSize: 12,
+			wantErr:  `tensor "b" data_offsets [8, 16] out of bounds for data size 12`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestSafetensors(t, tt.header, tt.dataSize)
+			_, _, err := NewEmpty(nil).parseHeader(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+			_, _, err = NewEmpty(nil).WithStrictValidation(true).parseHeader(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+			_, err = OpenTensorReader(path)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHeaderMetadata(t *testing.T) {
+	header := &Header{Metadata: map[string]any{"format": "pt", "total_size": "1024"}}
+	assert.Equal(t, "pt", header.Format())
+	totalSize, ok := header.TotalSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(1024), totalSize)
+
+	header = &Header{Metadata: map[string]any{"total_size": float64(2048)}}
+	totalSize, ok = header.TotalSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(2048), totalSize)
+
+	for _, metadata := range []map[string]any{nil, {"total_size": "many"}, {"total_size": 1.5}} {
+		header = &Header{Metadata: metadata}
+		_, ok = header.TotalSize()
+		assert.False(t, ok, "metadata %v", metadata)
+		assert.Equal(t, "", header.Format())
+	}
+}
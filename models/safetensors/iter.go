@@ -2,8 +2,6 @@ package safetensors
 
 import (
 	"os"
-	"path/filepath"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -115,7 +113,7 @@ func iterFromRepoDownload(backend compute.Backend, repo *hub.Repo, done <-chan s
 	}
 
 	var waitStart time.Time
-	for filename, err := range repo.IterFileNames() {
+	for filename, err := range repo.IterFileNamesMatching("*.safetensors") {
 		select {
 		case <-done:
 			return
@@ -127,10 +125,6 @@ func iterFromRepoDownload(backend compute.Backend, repo *hub.Repo, done <-chan s
 			return
 		}
 
-		if filepath.Ext(filename) != ".safetensors" && !strings.HasSuffix(filename, ".safetensors") {
-			continue
-		}
-
 		localPath, err := repo.DownloadFile(filename)
 		if err != nil {
 			reportErrFn(errors.Wrapf(err, "failed to download %s", filename))
@@ -182,7 +176,7 @@ func iterFromRepoDownload(backend compute.Backend, repo *hub.Repo, done <-chan s
 			}
 
 			meta := header.Tensors[name]
-			shape, err := meta.GoMLXShape()
+			shape, err := meta.checkLayout(name, int64(len(fr.mmap))-dataOffset)
 			if err != nil {
 				reportErrFn(err)
 				return
@@ -254,7 +248,7 @@ func iterFromRepoToDevice(backend compute.Backend, done <-chan struct{}, chDevic
 						return
 					}
 
-					data.tensor, data.err = tensors.FromRaw(backend, 0, data.shape, data.readBuffer)
+					data.tensor, data.err = tensorFromLittleEndian(backend, data.shape, data.readBuffer)
 					if data.err != nil {
 						data.err = errors.WithMessagef(data.err,
 							"failed to create tensor %q (%s) from bytes",
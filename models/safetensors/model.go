@@ -1,6 +1,8 @@
 package safetensors
 
 import (
+	"strings"
+
 	"github.com/gomlx/compute/shapes"
 	"github.com/gomlx/go-huggingface/hub"
 	"github.com/gomlx/gomlx/core/tensors"
@@ -14,6 +16,14 @@ type Model struct {
 	IndexFile string
 	Index     *ShardedModelIndex
 	Headers   map[string]*Header // ".safetensor" filename -> parsed header
+
+	strictValidation bool
+
+	// namePrefix is stripped from the tensor names, see WithNamePrefix.
+	namePrefix string
+
+	// remoteHeaders caches the headers fetched by TensorByteRange.
+	remoteHeaders map[string]remoteHeader
 }
 
 // ShardedModelIndex represents a model.safetensors.index.json file for sharded models.
@@ -22,6 +32,13 @@ type ShardedModelIndex struct {
 	WeightMap map[string]string `json:"weight_map"` // Tensor name -> filename
 }
 
+// TotalSize returns the "total_size" field of the index metadata: the size in bytes of the tensors data of all
+// shards, useful to report the model size, or to check a download is complete. It returns 0 if missing.
+func (idx *ShardedModelIndex) TotalSize() int64 {
+	totalSize, _ := metadataInt64(idx.Metadata, "total_size")
+	return totalSize
+}
+
 // New creates a new Model and loads the loads the headers from the repo safetensors file(s).
 // If err is nil, it's ready to be used.
 func New(repo *hub.Repo) (*Model, error) {
@@ -42,18 +59,81 @@ func NewEmpty(repo *hub.Repo) *Model {
 	}
 }
 
+// WithStrictValidation enables validation of the tensors "data_offsets" of each parsed header: they must not
+// overlap, and together cover exactly the data section of the file (see Header.Validate).
+// Offsets out of the file are always rejected, even without strict validation.
+//
+// It is disabled by default, and it must be set before the headers are loaded (Model.Load).
+// It hardens loading against corrupt or adversarial files, which could otherwise yield corrupt tensors.
+func (m *Model) WithStrictValidation(strict bool) *Model {
+	m.strictValidation = strict
+	return m
+}
+
+// WithNamePrefix sets a prefix (e.g.: "model.", "transformer." or "bert.") to strip from the tensor names.
+//
+// Tensor names are then reported (ListTensorNames, GetTensor, IterTensors, etc.) and requested without the prefix:
+// e.g. "model.embed_tokens.weight" becomes "embed_tokens.weight". Names that don't start with the prefix
+// (e.g.: "lm_head.weight") are left unchanged. Requesting a name with the prefix is an error.
+//
+// If stripping the prefix makes two tensor names collide, the one that had the prefix is used.
+func (m *Model) WithNamePrefix(strip string) *Model {
+	m.namePrefix = strip
+	return m
+}
+
+// stripNamePrefix converts a tensor name in the file to the name reported to the user, see WithNamePrefix.
+func (m *Model) stripNamePrefix(fileTensorName string) string {
+	return strings.TrimPrefix(fileTensorName, m.namePrefix)
+}
+
+// fileTensorName converts a tensor name requested by the user to the name used in the files, see WithNamePrefix.
+func (m *Model) fileTensorName(tensorName string) (string, error) {
+	if m.Index == nil {
+		return "", errors.New("model empty (not loaded) call Load first")
+	}
+	if m.namePrefix == "" {
+		return tensorName, nil
+	}
+	if _, found := m.Index.WeightMap[m.namePrefix+tensorName]; found {
+		return m.namePrefix + tensorName, nil
+	}
+	if strings.HasPrefix(tensorName, m.namePrefix) {
+		return "", errors.Errorf("tensor %q not found: tensor names have their prefix %q stripped (see Model.WithNamePrefix), use %q instead",
+			tensorName, m.namePrefix, m.stripNamePrefix(tensorName))
+	}
+	return tensorName, nil
+}
+
+// shadowedByPrefix returns whether the tensor name collides with a prefixed tensor name once the prefix is stripped,
+// in which case it is hidden. See WithNamePrefix.
+func (m *Model) shadowedByPrefix(fileTensorName string) bool {
+	if m.namePrefix == "" || strings.HasPrefix(fileTensorName, m.namePrefix) {
+		return false
+	}
+	_, collides := m.Index.WeightMap[m.namePrefix+fileTensorName]
+	return collides
+}
+
 // ListTensorNames returns all tensor names in the model.
 func (m *Model) ListTensorNames() []string {
 	names := make([]string, 0, len(m.Index.WeightMap))
 	for name := range m.Index.WeightMap {
-		names = append(names, name)
+		if m.shadowedByPrefix(name) {
+			continue
+		}
+		names = append(names, m.stripNamePrefix(name))
 	}
 	return names
 }
 
 // GetTensorFilename returns the filename containing a specific tensor.
 func (m *Model) GetTensorFilename(tensorName string) (string, error) {
-	filename, ok := m.Index.WeightMap[tensorName]
+	fileTensorName, err := m.fileTensorName(tensorName)
+	if err != nil {
+		return "", err
+	}
+	filename, ok := m.Index.WeightMap[fileTensorName]
 	if !ok {
 		return "", errors.Errorf("tensor %s not found in weight map", tensorName)
 	}
@@ -72,7 +152,8 @@ func (m *Model) GetTensorMetadata(tensorName string) (*TensorMetadata, error) {
 		return nil, err
 	}
 
-	meta, ok := st.Header.Tensors[tensorName]
+	fileTensorName, _ := m.fileTensorName(tensorName)
+	meta, ok := st.Header.Tensors[fileTensorName]
 	if !ok {
 		return nil, errors.Errorf("tensor %s not found in %s", tensorName, filename)
 	}
@@ -80,6 +161,35 @@ func (m *Model) GetTensorMetadata(tensorName string) (*TensorMetadata, error) {
 	return meta, nil
 }
 
+// TensorByteRange returns the file containing the tensor, and the byte range [start, end) of its data within
+// the file.
+//
+// Only the header of the file is downloaded (with HTTP Range requests), so together with hub.Repo.DownloadFileRange
+// it allows fetching a single tensor from a multi-GB shard without downloading the rest:
+//
+//	filename, start, end, err := model.TensorByteRange("lm_head.weight")
+//	...
+//	data, err := model.Repo.DownloadFileRange(filename, start, end)
+func (m *Model) TensorByteRange(tensorName string) (filename string, start, end int64, err error) {
+	filename, err = m.GetTensorFilename(tensorName)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	header, dataOffset, err := m.fetchHeader(filename)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	fileTensorName, _ := m.fileTensorName(tensorName)
+	meta, ok := header.Tensors[fileTensorName]
+	if !ok {
+		return "", 0, 0, errors.Errorf("tensor %s not found in %s", tensorName, filename)
+	}
+	if _, err = meta.checkLayout(fileTensorName, -1); err != nil {
+		return "", 0, 0, errors.WithMessagef(err, "in %s", filename)
+	}
+	return filename, dataOffset + meta.DataOffsets[0], dataOffset + meta.DataOffsets[1], nil
+}
+
 // FileInfo holds information about a safetensor file.
 type FileInfo struct {
 	Filename string
@@ -94,14 +204,51 @@ type TensorMetadata struct {
 	DataOffsets [2]int64 `json:"data_offsets"` // [start, end] byte offsets in file
 }
 
+// GoMLXShape returns the GoMLX shape of the tensor. It fails for unsupported dtypes or negative dimensions.
 func (t *TensorMetadata) GoMLXShape() (shapes.Shape, error) {
 	dtype, err := dtypeToGoMLX(t.Dtype)
 	if err != nil {
 		return shapes.Shape{}, err
 	}
+	for _, dim := range t.Shape {
+		if dim < 0 {
+			return shapes.Shape{}, errors.Errorf("invalid negative dimension in shape %v", t.Shape)
+		}
+	}
 	return shapes.Make(dtype, t.Shape...), nil
 }
 
+// checkBounds checks that the tensor data_offsets are a valid range (0 <= start <= end) within a data section of
+// dataSize bytes. If dataSize < 0 the size of the data section is not checked.
+func (t *TensorMetadata) checkBounds(name string, dataSize int64) error {
+	start, end := t.DataOffsets[0], t.DataOffsets[1]
+	if start < 0 || end < start || (dataSize >= 0 && end > dataSize) {
+		return errors.Errorf("tensor %q data_offsets [%d, %d] out of bounds for data size %d", name, start, end, dataSize)
+	}
+	return nil
+}
+
+// checkLayout validates that the tensor data_offsets are within a data section of dataSize bytes (if dataSize >= 0),
+// and that they span exactly the number of bytes implied by its dtype and shape: safetensors stores tensors
+// contiguously in row-major order, so any mismatch indicates an inconsistent (or unsupported) header.
+//
+// It returns the tensor shape.
+func (t *TensorMetadata) checkLayout(name string, dataSize int64) (shapes.Shape, error) {
+	shape, err := t.GoMLXShape()
+	if err != nil {
+		return shapes.Shape{}, errors.WithMessagef(err, "tensor %q", name)
+	}
+	if err := t.checkBounds(name, dataSize); err != nil {
+		return shapes.Shape{}, err
+	}
+	start, end := t.DataOffsets[0], t.DataOffsets[1]
+	if expected := int64(shape.ByteSize()); end-start != expected {
+		return shapes.Shape{}, errors.Errorf("tensor %q (%s) has %d elements requiring %d bytes, but its data_offsets [%d, %d] span %d bytes",
+			name, shape, shape.Size(), expected, start, end, end-start)
+	}
+	return shape, nil
+}
+
 // TensorAndName holds a tensor name and its GoMLX tensor data.
 type TensorAndName struct {
 	Name   string
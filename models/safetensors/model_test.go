@@ -1,9 +1,18 @@
 package safetensors
 
 import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/shapes"
 	"github.com/gomlx/go-huggingface/hub"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -78,3 +87,169 @@ func TestGetTensorMetadata(t *testing.T) {
 	assert.NotNil(t, meta.Shape)
 	assert.Greater(t, meta.DataOffsets[1]-meta.DataOffsets[0], int64(0))
 }
+
+func TestParseShardedModelIndex(t *testing.T) {
+	tests := []struct {
+		name, content string
+	}{
+		{"standard", `{"metadata": {"total_size": 16}, "weight_map": {"a": "model-00001-of-00002.safetensors", "b": "model-00002-of-00002.safetensors"}}`},
+		{"camelCase", `{"weightMap": {"a": "model-00001-of-00002.safetensors", "b": "model-00002-of-00002.safetensors"}}`},
+		{"nested", `{"index": {"weight_map": {"a": "model-00001-of-00002.safetensors", "b": "model-00002-of-00002.safetensors"}}}`},
+		{"bare", `{"a": "model-00001-of-00002.safetensors", "b": "model-00002-of-00002.safetensors"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := parseShardedModelIndex([]byte(tt.content))
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{
+				"a": "model-00001-of-00002.safetensors",
+				"b": "model-00002-of-00002.safetensors",
+			}, index.WeightMap)
+		})
+	}
+
+	_, err := parseShardedModelIndex([]byte(`{"metadata": {"total_size": 16}}`))
+	assert.ErrorContains(t, err, "no weight map found")
+
+	index, err := parseShardedModelIndex([]byte(tests[0].content))
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), index.TotalSize())
+	index, err = parseShardedModelIndex([]byte(tests[1].content))
+	require.NoError(t, err)
+	assert.Zero(t, index.TotalSize())
+}
+
+// TestTensorByteRange tests locating a tensor's bytes in a shard, fetching only the header with range requests.
+func TestTensorByteRange(t *testing.T) {
+	header := `{"a":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+		`"b":{"dtype":"I8","shape":[4],"data_offsets":[8,12]}}`
+	contents, err := os.ReadFile(writeTestSafetensors(t, header, 12))
+	require.NoError(t, err)
+	copy(contents[len(contents)-4:], "abcd")
+	// A corrupt file, whose tensor data_offsets go past its end.
+	corrupt, err := os.ReadFile(writeTestSafetensors(t, `{"c":{"dtype":"I8","shape":[4],"data_offsets":[0,4]}}`, 2))
+	require.NoError(t, err)
+
+	var rangeRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = fmt.Fprintf(w, `{"id": "org/model", "sha": "abc123", "siblings": [`+
+				`{"rfilename": "model-00001-of-00002.safetensors", "size": %d},`+
+				`{"rfilename": "model-00002-of-00002.safetensors", "size": %d}]}`, len(contents), len(corrupt))
+		case "/org/model/resolve/abc123/model-00001-of-00002.safetensors":
+			assert.NotEmpty(t, req.Header.Get("Range"), "whole file requested")
+			rangeRequests++
+			http.ServeContent(w, req, "model.safetensors", time.Time{}, bytes.NewReader(contents))
+		case "/org/model/resolve/abc123/model-00002-of-00002.safetensors":
+			http.ServeContent(w, req, "model.safetensors", time.Time{}, bytes.NewReader(corrupt))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	m := NewEmpty(repo)
+	m.Index = &ShardedModelIndex{WeightMap: map[string]string{
+		"a": "model-00001-of-00002.safetensors",
+		"b": "model-00001-of-00002.safetensors",
+		"c": "model-00002-of-00002.safetensors",
+	}}
+
+	filename, start, end, err := m.TensorByteRange("b")
+	require.NoError(t, err)
+	assert.Equal(t, "model-00001-of-00002.safetensors", filename)
+	dataOffset := int64(8 + len(header))
+	assert.Equal(t, dataOffset+8, start)
+	assert.Equal(t, dataOffset+12, end)
+	data, err := repo.DownloadFileRange(filename, start, end)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+
+	// The header is cached: only the tensor data is fetched.
+	rangeRequests = 0
+	_, start, end, err = m.TensorByteRange("a")
+	require.NoError(t, err)
+	assert.Equal(t, [2]int64{dataOffset, dataOffset + 8}, [2]int64{start, end})
+	assert.Equal(t, 0, rangeRequests)
+
+	_, _, _, err = m.TensorByteRange("missing")
+	require.Error(t, err)
+
+	// Out-of-file data_offsets are rejected.
+	_, _, _, err = m.TensorByteRange("c")
+	require.ErrorContains(t, err, "out of bounds")
+}
+
+// TestWithNamePrefix tests stripping a prefix from the tensor names.
+func TestWithNamePrefix(t *testing.T) {
+	header := `{"model.embed.weight":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+		`"lm_head.weight":{"dtype":"I8","shape":[4],"data_offsets":[8,12]}}`
+	contents, err := os.ReadFile(writeTestSafetensors(t, header, 12))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Place the file directly in the cache, so no download is needed.
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	cacheDir, err := repo.CacheDir()
+	require.NoError(t, err)
+	snapshotDir := filepath.Join(cacheDir, "snapshots", "abc123")
+	require.NoError(t, os.MkdirAll(snapshotDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, "model.safetensors"), contents, 0o644))
+
+	m := NewEmpty(repo).WithNamePrefix("model.")
+	m.Index = &ShardedModelIndex{WeightMap: map[string]string{
+		"model.embed.weight": "model.safetensors",
+		"lm_head.weight":     "model.safetensors",
+	}}
+
+	names := m.ListTensorNames()
+	slices.Sort(names)
+	assert.Equal(t, []string{"embed.weight", "lm_head.weight"}, names)
+
+	tn, err := m.GetTensor(nil, "embed.weight")
+	require.NoError(t, err)
+	assert.Equal(t, "embed.weight", tn.Name)
+	assert.Equal(t, shapes.Make(dtypes.Float32, 2), tn.Tensor.Shape())
+
+	tn, err = m.GetTensor(nil, "lm_head.weight")
+	require.NoError(t, err)
+	assert.Equal(t, "lm_head.weight", tn.Name)
+
+	_, err = m.GetTensor(nil, "model.embed.weight")
+	assert.ErrorContains(t, err, `use "embed.weight" instead`)
+
+	var iterNames []string
+	for tn, err := range m.IterTensors(nil) {
+		require.NoError(t, err)
+		iterNames = append(iterNames, tn.Name)
+	}
+	slices.Sort(iterNames)
+	assert.Equal(t, []string{"embed.weight", "lm_head.weight"}, iterNames)
+}
+
+// TestLoadNoModelArtifact tests the error when the repository has no safetensors files.
+func TestLoadNoModelArtifact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [{"rfilename": "model.gguf"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	_, err := New(repo)
+	require.ErrorIs(t, err, hub.ErrNoModelArtifact)
+	assert.ErrorContains(t, err, "searched for .safetensors")
+}
@@ -1,12 +1,15 @@
 package safetensors
 
 import (
+	"encoding/binary"
 	"iter"
 	"os"
+	"slices"
 	"sync"
 
 	"github.com/edsrzf/mmap-go"
 	"github.com/gomlx/compute"
+	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/compute/shapes"
 	"github.com/gomlx/gomlx/core/tensors"
 	"github.com/pkg/errors"
@@ -26,7 +29,20 @@ func (m *Model) NewTensorReader(fileName string) (*TensorReader, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to download %s", fileName)
 	}
+	return m.openTensorReader(localPath)
+}
+
+// OpenTensorReader creates a new TensorReader for a local .safetensors file.
+//
+// The file is memory-mapped, so tensors are copied directly from the page cache to their final buffer,
+// without intermediary copies in memory.
+func OpenTensorReader(localPath string) (*TensorReader, error) {
+	return (*Model)(nil).openTensorReader(localPath)
+}
 
+// openTensorReader parses the header of the local .safetensors file and memory-maps it.
+// The Model (it can be nil) sets the header validation.
+func (m *Model) openTensorReader(localPath string) (*TensorReader, error) {
 	header, dataOffset, err := m.parseHeader(localPath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse header for %s", localPath)
@@ -74,6 +90,15 @@ func (sr *TensorReader) Close() error {
 	return err2
 }
 
+// dataSize returns the size of the data section (after the header) of the memory-mapped file, or -1 if
+// the file is not memory-mapped.
+func (mr *TensorReader) dataSize() int64 {
+	if mr.mmapBuf == nil {
+		return -1
+	}
+	return int64(len(mr.mmapBuf)) - mr.dataOffset
+}
+
 // ReadTensor reads a tensor by name from the file.
 func (mr *TensorReader) ReadTensor(backend compute.Backend, tensorName string) (*tensors.Tensor, error) {
 	meta, ok := mr.Header.Tensors[tensorName]
@@ -81,28 +106,23 @@ func (mr *TensorReader) ReadTensor(backend compute.Backend, tensorName string) (
 		return nil, errors.Errorf("tensor %s not found", tensorName)
 	}
 
-	// Create shape.
-	shape, err := meta.GoMLXShape()
-	if err != nil {
-		return nil, err
-	}
-
 	if mr.mmapBuf == nil {
 		return nil, errors.New("file is not mmaped")
 	}
 
+	// Create shape, and check it matches the data in the file.
+	shape, err := meta.checkLayout(tensorName, mr.dataSize())
+	if err != nil {
+		return nil, err
+	}
+
 	// Get bytes directly from memory-mapped file
 	tensorOffset := mr.dataOffset + meta.DataOffsets[0]
 	tensorEnd := mr.dataOffset + meta.DataOffsets[1]
 
-	expectedBytes := int64(shape.ByteSize())
-	if tensorEnd-tensorOffset != expectedBytes {
-		return nil, errors.Errorf("tensor shape %s expected %d bytes, but got %d bytes in file", shape, expectedBytes, tensorEnd-tensorOffset)
-	}
-
 	readBuffer := mr.mmapBuf[tensorOffset:tensorEnd]
 
-	t, err := tensors.FromRaw(backend, 0, shape, readBuffer)
+	t, err := tensorFromLittleEndian(backend, shape, readBuffer)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "failed to create tensor %q (%s) from bytes", tensorName, shape)
 	}
@@ -110,6 +130,62 @@ func (mr *TensorReader) ReadTensor(backend compute.Backend, tensorName string) (
 	return t, nil
 }
 
+// ReadTensorRows reads the rows [start, end) of the outermost axis of a tensor (e.g. some vocabulary rows of a
+// large embedding table), returning a tensor of shape [end-start, ...]. Only the bytes of those rows are read
+// from the memory-mapped file.
+//
+// The tensor will be directly created on the given backend, if it is not nil.
+// Otherwise, it creates a local (on-host) tensor.
+func (mr *TensorReader) ReadTensorRows(backend compute.Backend, tensorName string, start, end int) (*tensors.Tensor, error) {
+	meta, ok := mr.Header.Tensors[tensorName]
+	if !ok {
+		return nil, errors.Errorf("tensor %s not found", tensorName)
+	}
+	if mr.mmapBuf == nil {
+		return nil, errors.New("file is not mmaped")
+	}
+	shape, err := meta.checkLayout(tensorName, mr.dataSize())
+	if err != nil {
+		return nil, err
+	}
+	if shape.Rank() == 0 {
+		return nil, errors.Errorf("tensor %q is a scalar, it has no rows to read", tensorName)
+	}
+	numRows := shape.Dimensions[0]
+	if start < 0 || end < start || end > numRows {
+		return nil, errors.Errorf("invalid rows range [%d, %d) for tensor %q with shape %s", start, end, tensorName, shape)
+	}
+	rowBytes := int64(shapes.Make(shape.DType, shape.Dimensions[1:]...).ByteSize())
+	if rowBytes*int64(numRows) != int64(shape.ByteSize()) {
+		return nil, errors.Errorf("rows of tensor %q (%s) are not byte-aligned, they can't be read separately", tensorName, shape)
+	}
+
+	tensorOffset := mr.dataOffset + meta.DataOffsets[0]
+	readBuffer := mr.mmapBuf[tensorOffset+int64(start)*rowBytes : tensorOffset+int64(end)*rowBytes]
+	dims := slices.Clone(shape.Dimensions)
+	dims[0] = end - start
+	rowsShape := shapes.Make(shape.DType, dims...)
+	t, err := tensorFromLittleEndian(backend, rowsShape, readBuffer)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create tensor %q rows [%d, %d) (%s) from bytes", tensorName, start, end, rowsShape)
+	}
+	return t, nil
+}
+
+// LoadSafetensorSlice reads the rows [start, end) of the outermost axis of the tensor tensorName from the local
+// .safetensors file, into a local (on-host) tensor of shape [end-start, ...].
+//
+// It is useful to use only some rows of huge tensors, like the embedding tables of large vocabularies, without
+// reading the whole tensor. See TensorReader.ReadTensorRows to read many slices from the same file.
+func LoadSafetensorSlice(filename, tensorName string, start, end int) (*tensors.Tensor, error) {
+	reader, err := OpenTensorReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = reader.Close() }()
+	return reader.ReadTensorRows(nil, tensorName, start, end)
+}
+
 // IterTensors reads multiple tensors from the file, yielding them one by one.
 // It uses a 2-stage pipeline (parse, upload to device) so that while a tensor
 // is being parsed, the previous one is being moved to device in parallel.
@@ -148,7 +224,7 @@ func (mr *TensorReader) IterTensors(backend compute.Backend, tensorNames []strin
 					return
 				}
 
-				shape, err := meta.GoMLXShape()
+				shape, err := meta.checkLayout(name, mr.dataSize())
 				if err != nil {
 					select {
 					case chParse <- tensorData{err: err}:
@@ -159,14 +235,6 @@ func (mr *TensorReader) IterTensors(backend compute.Backend, tensorNames []strin
 
 				tensorOffset := mr.dataOffset + meta.DataOffsets[0]
 				tensorEnd := mr.dataOffset + meta.DataOffsets[1]
-				expectedBytes := int64(shape.ByteSize())
-				if tensorEnd-tensorOffset != expectedBytes {
-					select {
-					case chParse <- tensorData{err: errors.Errorf("tensor shape %s expected %d bytes, but got %d bytes in file", shape, expectedBytes, tensorEnd-tensorOffset)}:
-					case <-done:
-					}
-					return
-				}
 
 				var readBuffer []byte
 				if mr.mmapBuf != nil {
@@ -207,7 +275,7 @@ func (mr *TensorReader) IterTensors(backend compute.Backend, tensorNames []strin
 					if mr.mmapBuf == nil {
 						data.err = errors.New("file is not mmaped")
 					} else {
-						data.tensor, data.err = tensors.FromRaw(backend, 0, data.shape, data.readBuffer)
+						data.tensor, data.err = tensorFromLittleEndian(backend, data.shape, data.readBuffer)
 						if data.err != nil {
 							data.err = errors.WithMessagef(data.err, "failed to create tensor %q (%s) from bytes", data.name, data.shape)
 						}
@@ -237,3 +305,52 @@ func (mr *TensorReader) IterTensors(backend compute.Backend, tensorNames []strin
 		}
 	}
 }
+
+// isHostLittleEndian is true on little-endian hosts (almost all of them), where the safetensors data
+// (always little-endian) can be used as is.
+var isHostLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// tensorFromLittleEndian creates a tensor with the given shape from the little-endian data (usually a slice
+// of the memory-mapped file), copying it only once, to the tensor buffer.
+//
+// The tensor will be directly created on the given backend, if it is not nil.
+// Otherwise, it creates a local (on-host) tensor.
+func tensorFromLittleEndian(backend compute.Backend, shape shapes.Shape, data []byte) (*tensors.Tensor, error) {
+	if isHostLittleEndian {
+		return tensors.FromRaw(backend, 0, shape, data)
+	}
+	if int(shape.ByteSize()) != len(data) {
+		return nil, errors.Errorf("shape %s has %d bytes, but data has %d bytes", shape, shape.ByteSize(), len(data))
+	}
+	if backend == nil {
+		// Swap the bytes in place, in the tensor buffer.
+		t := tensors.FromShape(shape)
+		t.MutableBytes(func(buf []byte) {
+			copy(buf, data)
+			swapBytesInPlace(shape.DType, buf)
+		})
+		return t, nil
+	}
+	// The data (memory-mapped read-only) can't be changed, and the backend buffer may not be accessible.
+	swapped := slices.Clone(data)
+	swapBytesInPlace(shape.DType, swapped)
+	return tensors.FromRaw(backend, 0, shape, swapped)
+}
+
+// swapBytesInPlace converts the byte order (little-endian <-> big-endian) of the values of the given dtype in data.
+// Complex numbers have each of their components swapped separately.
+func swapBytesInPlace(dtype dtypes.DType, data []byte) {
+	if dtype.IsComplex() {
+		dtype = dtype.RealDType()
+	}
+	if dtype.IsPacked() {
+		return
+	}
+	size := dtype.Size()
+	if size <= 1 {
+		return
+	}
+	for start := 0; start+size <= len(data); start += size {
+		slices.Reverse(data[start : start+size])
+	}
+}
@@ -1,8 +1,13 @@
 package safetensors
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/gomlx/compute/dtypes"
@@ -99,3 +104,120 @@ func TestTensorReaderTensor(t *testing.T) {
 	err = reader.Close()
 	require.NoError(t, err)
 }
+
+// TestTensorReaderLayoutMismatch tests that tensors whose data_offsets don't match their shape and dtype fail
+// with a clear error, instead of producing wrong tensors.
+func TestTensorReaderLayoutMismatch(t *testing.T) {
+	header := `{"ok":{"dtype":"F32","shape":[2],"data_offsets":[0,8]},` +
+		`"short":{"dtype":"F32","shape":[3],"data_offsets":[0,8]},` +
+		`"beyond":{"dtype":"F32","shape":[4],"data_offsets":[8,24]},` +
+		`"negative":{"dtype":"F32","shape":[-2],"data_offsets":[0,8]}}`
+	path := writeTestSafetensors(t, header, 16)
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	// Parse the header without validation (which would reject "beyond"), to test the checks when reading.
+	h, dataOffset, err := readHeader(bytes.NewReader(contents))
+	require.NoError(t, err)
+	reader := &TensorReader{mmapBuf: contents, dataOffset: dataOffset, Header: h}
+
+	tensor, err := reader.ReadTensor(nil, "ok")
+	require.NoError(t, err)
+	assert.Equal(t, shapes.Make(dtypes.Float32, 2), tensor.Shape())
+
+	_, err = reader.ReadTensor(nil, "short")
+	assert.ErrorContains(t, err, `tensor "short" ((Float32)[3]) has 3 elements requiring 12 bytes, but its data_offsets [0, 8] span 8 bytes`)
+	_, err = reader.ReadTensor(nil, "beyond")
+	assert.ErrorContains(t, err, `tensor "beyond" data_offsets [8, 24] out of bounds for data size 16`)
+	_, err = reader.ReadTensor(nil, "negative")
+	assert.ErrorContains(t, err, "negative dimension")
+}
+
+// writeFloat32Safetensors writes a safetensors file with one float32 tensor "weight" with the given dimensions,
+// with the values 0, 1, 2, ...
+func writeFloat32Safetensors(tb testing.TB, dims ...int) string {
+	size := 1
+	for _, dim := range dims {
+		size *= dim
+	}
+	headerJSON := fmt.Sprintf(`{"weight":{"dtype":"F32","shape":%s,"data_offsets":[0,%d]}}`,
+		strings.ReplaceAll(fmt.Sprint(dims), " ", ","), 4*size)
+	var buf bytes.Buffer
+	require.NoError(tb, binary.Write(&buf, binary.LittleEndian, uint64(len(headerJSON))))
+	buf.WriteString(headerJSON)
+	values := make([]float32, size)
+	for i := range values {
+		values[i] = float32(i)
+	}
+	require.NoError(tb, binary.Write(&buf, binary.LittleEndian, values))
+	path := filepath.Join(tb.TempDir(), "model.safetensors")
+	require.NoError(tb, os.WriteFile(path, buf.Bytes(), 0o644))
+	return path
+}
+
+func TestOpenTensorReader(t *testing.T) {
+	path := writeFloat32Safetensors(t, 2, 3)
+	reader, err := OpenTensorReader(path)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reader.Close()) }()
+
+	tensor, err := reader.ReadTensor(nil, "weight")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0, 1, 2}, {3, 4, 5}}, tensor.Value())
+}
+
+func TestLoadSafetensorSlice(t *testing.T) {
+	path := writeFloat32Safetensors(t, 4, 2, 3)
+	tensor, err := LoadSafetensorSlice(path, "weight", 1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, [][][]float32{{{6, 7, 8}, {9, 10, 11}}, {{12, 13, 14}, {15, 16, 17}}}, tensor.Value())
+
+	tensor, err = LoadSafetensorSlice(path, "weight", 4, 4)
+	require.NoError(t, err)
+	assert.Equal(t, shapes.Make(dtypes.Float32, 0, 2, 3), tensor.Shape())
+
+	_, err = LoadSafetensorSlice(path, "weight", 2, 5)
+	assert.ErrorContains(t, err, "invalid rows range [2, 5)")
+	_, err = LoadSafetensorSlice(path, "weight", 3, 2)
+	assert.ErrorContains(t, err, "invalid rows range [3, 2)")
+	_, err = LoadSafetensorSlice(path, "bias", 0, 1)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestSwapBytesInPlace(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	swapBytesInPlace(dtypes.Float32, data)
+	assert.Equal(t, []byte{4, 3, 2, 1, 8, 7, 6, 5}, data)
+	swapBytesInPlace(dtypes.Complex64, data)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, data)
+	swapBytesInPlace(dtypes.BFloat16, data)
+	assert.Equal(t, []byte{2, 1, 4, 3, 6, 5, 8, 7}, data)
+	swapBytesInPlace(dtypes.Uint8, data)
+	assert.Equal(t, []byte{2, 1, 4, 3, 6, 5, 8, 7}, data)
+}
+
+// BenchmarkReadTensor reads a word-embeddings sized tensor (BERT's [30522, 768] float32, ~94MB) from a
+// memory-mapped file. The "alloc/tensor" metric is the memory allocated per tensor byte: the tensor data is
+// copied only once, from the page cache to the tensor buffer, so it should be close to 1.
+func BenchmarkReadTensor(b *testing.B) {
+	path := writeFloat32Safetensors(b, 30522, 768)
+	reader, err := OpenTensorReader(path)
+	require.NoError(b, err)
+	defer func() { _ = reader.Close() }()
+	tensorBytes := reader.Header.Tensors["weight"].DataOffsets[1]
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.SetBytes(tensorBytes)
+	for b.Loop() {
+		tensor, err := reader.ReadTensor(nil, "weight")
+		if err != nil {
+			b.Fatal(err)
+		}
+		tensor.FinalizeAll()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(b.N)/float64(tensorBytes), "alloc/tensor")
+}
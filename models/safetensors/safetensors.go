@@ -27,20 +27,26 @@ package safetensors
 
 import (
 	"encoding/json"
+	"maps"
 	"os"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/gomlx/compute"
 	"github.com/gomlx/compute/support/xslices"
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/gomlx/gomlx/core/tensors"
 	"github.com/pkg/errors"
 )
 
 // Load loads the model from the repo, whether it's sharded or a single file.
 // It automatically detects sharded models via index files, otherwise treats the first
 // .safetensors file as a single-file model.
+//
+// If the repository has neither, it returns a *hub.NoModelArtifactError.
 func (m *Model) Load() error {
 	indexFile, isSharded, err := m.DetectShardedModel()
 	if err != nil {
@@ -65,7 +71,10 @@ func (m *Model) DetectShardedModel() (string, bool, error) {
 		"pytorch_model.safetensors.index.json",
 	}
 
-	for filename, err := range m.Repo.IterFileNames() {
+	// Some exporters use non-standard names, ending in ".safetensors.index.json": they are used if no
+	// standard name is found.
+	var nonStandardIndex string
+	for filename, err := range m.Repo.IterFileNamesMatching("*.safetensors.index.json") {
 		if err != nil {
 			return "", false, err
 		}
@@ -75,35 +84,41 @@ func (m *Model) DetectShardedModel() (string, bool, error) {
 				return filename, true, nil
 			}
 		}
+		if nonStandardIndex == "" {
+			nonStandardIndex = filename
+		}
+	}
+	if nonStandardIndex != "" {
+		return nonStandardIndex, true, nil
 	}
 
 	return "", false, nil
 }
 
 // LoadSingleFileModel loads a single-file safetensors model.
+//
+// If the repository has no .safetensors file, it returns a *hub.NoModelArtifactError.
 func (m *Model) LoadSingleFileModel() error {
 	if m.Repo == nil {
 		return errors.New("Repocreate a ModelSafetensor with NewModelSafetensor first")
 	}
 
 	localPaths := []string{}
-	for filename, err := range m.Repo.IterFileNames() {
+	for filename, err := range m.Repo.IterFileNamesMatching("*.safetensors") {
 		if err != nil {
 			return err
 		}
 
-		if filepath.Ext(filename) == ".safetensors" {
-			// Download and parse the file to get tensor names
-			localPath, err := m.Repo.DownloadFile(filename)
-			if err != nil {
-				return errors.Wrapf(err, "failed to download %s", filename)
-			}
-			localPaths = append(localPaths, localPath)
+		// Download and parse the file to get tensor names
+		localPath, err := m.Repo.DownloadFile(filename)
+		if err != nil {
+			return errors.Wrapf(err, "failed to download %s", filename)
 		}
+		localPaths = append(localPaths, localPath)
 	}
 
 	if len(localPaths) == 0 {
-		return errors.New("no .safetensors files found in repository")
+		return &hub.NoModelArtifactError{RepoID: m.Repo.ID, Formats: []string{".safetensors"}}
 	}
 
 	header, _, err := m.parseHeader(localPaths[0])
@@ -144,18 +159,81 @@ func (m *Model) LoadShardedModel(indexFilename string) error {
 		return errors.Wrapf(err, "failed to read %s", localPath)
 	}
 
-	var index ShardedModelIndex
-	if err := json.Unmarshal(data, &index); err != nil {
-		return errors.Wrap(err, "failed to parse sharded model index")
+	index, err := parseShardedModelIndex(data)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to parse sharded model index %s", indexFilename)
+	}
+
+	// Shard file names are relative to the index file.
+	if dir := path.Dir(indexFilename); dir != "." {
+		for tensorName, filename := range index.WeightMap {
+			index.WeightMap[tensorName] = path.Join(dir, filename)
+		}
+	}
+
+	// Validate that all shards exist, sorted for a deterministic error.
+	tensorNames := slices.Sorted(maps.Keys(index.WeightMap))
+	checked := make(map[string]bool)
+	for _, tensorName := range tensorNames {
+		filename := index.WeightMap[tensorName]
+		if checked[filename] {
+			continue
+		}
+		checked[filename] = true
+		if !m.Repo.HasFile(filename) {
+			return errors.Errorf("sharded model index %s references file %q (for tensor %q), which is not in the repository %s",
+				indexFilename, filename, tensorName, m.Repo)
+		}
 	}
 
 	m.IndexFile = indexFilename
-	m.Index = &index
+	m.Index = index
 	m.Headers = make(map[string]*Header)
 
 	return nil
 }
 
+// parseShardedModelIndex parses the contents of a sharded model index file, tolerating known variations of
+// the standard format ({"metadata": {...}, "weight_map": {...}}):
+//
+//   - The weight map under the "weightMap" key.
+//   - The index nested under an "index" key.
+//   - A bare weight map, mapping tensor names to file names.
+func parseShardedModelIndex(data []byte) (*ShardedModelIndex, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	if nested, found := fields["index"]; found {
+		return parseShardedModelIndex(nested)
+	}
+
+	index := &ShardedModelIndex{}
+	if metadata, found := fields["metadata"]; found {
+		if err := json.Unmarshal(metadata, &index.Metadata); err != nil {
+			return nil, errors.Wrap(err, "invalid \"metadata\"")
+		}
+	}
+	for _, key := range []string{"weight_map", "weightMap"} {
+		if weightMap, found := fields[key]; found {
+			if err := json.Unmarshal(weightMap, &index.WeightMap); err != nil {
+				return nil, errors.Wrapf(err, "invalid %q", key)
+			}
+			break
+		}
+	}
+	if index.WeightMap == nil && index.Metadata == nil {
+		// Bare weight map: all values must be strings.
+		if err := json.Unmarshal(data, &index.WeightMap); err != nil {
+			index.WeightMap = nil
+		}
+	}
+	if len(index.WeightMap) == 0 {
+		return nil, errors.New("no weight map found (expected a \"weight_map\" key)")
+	}
+	return index, nil
+}
+
 // GetSafetensor returns the parsed .safetensors file header for a specific tensor.
 //
 // It returns a FileInfo object for the .safetensor file, with its file name and header.
@@ -188,17 +266,12 @@ func (m *Model) GetSafetensor(filename string) (*FileInfo, error) {
 // The header holds metadata to all tensors contained in the file.
 func (m *Model) IterSafetensors() func(yield func(FileInfo, error) bool) {
 	return func(yield func(FileInfo, error) bool) {
-		for filename, err := range m.Repo.IterFileNames() {
+		for filename, err := range m.Repo.IterFileNamesMatching("*.safetensors") {
 			if err != nil {
 				yield(FileInfo{}, err)
 				return
 			}
 
-			// Only process .safetensors files
-			if !strings.HasSuffix(filename, ".safetensors") {
-				continue
-			}
-
 			// Download and parse header
 			localPath, err := m.Repo.DownloadFile(filename)
 			if err != nil {
@@ -231,6 +304,25 @@ func (m *Model) GetTensor(backend compute.Backend, tensorName string) (*TensorAn
 	return m.GetTensorFromFile(backend, filename, tensorName)
 }
 
+// LazyTensor returns a memoized loader for the tensor tensorName: the tensor is only read (from the
+// memory-mapped file) the first time the returned function is called, and subsequent calls return the
+// same tensor (or error).
+//
+// This allows graph-construction code to hold on to loaders for all tensors of a model (e.g.: optional bias
+// tensors), and only pay the cost of loading the ones actually used.
+//
+// The tensor will be directly created on the given backend, if it is not nil.
+// Otherwise, it creates a local (on-host) tensor.
+func (m *Model) LazyTensor(backend compute.Backend, tensorName string) func() (*tensors.Tensor, error) {
+	return sync.OnceValues(func() (*tensors.Tensor, error) {
+		tensorAndName, err := m.GetTensor(backend, tensorName)
+		if err != nil {
+			return nil, err
+		}
+		return tensorAndName.Tensor, nil
+	})
+}
+
 // GetTensorFromFile loads a tensor from within a .safetensors file and converts it to a GoMLX tensor.
 //
 // This requires a loaded model -- see Model.Load().
@@ -245,11 +337,16 @@ func (m *Model) GetTensorFromFile(backend compute.Backend, fileName, tensorName
 		return nil, errors.New("model empty (not loaded) call Load first")
 	}
 
+	fileTensorName, err := m.fileTensorName(tensorName)
+	if err != nil {
+		return nil, err
+	}
 	reader, err := m.NewTensorReader(fileName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create TensorReader for %s", fileName)
 	}
-	tensor, err := reader.ReadTensor(backend, tensorName)
+	defer func() { _ = reader.Close() }()
+	tensor, err := reader.ReadTensor(backend, fileTensorName)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to read tensor %s from %s", tensorName, fileName)
 	}
@@ -275,6 +372,9 @@ func (m *Model) IterTensors(backend compute.Backend) func(yield func(TensorAndNa
 		// Group tensors by shard file for efficient reading
 		shardToTensors := make(map[string][]string)
 		for tensorName, fileName := range m.Index.WeightMap {
+			if m.shadowedByPrefix(tensorName) {
+				continue
+			}
 			shardToTensors[fileName] = append(shardToTensors[fileName], tensorName)
 		}
 
@@ -299,6 +399,7 @@ func (m *Model) IterTensors(backend compute.Backend) func(yield func(TensorAndNa
 					return
 				}
 
+				tensorAndName.Name = m.stripNamePrefix(tensorAndName.Name)
 				if !yield(tensorAndName, nil) {
 					reader.Close()
 					return
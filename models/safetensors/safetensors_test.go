@@ -1,10 +1,14 @@
 package safetensors
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gomlx/go-huggingface/hub"
+	"github.com/gomlx/gomlx/core/tensors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -146,6 +150,71 @@ func TestGetTensor(t *testing.T) {
 	assert.Greater(t, tensor.Tensor.Shape().Size(), 0)
 }
 
+// TestLazyTensor tests that the lazy loader only reads the tensor once.
+func TestLazyTensor(t *testing.T) {
+	token := os.Getenv("HF_TOKEN")
+	if token == "" {
+		t.Skip("skipping test; HF_TOKEN not set")
+	}
+	repo := hub.New("sentence-transformers/all-MiniLM-L6-v2").WithAuth(token)
+	m, err := New(repo)
+	require.NoError(t, err)
+
+	loader := m.LazyTensor(nil, "embeddings.position_embeddings.weight")
+	tensor, err := loader()
+	require.NoError(t, err)
+	assert.Equal(t, "(Float32)[512, 384]", tensor.Shape().String())
+	tensor2, err := loader()
+	require.NoError(t, err)
+	assert.Same(t, tensor, tensor2)
+
+	_, err = m.LazyTensor(nil, "non_existent_tensor")()
+	assert.Error(t, err)
+}
+
+// TestLazyTensorOffline tests the lazy loader with a local server and a model placed in the cache.
+func TestLazyTensorOffline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Place the model directly in the cache, so no download is needed.
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	cacheDir, err := repo.CacheDir()
+	require.NoError(t, err)
+	snapshotDir := filepath.Join(cacheDir, "snapshots", "abc123")
+	require.NoError(t, os.MkdirAll(snapshotDir, 0o755))
+	modelPath := filepath.Join(snapshotDir, "model.safetensors")
+	require.NoError(t, Write(modelPath, map[string]*tensors.Tensor{
+		"weight": tensors.FromValue([][]float32{{1, 2}, {3, 4}}),
+	}, nil))
+	m := NewEmpty(repo)
+	m.Index = &ShardedModelIndex{WeightMap: map[string]string{"weight": "model.safetensors"}}
+
+	loader := m.LazyTensor(nil, "weight")
+	tensor, err := loader()
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 2}, {3, 4}}, tensor.Value())
+
+	// The tensor is not read again: it is returned even once the file is gone.
+	require.NoError(t, os.Remove(modelPath))
+	tensor2, err := loader()
+	require.NoError(t, err)
+	assert.Same(t, tensor, tensor2)
+
+	// Errors are memoized too.
+	missing := m.LazyTensor(nil, "missing")
+	_, err = missing()
+	require.Error(t, err)
+	_, err2 := missing()
+	assert.Equal(t, err, err2)
+}
+
 var allMiniVariablesToShape = map[string]string{
 	"embeddings.position_ids":                           "(Int64)[1, 512]",
 	"embeddings.LayerNorm.bias":                         "(Float32)[384]",
@@ -0,0 +1,224 @@
+package safetensors
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/gomlx/core/tensors"
+	"github.com/pkg/errors"
+)
+
+// goMLXToDtype maps the GoMLX dtypes supported by Write to the safetensors dtype names.
+var goMLXToDtype = map[dtypes.DType]string{
+	dtypes.Float64:  "F64",
+	dtypes.Float32:  "F32",
+	dtypes.Float16:  "F16",
+	dtypes.BFloat16: "BF16",
+	dtypes.Int64:    "I64",
+	dtypes.Int32:    "I32",
+	dtypes.Int16:    "I16",
+	dtypes.Int8:     "I8",
+	dtypes.Uint64:   "U64",
+	dtypes.Uint32:   "U32",
+	dtypes.Uint16:   "U16",
+	dtypes.Uint8:    "U8",
+	dtypes.Bool:     "BOOL",
+}
+
+// dtypeFromGoMLX returns the safetensors dtype name for the GoMLX dtype.
+func dtypeFromGoMLX(dtype dtypes.DType) (string, error) {
+	stDtype, found := goMLXToDtype[dtype]
+	if !found {
+		return "", errors.Errorf("dtype %s not supported by safetensors", dtype)
+	}
+	return stDtype, nil
+}
+
+// headerAlignment is the alignment of the tensors data section: the JSON header is padded with spaces,
+// as the reference (Rust/Python) implementation does.
+const headerAlignment = 8
+
+// Write saves the tensors (tensor name -> tensor) in a .safetensors file at path, with the optional
+// metadata stored in the "__metadata__" field of the header.
+//
+// Tensors are written contiguously, sorted by name, in little-endian byte order. Supported dtypes are
+// F64, F32, F16, BF16, I64 to I8, U64 to U8 and BOOL. An existing file at path is overwritten.
+func Write(path string, tensorsByName map[string]*tensors.Tensor, metadata map[string]string) error {
+	entries := make([]writeEntry, 0, len(tensorsByName))
+	for _, name := range slices.Sorted(maps.Keys(tensorsByName)) {
+		t := tensorsByName[name]
+		if t == nil {
+			return errors.Errorf("tensor %q is nil", name)
+		}
+		shape := t.Shape()
+		stDtype, err := dtypeFromGoMLX(shape.DType)
+		if err != nil {
+			return errors.WithMessagef(err, "tensor %q", name)
+		}
+		entries = append(entries, writeEntry{
+			name:  name,
+			dtype: stDtype,
+			shape: shape.Dimensions,
+			size:  int64(shape.ByteSize()),
+			write: func(w io.Writer) error {
+				var err error
+				accessErr := t.ConstBytes(func(data []byte) {
+					if !isHostLittleEndian {
+						data = slices.Clone(data)
+						swapBytesInPlace(shape.DType, data)
+					}
+					_, err = w.Write(data)
+				})
+				if accessErr != nil {
+					return errors.WithMessagef(accessErr, "failed to access the bytes of tensor %q", name)
+				}
+				return err
+			},
+		})
+	}
+	return writeFile(path, entries, metadata)
+}
+
+// writeEntry describes one tensor to be written by writeFile.
+type writeEntry struct {
+	name  string
+	dtype string // Safetensors dtype name.
+	shape []int
+	size  int64
+
+	// write writes the size bytes of the tensor data, in little-endian byte order.
+	write func(w io.Writer) error
+}
+
+// writeFile writes a .safetensors file at path with the tensors in entries, stored contiguously in the given order.
+func writeFile(path string, entries []writeEntry, metadata map[string]string) error {
+	rawHeader := make(map[string]any, len(entries)+1)
+	if len(metadata) > 0 {
+		rawHeader["__metadata__"] = metadata
+	}
+	var dataSize int64
+	for _, entry := range entries {
+		if entry.name == "__metadata__" {
+			return errors.Errorf("invalid tensor name %q, it is reserved for the metadata", entry.name)
+		}
+		if _, found := rawHeader[entry.name]; found {
+			return errors.Errorf("duplicate tensor name %q", entry.name)
+		}
+		rawHeader[entry.name] = &TensorMetadata{
+			Dtype:       entry.dtype,
+			Shape:       slices.Clone(entry.shape),
+			DataOffsets: [2]int64{dataSize, dataSize + entry.size},
+		}
+		dataSize += entry.size
+	}
+	headerBytes, err := json.Marshal(rawHeader)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode safetensors header")
+	}
+	if padding := len(headerBytes) % headerAlignment; padding != 0 {
+		headerBytes = append(headerBytes, strings.Repeat(" ", headerAlignment-padding)...)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", path)
+	}
+	w := bufio.NewWriter(f)
+	err = binary.Write(w, binary.LittleEndian, uint64(len(headerBytes)))
+	if err == nil {
+		_, err = w.Write(headerBytes)
+	}
+	for _, entry := range entries {
+		if err != nil {
+			break
+		}
+		err = entry.write(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	return nil
+}
+
+// Consolidate writes all the tensors of the model, typically sharded across several files, into a single
+// .safetensors file at outPath, with the "total_size" (of the tensors data) set in its metadata, along with the
+// metadata of the shards (e.g. "format").
+//
+// Tensor names (without stripping the prefix set with WithNamePrefix), dtypes (including the ones not supported by
+// GoMLX) and bytes are preserved exactly: the data is copied directly from the memory-mapped shards, so the model
+// is never fully loaded in memory.
+//
+// This requires a loaded model -- see Model.Load().
+func (m *Model) Consolidate(outPath string) error {
+	if m.Repo == nil {
+		return errors.New("repo is nil!?")
+	}
+	if m.Index == nil || len(m.Index.WeightMap) == 0 {
+		return errors.New("model empty (not loaded) call Load first")
+	}
+
+	readers := make(map[string]*TensorReader)
+	defer func() {
+		for _, reader := range readers {
+			_ = reader.Close()
+		}
+	}()
+	metadata := make(map[string]string)
+	var entries []writeEntry
+	var totalSize int64
+	for _, tensorName := range slices.Sorted(maps.Keys(m.Index.WeightMap)) {
+		fileName := m.Index.WeightMap[tensorName]
+		reader, found := readers[fileName]
+		if !found {
+			var err error
+			reader, err = m.NewTensorReader(fileName)
+			if err != nil {
+				return errors.Wrapf(err, "failed to create TensorReader for %s", fileName)
+			}
+			readers[fileName] = reader
+			for key, value := range reader.Header.Metadata {
+				if str, ok := value.(string); ok {
+					if _, found := metadata[key]; !found {
+						metadata[key] = str
+					}
+				}
+			}
+		}
+		meta, found := reader.Header.Tensors[tensorName]
+		if !found {
+			return errors.Errorf("tensor %q not found in %s", tensorName, fileName)
+		}
+		if err := meta.checkBounds(tensorName, reader.dataSize()); err != nil {
+			return errors.WithMessagef(err, "in %s", fileName)
+		}
+		start, end := meta.DataOffsets[0], meta.DataOffsets[1]
+		data := reader.mmapBuf[reader.dataOffset+start : reader.dataOffset+end]
+		entries = append(entries, writeEntry{
+			name:  tensorName,
+			dtype: meta.Dtype,
+			shape: meta.Shape,
+			size:  end - start,
+			write: func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			},
+		})
+		totalSize += end - start
+	}
+	metadata["total_size"] = strconv.FormatInt(totalSize, 10)
+	return writeFile(outPath, entries, metadata)
+}
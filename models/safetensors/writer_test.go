@@ -0,0 +1,125 @@
+package safetensors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/shapes"
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/gomlx/gomlx/core/tensors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	halfTensor := tensors.FromShape(shapes.Make(dtypes.BFloat16, 2))
+	halfTensor.MutableBytes(func(data []byte) {
+		copy(data, []byte{0x80, 0x3f, 0x00, 0xc0}) // 1.0 and -2.0 in bfloat16.
+	})
+	written := map[string]*tensors.Tensor{
+		"weight":  tensors.FromValue([][]float32{{1, 2, 3}, {4, 5, 6}}),
+		"ids":     tensors.FromValue([]int64{7, -8}),
+		"mask":    tensors.FromValue([]bool{true, false, true}),
+		"bf16":    halfTensor,
+		"pixels":  tensors.FromValue([][]uint8{{1}, {2}}),
+		"scalar":  tensors.FromValue(int16(-3)),
+		"float64": tensors.FromValue([]float64{0.5}),
+	}
+	path := filepath.Join(t.TempDir(), "out.safetensors")
+	require.NoError(t, Write(path, written, map[string]string{"format": "pt"}))
+
+	reader, err := OpenTensorReader(path)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reader.Close()) }()
+	assert.Equal(t, map[string]any{"format": "pt"}, reader.Header.Metadata)
+	assert.Zero(t, reader.dataOffset%headerAlignment)
+	require.NoError(t, reader.Header.Validate(reader.dataSize()))
+	assert.Equal(t, "BF16", reader.Header.Tensors["bf16"].Dtype)
+	assert.Equal(t, "BOOL", reader.Header.Tensors["mask"].Dtype)
+	for name, want := range written {
+		got, err := reader.ReadTensor(nil, name)
+		require.NoError(t, err, "reading tensor %q", name)
+		assert.Equal(t, want.Shape(), got.Shape(), "tensor %q", name)
+		want.ConstBytes(func(wantBytes []byte) {
+			got.ConstBytes(func(gotBytes []byte) {
+				assert.Equal(t, wantBytes, gotBytes, "tensor %q", name)
+			})
+		})
+	}
+
+	// Without metadata.
+	require.NoError(t, Write(path, map[string]*tensors.Tensor{"x": tensors.FromValue([]int32{1})}, nil))
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(contents), "__metadata__")
+
+	// The dtypes written are the ones read.
+	for dtype, stDtype := range goMLXToDtype {
+		readDtype, err := dtypeToGoMLX(stDtype)
+		require.NoError(t, err)
+		assert.Equal(t, dtype, readDtype, "safetensors dtype %q", stDtype)
+	}
+
+	// Errors.
+	err = Write(path, map[string]*tensors.Tensor{"c": tensors.FromValue([]complex64{1})}, nil)
+	assert.ErrorContains(t, err, "not supported")
+	err = Write(path, map[string]*tensors.Tensor{"__metadata__": tensors.FromValue([]int32{1})}, nil)
+	assert.ErrorContains(t, err, "reserved")
+}
+
+func TestConsolidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Place the shards directly in the cache, so no download is needed.
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	cacheDir, err := repo.CacheDir()
+	require.NoError(t, err)
+	snapshotDir := filepath.Join(cacheDir, "snapshots", "abc123")
+	require.NoError(t, os.MkdirAll(snapshotDir, 0o755))
+	require.NoError(t, Write(filepath.Join(snapshotDir, "model-00001-of-00002.safetensors"), map[string]*tensors.Tensor{
+		"model.embed.weight": tensors.FromValue([][]float32{{1, 2}, {3, 4}}),
+		"model.norm.weight":  tensors.FromValue([]float32{5, 6}),
+	}, map[string]string{"format": "pt"}))
+	// The second shard has a dtype not supported by GoMLX.
+	contents, err := os.ReadFile(writeTestSafetensors(t, `{"lm_head.weight":{"dtype":"F8_E4M3","shape":[2,2],"data_offsets":[0,4]}}`, 4))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, "model-00002-of-00002.safetensors"), contents, 0o644))
+
+	m := NewEmpty(repo).WithNamePrefix("model.")
+	m.Index = &ShardedModelIndex{WeightMap: map[string]string{
+		"model.embed.weight": "model-00001-of-00002.safetensors",
+		"model.norm.weight":  "model-00001-of-00002.safetensors",
+		"lm_head.weight":     "model-00002-of-00002.safetensors",
+	}}
+	outPath := filepath.Join(t.TempDir(), "model.safetensors")
+	require.NoError(t, m.Consolidate(outPath))
+
+	reader, err := OpenTensorReader(outPath)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, reader.Close()) }()
+	assert.Equal(t, map[string]any{"format": "pt", "total_size": "28"}, reader.Header.Metadata)
+	require.NoError(t, reader.Header.Validate(reader.dataSize()))
+	assert.Len(t, reader.Header.Tensors, 3)
+	assert.Equal(t, "F8_E4M3", reader.Header.Tensors["lm_head.weight"].Dtype)
+	assert.Equal(t, []int{2, 2}, reader.Header.Tensors["lm_head.weight"].Shape)
+	embed, err := reader.ReadTensor(nil, "model.embed.weight")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 2}, {3, 4}}, embed.Value())
+	norm, err := reader.ReadTensor(nil, "model.norm.weight")
+	require.NoError(t, err)
+	assert.Equal(t, []float32{5, 6}, norm.Value())
+
+	// Not loaded.
+	assert.Error(t, NewEmpty(repo).Consolidate(outPath))
+}
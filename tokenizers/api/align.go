@@ -0,0 +1,77 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WordLabel is a word of the original text with the label assigned to it, see AlignLabels.
+type WordLabel struct {
+	Word  string
+	Span  TokenSpan // Byte span of the word in the original text.
+	Label string
+}
+
+// AlignLabels collapses token-level labels (e.g. the predictions of a token classification/NER model) to
+// word-level labels, using the label of the first token (subword) of each word, by convention.
+//
+// The encoding must have been created with spans (see EncodeOptions.IncludeSpans) from text, and labels must
+// have one entry per token in encoding.IDs. Tokens without a span (special tokens like [CLS]) are skipped, and
+// tokens without a label get "".
+//
+// Words are the whitespace-separated parts of text covered by the tokens: a token starts a new word if it is
+// separated from the previous one by whitespace (or its span starts with whitespace, as in byte-level BPE).
+func AlignLabels(text string, encoding AnnotatedEncoding, labels []string) []WordLabel {
+	var words []WordLabel
+	current := -1 // Index of the current word in words, or -1 if none.
+	for i, span := range encoding.Spans {
+		if span.Start < 0 || span.End <= span.Start || span.End > len(text) {
+			continue
+		}
+		start := span.Start
+		for start < span.End {
+			r, size := utf8.DecodeRuneInString(text[start:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			start += size
+		}
+		if start == span.End {
+			// Token only covers whitespace.
+			continue
+		}
+		if current < 0 || (start > words[current].Span.End && containsSpace(text[words[current].Span.End:start])) {
+			var label string
+			if i < len(labels) {
+				label = labels[i]
+			}
+			words = append(words, WordLabel{Span: TokenSpan{Start: start, End: span.End}, Label: label})
+			current = len(words) - 1
+			continue
+		}
+		words[current].Span.End = max(words[current].Span.End, span.End)
+	}
+	for i := range words {
+		words[i].Word = text[words[i].Span.Start:words[i].Span.End]
+	}
+	return words
+}
+
+// containsSpace returns whether s has any whitespace character.
+func containsSpace(s string) bool {
+	return strings.IndexFunc(s, unicode.IsSpace) >= 0
+}
+
+// FormatCoNLL formats the word labels in the CoNLL format: one "word<TAB>label" line per word.
+// Sentences (e.g. the results of AlignLabels for each of them) should be separated by an empty line.
+func FormatCoNLL(words []WordLabel) string {
+	var sb strings.Builder
+	for _, w := range words {
+		sb.WriteString(w.Word)
+		sb.WriteByte('\t')
+		sb.WriteString(w.Label)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
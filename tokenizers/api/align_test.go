@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlignLabels(t *testing.T) {
+	text := "John lives  in Zürich."
+	// Tokens as a WordPiece tokenizer would return: [CLS] john lives in zu ##rich . [SEP]
+	encoding := AnnotatedEncoding{
+		IDs: []int{101, 1, 2, 3, 4, 5, 6, 102},
+		Spans: []TokenSpan{{Start: -1, End: -1}, {Start: 0, End: 4}, {Start: 5, End: 10}, {Start: 12, End: 14},
+			{Start: 15, End: 18}, {Start: 18, End: 22}, {Start: 22, End: 23}, {Start: -1, End: -1}},
+	}
+	labels := []string{"O", "B-PER", "O", "O", "B-LOC", "I-LOC", "O", "O"}
+	words := AlignLabels(text, encoding, labels)
+	assert.Equal(t, []WordLabel{
+		{Word: "John", Span: TokenSpan{Start: 0, End: 4}, Label: "B-PER"},
+		{Word: "lives", Span: TokenSpan{Start: 5, End: 10}, Label: "O"},
+		{Word: "in", Span: TokenSpan{Start: 12, End: 14}, Label: "O"},
+		{Word: "Zürich.", Span: TokenSpan{Start: 15, End: 23}, Label: "B-LOC"},
+	}, words)
+	assert.Equal(t, "John\tB-PER\nlives\tO\nin\tO\nZürich.\tB-LOC\n", FormatCoNLL(words))
+
+	// Byte-level BPE spans include the preceding space.
+	text = "New York"
+	encoding = AnnotatedEncoding{
+		IDs:   []int{1, 2, 3},
+		Spans: []TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 6}, {Start: 6, End: 8}},
+	}
+	words = AlignLabels(text, encoding, []string{"B-LOC", "I-LOC"})
+	assert.Equal(t, []WordLabel{
+		{Word: "New", Span: TokenSpan{Start: 0, End: 3}, Label: "B-LOC"},
+		{Word: "York", Span: TokenSpan{Start: 4, End: 8}, Label: "I-LOC"},
+	}, words)
+}
@@ -1,7 +1,12 @@
 // Package api defines the Tokenizer API.
 package api
 
-import "errors"
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
 
 var ErrNotImplemented = errors.New("not implemented")
 
@@ -53,6 +58,32 @@ type AnnotatedEncoding struct {
 	IDs               []int       // token IDs
 	Spans             []TokenSpan // byte spans for each token (use originalText[span.Start:span.End] to extract)
 	SpecialTokensMask []int
+
+	// OffsetsApproximate is set if some of the Spans are approximations: e.g. when the offsets through a
+	// normalizer (like NFC) are interpolated instead of tracked exactly. Callers that need precise character
+	// ranges (NER) can use it to detect and warn about it.
+	OffsetsApproximate bool
+
+	// Tokens are the token strings (the vocabulary entries) of each of the IDs, useful for debugging and
+	// visualization.
+	Tokens []string
+
+	// AttentionMask is 1 for each of the tokens, and 0 for padding (there is no padding on a single encoding).
+	AttentionMask []int
+
+	// TypeIDs (also known as token type ids or segment ids) are 0 for the tokens of the first sequence and 1 for
+	// the tokens of the second sequence, when encoding sentence pairs. It is nil for single sequences.
+	TypeIDs []int
+
+	// SequenceIDs identify, when encoding sentence pairs, the sequence each token comes from: 0 for the first,
+	// 1 for the second and -1 for special tokens. Use it to know which text the Spans refer to, since TypeIDs
+	// are not always 1 for the second sequence (e.g. RoBERTa). It is nil for single sequences.
+	SequenceIDs []int
+
+	// NumTruncated is the number of tokens dropped to fit EncodeOptions.MaxLen, and Overflowing holds their IDs,
+	// in order. For sentence pairs, the dropped tokens of the first sequence come first.
+	NumTruncated int
+	Overflowing  []int
 }
 
 // TokenSpan represents the byte span of a token in the original text.
@@ -74,7 +105,8 @@ type EncodeOptions struct {
 	AddSpecialTokens bool
 
 	// MaxLen option takes an int value. Set it to a value <= 0 to disable MaxLen.
-	// Encoding will be truncated to this length.
+	// Encoding will be truncated to this length, including the special tokens added, and the number of dropped
+	// tokens reported in AnnotatedEncoding.NumTruncated.
 	MaxLen int
 
 	// IncludeSpans option takes a boolean, and indicates if EncodeWithAnnotations should include spans.
@@ -82,6 +114,55 @@ type EncodeOptions struct {
 
 	// IncludeSpecialTokensMask option takes a boolean value, and enables post-processing (e.g., [CLS]/[SEP] for BERT).
 	IncludeSpecialTokensMask bool
+
+	// IncludeTokens option takes a boolean, and indicates if EncodeWithAnnotations should include the token strings.
+	IncludeTokens bool
+
+	// IncludeAttentionMask option takes a boolean, and indicates if EncodeWithAnnotations should include the
+	// attention mask.
+	IncludeAttentionMask bool
+}
+
+// DecodeOptions for decoding batches of sequences, see DecodeBatch.
+type DecodeOptions struct {
+	// MaxParallelization is the maximum number of sequences decoded in parallel.
+	// Set it to 0 or 1 to decode sequentially, or to -1 to use runtime.NumCPU().
+	MaxParallelization int
+}
+
+// DecodeBatch decodes each sequence of batch with decode, in parallel if configured in opts.
+//
+// It is a helper for implementing DecodeBatch in tokenizers, and the decode function must be safe
+// for concurrent use if opts.MaxParallelization != 0 and != 1.
+func DecodeBatch(decode func([]int) string, batch [][]int, opts DecodeOptions) []string {
+	results := make([]string, len(batch))
+	maxParallelization := opts.MaxParallelization
+	if maxParallelization < 0 {
+		maxParallelization = runtime.NumCPU()
+	}
+	maxParallelization = min(maxParallelization, len(batch))
+	if maxParallelization <= 1 {
+		for i, ids := range batch {
+			results[i] = decode(ids)
+		}
+		return results
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range maxParallelization {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(batch) {
+					return
+				}
+				results[i] = decode(batch[i])
+			}
+		})
+	}
+	wg.Wait()
+	return results
 }
 
 // SpecialToken is an enum of commonly used special tokens.
@@ -0,0 +1,484 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ChatMessage is one message of a conversation, see ApplyChatTemplate.
+type ChatMessage struct {
+	// Role of the author of the message, usually "system", "user" or "assistant".
+	Role string `json:"role"`
+
+	// Content of the message.
+	Content string `json:"content"`
+}
+
+// ApplyChatTemplate renders the conversation messages with the Jinja "chat_template" of the config (from
+// tokenizer_config.json) into a prompt string, ready to be encoded.
+//
+// If addGenerationPrompt is set, the template appends the tokens that start an assistant reply (for templates
+// that support it).
+//
+// Only a minimal subset of Jinja is supported, enough for the common templates:
+//
+//   - Statements "if"/"elif"/"else", "for" (with the "loop" variable, but no loop filters "for ... if ...") and
+//     "set" (including "namespace()" attributes), and comments.
+//   - Whitespace control ("{%-", "-%}", ...), with the trim_blocks and lstrip_blocks options used by HuggingFace.
+//   - Expressions with literals, lists, attributes, indexing and slicing, arithmetic, comparisons, "in",
+//     "and"/"or"/"not", "is" tests, conditional expressions, common filters (e.g. "trim", "length", "tojson") and
+//     string methods (e.g. "strip()"), and "raise_exception()". Mappings keep their keys in insertion order, and
+//     "tojson" formats them as Python's json.dumps (as transformers does).
+//
+// The variables available to the template are "messages", "add_generation_prompt", and the special tokens
+// "bos_token", "eos_token", "unk_token", "pad_token", "cls_token", "sep_token" and "mask_token".
+func ApplyChatTemplate(cfg *Config, messages []ChatMessage, addGenerationPrompt bool) (string, error) {
+	if cfg == nil || cfg.ChatTemplate == "" {
+		return "", errors.New("tokenizer config has no chat_template")
+	}
+	nodes, err := parseChatTemplate(cfg.ChatTemplate)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse chat_template")
+	}
+	messagesValue := make([]any, len(messages))
+	for i, message := range messages {
+		messageValue := newTemplateDict()
+		messageValue.set("role", message.Role)
+		messageValue.set("content", message.Content)
+		messagesValue[i] = messageValue
+	}
+	scope := &templateScope{vars: map[string]any{
+		"messages":              messagesValue,
+		"add_generation_prompt": addGenerationPrompt,
+		"bos_token":             cfg.BosToken,
+		"eos_token":             cfg.EosToken,
+		"unk_token":             cfg.UnkToken,
+		"pad_token":             cfg.PadToken,
+		"cls_token":             cfg.ClsToken,
+		"sep_token":             cfg.SepToken,
+		"mask_token":            cfg.MaskToken,
+	}}
+	var sb strings.Builder
+	if err := renderTemplateNodes(&sb, nodes, scope); err != nil {
+		return "", errors.WithMessage(err, "failed to render chat_template")
+	}
+	return sb.String(), nil
+}
+
+// templateSegment is a piece of the template source: either text, or the content of a "{{ }}" (kind '{'),
+// "{% %}" (kind '%') or "{# #}" (kind '#') tag.
+type templateSegment struct {
+	kind    byte
+	content string
+
+	// trimLeft and trimRight are set by the "-" whitespace control of tags, e.g. "{%-" and "-%}".
+	trimLeft, trimRight bool
+}
+
+// splitTemplate splits the template source into segments, and applies the whitespace control to the text
+// segments.
+func splitTemplate(source string) ([]templateSegment, error) {
+	var segments []templateSegment
+	for len(source) > 0 {
+		start := indexTemplateTag(source)
+		if start < 0 {
+			segments = append(segments, templateSegment{content: source})
+			break
+		}
+		if start > 0 {
+			segments = append(segments, templateSegment{content: source[:start]})
+		}
+		seg := templateSegment{kind: source[start+1]}
+		closing := map[byte]string{'{': "}}", '%': "%}", '#': "#}"}[seg.kind]
+		body := source[start+2:]
+		if strings.HasPrefix(body, "-") {
+			seg.trimLeft = true
+			body = body[1:]
+		}
+		end := indexTagEnd(body, closing, seg.kind == '#')
+		if end < 0 {
+			return nil, errors.Errorf("tag %q not closed", source[start:min(start+20, len(source))])
+		}
+		content := body[:end]
+		if strings.HasSuffix(content, "-") {
+			seg.trimRight = true
+			content = content[:len(content)-1]
+		}
+		seg.content = strings.TrimSpace(content)
+		segments = append(segments, seg)
+		source = body[end+len(closing):]
+	}
+
+	// Whitespace control: HuggingFace renders the templates with trim_blocks (the first newline after a
+	// statement is removed) and lstrip_blocks (spaces and tabs from the start of the line to a statement are
+	// removed).
+	for i := range segments {
+		if segments[i].kind != 0 {
+			continue
+		}
+		original := segments[i].content
+		text := original
+		if i > 0 {
+			if prev := segments[i-1]; prev.trimRight {
+				text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			} else if prev.kind == '%' || prev.kind == '#' {
+				text = strings.TrimPrefix(text, "\n")
+			}
+		}
+		if i+1 < len(segments) {
+			if next := segments[i+1]; next.trimLeft {
+				text = strings.TrimRightFunc(text, unicode.IsSpace)
+			} else if next.kind == '%' || next.kind == '#' {
+				if i == 0 || strings.Contains(original, "\n") {
+					lineStart := strings.LastIndexByte(text, '\n') + 1
+					if strings.Trim(text[lineStart:], " \t") == "" {
+						text = text[:lineStart]
+					}
+				}
+			}
+		}
+		segments[i].content = text
+	}
+	return segments, nil
+}
+
+// indexTemplateTag returns the position of the first "{{", "{%" or "{#" in source, or -1.
+func indexTemplateTag(source string) int {
+	for pos := 0; ; {
+		i := strings.IndexByte(source[pos:], '{')
+		if i < 0 || pos+i+1 >= len(source) {
+			return -1
+		}
+		pos += i + 1
+		if c := source[pos]; c == '{' || c == '%' || c == '#' {
+			return pos - 1
+		}
+	}
+}
+
+// indexTagEnd returns the position of the closing of a tag in body, skipping quoted strings (except in
+// comments), or -1 if not found. A "-" whitespace control before the closing is part of the tag content.
+func indexTagEnd(body, closing string, isComment bool) int {
+	if isComment {
+		return strings.Index(body, closing)
+	}
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; c {
+		case '\'', '"':
+			for i++; i < len(body) && body[i] != c; i++ {
+				if body[i] == '\\' {
+					i++
+				}
+			}
+		default:
+			if strings.HasPrefix(body[i:], closing) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// templateNode is a parsed element of a template.
+type templateNode interface {
+	render(sb *strings.Builder, scope *templateScope) error
+}
+
+// templateText is literal text.
+type templateText string
+
+func (n templateText) render(sb *strings.Builder, _ *templateScope) error {
+	sb.WriteString(string(n))
+	return nil
+}
+
+// templateOutput is a "{{ expression }}".
+type templateOutput struct {
+	value templateExpr
+}
+
+func (n *templateOutput) render(sb *strings.Builder, scope *templateScope) error {
+	value, err := n.value(scope)
+	if err != nil {
+		return err
+	}
+	sb.WriteString(templateString(value))
+	return nil
+}
+
+// templateIf is an "if"/"elif"/"else" statement.
+type templateIf struct {
+	conditions []templateExpr
+	bodies     [][]templateNode
+	elseBody   []templateNode
+}
+
+func (n *templateIf) render(sb *strings.Builder, scope *templateScope) error {
+	for i, condition := range n.conditions {
+		value, err := condition(scope)
+		if err != nil {
+			return err
+		}
+		if templateTruth(value) {
+			return renderTemplateNodes(sb, n.bodies[i], scope)
+		}
+	}
+	return renderTemplateNodes(sb, n.elseBody, scope)
+}
+
+// templateFor is a "for" loop. The variables set in the loop don't leak out of it.
+type templateFor struct {
+	names    []string
+	iterable templateExpr
+	body     []templateNode
+}
+
+func (n *templateFor) render(sb *strings.Builder, scope *templateScope) error {
+	value, err := n.iterable(scope)
+	if err != nil {
+		return err
+	}
+	items, err := templateIterate(value)
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		loopScope := &templateScope{vars: map[string]any{}, parent: scope}
+		loop := newTemplateDict()
+		loop.set("index", i+1)
+		loop.set("index0", i)
+		loop.set("revindex", len(items)-i)
+		loop.set("revindex0", len(items)-i-1)
+		loop.set("first", i == 0)
+		loop.set("last", i == len(items)-1)
+		loop.set("length", len(items))
+		loopScope.vars["loop"] = loop
+		if len(n.names) == 1 {
+			loopScope.vars[n.names[0]] = item
+		} else {
+			values, ok := item.([]any)
+			if !ok || len(values) != len(n.names) {
+				return errors.Errorf("can't unpack %s into %d loop variables", templateString(item), len(n.names))
+			}
+			for j, name := range n.names {
+				loopScope.vars[name] = values[j]
+			}
+		}
+		if err := renderTemplateNodes(sb, n.body, loopScope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateSet is a "set" statement, assigning a variable or the attribute of a namespace.
+type templateSet struct {
+	name, attr string
+	value      templateExpr
+}
+
+func (n *templateSet) render(_ *strings.Builder, scope *templateScope) error {
+	value, err := n.value(scope)
+	if err != nil {
+		return err
+	}
+	if n.attr == "" {
+		scope.vars[n.name] = value
+		return nil
+	}
+	namespace, ok := scope.get(n.name).(*templateDict)
+	if !ok {
+		return errors.Errorf("can't set attribute %q of %q: not a namespace", n.attr, n.name)
+	}
+	namespace.set(n.attr, value)
+	return nil
+}
+
+func renderTemplateNodes(sb *strings.Builder, nodes []templateNode, scope *templateScope) error {
+	for _, node := range nodes {
+		if err := node.render(sb, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseChatTemplate parses the template source into nodes.
+func parseChatTemplate(source string) ([]templateNode, error) {
+	segments, err := splitTemplate(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &templateParser{segments: segments}
+	nodes, _, _, err := p.parseNodes()
+	return nodes, err
+}
+
+// templateParser parses the statements of a template.
+type templateParser struct {
+	segments []templateSegment
+	pos      int
+}
+
+// parseNodes parses the nodes until one of the stop statements (e.g. "endif"), and returns the statement found
+// and its arguments.
+func (p *templateParser) parseNodes(stop ...string) (nodes []templateNode, statement, args string, err error) {
+	for p.pos < len(p.segments) {
+		seg := p.segments[p.pos]
+		p.pos++
+		switch seg.kind {
+		case 0:
+			if seg.content != "" {
+				nodes = append(nodes, templateText(seg.content))
+			}
+		case '{':
+			value, err := parseTemplateExpr(seg.content)
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, &templateOutput{value: value})
+		case '%':
+			statement, args, _ := strings.Cut(seg.content, " ")
+			args = strings.TrimSpace(args)
+			if slices.Contains(stop, statement) {
+				return nodes, statement, args, nil
+			}
+			var node templateNode
+			switch statement {
+			case "if":
+				node, err = p.parseIf(args)
+			case "for":
+				node, err = p.parseFor(args)
+			case "set":
+				node, err = parseSet(args)
+			default:
+				err = errors.Errorf("unsupported statement {%% %s %%}", seg.content)
+			}
+			if err != nil {
+				return nil, "", "", err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	if len(stop) > 0 {
+		return nil, "", "", errors.Errorf("missing {%% %s %%}", stop[len(stop)-1])
+	}
+	return nodes, "", "", nil
+}
+
+func (p *templateParser) parseIf(condition string) (templateNode, error) {
+	node := &templateIf{}
+	for {
+		value, err := parseTemplateExpr(condition)
+		if err != nil {
+			return nil, err
+		}
+		body, statement, args, err := p.parseNodes("elif", "else", "endif")
+		if err != nil {
+			return nil, err
+		}
+		node.conditions = append(node.conditions, value)
+		node.bodies = append(node.bodies, body)
+		switch statement {
+		case "elif":
+			condition = args
+		case "else":
+			node.elseBody, _, _, err = p.parseNodes("endif")
+			return node, err
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (p *templateParser) parseFor(args string) (templateNode, error) {
+	names, iterable, found := strings.Cut(args, " in ")
+	if !found {
+		return nil, errors.Errorf("invalid {%% for %s %%}", args)
+	}
+	node := &templateFor{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if !isTemplateName(name) {
+			return nil, errors.Errorf("invalid loop variable %q in {%% for %s %%}", name, args)
+		}
+		node.names = append(node.names, name)
+	}
+	if hasTopLevelIf(iterable) {
+		return nil, errors.Errorf("unsupported {%% for %s %%}: loop filters (\"for ... if ...\") are not supported", args)
+	}
+	var err error
+	if node.iterable, err = parseTemplateExpr(iterable); err != nil {
+		return nil, err
+	}
+	node.body, _, _, err = p.parseNodes("endfor")
+	return node, err
+}
+
+// hasTopLevelIf returns whether the expression has an "if" outside parentheses or brackets: in the iterable of a
+// "for" statement, Jinja parses it as a loop filter, not as a conditional expression.
+func hasTopLevelIf(source string) bool {
+	tokens, err := lexTemplateExpr(source)
+	if err != nil {
+		return false
+	}
+	depth := 0
+	for _, token := range tokens {
+		switch {
+		case token.kind == 'o' && strings.ContainsAny(token.text, "([{"):
+			depth++
+		case token.kind == 'o' && strings.ContainsAny(token.text, ")]}"):
+			depth--
+		case token.kind == 'n' && token.text == "if" && depth == 0:
+			return true
+		}
+	}
+	return false
+}
+
+func parseSet(args string) (templateNode, error) {
+	target, value, found := strings.Cut(args, "=")
+	if !found {
+		return nil, errors.Errorf("unsupported {%% set %s %%}: only assignments are supported", args)
+	}
+	node := &templateSet{}
+	node.name, node.attr, _ = strings.Cut(strings.TrimSpace(target), ".")
+	if !isTemplateName(node.name) || (node.attr != "" && !isTemplateName(node.attr)) {
+		return nil, errors.Errorf("invalid target %q in {%% set %s %%}", target, args)
+	}
+	var err error
+	node.value, err = parseTemplateExpr(value)
+	return node, err
+}
+
+// isTemplateName returns whether name is a valid variable name.
+func isTemplateName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// templateScope holds the variables of a template, with nested scopes for the loops.
+type templateScope struct {
+	vars   map[string]any
+	parent *templateScope
+}
+
+// get returns the variable, or templateUndefined if it is not defined.
+func (s *templateScope) get(name string) any {
+	for ; s != nil; s = s.parent {
+		if value, found := s.vars[name]; found {
+			return value
+		}
+	}
+	return templateUndefined{}
+}
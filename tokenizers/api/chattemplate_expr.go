@@ -0,0 +1,1229 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// templateExpr is a parsed chat template expression.
+type templateExpr func(scope *templateScope) (any, error)
+
+// templateUndefined is the value of undefined variables and attributes: it renders as an empty string, and is
+// false.
+type templateUndefined struct{}
+
+// templateDict is the value of mappings (Python dicts): as in Python, the keys are kept in insertion order.
+type templateDict struct {
+	keys   []string
+	values map[string]any
+}
+
+// newTemplateDict returns an empty templateDict.
+func newTemplateDict() *templateDict {
+	return &templateDict{values: make(map[string]any)}
+}
+
+// get returns the value of key, and whether it is set.
+func (d *templateDict) get(key string) (any, bool) {
+	value, found := d.values[key]
+	return value, found
+}
+
+// set sets the value of key, appending it to the keys if it is new.
+func (d *templateDict) set(key string, value any) {
+	if _, found := d.values[key]; !found {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+}
+
+// templateToken is a token of an expression: kind is 'n' for names, 's' for strings, '0' for numbers and 'o' for
+// operators and punctuation.
+type templateToken struct {
+	kind byte
+	text string
+}
+
+// templateOperators are the operators and punctuation of expressions, longest first.
+var templateOperators = []string{
+	"==", "!=", "<=", ">=", "//", "+", "-", "*", "/", "%", "~", "|", ".", "[", "]", "(", ")", ",", ":", "<", ">",
+	"=",
+}
+
+// lexTemplateExpr splits the expression source into tokens.
+func lexTemplateExpr(source string) ([]templateToken, error) {
+	var tokens []templateToken
+	for pos := 0; pos < len(source); {
+		c := source[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			end := pos + 1
+			for ; end < len(source) && source[end] != c; end++ {
+				if source[end] == '\\' && end+1 < len(source) {
+					end++
+					switch source[end] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					case 'r':
+						sb.WriteByte('\r')
+					default:
+						sb.WriteByte(source[end])
+					}
+					continue
+				}
+				sb.WriteByte(source[end])
+			}
+			if end >= len(source) {
+				return nil, errors.Errorf("unterminated string in %q", source)
+			}
+			tokens = append(tokens, templateToken{kind: 's', text: sb.String()})
+			pos = end + 1
+		case c >= '0' && c <= '9':
+			end := pos
+			for end < len(source) && (source[end] >= '0' && source[end] <= '9' ||
+				source[end] == '.' && end+1 < len(source) && source[end+1] >= '0' && source[end+1] <= '9') {
+				end++
+			}
+			tokens = append(tokens, templateToken{kind: '0', text: source[pos:end]})
+			pos = end
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := pos
+			for end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) ||
+				unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, templateToken{kind: 'n', text: source[pos:end]})
+			pos = end
+		default:
+			found := false
+			for _, op := range templateOperators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, templateToken{kind: 'o', text: op})
+					pos += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, errors.Errorf("unexpected character %q in %q", c, source)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parseTemplateExpr parses an expression.
+func parseTemplateExpr(source string) (templateExpr, error) {
+	tokens, err := lexTemplateExpr(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &templateExprParser{tokens: tokens, source: source}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.Errorf("unexpected %q in expression %q", p.tokens[p.pos].text, source)
+	}
+	return expr, nil
+}
+
+// templateExprParser is a recursive descent parser of expressions, following Jinja's precedences.
+type templateExprParser struct {
+	tokens []templateToken
+	pos    int
+	source string
+}
+
+func (p *templateExprParser) peek(offset int) templateToken {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return templateToken{}
+}
+
+// accept consumes the next token if it is of the given kind and text.
+func (p *templateExprParser) accept(kind byte, text string) bool {
+	if token := p.peek(0); token.kind == kind && token.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *templateExprParser) expect(text string) error {
+	if !p.accept('o', text) {
+		return errors.Errorf("expected %q in expression %q", text, p.source)
+	}
+	return nil
+}
+
+func (p *templateExprParser) expectName() (string, error) {
+	token := p.peek(0)
+	if token.kind != 'n' {
+		return "", errors.Errorf("expected a name in expression %q", p.source)
+	}
+	p.pos++
+	return token.text, nil
+}
+
+// parseExpr parses a conditional expression: "value if condition else otherwise".
+func (p *templateExprParser) parseExpr() (templateExpr, error) {
+	value, err := p.parseOr()
+	if err != nil || !p.accept('n', "if") {
+		return value, err
+	}
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	otherwise := templateConst(templateUndefined{})
+	if p.accept('n', "else") {
+		if otherwise, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return func(scope *templateScope) (any, error) {
+		c, err := condition(scope)
+		if err != nil {
+			return nil, err
+		}
+		if templateTruth(c) {
+			return value(scope)
+		}
+		return otherwise(scope)
+	}, nil
+}
+
+func (p *templateExprParser) parseOr() (templateExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept('n', "or") {
+		var right templateExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = templateShortCircuit(left, right, true)
+		}
+	}
+	return left, err
+}
+
+func (p *templateExprParser) parseAnd() (templateExpr, error) {
+	left, err := p.parseNot()
+	for err == nil && p.accept('n', "and") {
+		var right templateExpr
+		if right, err = p.parseNot(); err == nil {
+			left = templateShortCircuit(left, right, false)
+		}
+	}
+	return left, err
+}
+
+// templateShortCircuit implements "or" (if isOr) and "and": like Python, it returns the last operand evaluated.
+func templateShortCircuit(left, right templateExpr, isOr bool) templateExpr {
+	return func(scope *templateScope) (any, error) {
+		value, err := left(scope)
+		if err != nil || templateTruth(value) == isOr {
+			return value, err
+		}
+		return right(scope)
+	}
+}
+
+func (p *templateExprParser) parseNot() (templateExpr, error) {
+	if !p.accept('n', "not") {
+		return p.parseCompare()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(scope *templateScope) (any, error) {
+		value, err := operand(scope)
+		return !templateTruth(value), err
+	}, nil
+}
+
+func (p *templateExprParser) parseCompare() (templateExpr, error) {
+	left, err := p.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek(0)
+		var op func(a, b any) (any, error)
+		switch {
+		case token.kind == 'o' && slices.Contains([]string{"==", "!=", "<", ">", "<=", ">="}, token.text):
+			p.pos++
+			op = func(a, b any) (any, error) { return templateCompare(token.text, a, b) }
+		case token.kind == 'n' && token.text == "in":
+			p.pos++
+			op = func(a, b any) (any, error) { return templateContains(b, a) }
+		case token.kind == 'n' && token.text == "not" && p.peek(1).kind == 'n' && p.peek(1).text == "in":
+			p.pos += 2
+			op = func(a, b any) (any, error) {
+				found, err := templateContains(b, a)
+				return !found, err
+			}
+		case token.kind == 'n' && token.text == "is":
+			p.pos++
+			negate := p.accept('n', "not")
+			test, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			left = templateTest(left, test, negate)
+			continue
+		default:
+			return left, nil
+		}
+		right, err := p.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+		left = templateBinary(left, right, op)
+	}
+}
+
+// parseBinary parses a left-associative sequence of the given operators, with operands parsed by next.
+func (p *templateExprParser) parseBinary(next func() (templateExpr, error), ops ...string) (templateExpr, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek(0)
+		if token.kind != 'o' || !slices.Contains(ops, token.text) {
+			return left, nil
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = templateBinary(left, right, func(a, b any) (any, error) { return templateArithmetic(token.text, a, b) })
+	}
+}
+
+func (p *templateExprParser) parseMath1() (templateExpr, error) {
+	return p.parseBinary(p.parseConcat, "+", "-")
+}
+
+func (p *templateExprParser) parseConcat() (templateExpr, error) {
+	return p.parseBinary(p.parseMath2, "~")
+}
+
+func (p *templateExprParser) parseMath2() (templateExpr, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "//", "%")
+}
+
+func (p *templateExprParser) parseUnary() (templateExpr, error) {
+	if !p.accept('o', "-") {
+		return p.parsePostfix()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return templateBinary(templateConst(0), operand, func(a, b any) (any, error) {
+		return templateArithmetic("-", a, b)
+	}), nil
+}
+
+// parsePostfix parses a primary expression followed by attributes, indexing, slicing, method calls and filters.
+func (p *templateExprParser) parsePostfix() (templateExpr, error) {
+	value, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept('o', "."):
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if p.accept('o', "(") {
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				value = templateMethodCall(value, name, args)
+			} else {
+				value = templateBinary(value, templateConst(name), templateGetItem)
+			}
+		case p.accept('o', "["):
+			if value, err = p.parseSubscript(value); err != nil {
+				return nil, err
+			}
+		case p.accept('o', "|"):
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			var args []templateExpr
+			if p.accept('o', "(") {
+				if args, err = p.parseArgs(); err != nil {
+					return nil, err
+				}
+			}
+			value = templateFilter(value, name, args)
+		default:
+			return value, nil
+		}
+	}
+}
+
+// parseSubscript parses an index or slice, after the "[".
+func (p *templateExprParser) parseSubscript(value templateExpr) (templateExpr, error) {
+	var bounds [3]templateExpr
+	isSlice := false
+	for i := range bounds {
+		if i > 0 {
+			if !p.accept('o', ":") {
+				break
+			}
+			isSlice = true
+		}
+		if token := p.peek(0); token.kind == 'o' && (token.text == ":" || token.text == "]") {
+			continue
+		}
+		var err error
+		if bounds[i], err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	if !isSlice {
+		return templateBinary(value, bounds[0], templateGetItem), nil
+	}
+	return func(scope *templateScope) (any, error) {
+		v, err := value(scope)
+		if err != nil {
+			return nil, err
+		}
+		var indices [3]any
+		for i, bound := range bounds {
+			if bound != nil {
+				if indices[i], err = bound(scope); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return templateSlice(v, indices)
+	}, nil
+}
+
+// parseArgs parses the arguments of a call, after the "(". Keyword arguments are only used by namespace(), and
+// are returned as a templateDict, in order, in the last argument.
+func (p *templateExprParser) parseArgs() ([]templateExpr, error) {
+	var args []templateExpr
+	var kwargNames []string
+	var kwargs []templateExpr
+	for !p.accept('o', ")") {
+		if len(args) > 0 || kwargs != nil {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek(0).kind == 'n' && p.peek(1).kind == 'o' && p.peek(1).text == "=" {
+			name := p.peek(0).text
+			p.pos += 2
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			kwargNames = append(kwargNames, name)
+			kwargs = append(kwargs, value)
+			continue
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if kwargs != nil {
+		args = append(args, func(scope *templateScope) (any, error) {
+			values := newTemplateDict()
+			for i, kwarg := range kwargs {
+				value, err := kwarg(scope)
+				if err != nil {
+					return nil, err
+				}
+				values.set(kwargNames[i], value)
+			}
+			return values, nil
+		})
+	}
+	return args, nil
+}
+
+func (p *templateExprParser) parsePrimary() (templateExpr, error) {
+	token := p.peek(0)
+	p.pos++
+	switch token.kind {
+	case 's':
+		return templateConst(token.text), nil
+	case '0':
+		if strings.Contains(token.text, ".") {
+			f, err := strconv.ParseFloat(token.text, 64)
+			return templateConst(f), errors.Wrapf(err, "invalid number in expression %q", p.source)
+		}
+		i, err := strconv.Atoi(token.text)
+		return templateConst(i), errors.Wrapf(err, "invalid number in expression %q", p.source)
+	case 'n':
+		switch token.text {
+		case "true", "True":
+			return templateConst(true), nil
+		case "false", "False":
+			return templateConst(false), nil
+		case "none", "None":
+			return templateConst(nil), nil
+		}
+		if p.accept('o', "(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return templateFunctionCall(token.text, args)
+		}
+		return func(scope *templateScope) (any, error) { return scope.get(token.text), nil }, nil
+	case 'o':
+		switch token.text {
+		case "(":
+			value, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return value, p.expect(")")
+		case "[":
+			var items []templateExpr
+			for !p.accept('o', "]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+					if p.accept('o', "]") {
+						break
+					}
+				}
+				item, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return templateEvalAll(items, func(values []any) (any, error) { return values, nil }), nil
+		}
+	}
+	if token.kind == 0 {
+		return nil, errors.Errorf("unexpected end of expression %q", p.source)
+	}
+	return nil, errors.Errorf("unexpected %q in expression %q", token.text, p.source)
+}
+
+func templateConst(value any) templateExpr {
+	return func(*templateScope) (any, error) { return value, nil }
+}
+
+func templateBinary(left, right templateExpr, op func(a, b any) (any, error)) templateExpr {
+	return func(scope *templateScope) (any, error) {
+		a, err := left(scope)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(scope)
+		if err != nil {
+			return nil, err
+		}
+		return op(a, b)
+	}
+}
+
+// templateEvalAll returns an expression that evaluates all exprs, and then calls fn with their values.
+func templateEvalAll(exprs []templateExpr, fn func(values []any) (any, error)) templateExpr {
+	return func(scope *templateScope) (any, error) {
+		values := make([]any, len(exprs))
+		for i, expr := range exprs {
+			var err error
+			if values[i], err = expr(scope); err != nil {
+				return nil, err
+			}
+		}
+		return fn(values)
+	}
+}
+
+// templateFunctionCall returns the call to one of the global functions.
+func templateFunctionCall(name string, args []templateExpr) (templateExpr, error) {
+	switch name {
+	case "raise_exception":
+		return templateEvalAll(args, func(values []any) (any, error) {
+			message := ""
+			if len(values) > 0 {
+				message = templateString(values[0])
+			}
+			return nil, errors.Errorf("chat template raised exception: %s", message)
+		}), nil
+	case "namespace":
+		return templateEvalAll(args, func(values []any) (any, error) {
+			namespace := newTemplateDict()
+			if len(values) > 0 {
+				if kwargs, ok := values[len(values)-1].(*templateDict); ok {
+					for _, key := range kwargs.keys {
+						namespace.set(key, kwargs.values[key])
+					}
+				}
+			}
+			return namespace, nil
+		}), nil
+	case "range":
+		return templateEvalAll(args, func(values []any) (any, error) {
+			var bounds []int
+			for _, value := range values {
+				n, ok := value.(int)
+				if !ok {
+					return nil, errors.Errorf("range() arguments must be integers, got %s", templateString(value))
+				}
+				bounds = append(bounds, n)
+			}
+			start, stop := 0, 0
+			switch len(bounds) {
+			case 1:
+				stop = bounds[0]
+			case 2:
+				start, stop = bounds[0], bounds[1]
+			default:
+				return nil, errors.Errorf("range() takes 1 or 2 arguments, got %d", len(bounds))
+			}
+			var items []any
+			for i := start; i < stop; i++ {
+				items = append(items, i)
+			}
+			return items, nil
+		}), nil
+	}
+	return nil, errors.Errorf("unknown function %q", name)
+}
+
+// templateMethodCall returns the call of the method of a string or a mapping.
+func templateMethodCall(object templateExpr, name string, args []templateExpr) templateExpr {
+	return templateEvalAll(append([]templateExpr{object}, args...), func(values []any) (any, error) {
+		self, args := values[0], values[1:]
+		stringArg := func(i int, defaultValue string) string {
+			if i < len(args) {
+				return templateString(args[i])
+			}
+			return defaultValue
+		}
+		switch s := self.(type) {
+		case string:
+			switch name {
+			case "strip":
+				return strings.Trim(s, stringArg(0, " \t\n\r")), nil
+			case "lstrip":
+				return strings.TrimLeft(s, stringArg(0, " \t\n\r")), nil
+			case "rstrip":
+				return strings.TrimRight(s, stringArg(0, " \t\n\r")), nil
+			case "upper":
+				return strings.ToUpper(s), nil
+			case "lower":
+				return strings.ToLower(s), nil
+			case "startswith":
+				return strings.HasPrefix(s, stringArg(0, "")), nil
+			case "endswith":
+				return strings.HasSuffix(s, stringArg(0, "")), nil
+			case "replace":
+				return strings.ReplaceAll(s, stringArg(0, ""), stringArg(1, "")), nil
+			case "split":
+				var parts []string
+				if len(args) == 0 {
+					parts = strings.Fields(s)
+				} else {
+					parts = strings.Split(s, stringArg(0, ""))
+				}
+				items := make([]any, len(parts))
+				for i, part := range parts {
+					items[i] = part
+				}
+				return items, nil
+			}
+		case *templateDict:
+			switch name {
+			case "get":
+				if value, found := s.get(stringArg(0, "")); found {
+					return value, nil
+				}
+				if len(args) > 1 {
+					return args[1], nil
+				}
+				return nil, nil
+			case "keys", "values", "items":
+				items := make([]any, len(s.keys))
+				for i, key := range s.keys {
+					switch name {
+					case "keys":
+						items[i] = key
+					case "values":
+						items[i] = s.values[key]
+					default:
+						items[i] = []any{key, s.values[key]}
+					}
+				}
+				return items, nil
+			}
+		}
+		return nil, errors.Errorf("unknown method %q of %s", name, templateString(self))
+	})
+}
+
+// templateFilter returns the application of a filter.
+func templateFilter(value templateExpr, name string, args []templateExpr) templateExpr {
+	return templateEvalAll(append([]templateExpr{value}, args...), func(values []any) (any, error) {
+		v, args := values[0], values[1:]
+		switch name {
+		case "trim":
+			return strings.TrimSpace(templateString(v)), nil
+		case "upper":
+			return strings.ToUpper(templateString(v)), nil
+		case "lower":
+			return strings.ToLower(templateString(v)), nil
+		case "capitalize":
+			s := strings.ToLower(templateString(v))
+			if s != "" {
+				r := []rune(s)
+				r[0] = unicode.ToUpper(r[0])
+				s = string(r)
+			}
+			return s, nil
+		case "string":
+			return templateString(v), nil
+		case "safe":
+			return v, nil
+		case "length", "count":
+			switch v := v.(type) {
+			case string:
+				return len([]rune(v)), nil
+			case []any:
+				return len(v), nil
+			case *templateDict:
+				return len(v.keys), nil
+			}
+			return nil, errors.Errorf("object %s has no length", templateString(v))
+		case "first", "last":
+			items, err := templateIterate(v)
+			if err != nil || len(items) == 0 {
+				return templateUndefined{}, err
+			}
+			if name == "first" {
+				return items[0], nil
+			}
+			return items[len(items)-1], nil
+		case "list":
+			return templateIterate(v)
+		case "reverse":
+			items, err := templateIterate(v)
+			if err != nil {
+				return nil, err
+			}
+			items = slices.Clone(items)
+			slices.Reverse(items)
+			if s, ok := v.(string); ok {
+				r := []rune(s)
+				slices.Reverse(r)
+				return string(r), nil
+			}
+			return items, nil
+		case "join":
+			items, err := templateIterate(v)
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]string, len(items))
+			for i, item := range items {
+				parts[i] = templateString(item)
+			}
+			separator := ""
+			if len(args) > 0 {
+				separator = templateString(args[0])
+			}
+			return strings.Join(parts, separator), nil
+		case "default", "d":
+			_, isUndefined := v.(templateUndefined)
+			useDefault := isUndefined || (len(args) > 1 && templateTruth(args[1]) && !templateTruth(v))
+			if useDefault {
+				if len(args) > 0 {
+					return args[0], nil
+				}
+				return "", nil
+			}
+			return v, nil
+		case "tojson":
+			var sb strings.Builder
+			if err := templateWriteJSON(&sb, v); err != nil {
+				return nil, errors.Wrapf(err, "failed to convert to JSON")
+			}
+			return sb.String(), nil
+		case "int":
+			switch v := v.(type) {
+			case int:
+				return v, nil
+			case float64:
+				return int(v), nil
+			case string:
+				i, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil {
+					return 0, nil
+				}
+				return i, nil
+			}
+			return 0, nil
+		}
+		return nil, errors.Errorf("unknown filter %q", name)
+	})
+}
+
+// templateTest returns the application of an "is" test.
+func templateTest(value templateExpr, name string, negate bool) templateExpr {
+	return func(scope *templateScope) (any, error) {
+		v, err := value(scope)
+		if err != nil {
+			return nil, err
+		}
+		var result bool
+		switch name {
+		case "defined":
+			_, isUndefined := v.(templateUndefined)
+			result = !isUndefined
+		case "undefined":
+			_, result = v.(templateUndefined)
+		case "none":
+			result = v == nil
+		case "string":
+			_, result = v.(string)
+		case "number":
+			_, isInt := v.(int)
+			_, isFloat := v.(float64)
+			result = isInt || isFloat
+		case "integer":
+			_, result = v.(int)
+		case "boolean":
+			_, result = v.(bool)
+		case "true", "false":
+			b, isBool := v.(bool)
+			result = isBool && b == (name == "true")
+		case "mapping":
+			_, result = v.(*templateDict)
+		case "sequence", "iterable":
+			switch v.(type) {
+			case string, []any, *templateDict:
+				result = true
+			}
+		case "odd", "even":
+			i, isInt := v.(int)
+			result = isInt && (i%2 == 1 || i%2 == -1) == (name == "odd")
+		default:
+			return nil, errors.Errorf("unknown test %q", name)
+		}
+		return result != negate, nil
+	}
+}
+
+// templateGetItem returns the attribute or item of object, or templateUndefined if it doesn't exist.
+func templateGetItem(object, key any) (any, error) {
+	switch o := object.(type) {
+	case *templateDict:
+		if k, ok := key.(string); ok {
+			if value, found := o.get(k); found {
+				return value, nil
+			}
+		}
+	case []any:
+		if i, ok := key.(int); ok {
+			if i < 0 {
+				i += len(o)
+			}
+			if i >= 0 && i < len(o) {
+				return o[i], nil
+			}
+		}
+	case string:
+		if i, ok := key.(int); ok {
+			r := []rune(o)
+			if i < 0 {
+				i += len(r)
+			}
+			if i >= 0 && i < len(r) {
+				return string(r[i]), nil
+			}
+		}
+	}
+	return templateUndefined{}, nil
+}
+
+// templateSlice implements the Python slices object[start:stop:step] of lists and strings.
+func templateSlice(object any, indices [3]any) (any, error) {
+	var items []any
+	_, isString := object.(string)
+	switch o := object.(type) {
+	case []any:
+		items = o
+	case string:
+		for _, r := range o {
+			items = append(items, string(r))
+		}
+	default:
+		return nil, errors.Errorf("can't slice %s", templateString(object))
+	}
+	var bounds [3]int
+	var isSet [3]bool
+	for i, index := range indices {
+		if index == nil {
+			continue
+		}
+		n, ok := index.(int)
+		if !ok {
+			return nil, errors.Errorf("slice indices must be integers, got %s", templateString(index))
+		}
+		bounds[i], isSet[i] = n, true
+	}
+	step := 1
+	if isSet[2] {
+		step = bounds[2]
+		if step == 0 {
+			return nil, errors.New("slice step cannot be zero")
+		}
+	}
+	n := len(items)
+	normalize := func(i int) int {
+		if i < 0 {
+			i += n
+		}
+		return min(max(i, -1), n)
+	}
+	start, stop := 0, n
+	if step < 0 {
+		start, stop = n-1, -1
+	}
+	if isSet[0] {
+		start = normalize(bounds[0])
+		if step > 0 {
+			start = max(start, 0)
+		} else {
+			start = min(start, n-1)
+		}
+	}
+	if isSet[1] {
+		stop = normalize(bounds[1])
+		if step > 0 {
+			stop = max(stop, 0)
+		}
+	}
+	result := []any{}
+	for i := start; (step > 0 && i < stop) || (step < 0 && i > stop); i += step {
+		result = append(result, items[i])
+	}
+	if isString {
+		var sb strings.Builder
+		for _, item := range result {
+			sb.WriteString(item.(string))
+		}
+		return sb.String(), nil
+	}
+	return result, nil
+}
+
+// templateIterate returns the items to iterate over: the elements of lists, the characters of strings and the
+// keys of mappings, in insertion order. Undefined values have no items.
+func templateIterate(value any) ([]any, error) {
+	switch v := value.(type) {
+	case []any:
+		return v, nil
+	case string:
+		var items []any
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	case *templateDict:
+		items := make([]any, len(v.keys))
+		for i, key := range v.keys {
+			items[i] = key
+		}
+		return items, nil
+	case templateUndefined:
+		return nil, nil
+	}
+	return nil, errors.Errorf("%s is not iterable", templateString(value))
+}
+
+// templateTruth returns whether the value is true, following Python.
+func templateTruth(value any) bool {
+	switch v := value.(type) {
+	case nil, templateUndefined:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case []any:
+		return len(v) > 0
+	case *templateDict:
+		return len(v.keys) > 0
+	}
+	return true
+}
+
+// templateString renders the value as a string, following Python.
+func templateString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case templateUndefined:
+		return ""
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.ContainsAny(s, ".eInN") {
+			s += ".0"
+		}
+		return s
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = templateRepr(item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case *templateDict:
+		parts := make([]string, len(v.keys))
+		for i, key := range v.keys {
+			parts[i] = templateRepr(key) + ": " + templateRepr(v.values[key])
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return fmt.Sprint(value)
+}
+
+// templateRepr is like templateString, but quotes strings, as Python's repr.
+func templateRepr(value any) string {
+	if s, ok := value.(string); ok {
+		return "'" + strings.ReplaceAll(s, "'", "\\'") + "'"
+	}
+	return templateString(value)
+}
+
+// templateWriteJSON writes the value as JSON, as Python's json.dumps with ensure_ascii=False (the "tojson" filter of
+// transformers): keys in insertion order, ", " and ": " separators, and no escaping of non-ASCII or HTML characters.
+// Undefined values are written as null.
+func templateWriteJSON(sb *strings.Builder, value any) error {
+	switch v := value.(type) {
+	case nil, templateUndefined:
+		sb.WriteString("null")
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	case int, float64:
+		sb.WriteString(templateString(v))
+	case []any:
+		sb.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := templateWriteJSON(sb, item); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(']')
+	case *templateDict:
+		sb.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := templateWriteJSON(sb, key); err != nil {
+				return err
+			}
+			sb.WriteString(": ")
+			if err := templateWriteJSON(sb, v.values[key]); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte('}')
+	default:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+		sb.WriteString(strings.TrimSuffix(buf.String(), "\n"))
+	}
+	return nil
+}
+
+// templateNumber converts numeric values to float64.
+func templateNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// templateCompare implements the comparison operators.
+func templateCompare(op string, a, b any) (any, error) {
+	if op == "==" || op == "!=" {
+		return templateEqual(a, b) == (op == "=="), nil
+	}
+	var order int
+	if x, ok := templateNumber(a); ok {
+		y, ok := templateNumber(b)
+		if !ok {
+			return nil, errors.Errorf("can't compare %s %s %s", templateRepr(a), op, templateRepr(b))
+		}
+		order = cmp.Compare(x, y)
+	} else {
+		x, okA := a.(string)
+		y, okB := b.(string)
+		if !okA || !okB {
+			return nil, errors.Errorf("can't compare %s %s %s", templateRepr(a), op, templateRepr(b))
+		}
+		order = strings.Compare(x, y)
+	}
+	switch op {
+	case "<":
+		return order < 0, nil
+	case ">":
+		return order > 0, nil
+	case "<=":
+		return order <= 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+// templateEqual returns whether the values are equal, comparing numbers by value.
+func templateEqual(a, b any) bool {
+	_, isBoolA := a.(bool)
+	_, isBoolB := b.(bool)
+	if x, ok := templateNumber(a); ok && !isBoolA {
+		if y, ok := templateNumber(b); ok && !isBoolB {
+			return x == y
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// templateContains implements "item in container".
+func templateContains(container, item any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, errors.Errorf("'in <string>' requires a string, got %s", templateRepr(item))
+		}
+		return strings.Contains(c, s), nil
+	case []any:
+		return slices.ContainsFunc(c, func(element any) bool { return templateEqual(element, item) }), nil
+	case *templateDict:
+		s, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c.get(s)
+		return found, nil
+	case templateUndefined:
+		return false, nil
+	}
+	return false, errors.Errorf("%s is not a container", templateRepr(container))
+}
+
+// templateArithmetic implements the arithmetic operators, "+" on strings and lists, and the string
+// concatenation "~".
+func templateArithmetic(op string, a, b any) (any, error) {
+	if op == "~" {
+		return templateString(a) + templateString(b), nil
+	}
+	if op == "+" {
+		switch x := a.(type) {
+		case string:
+			if y, ok := b.(string); ok {
+				return x + y, nil
+			}
+		case []any:
+			if y, ok := b.([]any); ok {
+				return append(slices.Clone(x), y...), nil
+			}
+		}
+	}
+	x, okA := templateNumber(a)
+	y, okB := templateNumber(b)
+	if !okA || !okB {
+		return nil, errors.Errorf("unsupported operation %s %s %s", templateRepr(a), op, templateRepr(b))
+	}
+	_, isFloatA := a.(float64)
+	_, isFloatB := b.(float64)
+	isInt := !isFloatA && !isFloatB
+	var result float64
+	switch op {
+	case "+":
+		result = x + y
+	case "-":
+		result = x - y
+	case "*":
+		result = x * y
+	case "/":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return x / y, nil
+	case "//", "%":
+		if y == 0 {
+			return nil, errors.New("division by zero")
+		}
+		result = math.Floor(x / y)
+		if op == "%" {
+			result = x - y*result
+		}
+	}
+	if isInt {
+		return int(result), nil
+	}
+	return result, nil
+}
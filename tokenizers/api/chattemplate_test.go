@@ -0,0 +1,107 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyChatTemplate(t *testing.T) {
+	conversation := []ChatMessage{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: "Hi!"},
+		{Role: "assistant", Content: " Hello. "},
+		{Role: "user", Content: "How are you?"},
+	}
+	testCases := []struct {
+		name, template string
+		messages       []ChatMessage
+		want           string
+	}{
+		{
+			name:     "ChatML",
+			template: `{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}`,
+			messages: conversation[:2],
+			want:     "<|im_start|>system\nYou are helpful.<|im_end|>\n<|im_start|>user\nHi!<|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			name:     "Llama3",
+			template: `{% set loop_messages = messages %}{% for message in loop_messages %}{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n'+ message['content'] | trim + '<|eot_id|>' %}{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}{{ content }}{% endfor %}{% if add_generation_prompt %}{{ '<|start_header_id|>assistant<|end_header_id|>\n\n' }}{% endif %}`,
+			messages: conversation[1:3],
+			want:     "<s><|start_header_id|>user<|end_header_id|>\n\nHi!<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nHello.<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			name:     "Llama2",
+			template: `{% if messages[0]['role'] == 'system' %}{% set loop_messages = messages[1:] %}{% set system_message = messages[0]['content'] %}{% else %}{% set loop_messages = messages %}{% set system_message = false %}{% endif %}{% for message in loop_messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}{% if loop.index0 == 0 and system_message != false %}{% set content = '<<SYS>>\n' + system_message + '\n<</SYS>>\n\n' + message['content'] %}{% else %}{% set content = message['content'] %}{% endif %}{% if message['role'] == 'user' %}{{ bos_token + '[INST] ' + content.strip() + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ ' '  + content.strip() + ' ' + eos_token }}{% endif %}{% endfor %}`,
+			messages: conversation,
+			want:     "<s>[INST] <<SYS>>\nYou are helpful.\n<</SYS>>\n\nHi! [/INST] Hello. </s><s>[INST] How are you? [/INST]",
+		},
+		{
+			// Uses newlines between statements, removed by trim_blocks.
+			name: "Zephyr",
+			template: `{% for message in messages %}
+{% if message['role'] == 'user' %}
+{{ '<|user|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'system' %}
+{{ '<|system|>\n' + message['content'] + eos_token }}
+{% elif message['role'] == 'assistant' %}
+{{ '<|assistant|>\n'  + message['content'] + eos_token }}
+{% endif %}
+{% if loop.last and add_generation_prompt %}
+{{ '<|assistant|>' }}
+{% endif %}
+{% endfor %}`,
+			messages: conversation[:2],
+			want:     "<|system|>\nYou are helpful.</s>\n<|user|>\nHi!</s>\n<|assistant|>\n",
+		},
+	}
+	config := &Config{BosToken: "<s>", EosToken: "</s>"}
+	for _, tc := range testCases {
+		config.ChatTemplate = tc.template
+		got, err := ApplyChatTemplate(config, tc.messages, true)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.want, got, tc.name)
+	}
+}
+
+func TestApplyChatTemplate_Features(t *testing.T) {
+	messages := []ChatMessage{{Role: "user", Content: " a "}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}}
+	render := func(template string) (string, error) {
+		return ApplyChatTemplate(&Config{ChatTemplate: template, EosToken: "</s>"}, messages, false)
+	}
+	testCases := []struct{ template, want string }{
+		// Whitespace control and lstrip_blocks.
+		{"A  {{- 'x' -}}  B", "AxB"},
+		{"A\n    {% if true %}x{% endif %}\nB", "A\nxB"},
+		{"A {% if true %}x{% endif %} B", "A x B"},
+		{"{# comment #}A", "A"},
+		// Namespaces escape the loop scope, plain variables don't.
+		{"{% set ns = namespace(n=0) %}{% set m = 0 %}{% for x in messages %}{% set ns.n = ns.n + 1 %}{% set m = 1 %}{% endfor %}{{ ns.n }}{{ m }}", "30"},
+		// Loop variables, slicing, filters, methods and tests.
+		{"{% for m in messages[::-1] %}{{ loop.index }}{{ m.content|trim|upper }}{% if not loop.last %},{% endif %}{% endfor %}", "1C,2B,3A"},
+		{"{{ messages|length }} {{ messages[-1].role }} {{ messages[0]['content'].strip() }} {{ messages[5] is defined }}", "3 user a False"},
+		{"{{ foo|default('none') }} {{ 'x' if foo is undefined else 'y' }} {{ [1, 2] + [3] }} {{ 7 // 2 }} {{ 7 % 2 }} {{ 1 ~ 2 }}", "none x [1, 2, 3] 3 1 12"},
+		{"{{ messages[1]|tojson }} {{ 'b' in messages[1].content }} {{ 'x' not in ['x'] }} {{ eos_token }}", `{"role": "assistant", "content": "b"} True False </s>`},
+		{"{% for k, v in messages[0].items() %}{{ k }}={{ v }};{% endfor %}", "role=user;content= a ;"},
+		{"{% for m in (messages if true else []) %}{{ m.role[0] }}{% endfor %}", "uau"},
+		// tojson follows Python's json.dumps(ensure_ascii=False), as transformers.
+		{`{{ ['<a> & "b"', 'é', 1, 2.5, true, none, foo, namespace(z=1, a=[])]|tojson }}`, `["<a> & \"b\"", "é", 1, 2.5, true, null, null, {"z": 1, "a": []}]`},
+	}
+	for _, tc := range testCases {
+		got, err := render(tc.template)
+		require.NoError(t, err, tc.template)
+		assert.Equal(t, tc.want, got, tc.template)
+	}
+
+	// Errors.
+	_, err := render("{{ raise_exception('bad roles') }}")
+	require.ErrorContains(t, err, "bad roles")
+	for _, template := range []string{"{% if true %}", "{{ 'a' ", "{% macro m() %}{% endmacro %}", "{{ unknown() }}", "{{ 1 +* 2 }}",
+		"{% for m in messages if m.role == 'user' %}{{ m.content }}{% endfor %}"} {
+		_, err = render(template)
+		require.Error(t, err, template)
+	}
+	_, err = ApplyChatTemplate(&Config{}, messages, false)
+	require.Error(t, err)
+}
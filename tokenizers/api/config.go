@@ -2,8 +2,10 @@ package api
 
 import (
 	"encoding/json"
-	"github.com/pkg/errors"
 	"os"
+
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/pkg/errors"
 )
 
 type TokensDecoder struct {
@@ -39,6 +41,10 @@ type Config struct {
 	EosToken  string `json:"eos_token"`
 	PadToken  string `json:"pad_token"`
 
+	// AddBosToken and AddEosToken configure whether BOS/EOS are added when encoding with AddSpecialTokens.
+	//
+	// If not set in the file, ParseConfigContent defaults AddEosToken to true for seq2seq tokenizer classes that
+	// append EOS without BOS (T5, mT5, UMT5 and Pegasus), as HuggingFace's transformers does.
 	AddBosToken             bool                  `json:"add_bos_token"`
 	AddEosToken             bool                  `json:"add_eos_token"`
 	AddedTokensDecoder      map[int]TokensDecoder `json:"added_tokens_decoder"`
@@ -59,6 +65,17 @@ type Config struct {
 	TruncationStrategy string `json:"truncation_strategy"`
 }
 
+// eosOnlyTokenizerClasses are the seq2seq tokenizer classes that by default append EOS, and don't prepend BOS.
+var eosOnlyTokenizerClasses = map[string]bool{
+	"T5Tokenizer":          true,
+	"T5TokenizerFast":      true,
+	"MT5Tokenizer":         true,
+	"MT5TokenizerFast":     true,
+	"UMT5Tokenizer":        true,
+	"PegasusTokenizer":     true,
+	"PegasusTokenizerFast": true,
+}
+
 // ParseConfigFile parses the given file (holding a tokenizer_config.json file) into a Config structure.
 func ParseConfigFile(filePath string) (*Config, error) {
 	content, err := os.ReadFile(filePath)
@@ -74,11 +91,152 @@ func ParseConfigFile(filePath string) (*Config, error) {
 }
 
 // ParseConfigContent parses the given json content (of a tokenizer_config.json file) into a Config structure.
+//
+// Special tokens can be given either as a string or as an object (`{"content": "<s>", ...}`): only the content
+// is kept.
 func ParseConfigContent(jsonContent []byte) (*Config, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonContent, &fields); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse tokenizer_config json content")
+	}
+	if err := flattenSpecialTokens(fields); err != nil {
+		return nil, err
+	}
+	jsonContent, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to re-encode tokenizer_config json content")
+	}
 	config := &Config{}
-	err := json.Unmarshal(jsonContent, config)
+	err = json.Unmarshal(jsonContent, config)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to parse tokenizer_config json content")
 	}
+	if eosOnlyTokenizerClasses[config.TokenizerClass] {
+		if _, found := fields["add_eos_token"]; !found {
+			config.AddEosToken = true
+		}
+	}
+	return config, nil
+}
+
+// specialTokenFields are the json fields of the special tokens, in tokenizer_config.json and
+// special_tokens_map.json.
+var specialTokenFields = []string{
+	"cls_token", "unk_token", "sep_token", "mask_token", "bos_token", "eos_token", "pad_token",
+}
+
+// flattenSpecialTokens replaces the special tokens given as objects (`{"content": "<s>", ...}`) by their content,
+// including the list of additional_special_tokens.
+func flattenSpecialTokens(fields map[string]json.RawMessage) error {
+	for _, key := range specialTokenFields {
+		raw, found := fields[key]
+		if !found {
+			continue
+		}
+		content, err := specialTokenContent(raw)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to parse %q", key)
+		}
+		fields[key], _ = json.Marshal(content)
+	}
+	if raw, found := fields["additional_special_tokens"]; found && string(raw) != "null" {
+		var tokens []json.RawMessage
+		if err := json.Unmarshal(raw, &tokens); err != nil {
+			return errors.Wrapf(err, "failed to parse \"additional_special_tokens\"")
+		}
+		contents := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			content, err := specialTokenContent(token)
+			if err != nil {
+				return errors.WithMessagef(err, "failed to parse \"additional_special_tokens\"")
+			}
+			contents = append(contents, content)
+		}
+		fields["additional_special_tokens"], _ = json.Marshal(contents)
+	}
+	return nil
+}
+
+// specialTokenContent returns the content of a special token given as a string, an object with a "content" field,
+// or null.
+func specialTokenContent(raw json.RawMessage) (string, error) {
+	var content string
+	if err := json.Unmarshal(raw, &content); err == nil {
+		return content, nil
+	}
+	var token TokensDecoder
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return "", errors.Wrapf(err, "special token %s is neither a string nor an object with \"content\"", raw)
+	}
+	return token.Content, nil
+}
+
+// mergeSpecialTokensMap sets the special tokens defined in the special_tokens_map.json content into config.
+// As in HuggingFace's transformers, they take precedence over the ones in tokenizer_config.json.
+func mergeSpecialTokensMap(config *Config, jsonContent []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonContent, &fields); err != nil {
+		return errors.Wrapf(err, "failed to parse special_tokens_map json content")
+	}
+	if err := flattenSpecialTokens(fields); err != nil {
+		return err
+	}
+	var tokens Config
+	jsonContent, _ = json.Marshal(fields)
+	if err := json.Unmarshal(jsonContent, &tokens); err != nil {
+		return errors.Wrapf(err, "failed to parse special_tokens_map json content")
+	}
+	for from, to := range map[*string]*string{
+		&tokens.ClsToken:  &config.ClsToken,
+		&tokens.UnkToken:  &config.UnkToken,
+		&tokens.SepToken:  &config.SepToken,
+		&tokens.MaskToken: &config.MaskToken,
+		&tokens.BosToken:  &config.BosToken,
+		&tokens.EosToken:  &config.EosToken,
+		&tokens.PadToken:  &config.PadToken,
+	} {
+		if *from != "" {
+			*to = *from
+		}
+	}
+	if len(tokens.AdditionalSpecialTokens) > 0 {
+		config.AdditionalSpecialTokens = tokens.AdditionalSpecialTokens
+	}
+	return nil
+}
+
+// LoadConfig downloads and parses the "tokenizer_config.json" and "special_tokens_map.json" files of the repo
+// (see hub.New), merging their special tokens definitions.
+//
+// Missing files are not an error: if the repo has neither, an empty Config is returned.
+func LoadConfig(repo *hub.Repo) (*Config, error) {
+	err := repo.DownloadInfo(false)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if repo.HasFile("tokenizer_config.json") {
+		localConfigFile, err := repo.DownloadFile("tokenizer_config.json")
+		if err != nil {
+			return nil, err
+		}
+		config, err = ParseConfigFile(localConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if repo.HasFile("special_tokens_map.json") {
+		localMapFile, err := repo.DownloadFile("special_tokens_map.json")
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(localMapFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read file %q", localMapFile)
+		}
+		if err := mergeSpecialTokensMap(config, content); err != nil {
+			return nil, errors.WithMessagef(err, "read from file %q", localMapFile)
+		}
+	}
 	return config, nil
 }
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/gomlx/go-huggingface/hub"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfigContent_EOSOnlyClasses(t *testing.T) {
+	// T5 appends EOS by default.
+	config, err := ParseConfigContent([]byte(`{"tokenizer_class": "T5Tokenizer", "eos_token": "</s>"}`))
+	require.NoError(t, err)
+	assert.False(t, config.AddBosToken)
+	assert.True(t, config.AddEosToken)
+
+	// Explicit configuration takes precedence.
+	config, err = ParseConfigContent([]byte(`{"tokenizer_class": "T5Tokenizer", "add_eos_token": false}`))
+	require.NoError(t, err)
+	assert.False(t, config.AddEosToken)
+
+	// Other classes are not affected.
+	config, err = ParseConfigContent([]byte(`{"tokenizer_class": "LlamaTokenizer", "add_bos_token": true}`))
+	require.NoError(t, err)
+	assert.True(t, config.AddBosToken)
+	assert.False(t, config.AddEosToken)
+}
+
+func TestParseConfigContent_SpecialTokenObjects(t *testing.T) {
+	config, err := ParseConfigContent([]byte(`{
+		"bos_token": {"content": "<s>", "lstrip": false, "special": true},
+		"eos_token": "</s>",
+		"pad_token": null,
+		"additional_special_tokens": ["<extra_0>", {"content": "<extra_1>"}]
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "<s>", config.BosToken)
+	assert.Equal(t, "</s>", config.EosToken)
+	assert.Equal(t, "", config.PadToken)
+	assert.Equal(t, []string{"<extra_0>", "<extra_1>"}, config.AdditionalSpecialTokens)
+
+	_, err = ParseConfigContent([]byte(`{"unk_token": 3}`))
+	require.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"tokenizer_config.json":   `{"tokenizer_class": "LlamaTokenizer", "bos_token": "<bos>", "eos_token": "</s>"}`,
+		"special_tokens_map.json": `{"bos_token": {"content": "<s>"}, "unk_token": "<unk>"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/models/org/model/revision/main":
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [
+				{"rfilename": "tokenizer_config.json"}, {"rfilename": "special_tokens_map.json"}]}`))
+		case "/org/model/resolve/abc123/tokenizer_config.json", "/org/model/resolve/abc123/special_tokens_map.json":
+			name := path.Base(req.URL.Path)
+			w.Header().Set("ETag", `"`+name+`"`)
+			_, _ = w.Write([]byte(files[name]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+	config, err := LoadConfig(repo)
+	require.NoError(t, err)
+	assert.Equal(t, "LlamaTokenizer", config.TokenizerClass)
+	assert.Equal(t, "<s>", config.BosToken) // special_tokens_map.json takes precedence.
+	assert.Equal(t, "</s>", config.EosToken)
+	assert.Equal(t, "<unk>", config.UnkToken)
+}
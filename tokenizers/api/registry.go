@@ -0,0 +1,58 @@
+package api
+
+import (
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/pkg/errors"
+)
+
+// TokenizerConstructor creates a tokenizer for the given HuggingFace repo and config.
+type TokenizerConstructor func(config *Config, repo *hub.Repo) (Tokenizer, error)
+
+// registeredTokenizer is a tokenizer implementation registered with RegisterTokenizer.
+type registeredTokenizer struct {
+	name        string
+	detect      func(repo *hub.Repo) bool
+	constructor TokenizerConstructor
+}
+
+var registeredTokenizers []registeredTokenizer
+
+// RegisterTokenizer registers a tokenizer implementation, used by FromRepo for the repos for which detect returns
+// true (usually, if the repo has the files the implementation needs).
+//
+// Registering an existing name replaces it, keeping its position. The implementations in this module
+// register themselves when imported: "hftokenizer" (for repos with "tokenizer.json"), "sentencepiece" (for
+// repos with "tokenizer.model") and "tiktoken" (for repos with a "*.tiktoken" file).
+//
+// Implementations registered from init functions take precedence in the order the packages are initialized:
+// since Go 1.21, packages that don't import one another are initialized sorted by import path, so the ones in this
+// module are tried in the order above.
+func RegisterTokenizer(name string, detect func(repo *hub.Repo) bool, constructor TokenizerConstructor) {
+	entry := registeredTokenizer{name: name, detect: detect, constructor: constructor}
+	for i, registered := range registeredTokenizers {
+		if registered.name == name {
+			registeredTokenizers[i] = entry
+			return
+		}
+	}
+	registeredTokenizers = append(registeredTokenizers, entry)
+}
+
+// FromRepo creates a tokenizer for the repo (see hub.New) with the first registered implementation (see
+// RegisterTokenizer), in order of registration, that detects it can handle the repo.
+//
+// The config is passed to the constructor, see LoadConfig.
+func FromRepo(repo *hub.Repo, config *Config) (Tokenizer, error) {
+	err := repo.DownloadInfo(false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(registeredTokenizers))
+	for _, registered := range registeredTokenizers {
+		if registered.detect(repo) {
+			return registered.constructor(config, repo)
+		}
+		names = append(names, registered.name)
+	}
+	return nil, errors.Errorf("no registered tokenizer (%v) can handle repo %s", names, repo)
+}
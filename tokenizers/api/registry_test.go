@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromRepo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/models/org/model/revision/main" {
+			_, _ = w.Write([]byte(`{"id": "org/model", "sha": "abc123", "siblings": [{"rfilename": "vocab.tiktoken"}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	repo := hub.New("org/model").WithEndpoint(server.URL).WithCacheDir(t.TempDir())
+
+	saved := registeredTokenizers
+	defer func() { registeredTokenizers = saved }()
+	registeredTokenizers = nil
+
+	var constructed string
+	constructor := func(name string) TokenizerConstructor {
+		return func(config *Config, repo *hub.Repo) (Tokenizer, error) {
+			constructed = name
+			return nil, nil
+		}
+	}
+	hasFile := func(name string) func(repo *hub.Repo) bool {
+		return func(repo *hub.Repo) bool { return repo.HasFile(name) }
+	}
+	RegisterTokenizer("json", hasFile("tokenizer.json"), constructor("json"))
+	_, err := FromRepo(repo, nil)
+	require.ErrorContains(t, err, "no registered tokenizer")
+
+	RegisterTokenizer("tiktoken", hasFile("vocab.tiktoken"), constructor("tiktoken"))
+	RegisterTokenizer("any", func(*hub.Repo) bool { return true }, constructor("any"))
+	_, err = FromRepo(repo, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "tiktoken", constructed)
+
+	// Replacing a registration keeps its position.
+	RegisterTokenizer("json", func(*hub.Repo) bool { return true }, constructor("json2"))
+	_, err = FromRepo(repo, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "json2", constructed)
+}
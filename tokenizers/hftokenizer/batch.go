@@ -0,0 +1,128 @@
+package hftokenizer
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+)
+
+// WithMaxParallelization sets the maximum number of texts EncodeBatch encodes in parallel.
+// Set it to 0 or 1 (the default) to encode sequentially, or to -1 to use runtime.NumCPU().
+//
+// It returns the tokenizer itself, for chaining calls.
+func (t *Tokenizer) WithMaxParallelization(maxParallelization int) *Tokenizer {
+	t.maxParallelization = maxParallelization
+	return t
+}
+
+// EncodeBatch encodes each of the texts with the options set with Tokenizer.With (see EncodeWithAnnotations), and
+// pads them to the same length.
+//
+// The texts are encoded concurrently, see WithMaxParallelization. The output preserves the order of the input.
+// It returns nil for an empty batch.
+//
+// The AttentionMask of each encoding is always set, with 1 for the tokens and 0 for the padding. The other
+// annotations, if requested, are padded as well: Spans with empty spans, SpecialTokensMask with 1, Tokens with the
+// pad token.
+//
+// The "padding" configuration of the tokenizer.json file is used if present: the sequences are padded to the
+// fixed length, if configured, otherwise to the longest sequence in the batch, rounded up to a multiple of
+// "pad_to_multiple_of" if set. Sequences longer than the fixed length are not cut (use WithTruncation for that):
+// the batch is then padded to the longest sequence instead.
+//
+// Without a padding configuration, sequences are padded on the right to the longest sequence, with the pad token
+// (or 0 if the tokenizer has none).
+func (t *Tokenizer) EncodeBatch(texts []string) []api.AnnotatedEncoding {
+	if len(texts) == 0 {
+		return nil
+	}
+	encodings := t.encodeAll(texts)
+	longest := 0
+	for _, encoding := range encodings {
+		longest = max(longest, len(encoding.IDs))
+	}
+
+	padID := max(t.padID, 0)
+	length := longest
+	var leftPadding bool
+	if padding := t.tokenizer.Padding; padding != nil {
+		padID = padding.PadID
+		leftPadding = strings.EqualFold(padding.Direction, "left")
+		length = max(padding.Strategy.Fixed, longest)
+		if padding.PadToMultipleOf > 0 {
+			length = (length + padding.PadToMultipleOf - 1) / padding.PadToMultipleOf * padding.PadToMultipleOf
+		}
+	}
+	padToken := t.idToToken[padID]
+	if padding := t.tokenizer.Padding; padding != nil && padding.PadToken != "" {
+		padToken = padding.PadToken
+	}
+
+	for i := range encodings {
+		encoding := &encodings[i]
+		numPad := length - len(encoding.IDs)
+		encoding.AttentionMask = padSequence(slices.Repeat([]int{1}, len(encoding.IDs)), 0, numPad, leftPadding)
+		encoding.IDs = padSequence(encoding.IDs, padID, numPad, leftPadding)
+		if encoding.Spans != nil {
+			encoding.Spans = padSequence(encoding.Spans, api.TokenSpan{}, numPad, leftPadding)
+		}
+		if encoding.SpecialTokensMask != nil {
+			encoding.SpecialTokensMask = padSequence(encoding.SpecialTokensMask, 1, numPad, leftPadding)
+		}
+		if encoding.Tokens != nil {
+			encoding.Tokens = padSequence(encoding.Tokens, padToken, numPad, leftPadding)
+		}
+	}
+	return encodings
+}
+
+// padSequence returns seq padded with numPad copies of pad, on the left or on the right.
+func padSequence[T any](seq []T, pad T, numPad int, left bool) []T {
+	padded := make([]T, 0, len(seq)+numPad)
+	if !left {
+		padded = append(padded, seq...)
+	}
+	for range numPad {
+		padded = append(padded, pad)
+	}
+	if left {
+		padded = append(padded, seq...)
+	}
+	return padded
+}
+
+// encodeAll encodes the texts using a pool of goroutines, see WithMaxParallelization.
+//
+// Encoding only reads the tokenizer state (vocabulary, merge ranks, etc.), so it is safe to do it concurrently.
+// If tracing is enabled, it encodes serially, to keep the trace readable.
+func (t *Tokenizer) encodeAll(texts []string) []api.AnnotatedEncoding {
+	encodings := make([]api.AnnotatedEncoding, len(texts))
+	numWorkers := t.maxParallelization
+	if numWorkers < 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	numWorkers = min(numWorkers, len(texts))
+	if numWorkers <= 1 || t.trace != nil {
+		for i, text := range texts {
+			encodings[i] = t.EncodeWithAnnotations(text)
+		}
+		return encodings
+	}
+
+	// Each worker encodes a contiguous chunk of the texts.
+	chunkSize := (len(texts) + numWorkers - 1) / numWorkers
+	var wg sync.WaitGroup
+	for start := 0; start < len(texts); start += chunkSize {
+		end := min(start+chunkSize, len(texts))
+		wg.Go(func() {
+			for i := start; i < end; i++ {
+				encodings[i] = t.EncodeWithAnnotations(texts[i])
+			}
+		})
+	}
+	wg.Wait()
+	return encodings
+}
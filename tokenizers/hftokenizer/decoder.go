@@ -6,6 +6,9 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
 )
 
 func compileDecoderRegex(decoder *Decoder) error {
@@ -40,9 +43,35 @@ func compileDecoderRegex(decoder *Decoder) error {
 }
 
 // Decode converts a sequence of token IDs back to text.
+//
+// Byte-level tokens (ByteLevel or ByteFallback decoders) may split multibyte UTF-8 characters -- common when
+// decoding mid-generation. Invalid UTF-8 sequences are replaced by U+FFFD, as HuggingFace does.
+// Use DecodeBytes to get the raw bytes instead.
 func (t *Tokenizer) Decode(ids []int) string {
+	return t.DecodeWithOptions(ids, false)
+}
+
+// DecodeWithOptions is like Decode, but if skipSpecial is set the special tokens (added tokens marked as special,
+// like "[CLS]" or "<s>") are dropped before applying the decoder.
+func (t *Tokenizer) DecodeWithOptions(ids []int, skipSpecial bool) string {
+	return strings.ToValidUTF8(t.decodeRaw(ids, skipSpecial), "\uFFFD")
+}
+
+// DecodeBytes converts a sequence of token IDs back to the raw decoded bytes, which may not be valid UTF-8 if
+// byte-level tokens split a multibyte character.
+//
+// It allows streaming generation to accumulate bytes across calls, and only convert to string complete characters.
+func (t *Tokenizer) DecodeBytes(ids []int) []byte {
+	return []byte(t.decodeRaw(ids, false))
+}
+
+// decodeRaw converts a sequence of token IDs back to text, possibly with invalid UTF-8 sequences.
+func (t *Tokenizer) decodeRaw(ids []int, skipSpecial bool) string {
 	var tokens []string
 	for _, id := range ids {
+		if skipSpecial && t.specialIDs[id] {
+			continue
+		}
 		if token, ok := t.idToToken[id]; ok {
 			tokens = append(tokens, token)
 		}
@@ -50,16 +79,62 @@ func (t *Tokenizer) Decode(ids []int) string {
 
 	// Apply decoder
 	result := t.applyDecoder(tokens)
+	if t.cleansUpSpaces() {
+		result = cleanUpTokenizationSpaces(result)
+	}
 	return result
 }
 
+// WithCleanUpTokenizationSpaces sets whether Decode removes the spaces before punctuation and contractions
+// (e.g. "hello , world !" -> "hello, world!"), see cleanUpTokenizationSpaces.
+//
+// By default it is enabled for WordPiece decoders (unless their "cleanup" is false in tokenizer.json), and for
+// other decoders it follows "clean_up_tokenization_spaces" in tokenizer_config.json.
+// It returns the tokenizer itself, for chaining calls.
+func (t *Tokenizer) WithCleanUpTokenizationSpaces(enabled bool) *Tokenizer {
+	t.cleanUpSpaces = &enabled
+	return t
+}
+
+// cleansUpSpaces returns whether the decoded text is passed through cleanUpTokenizationSpaces.
+func (t *Tokenizer) cleansUpSpaces() bool {
+	if t.cleanUpSpaces != nil {
+		return *t.cleanUpSpaces
+	}
+	decoder := t.tokenizer.Decoder
+	if decoder == nil || decoder.Type == "WordPiece" {
+		// No decoder defaults to WordPiece-style decoding.
+		return decoder == nil || decoder.Cleanup == nil || *decoder.Cleanup
+	}
+	return t.config != nil && t.config.CleanUpTokenizationSpaces
+}
+
+// cleanUpTokenizationSpaces removes the spaces before punctuation and in contractions, left by decoders that join
+// tokens with spaces. It implements the same replacements as HuggingFace's WordPiece decoder cleanup.
+func cleanUpTokenizationSpaces(text string) string {
+	for _, r := range [][2]string{
+		{" .", "."}, {" ?", "?"}, {" !", "!"}, {" ,", ","}, {" ' ", "'"}, {" n't", "n't"}, {" 'm", "'m"},
+		{" do not", " don't"}, {" 's", "'s"}, {" 've", "'ve"}, {" 're", "'re"},
+	} {
+		text = strings.ReplaceAll(text, r[0], r[1])
+	}
+	return text
+}
+
+// DecodeBatch converts many sequences of token IDs back to text, optionally in parallel (see api.DecodeOptions).
+func (t *Tokenizer) DecodeBatch(batch [][]int, opts api.DecodeOptions) []string {
+	return api.DecodeBatch(t.Decode, batch, opts)
+}
+
 // applyDecoder applies the decoder to convert tokens back to text.
 func (t *Tokenizer) applyDecoder(tokens []string) string {
 	if t.tokenizer.Decoder == nil {
 		// Default: handle WordPiece-style decoding
+		t.tracef("no decoder: using default WordPiece-style decoding")
 		return t.defaultDecode(tokens)
 	}
 
+	t.tracef("decoder %s", t.tokenizer.Decoder.Type)
 	switch t.tokenizer.Decoder.Type {
 	case "WordPiece":
 		return t.wordPieceDecode(tokens)
@@ -69,6 +144,8 @@ func (t *Tokenizer) applyDecoder(tokens []string) string {
 		return t.metaspaceDecode(tokens)
 	case "BPEDecoder":
 		return t.bpeDecode(tokens)
+	case "ByteFallback", "Strip", "Fuse":
+		return strings.Join(t.applyDecoderStep(tokens, t.tokenizer.Decoder), "")
 	case "Sequence":
 		result := tokens
 		for _, dec := range t.tokenizer.Decoder.Decoders {
@@ -76,11 +153,13 @@ func (t *Tokenizer) applyDecoder(tokens []string) string {
 		}
 		return strings.Join(result, "")
 	default:
+		t.tracef("decoder %q: not supported, using default WordPiece-style decoding", t.tokenizer.Decoder.Type)
 		return t.defaultDecode(tokens)
 	}
 }
 
 func (t *Tokenizer) applyDecoderStep(tokens []string, d *Decoder) []string {
+	t.tracef("decoder step %s", d.Type)
 	switch d.Type {
 	case "Replace":
 		// Replace pattern in tokens
@@ -90,16 +169,30 @@ func (t *Tokenizer) applyDecoderStep(tokens []string, d *Decoder) []string {
 		}
 		return result
 	case "Strip":
-		// Strip characters
-		content := d.Content
-		if content == "" {
-			content = " \t\n\r"
-		}
-		var result []string
+		// Strip up to d.Start (d.Stop) occurrences of the content character from the start (end) of each token.
+		content, _ := utf8.DecodeRuneInString(d.Content)
+		result := make([]string, 0, len(tokens))
 		for _, tok := range tokens {
-			result = append(result, strings.Trim(tok, content))
+			for range d.Start {
+				r, size := utf8.DecodeRuneInString(tok)
+				if size == 0 || r != content {
+					break
+				}
+				tok = tok[size:]
+			}
+			for range d.Stop {
+				r, size := utf8.DecodeLastRuneInString(tok)
+				if size == 0 || r != content {
+					break
+				}
+				tok = tok[:len(tok)-size]
+			}
+			result = append(result, tok)
 		}
 		return result
+	case "Fuse":
+		// Fuse all tokens into one, so further steps see the whole text.
+		return []string{strings.Join(tokens, "")}
 	case "ByteFallback":
 		// Handle byte fallback decoding
 		// In byte fallback, tokens that represent a single byte are encoded as <0xXX>
@@ -122,21 +215,19 @@ func (t *Tokenizer) applyDecoderStep(tokens []string, d *Decoder) []string {
 		// The final join will then be a sequence of bytes which might be valid UTF-8.
 		return result
 	case "Metaspace":
-		// Metaspace replaces leading space with a replacement character (default \u2581)
-		replacement := d.Replacement
-		if replacement == "" {
-			replacement = "\u2581"
-		}
+		// Metaspace replaces the spaces with a replacement character (default "▁"), and may have prepended one.
+		replacement, stripFirst := t.metaspaceDecodeConfig(d)
 		var result []string
 		for i, tok := range tokens {
 			decoded := strings.ReplaceAll(tok, replacement, " ")
-			if i == 0 && d.PrependScheme == "always" && strings.HasPrefix(decoded, " ") {
+			if i == 0 && stripFirst {
 				decoded = strings.TrimPrefix(decoded, " ")
 			}
 			result = append(result, decoded)
 		}
 		return result
 	default:
+		t.tracef("decoder step %q: not supported, tokens left unchanged", d.Type)
 		return tokens
 	}
 }
@@ -190,24 +281,26 @@ func (t *Tokenizer) byteLevelDecode(tokens []string) string {
 }
 
 func (t *Tokenizer) metaspaceDecode(tokens []string) string {
-	replacement := t.tokenizer.Decoder.Replacement
-	if replacement == "" {
-		replacement = "\u2581"
-	}
-	prependScheme := t.tokenizer.Decoder.PrependScheme
-	var result strings.Builder
-	for i, token := range tokens {
-		// Metaspace replaces leading space with special char
-		decoded := strings.ReplaceAll(token, replacement, " ")
-		if i == 0 && prependScheme == "always" {
-			decoded = strings.TrimPrefix(decoded, " ")
-		} else if i == 0 && t.tokenizer.PreTokenizer != nil && (t.tokenizer.PreTokenizer.AddPrefixSpace || t.tokenizer.PreTokenizer.PrependScheme == "always") {
-			// Also check pre-tokenizer for compatibility
-			decoded = strings.TrimPrefix(decoded, " ")
+	return strings.Join(t.applyDecoderStep(tokens, t.tokenizer.Decoder), "")
+}
+
+// metaspaceDecodeConfig returns the replacement character of the Metaspace decoder d, and whether it removes the
+// leading space of the first token (if the replacement character was prepended during pre-tokenization).
+// Settings missing in the decoder are taken from the Metaspace pre-tokenizer, so both use them consistently.
+func (t *Tokenizer) metaspaceDecodeConfig(d *Decoder) (replacement string, stripFirst bool) {
+	replacement = d.Replacement
+	prependScheme := d.PrependScheme
+	var addPrefixSpace bool
+	if pt := findPreTokenizer(t.tokenizer.PreTokenizer, "Metaspace"); pt != nil {
+		if replacement == "" {
+			replacement = pt.Replacement
 		}
-		result.WriteString(decoded)
+		if prependScheme == "" {
+			prependScheme = pt.PrependScheme
+		}
+		addPrefixSpace = pt.AddPrefixSpace
 	}
-	return result.String()
+	return metaspaceReplacement(replacement), metaspacePrepends(prependScheme, addPrefixSpace, inputStart)
 }
 
 func (t *Tokenizer) bpeDecode(tokens []string) string {
@@ -254,6 +347,11 @@ func (t *Tokenizer) TokenToID(token string) (int, bool) {
 	return id, ok
 }
 
+// TokenScore returns the score (log-probability) of a token of a Unigram model. See Model.TokenScore.
+func (t *Tokenizer) TokenScore(token string) (float64, bool) {
+	return t.tokenizer.Model.TokenScore(token)
+}
+
 // IDToToken converts a token ID to its string.
 func (t *Tokenizer) IDToToken(id int) (string, bool) {
 	token, ok := t.idToToken[id]
@@ -0,0 +1,54 @@
+package hftokenizer
+
+// supportsVocabFastPath returns whether, for this tokenizer configuration, any ASCII alphanumeric word in the
+// vocabulary is guaranteed to be encoded as its own vocabulary id by the full pipeline.
+//
+// That is the case for WordPiece models with no normalizer (or a BertNormalizer, as long as the word is already
+// lower-cased) and a pre-tokenizer that only splits on whitespace and punctuation. Other models (e.g. BPE merges
+// may not reconstruct a word in the vocabulary) and pre-tokenizers (e.g. ByteLevel or Metaspace change the text)
+// don't qualify.
+func supportsVocabFastPath(tj *TokenizerJSON) bool {
+	if tj.Model.Type != "WordPiece" {
+		return false
+	}
+	if tj.Normalizer != nil && tj.Normalizer.Type != "BertNormalizer" {
+		return false
+	}
+	if tj.PreTokenizer != nil {
+		switch tj.PreTokenizer.Type {
+		case "BertPreTokenizer", "Whitespace", "WhitespaceSplit":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// singleTokenFastPath returns the token id if the whole text is a single known token, in which case running the
+// full normalize, pre-tokenize and model pipeline is not needed.
+//
+// The text must be exactly an added token, or a vocabulary entry in a tokenizer that supports it (see
+// supportsVocabFastPath). Either way the result is the same as the one of the full pipeline.
+func (t *Tokenizer) singleTokenFastPath(text string) (int, bool) {
+	if text == "" {
+		return 0, false
+	}
+	if id, found := t.addedTokens[text]; found {
+		return id, true
+	}
+	if !t.vocabFastPath || (t.maxInputCharsPerWord > 0 && len(text) > t.maxInputCharsPerWord) {
+		return 0, false
+	}
+	lowercase := t.tokenizer.Normalizer != nil && t.tokenizer.Normalizer.Lowercase
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		isLower := c >= 'a' && c <= 'z'
+		isUpper := c >= 'A' && c <= 'Z'
+		isDigit := c >= '0' && c <= '9'
+		if !isLower && !isDigit && (!isUpper || lowercase) {
+			return 0, false
+		}
+	}
+	id, found := t.tokenizer.Model.Vocab[text]
+	return id, found
+}
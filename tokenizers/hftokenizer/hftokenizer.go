@@ -4,11 +4,15 @@
 package hftokenizer
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 
@@ -21,7 +25,8 @@ import (
 // UnmarshalJSON implements custom unmarshaling to handle both vocab and merges formats:
 // Vocab formats:
 //  1. Object format: {"token": id, ...} (WordPiece, BPE)
-//  2. Array format: [["token", score], ...] (Unigram) - ID is the array index
+//  2. Array format: [["token", score], ...] (Unigram) - ID is the array index, and the scores are kept for the
+//     Viterbi segmentation.
 //
 // Merges formats:
 //  1. Array of strings: ["token1 token2", ...] (standard BPE)
@@ -57,12 +62,18 @@ func (m *Model) UnmarshalJSON(data []byte) error {
 			var vocabArray [][]interface{}
 			if err := json.Unmarshal(raw.Vocab, &vocabArray); err == nil {
 				m.Vocab = make(map[string]int, len(vocabArray))
+				m.vocabScores = make(map[string]float64, len(vocabArray))
 				for idx, pair := range vocabArray {
 					if len(pair) >= 1 {
 						token, ok := pair[0].(string)
 						if ok {
 							// Use array index as the token ID
 							m.Vocab[token] = idx
+							if len(pair) >= 2 {
+								if score, ok := pair[1].(float64); ok {
+									m.vocabScores[token] = score
+								}
+							}
 						}
 					}
 				}
@@ -98,11 +109,45 @@ func (m *Model) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON implements json.Marshaler, the inverse of UnmarshalJSON: the vocabulary is written in the object
+// format, or in the array format (with the scores, ordered by id) for models loaded from it (Unigram), and the
+// merges as an array of strings.
+func (m Model) MarshalJSON() ([]byte, error) {
+	type ModelAlias Model
+	var vocab any = m.Vocab
+	if m.vocabScores != nil {
+		tokens := slices.SortedFunc(maps.Keys(m.Vocab), func(a, b string) int {
+			return m.Vocab[a] - m.Vocab[b]
+		})
+		vocabArray := make([][]any, 0, len(tokens))
+		for _, token := range tokens {
+			vocabArray = append(vocabArray, []any{token, m.vocabScores[token]})
+		}
+		vocab = vocabArray
+	}
+	return json.Marshal(struct {
+		ModelAlias
+		Vocab  any      `json:"vocab"`
+		Merges []string `json:"merges,omitempty"`
+	}{ModelAlias(m), vocab, m.Merges})
+}
+
+// TokenScore returns the score (log-probability) of the token, as given in the Unigram array vocabulary format.
+// It returns false if the token is not in the vocabulary, or if the vocabulary has no scores (WordPiece and BPE).
+func (m *Model) TokenScore(token string) (float64, bool) {
+	score, found := m.vocabScores[token]
+	return score, found
+}
+
 // Compile time assert that Tokenizer implements api.Tokenizer interface.
 var _ api.Tokenizer = &Tokenizer{}
 
+func init() {
+	api.RegisterTokenizer("hftokenizer", func(repo *hub.Repo) bool { return repo.HasFile("tokenizer.json") }, New)
+}
+
 // New creates a HuggingFace tokenizer from the tokenizer.json file.
-// It implements a tokenizer.TokenizerConstructor function signature.
+// It implements the api.TokenizerConstructor function signature, and is registered with api.RegisterTokenizer.
 func New(config *api.Config, repo *hub.Repo) (api.Tokenizer, error) {
 	if !repo.HasFile("tokenizer.json") {
 		return nil, errors.Errorf("\"tokenizer.json\" file not found in repo")
@@ -134,6 +179,12 @@ func NewFromContent(config *api.Config, content []byte) (*Tokenizer, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to compile decoder regex")
 	}
+	if err := compileNormalizer(tj.Normalizer); err != nil {
+		return nil, errors.WithMessage(err, "failed to parse normalizer")
+	}
+	if err := compilePreTokenizer(tj.PreTokenizer); err != nil {
+		return nil, errors.WithMessage(err, "failed to parse pre-tokenizer")
+	}
 
 	t := &Tokenizer{
 		config:      config,
@@ -152,21 +203,26 @@ func NewFromContent(config *api.Config, content []byte) (*Tokenizer, error) {
 		},
 	}
 
+	// WordPiece defaults to 100 characters per word if not configured.
+	t.maxInputCharsPerWord = tj.Model.MaxInputCharsPerWord
+	if t.maxInputCharsPerWord <= 0 {
+		t.maxInputCharsPerWord = defaultMaxInputCharsPerWord
+	}
+
 	// Build reverse vocab (id -> token)
 	for token, id := range tj.Model.Vocab {
 		t.idToToken[id] = token
 	}
 
-	// Build added tokens map and sorted list for splitting
+	// Build added tokens map and trie for splitting
 	for _, at := range tj.AddedTokens {
-		t.addedTokens[at.Content] = at.ID
-		t.idToToken[at.ID] = at.Content
-		t.addedTokensSorted = append(t.addedTokensSorted, addedTokenEntry{content: at.Content, id: at.ID})
+		t.registerAddedToken(at)
 	}
-	// Sort longest-first for greedy matching
-	sort.Slice(t.addedTokensSorted, func(i, j int) bool {
-		return len(t.addedTokensSorted[i].content) > len(t.addedTokensSorted[j].content)
-	})
+
+	if tj.Model.Type == "Unigram" {
+		t.initUnigram()
+	}
+	t.initByteFallback()
 
 	// Build merge ranks for BPE
 	if tj.Model.Type == "BPE" {
@@ -176,20 +232,85 @@ func NewFromContent(config *api.Config, content []byte) (*Tokenizer, error) {
 		}
 	}
 
+	if tj.Truncation != nil {
+		truncation := *tj.Truncation
+		t.truncation = &truncation
+	}
+
 	// Resolve special token IDs
 	t.resolveSpecialTokens()
+	t.initNumSpecialTokens()
+	t.vocabFastPath = supportsVocabFastPath(&tj)
+	if pt := findPreTokenizer(tj.PreTokenizer, "ByteLevel"); pt != nil {
+		t.trimOffsets = pt.TrimOffsets
+	}
 
 	return t, nil
 }
 
+// registerAddedToken adds the added token to the lookup tables and to the trie used for splitting.
+func (t *Tokenizer) registerAddedToken(at AddedToken) {
+	t.addedTokens[at.Content] = at.ID
+	t.idToToken[at.ID] = at.Content
+	if at.Special {
+		if t.specialIDs == nil {
+			t.specialIDs = make(map[int]bool)
+		}
+		t.specialIDs[at.ID] = true
+	}
+	if at.Content == "" {
+		return
+	}
+	if t.addedTokensTrie == nil {
+		t.addedTokensTrie = &addedTokensTrie{}
+	}
+	t.addedTokensTrie.insert(addedTokenEntry{
+		content: at.Content, id: at.ID, lstrip: at.Lstrip, rstrip: at.Rstrip, singleWord: at.SingleWord})
+}
+
+// AddTokens adds the tokens to the added tokens of the tokenizer, and returns their ids.
+//
+// New tokens are assigned consecutive ids after MaxTokenID (the AddedToken.ID given is ignored), and tokens already
+// in the vocabulary keep their id. Tokens that are already added tokens are not added again: their current id is
+// returned.
+// Special tokens (e.g. "[MASK]" or "<unk>") are re-resolved, and Save includes the new tokens.
+//
+// It is not safe to call AddTokens concurrently with encoding.
+func (t *Tokenizer) AddTokens(tokens []AddedToken) []int {
+	ids := make([]int, len(tokens))
+	nextID := t.MaxTokenID() + 1
+	for i, at := range tokens {
+		if id, found := t.addedTokens[at.Content]; found {
+			ids[i] = id
+			continue
+		}
+		if id, found := t.tokenizer.Model.Vocab[at.Content]; found {
+			at.ID = id
+		} else {
+			at.ID = nextID
+			nextID++
+		}
+		t.tokenizer.AddedTokens = append(t.tokenizer.AddedTokens, at)
+		t.registerAddedToken(at)
+		ids[i] = at.ID
+	}
+	t.resolveSpecialTokens()
+	t.vocabOnce = sync.Once{}
+	t.vocab = nil
+	return ids
+}
+
 // resolveSpecialTokens maps special tokens from config to their IDs.
 func (t *Tokenizer) resolveSpecialTokens() {
-	// First check the model's unk_token
+	// First check the model's unk_token (or unk_id for Unigram models)
 	if t.tokenizer.Model.UnkToken != "" {
 		if id, ok := t.tokenizer.Model.Vocab[t.tokenizer.Model.UnkToken]; ok {
 			t.unkID = id
 		}
 	}
+	if t.tokenizer.Model.UnkID != nil {
+		t.unkID = *t.tokenizer.Model.UnkID
+	}
 
 	// Then check added tokens for special tokens
 	for _, at := range t.tokenizer.AddedTokens {
@@ -265,7 +386,29 @@ func (t *Tokenizer) Normalize(text string) string {
 	if t.tokenizer.Normalizer == nil {
 		return text
 	}
-	return t.applyNormalizer(text, t.tokenizer.Normalizer)
+	return t.applyNormalizer(text, t.tokenizer.Normalizer, inputStart)
+}
+
+// NormalizeWithOffsets is like Normalize, but it also returns the offsets mapping: for each byte of the normalized
+// text, the byte position in text it comes from. It is the mapping used to compute the spans of the tokens.
+//
+// The offsets of normalizers that can't be tracked exactly use a linear approximation instead: WithTrace reports
+// when that happens, and the encodings have OffsetsApproximate set.
+func (t *Tokenizer) NormalizeWithOffsets(text string) (normalized string, offsets []int) {
+	normalized, offsets, _ = t.normalizeWithSpans(text)
+	return normalized, offsets
+}
+
+// WithMaxInputChars sets the maximum number of characters (runes) of a word for the WordPiece model:
+// longer words are mapped to the unknown token. Set it to 0 for no limit.
+//
+// It overrides the "max_input_chars_per_word" value of tokenizer.json, which defaults to 100 if not set.
+// Raising it (or disabling it) is useful when long URLs or identifiers are unexpectedly collapsed to [UNK].
+//
+// It has no effect on other model types. It returns the tokenizer itself, for chaining calls.
+func (t *Tokenizer) WithMaxInputChars(n int) *Tokenizer {
+	t.maxInputCharsPerWord = max(n, 0)
+	return t
 }
 
 // With applies options to a tokenizer.
@@ -274,27 +417,95 @@ func (t *Tokenizer) With(options api.EncodeOptions) error {
 	return nil
 }
 
+// Encode converts text to token IDs, adding the special tokens if AddSpecialTokens is set.
+//
+// It doesn't compute the token spans, so it is faster than EncodeWithAnnotations.
 func (t *Tokenizer) Encode(text string) []int {
-	result := t.encodeCore(text)
+	result := t.encodeCore(text, false)
+	t.truncate(&result, t.options)
 	if t.options.AddSpecialTokens {
-		result.IDs, result.Spans, _ = t.applyPostProcessor(result.IDs, result.Spans)
+		result.IDs, _, _ = t.applyPostProcessor(result.IDs, nil)
 	}
 	return result.IDs
 }
 
 // EncodeWithAnnotations returns the encoded text along with requested annotations.
 func (t *Tokenizer) EncodeWithAnnotations(text string) api.AnnotatedEncoding {
-	result := t.encodeCore(text)
+	return t.EncodeWithOptions(text, t.options)
+}
+
+// EncodeWithOptions is like EncodeWithAnnotations, but uses the given options for this call only, instead of the
+// ones set with Tokenizer.With.
+//
+// E.g.: use it with AddSpecialTokens set to false to get the raw tokenization, without "[CLS]" or "[SEP]".
+func (t *Tokenizer) EncodeWithOptions(text string, options api.EncodeOptions) api.AnnotatedEncoding {
+	result := t.encodeCore(text, options.IncludeSpans)
+	t.truncate(&result, options)
 	var specialTokensMask []int
-	if t.options.AddSpecialTokens {
+	if options.AddSpecialTokens {
 		result.IDs, result.Spans, specialTokensMask = t.applyPostProcessor(result.IDs, result.Spans)
 	}
+	if !options.IncludeSpans {
+		result.Spans = nil
+	}
+	if options.IncludeSpecialTokensMask {
+		result.SpecialTokensMask = specialTokensMask
+	}
+	t.annotateTokens(&result, options)
+	return result
+}
+
+// annotateTokens sets the Tokens and AttentionMask of the encoding, if requested in options.
+func (t *Tokenizer) annotateTokens(result *api.AnnotatedEncoding, options api.EncodeOptions) {
+	if options.IncludeTokens {
+		result.Tokens = make([]string, len(result.IDs))
+		for i, id := range result.IDs {
+			result.Tokens[i] = t.idToToken[id]
+		}
+	}
+	if options.IncludeAttentionMask {
+		result.AttentionMask = make([]int, len(result.IDs))
+		for i := range result.AttentionMask {
+			result.AttentionMask[i] = 1
+		}
+	}
+}
+
+// EncodePair encodes a pair of sequences (e.g. question and context, or premise and hypothesis) into a single
+// sequence, as expected by cross-encoders and NLI models.
+//
+// If AddSpecialTokens is set, the special tokens are added following the post-processor, e.g.
+// "[CLS] A [SEP] B [SEP]" for BertProcessing. Otherwise, the two sequences are simply concatenated.
+//
+// The returned TypeIDs and SequenceIDs are always set. Spans (if IncludeSpans is set) are byte offsets into textA
+// for tokens with sequence id 0, and into textB for tokens with sequence id 1.
+func (t *Tokenizer) EncodePair(textA, textB string) api.AnnotatedEncoding {
+	a := t.encodeCore(textA, t.options.IncludeSpans)
+	b := t.encodeCore(textB, t.options.IncludeSpans)
+	t.truncatePair(&a, &b, t.options)
+	var pair pairBuilder
+	if t.options.AddSpecialTokens {
+		pair = t.applyPostProcessorPair(a.IDs, a.Spans, b.IDs, b.Spans)
+	} else {
+		pair = concatenatePair(a.IDs, a.Spans, b.IDs, b.Spans)
+	}
+	result := api.AnnotatedEncoding{
+		IDs:          pair.ids,
+		Spans:        pair.spans,
+		TypeIDs:      pair.typeIDs,
+		SequenceIDs:  pair.sequenceIDs,
+		NumTruncated: a.NumTruncated + b.NumTruncated,
+		Overflowing:  append(a.Overflowing, b.Overflowing...),
+	}
 	if !t.options.IncludeSpans {
 		result.Spans = nil
+	} else {
+		result.OffsetsApproximate = a.OffsetsApproximate || b.OffsetsApproximate
 	}
 	if t.options.IncludeSpecialTokensMask {
-		result.SpecialTokensMask = specialTokensMask
+		result.SpecialTokensMask = pair.specialMask
 	}
+	t.annotateTokens(&result, t.options)
 	return result
 }
 
@@ -303,18 +514,42 @@ type wordWithOffset struct {
 	text  string
 	start int // start position in original text (inclusive)
 	end   int // end position in original text (exclusive)
+
+	// runeOffsets, if set, holds the position in the original text of each rune of text, for words whose text
+	// doesn't map byte by byte to the original text (e.g. ByteLevel words).
+	runeOffsets []int
 }
 
 // encodeCore runs the core tokenization pipeline (split added tokens → normalize →
 // pre-tokenize → tokenize) without post-processing.
-func (t *Tokenizer) encodeCore(text string) api.AnnotatedEncoding {
+//
+// If withSpans is false, the spans are not computed (Spans is left nil), which saves the offsets bookkeeping.
+//
+// Inputs that are a single known token skip the pipeline (see singleTokenFastPath), except when tracing.
+func (t *Tokenizer) encodeCore(text string, withSpans bool) api.AnnotatedEncoding {
+	if t.trace == nil {
+		if id, ok := t.singleTokenFastPath(text); ok {
+			result := api.AnnotatedEncoding{IDs: []int{id}}
+			if withSpans {
+				result.Spans = []api.TokenSpan{{Start: 0, End: len(text)}}
+			}
+			return result
+		}
+	}
+	if !withSpans {
+		return api.AnnotatedEncoding{IDs: t.encodeIDs(text)}
+	}
 	segments := t.splitOnAddedTokens(text)
 
 	var ids []int
 	var spans []api.TokenSpan
+	var approximate bool
 
 	for _, seg := range segments {
 		if seg.isAddedToken {
+			if t.trace != nil {
+				t.tracef("added token %q -> %d", text[seg.start:seg.end], seg.tokenID)
+			}
 			ids = append(ids, seg.tokenID)
 			spans = append(spans, api.TokenSpan{Start: seg.start, End: seg.end})
 			continue
@@ -322,24 +557,97 @@ func (t *Tokenizer) encodeCore(text string) api.AnnotatedEncoding {
 
 		segText := text[seg.start:seg.end]
 
-		normalized, normSpans := t.normalizeWithSpans(segText)
-		for i := range normSpans {
-			normSpans[i] += seg.start
-		}
-
-		words := t.preTokenizeWithSpans(normalized, normSpans)
+		// Pre-tokenization and tokenization spans are in the normalized text coordinates, and only at the end
+		// aligned to the original text: this way, normalizers that change the length of characters (e.g. NFD
+		// or StripAccents) don't skew the spans of the tokens.
+		normalized, normOffsets, segApproximate := t.normalizeWithSpans(segText)
+		approximate = approximate || segApproximate
+		words := t.preTokenizeWithSpans(normalized, identityOffsets(len(normalized)), segmentPosition(seg.start))
 
 		for _, word := range words {
 			wordIDs, wordSpans := t.tokenizeWordWithSpans(word)
+			if t.trimOffsets {
+				t.trimByteLevelSpans(wordIDs, wordSpans)
+			}
 			ids = append(ids, wordIDs...)
-			spans = append(spans, wordSpans...)
+			for _, span := range wordSpans {
+				span = alignSpan(span, normOffsets, len(segText))
+				span.Start += seg.start
+				span.End += seg.start
+				spans = append(spans, span)
+			}
 		}
 	}
 
 	return api.AnnotatedEncoding{
-		IDs:   ids,
-		Spans: spans,
+		IDs:                ids,
+		Spans:              spans,
+		OffsetsApproximate: approximate,
+	}
+}
+
+// encodeIDs is the equivalent of encodeCore without spans: the normalizers, the pre-tokenizers and the model
+// skip the offsets bookkeeping.
+func (t *Tokenizer) encodeIDs(text string) []int {
+	return t.appendEncodedIDs(nil, text, false)
+}
+
+// appendEncodedIDs appends the ids of text, encoded as in encodeIDs, to ids.
+//
+// If continued is set, text continues the previous chunk of the input (see EncodeReader): its start is not handled
+// as the start of the input, or of a segment.
+func (t *Tokenizer) appendEncodedIDs(ids []int, text string, continued bool) []int {
+	t.forEachWordIDs(text, continued, func(wordIDs []int) {
+		ids = append(ids, wordIDs...)
+	})
+	return ids
+}
+
+// forEachWordIDs runs the tokenization pipeline without spans (see encodeIDs), and calls fn with the ids of each
+// added token and each pre-tokenized word, in order. The ids passed to fn are not retained.
+//
+// If continued is set, text continues the previous chunk of the input, see appendEncodedIDs.
+func (t *Tokenizer) forEachWordIDs(text string, continued bool, fn func(wordIDs []int)) {
+	for _, seg := range t.splitOnAddedTokens(text) {
+		if seg.isAddedToken {
+			if t.trace != nil {
+				t.tracef("added token %q -> %d", text[seg.start:seg.end], seg.tokenID)
+			}
+			fn([]int{seg.tokenID})
+			continue
+		}
+		pos := segmentPosition(seg.start)
+		if continued && seg.start == 0 {
+			pos = chunkContinuation
+		}
+		normalized := text[seg.start:seg.end]
+		if t.tokenizer.Normalizer != nil {
+			normalized = t.applyNormalizer(normalized, t.tokenizer.Normalizer, pos)
+		}
+		for _, word := range t.preTokenizeWithSpans(normalized, nil, pos) {
+			fn(t.tokenizeWordIDs(word))
+		}
+	}
+}
+
+// CountTokens returns the number of tokens of the encoding of text, the same as len(t.Encode(text)) -- including
+// the special tokens if AddSpecialTokens is set, and the truncation -- but without building the ids slice.
+//
+// It is useful for length-based batching or cost estimation.
+func (t *Tokenizer) CountTokens(text string) int {
+	var count int
+	if _, ok := t.singleTokenFastPath(text); ok && t.trace == nil {
+		count = 1
+	} else {
+		t.forEachWordIDs(text, false, func(wordIDs []int) {
+			count += len(wordIDs)
+		})
+	}
+	numSpecial := t.numSpecialTokensToAdd(false, t.options.AddSpecialTokens)
+	if maxLen, _ := t.truncationConfig(t.options); maxLen > 0 {
+		count = min(count, max(maxLen-numSpecial, 0))
 	}
+	return count + numSpecial
 }
 
 // parseTokenIDTuple parses a JSON [string, int] tuple (e.g., ["[CLS]", 101])
@@ -363,6 +671,69 @@ func parseTokenIDTuple(raw json.RawMessage) (int, bool) {
 type addedTokenEntry struct {
 	content string
 	id      int
+
+	// lstrip and rstrip: whether the added token consumes the whitespace on its left and right.
+	lstrip, rstrip bool
+
+	// singleWord: whether the added token only matches as a whole word, see isWordBoundary.
+	singleWord bool
+}
+
+// addedTokensTrie is a byte-level prefix trie over the content of the added tokens, used to find them in the
+// input text in a single pass, regardless of the number of added tokens.
+type addedTokensTrie struct {
+	children map[byte]*addedTokensTrie
+	entry    *addedTokenEntry // Set if an added token ends at this node.
+}
+
+// insert adds the entry to the trie. If an entry with the same content already exists it is replaced.
+func (trie *addedTokensTrie) insert(entry addedTokenEntry) {
+	node := trie
+	for i := range len(entry.content) {
+		child := node.children[entry.content[i]]
+		if child == nil {
+			child = &addedTokensTrie{}
+			if node.children == nil {
+				node.children = make(map[byte]*addedTokensTrie)
+			}
+			node.children[entry.content[i]] = child
+		}
+		node = child
+	}
+	node.entry = &entry
+}
+
+// appendMatches appends to matches the added tokens that are a prefix of text, longest first.
+func (trie *addedTokensTrie) appendMatches(matches []*addedTokenEntry, text string) []*addedTokenEntry {
+	first := len(matches)
+	node := trie
+	for i := 0; i < len(text) && node != nil; i++ {
+		node = node.children[text[i]]
+		if node != nil && node.entry != nil {
+			matches = append(matches, node.entry)
+		}
+	}
+	slices.Reverse(matches[first:])
+	return matches
+}
+
+// isWordBoundary returns whether pos in text is a word boundary for single_word added tokens: the start or end
+// of the text, or next to a whitespace or punctuation character. If before is set the character before pos is
+// checked, otherwise the character at pos.
+func isWordBoundary(text string, pos int, before bool) bool {
+	var r rune
+	if before {
+		if pos == 0 {
+			return true
+		}
+		r, _ = utf8.DecodeLastRuneInString(text[:pos])
+	} else {
+		if pos == len(text) {
+			return true
+		}
+		r, _ = utf8.DecodeRuneInString(text[pos:])
+	}
+	return isWhitespace(r) || isPunctuation(r)
 }
 
 // textSegment represents a piece of input text, either an added token or regular text.
@@ -374,38 +745,63 @@ type textSegment struct {
 }
 
 // splitOnAddedTokens splits text into segments of added tokens and regular text.
-// Added tokens are matched greedily (longest first).
+// Added tokens are matched greedily (longest first), using the addedTokensTrie.
+//
+// Added tokens with lstrip (rstrip) set also consume the whitespace on their left (right), which is then
+// included in their segment, and not pre-tokenized. Added tokens with single_word set only match whole words.
 func (t *Tokenizer) splitOnAddedTokens(text string) []textSegment {
 	if len(text) == 0 {
 		return nil
 	}
-	if len(t.addedTokensSorted) == 0 {
+	if t.addedTokensTrie == nil {
 		return []textSegment{{start: 0, end: len(text)}}
 	}
 
 	var segments []textSegment
+	var matches []*addedTokenEntry
 	regularStart := 0 // start of current regular text run
 	pos := 0
 
 	for pos < len(text) {
 		matched := false
-		for _, entry := range t.addedTokensSorted {
-			if pos+len(entry.content) <= len(text) && text[pos:pos+len(entry.content)] == entry.content {
-				// Flush any preceding regular text
-				if regularStart < pos {
-					segments = append(segments, textSegment{start: regularStart, end: pos})
+		matches = t.addedTokensTrie.appendMatches(matches[:0], text[pos:])
+		for _, entry := range matches {
+			if entry.singleWord && (!isWordBoundary(text, pos, true) || !isWordBoundary(text, pos+len(entry.content), false)) {
+				continue
+			}
+			start, end := pos, pos+len(entry.content)
+			if entry.lstrip {
+				for start > regularStart {
+					r, size := utf8.DecodeLastRuneInString(text[regularStart:start])
+					if !unicode.IsSpace(r) {
+						break
+					}
+					start -= size
 				}
-				segments = append(segments, textSegment{
-					start:        pos,
-					end:          pos + len(entry.content),
-					isAddedToken: true,
-					tokenID:      entry.id,
-				})
-				pos += len(entry.content)
-				regularStart = pos
-				matched = true
-				break
 			}
+			if entry.rstrip {
+				for end < len(text) {
+					r, size := utf8.DecodeRuneInString(text[end:])
+					if !unicode.IsSpace(r) {
+						break
+					}
+					end += size
+				}
+			}
+			// Flush any preceding regular text
+			if regularStart < start {
+				segments = append(segments, textSegment{start: regularStart, end: start})
+			}
+			segments = append(segments, textSegment{
+				start:        start,
+				end:          end,
+				isAddedToken: true,
+				tokenID:      entry.id,
+			})
+			pos = end
+			regularStart = pos
+			matched = true
+			break
 		}
 		if !matched {
 			_, size := utf8.DecodeRuneInString(text[pos:])
@@ -424,20 +820,95 @@ func (t *Tokenizer) splitOnAddedTokens(text string) []textSegment {
 // normalizeWithSpans applies normalization and returns the normalized text along with
 // a mapping from normalized byte positions to original byte positions.
 // The returned slice maps normalized position -> original position.
-func (t *Tokenizer) normalizeWithSpans(text string) (string, []int) {
+// approximate is set if the mapping of some normalizer was approximated, see approximateOffsets.
+func (t *Tokenizer) normalizeWithSpans(text string) (normalized string, offsets []int, approximate bool) {
 	if t.tokenizer.Normalizer == nil {
 		// No normalization - create identity mapping
-		offsets := make([]int, len(text))
-		for i := range text {
-			offsets[i] = i
-		}
-		return text, offsets
+		return text, identityOffsets(len(text)), false
 	}
 	return t.applyNormalizerWithSpans(text, t.tokenizer.Normalizer)
 }
 
+// identityOffsets returns the offsets mapping of a text of length n that is not changed.
+func identityOffsets(n int) []int {
+	offsets := make([]int, n)
+	for i := range offsets {
+		offsets[i] = i
+	}
+	return offsets
+}
+
+// alignSpan converts a span in the normalized text to the original text (of length originalLen), using the
+// offsets mapping from normalized byte positions to original byte positions returned by normalizeWithSpans.
+//
+// The end of the span is the original position of the first following normalized byte that comes from a later
+// original character (or the end of the original text). So a token includes the whole original characters it
+// was normalized from -- e.g. "é" for a token "e", after stripping accents -- as well as characters removed by
+// the normalizer right after it.
+func alignSpan(span api.TokenSpan, normOffsets []int, originalLen int) api.TokenSpan {
+	n := len(normOffsets)
+	start, end := min(max(span.Start, 0), n), min(max(span.End, 0), n)
+	if start == n {
+		return api.TokenSpan{Start: originalLen, End: originalLen}
+	}
+	aligned := api.TokenSpan{Start: normOffsets[start], End: originalLen}
+	if end <= start {
+		aligned.End = aligned.Start
+		return aligned
+	}
+	last := normOffsets[end-1]
+	for _, offset := range normOffsets[end:] {
+		if offset > last {
+			aligned.End = offset
+			break
+		}
+	}
+	return aligned
+}
+
+// normalizationForms maps the Unicode normalizer types to their normalization form.
+var normalizationForms = map[string]norm.Form{"NFC": norm.NFC, "NFD": norm.NFD, "NFKC": norm.NFKC, "NFKD": norm.NFKD}
+
+// normalizeFormWithSpans applies a Unicode normalization form (NFC, NFD, NFKC or NFKD) and tracks the offsets
+// exactly.
+//
+// The text is normalized one segment at a time (a starter character and the following combining characters,
+// see norm.Iter), so the input of each segment is known. If the segment normalizes character by character (e.g.
+// decompositions, or the "ﬁ" ligature to "fi") each output byte maps to the character it comes from. Otherwise
+// (compositions, like "e" + U+0301 to "é", or reordering of combining marks) all the output bytes of the segment
+// map to its start: alignSpan then extends the spans over the whole segment.
+func normalizeFormWithSpans(text string, form norm.Form) (string, []int) {
+	var result strings.Builder
+	offsets := make([]int, 0, len(text))
+	var iter norm.Iter
+	iter.InitString(form, text)
+	for segStart := 0; !iter.Done(); {
+		segment := iter.Next()
+		segEnd := iter.Pos()
+		result.Write(segment)
+		var perRune []byte
+		for _, r := range text[segStart:segEnd] {
+			perRune = append(perRune, form.String(string(r))...)
+		}
+		if bytes.Equal(perRune, segment) {
+			for origPos, r := range text[segStart:segEnd] {
+				for range len(form.String(string(r))) {
+					offsets = append(offsets, segStart+origPos)
+				}
+			}
+		} else {
+			for range segment {
+				offsets = append(offsets, segStart)
+			}
+		}
+		segStart = segEnd
+	}
+	return result.String(), offsets
+}
+
 // applyNormalizerWithSpans applies a normalizer and tracks byte positions.
-func (t *Tokenizer) applyNormalizerWithSpans(text string, n *Normalizer) (string, []int) {
+// approximate is set if the offsets couldn't be tracked exactly, see approximateOffsets.
+func (t *Tokenizer) applyNormalizerWithSpans(text string, n *Normalizer) (normalized string, offsets []int, approximate bool) {
 	// For most normalizers, we need to track how characters map through the transformation.
 	// This is complex because normalizers can:
 	// 1. Remove characters (accents, control chars)
@@ -447,97 +918,83 @@ func (t *Tokenizer) applyNormalizerWithSpans(text string, n *Normalizer) (string
 	//
 	// For simplicity, we handle the common cases and fall back to approximate mapping for complex cases.
 
+	if t.trace != nil {
+		switch n.Type {
+		case "Lowercase", "BertNormalizer", "NFD", "NFKD", "NFC", "NFKC", "StripAccents", "Sequence", "Replace", "Strip", "Precompiled":
+			t.tracef("normalizer %s", n.Type)
+		}
+	}
 	switch n.Type {
 	case "Lowercase":
-		// Lowercase preserves character positions (1:1 mapping)
-		normalized := strings.ToLower(text)
-		offsets := make([]int, len(normalized))
-		origPos := 0
-		normPos := 0
-		for _, r := range text {
-			lowerRunes := []rune(strings.ToLower(string(r)))
-			for range lowerRunes {
-				if normPos < len(offsets) {
-					offsets[normPos] = origPos
-					normPos++
-				}
+		// Lowercase maps each character to its lowercase version, which may have a different length in bytes.
+		var result strings.Builder
+		offsets = make([]int, 0, len(text))
+		for origPos, r := range text {
+			lower := strings.ToLower(string(r))
+			result.WriteString(lower)
+			for range len(lower) {
+				offsets = append(offsets, origPos)
 			}
-			origPos += len(string(r))
 		}
-		return normalized, offsets
+		return result.String(), offsets, false
 
 	case "BertNormalizer":
-		// Clean text and optionally lowercase
-		var result strings.Builder
-		var offsets []int
-		origPos := 0
-		for _, r := range text {
-			runeLen := len(string(r))
-			if r == 0 || r == 0xFFFD || isControl(r) {
-				// Skip this character
-				origPos += runeLen
-				continue
-			}
+		normalized, offsets = bertNormalizeWithSpans(text, n, true)
+		return normalized, offsets, false
 
-			if n.HandleChineseChars && isChineseChar(r) {
-				result.WriteRune(' ')
-				offsets = append(offsets, origPos)
-				result.WriteRune(r)
-				offsets = append(offsets, origPos)
-				result.WriteRune(' ')
-				offsets = append(offsets, origPos)
-			} else if isWhitespace(r) {
-				result.WriteRune(' ')
-				offsets = append(offsets, origPos)
-			} else {
-				// Potential accent stripping and lowercasing
-				s := string(r)
-				if (n.StripAccents != nil && *n.StripAccents) || (n.StripAccents == nil && n.Lowercase) {
-					s = removeAccents(norm.NFD.String(s))
-				}
-				if n.Lowercase {
-					s = strings.ToLower(s)
-				}
-				for range s {
-					offsets = append(offsets, origPos)
-				}
-				result.WriteString(s)
-			}
-			origPos += runeLen
-		}
-		return result.String(), offsets
+	case "Replace":
+		normalized, offsets = t.replaceWithSpans(text, n, true)
+		return normalized, offsets, false
+
+	case "Strip":
+		normalized, offsets = stripWithSpans(text, n, true)
+		return normalized, offsets, false
+
+	case "NFD", "NFKD", "NFC", "NFKC":
+		normalized, offsets = normalizeFormWithSpans(text, normalizationForms[n.Type])
+		return normalized, offsets, false
 
-	case "NFD", "NFC", "NFKC", "NFKD":
-		// Unicode normalization - approximate mapping
-		normalized := t.applyNormalizer(text, n)
-		return approximateOffsets(text, normalized)
+	case "Precompiled":
+		if n.charsmap != nil {
+			normalized, offsets = n.charsmap.normalize(text, true)
+			return normalized, offsets, false
+		}
+		// Approximated with NFKC, see applyNormalizer: the offsets through NFKC are exact, but the normalization
+		// itself is an approximation, so it is still reported as approximate.
+		if t.trace != nil {
+			t.tracef("normalizer Precompiled: no precompiled_charsmap, approximated with NFKC")
+		}
+		normalized, offsets = normalizeFormWithSpans(text, norm.NFKC)
+		return normalized, offsets, true
 
 	case "StripAccents":
-		// NFD then remove combining marks
-		nfd := norm.NFD.String(text)
+		// NFD then remove combining marks: the remaining characters of the decomposition map to the
+		// original character.
 		var result strings.Builder
-		var offsets []int
-		origPos := 0
-		for _, r := range nfd {
-			runeLen := len(string(r))
-			if !unicode.Is(unicode.Mn, r) {
-				result.WriteRune(r)
-				offsets = append(offsets, origPos)
+		offsets = make([]int, 0, len(text))
+		for origPos, r := range text {
+			for _, decomposed := range norm.NFD.String(string(r)) {
+				if unicode.Is(unicode.Mn, decomposed) {
+					continue
+				}
+				result.WriteRune(decomposed)
+				for range utf8.RuneLen(decomposed) {
+					offsets = append(offsets, origPos)
+				}
 			}
-			origPos += runeLen
 		}
-		// Re-map offsets to original text positions
-		return result.String(), remapOffsetsFromNFD(text, offsets)
+		return result.String(), offsets, false
 
 	case "Sequence":
 		result := text
 		currentOffsets := make([]int, len(text))
-		for i := range text {
+		for i := range len(text) {
 			currentOffsets[i] = i
 		}
 		for _, child := range n.Normalizers {
 			childCopy := child
-			newResult, newOffsets := t.applyNormalizerWithSpans(result, &childCopy)
+			newResult, newOffsets, childApproximate := t.applyNormalizerWithSpans(result, &childCopy)
+			approximate = approximate || childApproximate
 			// Compose the offset mappings
 			composedOffsets := make([]int, len(newOffsets))
 			for i, off := range newOffsets {
@@ -550,13 +1007,170 @@ func (t *Tokenizer) applyNormalizerWithSpans(text string, n *Normalizer) (string
 			result = newResult
 			currentOffsets = composedOffsets
 		}
-		return result, currentOffsets
+		return result, currentOffsets, approximate
 
 	default:
 		// Unknown normalizer - use approximate mapping
-		normalized := t.applyNormalizer(text, n)
-		return approximateOffsets(text, normalized)
+		normalized = t.applyNormalizer(text, n, inputStart)
+		if t.trace != nil {
+			t.tracef("normalizer %s: using approximate offsets", n.Type)
+		}
+		normalized, offsets = approximateOffsets(text, normalized)
+		return normalized, offsets, true
+	}
+}
+
+// replaceWithSpans applies a Replace normalizer: the matches of the literal Pattern.String, or of the regular
+// expression Pattern.Regex, are replaced by Content.
+//
+// If withSpans is set, it also returns the offsets mapping: the bytes of each replacement map to the start of
+// the match they replace.
+func (t *Tokenizer) replaceWithSpans(text string, n *Normalizer, withSpans bool) (string, []int) {
+	var matches [][]int
+	switch {
+	case n.Pattern != nil && n.Pattern.String != "":
+		for pos := 0; pos <= len(text); {
+			idx := strings.Index(text[pos:], n.Pattern.String)
+			if idx < 0 {
+				break
+			}
+			matches = append(matches, []int{pos + idx, pos + idx + len(n.Pattern.String)})
+			pos += idx + len(n.Pattern.String)
+		}
+	case n.compiled != nil:
+		matches = n.compiled.FindAllStringIndex(text, -1)
+	case n.Pattern != nil && n.Pattern.Regex != "":
+		if t.trace != nil {
+			t.tracef("normalizer Replace: invalid regex %q, text left unchanged", n.Pattern.Regex)
+		}
+	}
+
+	var offsets []int
+	if withSpans {
+		offsets = make([]int, 0, len(text))
 	}
+	if len(matches) == 0 {
+		if withSpans {
+			for i := range len(text) {
+				offsets = append(offsets, i)
+			}
+		}
+		return text, offsets
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, match := range matches {
+		result.WriteString(text[last:match[0]])
+		result.WriteString(n.Content)
+		if withSpans {
+			for i := last; i < match[0]; i++ {
+				offsets = append(offsets, i)
+			}
+			for range len(n.Content) {
+				offsets = append(offsets, match[0])
+			}
+		}
+		last = match[1]
+	}
+	result.WriteString(text[last:])
+	if withSpans {
+		for i := last; i < len(text); i++ {
+			offsets = append(offsets, i)
+		}
+	}
+	return result.String(), offsets
+}
+
+// bertNormalizeWithSpans applies a BertNormalizer: it removes control characters and maps whitespace to " " (if
+// CleanText), surrounds CJK characters with spaces (if HandleChineseChars), strips accents (if StripAccents, which
+// defaults to Lowercase) and lowercases (if Lowercase). It works character by character.
+//
+// If withSpans is set, it also returns the offsets mapping: the bytes each character is normalized to map to
+// its start.
+func bertNormalizeWithSpans(text string, n *Normalizer, withSpans bool) (string, []int) {
+	stripAccents := (n.StripAccents != nil && *n.StripAccents) || (n.StripAccents == nil && n.Lowercase)
+	var result strings.Builder
+	result.Grow(len(text))
+	var offsets []int
+	if withSpans {
+		offsets = make([]int, 0, len(text))
+	}
+	for origPos, r := range text {
+		var s string
+		switch {
+		case n.CleanText && (r == 0 || r == 0xFFFD || isControl(r)):
+			continue
+		case n.HandleChineseChars && isChineseChar(r):
+			s = " " + string(r) + " "
+		case n.CleanText && isWhitespace(r):
+			s = " "
+		default:
+			s = string(r)
+			if stripAccents {
+				s = removeAccents(norm.NFD.String(s))
+			}
+			if n.Lowercase {
+				s = strings.ToLower(s)
+			}
+		}
+		result.WriteString(s)
+		if withSpans {
+			for range len(s) {
+				offsets = append(offsets, origPos)
+			}
+		}
+	}
+	return result.String(), offsets
+}
+
+// stripWithSpans applies a Strip normalizer, removing the leading (if StripLeft) and trailing (if StripRight)
+// whitespace. If withSpans is set, it also returns the offsets mapping.
+func stripWithSpans(text string, n *Normalizer, withSpans bool) (string, []int) {
+	start, end := 0, len(text)
+	if n.StripLeft {
+		start = len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	}
+	if n.StripRight {
+		end = start + len(strings.TrimRightFunc(text[start:], unicode.IsSpace))
+	}
+	if !withSpans {
+		return text[start:end], nil
+	}
+	offsets := make([]int, end-start)
+	for i := range offsets {
+		offsets[i] = start + i
+	}
+	return text[start:end], offsets
+}
+
+// compileNormalizer compiles the regular expressions of the Replace normalizers and parses the charsmaps of the
+// Precompiled normalizers, recursively.
+//
+// Invalid regular expressions are left uncompiled, and the normalizer leaves the text unchanged.
+func compileNormalizer(n *Normalizer) error {
+	if n == nil {
+		return nil
+	}
+	if n.Type == "Replace" && n.Pattern != nil && n.Pattern.String == "" && n.Pattern.Regex != "" {
+		n.compiled, _ = regexp.Compile(n.Pattern.Regex)
+	}
+	if n.Type == "Precompiled" && n.PrecompiledCharsmap != "" {
+		charsmap, err := parsePrecompiledCharsmap(n.PrecompiledCharsmap)
+		if err != nil {
+			return err
+		}
+		n.charsmap = charsmap
+	}
+	if err := compileNormalizer(n.Normalizer); err != nil {
+		return err
+	}
+	for i := range n.Normalizers {
+		if err := compileNormalizer(&n.Normalizers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // approximateOffsets creates an approximate offset mapping when exact tracking is too complex.
@@ -588,42 +1202,11 @@ func approximateOffsets(original, normalized string) (string, []int) {
 	return normalized, offsets
 }
 
-// remapOffsetsFromNFD maps offsets from NFD-normalized text back to original text positions.
-func remapOffsetsFromNFD(original string, nfdOffsets []int) []int {
-	// This is an approximation - maps NFD positions to original positions
-	nfd := norm.NFD.String(original)
-	if len(nfd) == len(original) {
-		return nfdOffsets // No change in length, direct mapping
-	}
-
-	// Build mapping from NFD position to original position
-	nfdToOrig := make([]int, len(nfd))
-	origPos := 0
-	nfdPos := 0
-	for _, r := range original {
-		nfdRunes := []rune(norm.NFD.String(string(r)))
-		for range nfdRunes {
-			if nfdPos < len(nfdToOrig) {
-				nfdToOrig[nfdPos] = origPos
-				nfdPos++
-			}
-		}
-		origPos += len(string(r))
-	}
-
-	// Remap the offsets
-	result := make([]int, len(nfdOffsets))
-	for i, off := range nfdOffsets {
-		if off < len(nfdToOrig) {
-			result[i] = nfdToOrig[off]
-		} else if len(nfdToOrig) > 0 {
-			result[i] = nfdToOrig[len(nfdToOrig)-1]
-		}
+// applyNormalizer applies the normalizer n to text, at the position pos of the input.
+func (t *Tokenizer) applyNormalizer(text string, n *Normalizer, pos textPosition) string {
+	if t.trace != nil {
+		t.tracef("normalizer %s", n.Type)
 	}
-	return result
-}
-
-func (t *Tokenizer) applyNormalizer(text string, n *Normalizer) string {
 	switch n.Type {
 	case "Lowercase":
 		return strings.ToLower(text)
@@ -635,50 +1218,52 @@ func (t *Tokenizer) applyNormalizer(text string, n *Normalizer) string {
 		return norm.NFKC.String(text)
 	case "NFKD":
 		return norm.NFKD.String(text)
+	case "Precompiled":
+		// The SentencePiece "precompiled_charsmap" is a compiled normalization table (usually "nmt_nfkc").
+		// If it is missing, we approximate it with NFKC, its closest standard Unicode normalization form.
+		if n.charsmap != nil {
+			normalized, _ := n.charsmap.normalize(text, false)
+			return normalized
+		}
+		if t.trace != nil {
+			t.tracef("normalizer Precompiled: no precompiled_charsmap, approximated with NFKC")
+		}
+		return norm.NFKC.String(text)
 	case "StripAccents":
 		// NFD decomposition then remove combining marks (Mn category)
 		return removeAccents(norm.NFD.String(text))
 	case "BertNormalizer":
-		// Clean text, handle Chinese chars, strip accents, lowercase
-		result := text
-		if n.CleanText {
-			result = cleanText(result)
-		}
-		if n.HandleChineseChars {
-			result = tokenizeChineseChars(result)
-		}
-		if (n.StripAccents != nil && *n.StripAccents) || (n.StripAccents == nil && n.Lowercase) {
-			result = removeAccents(norm.NFD.String(result))
-		}
-		if n.Lowercase {
-			result = strings.ToLower(result)
-		}
-		return result
+		normalized, _ := bertNormalizeWithSpans(text, n, false)
+		return normalized
 	case "Sequence":
 		result := text
 		for _, child := range n.Normalizers {
 			childCopy := child
-			result = t.applyNormalizer(result, &childCopy)
+			result = t.applyNormalizer(result, &childCopy, pos)
 		}
 		return result
 	case "Replace":
-		if n.Pattern == nil {
-			return text
+		normalized, _ := t.replaceWithSpans(text, n, false)
+		return normalized
+	case "Strip":
+		if pos == chunkContinuation && n.StripLeft {
+			// The leading whitespace of a chunk continuation is inside the input.
+			noLeft := *n
+			noLeft.StripLeft = false
+			n = &noLeft
 		}
-		if n.Pattern.String != "" {
-			return strings.ReplaceAll(text, n.Pattern.String, n.Content)
-		}
-		if n.Pattern.Regex != "" {
-			re, err := regexp.Compile(n.Pattern.Regex)
-			if err == nil {
-				return re.ReplaceAllString(text, n.Content)
-			}
-		}
-		return text
+		normalized, _ := stripWithSpans(text, n, false)
+		return normalized
 	case "Prepend":
 		// Prepend a string (used by some tokenizers)
+		if t.trace != nil {
+			t.tracef("normalizer Prepend: not supported, text left unchanged")
+		}
 		return text
 	default:
+		if t.trace != nil {
+			t.tracef("normalizer %q: not supported, text left unchanged", n.Type)
+		}
 		return text
 	}
 }
@@ -723,20 +1308,92 @@ func (t *Tokenizer) SpecialTokenID(token api.SpecialToken) (int, error) {
 }
 
 // VocabSize returns the size of the vocabulary.
+//
+// It's the number of vocabulary tokens plus added tokens, which may not match MaxTokenID()+1 if there are
+// gaps in the ids, or added tokens that reuse ids of the vocabulary -- see ValidateVocab.
+// Use MaxTokenID to size embedding tables.
 func (t *Tokenizer) VocabSize() int {
 	return len(t.tokenizer.Model.Vocab) + len(t.tokenizer.AddedTokens)
 }
 
-// GetVocab returns the full vocabulary mapping.
-func (t *Tokenizer) GetVocab() map[string]int {
-	vocab := make(map[string]int)
-	for k, v := range t.tokenizer.Model.Vocab {
-		vocab[k] = v
+// MaxTokenID returns the highest token id, including added tokens, or -1 if the vocabulary is empty.
+func (t *Tokenizer) MaxTokenID() int {
+	maxID := -1
+	for id := range t.idToToken {
+		maxID = max(maxID, id)
+	}
+	return maxID
+}
+
+// maxReportedMissingIDs is the maximum number of missing ids listed by ValidateVocab.
+const maxReportedMissingIDs = 10
+
+// ValidateVocab checks the consistency of the vocabulary and added tokens, and returns a description of each
+// issue found, or nil if there are none.
+//
+// It reports vocabulary tokens sharing the same id, added tokens whose id is used by a different vocabulary token
+// or whose content is in the vocabulary with a different id, and gaps: ids in [0, MaxTokenID()] without a token.
+func (t *Tokenizer) ValidateVocab() []string {
+	var issues []string
+	vocabByID := make(map[int]string, len(t.tokenizer.Model.Vocab))
+	for token, id := range t.tokenizer.Model.Vocab {
+		if other, found := vocabByID[id]; !found || token < other {
+			vocabByID[id] = token
+		}
+	}
+	for token, id := range t.tokenizer.Model.Vocab {
+		if first := vocabByID[id]; token != first {
+			issues = append(issues, fmt.Sprintf("vocab tokens %q and %q share id %d", first, token, id))
+		}
 	}
 	for _, at := range t.tokenizer.AddedTokens {
-		vocab[at.Content] = at.ID
+		if token, found := vocabByID[at.ID]; found && token != at.Content {
+			issues = append(issues, fmt.Sprintf("added token %q has id %d, already used by vocab token %q", at.Content, at.ID, token))
+		}
+		if id, found := t.tokenizer.Model.Vocab[at.Content]; found && id != at.ID {
+			issues = append(issues, fmt.Sprintf("added token %q has id %d, but it is in the vocab with id %d", at.Content, at.ID, id))
+		}
+	}
+	slices.Sort(issues)
+
+	var missing []int
+	numMissing := 0
+	for id := range t.MaxTokenID() + 1 {
+		if _, found := t.idToToken[id]; !found {
+			numMissing++
+			if len(missing) < maxReportedMissingIDs {
+				missing = append(missing, id)
+			}
+		}
+	}
+	if numMissing > 0 {
+		issues = append(issues, fmt.Sprintf("%d ids in [0, %d] have no token, the first ones are %v", numMissing, t.MaxTokenID(), missing))
 	}
-	return vocab
+	return issues
+}
+
+// GetVocab returns the full vocabulary mapping, including the added tokens.
+//
+// It returns a new copy on each call, which the caller can modify. See GetVocabRef to avoid the copy.
+func (t *Tokenizer) GetVocab() map[string]int {
+	return maps.Clone(t.GetVocabRef())
+}
+
+// GetVocabRef is like GetVocab, but returns the map shared by all calls, built once on the first call.
+//
+// The returned map must not be modified. It is not updated by later calls to AddTokens: call GetVocabRef again.
+func (t *Tokenizer) GetVocabRef() map[string]int {
+	t.vocabOnce.Do(func() {
+		vocab := make(map[string]int, len(t.tokenizer.Model.Vocab)+len(t.tokenizer.AddedTokens))
+		for k, v := range t.tokenizer.Model.Vocab {
+			vocab[k] = v
+		}
+		for _, at := range t.tokenizer.AddedTokens {
+			vocab[at.Content] = at.ID
+		}
+		t.vocab = vocab
+	})
+	return t.vocab
 }
 
 // Config returns the HuggingFace tokenizer configuration.
@@ -764,39 +1421,6 @@ func isChineseChar(r rune) bool {
 	return false
 }
 
-func tokenizeChineseChars(text string) string {
-	var result strings.Builder
-	for _, r := range text {
-		if isChineseChar(r) {
-			result.WriteRune(' ')
-			result.WriteRune(r)
-			result.WriteRune(' ')
-		} else {
-			result.WriteRune(r)
-		}
-	}
-	return result.String()
-}
-
-func cleanText(text string) string {
-	var result strings.Builder
-	for _, r := range text {
-		if r == 0 || r == 0xFFFD || isControl(r) {
-			continue
-		}
-		if isChineseChar(r) {
-			result.WriteRune(' ')
-			result.WriteRune(r)
-			result.WriteRune(' ')
-		} else if isWhitespace(r) {
-			result.WriteRune(' ')
-		} else {
-			result.WriteRune(r)
-		}
-	}
-	return result.String()
-}
-
 func isWhitespace(r rune) bool {
 	if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
 		return true
@@ -1,7 +1,19 @@
 package hftokenizer
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/gomlx/go-huggingface/tokenizers/api"
 )
@@ -772,13 +784,14 @@ func TestCleanText(t *testing.T) {
 		{"hello\tworld", "hello world"},
 		{"hello\nworld", "hello world"},
 		{"hello\x00world", "helloworld"}, // null char removed
+		{"hello 你好", "hello 你好"},         // CJK characters are only padded with handle_chinese_chars.
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			got := cleanText(tt.input)
+			got, _ := bertNormalizeWithSpans(tt.input, &Normalizer{Type: "BertNormalizer", CleanText: true}, false)
 			if got != tt.want {
-				t.Errorf("cleanText(%q) = %q, want %q", tt.input, got, tt.want)
+				t.Errorf("BertNormalizer{clean_text: true}(%q) = %q, want %q", tt.input, got, tt.want)
 			}
 		})
 	}
@@ -1622,6 +1635,9 @@ func BenchmarkEncodeWithAnnotations(b *testing.B) {
 	if err != nil {
 		b.Fatalf("NewFromContent failed: %v", err)
 	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		b.Fatalf("With failed: %v", err)
+	}
 
 	inputs := []string{
 		"hello world",
@@ -1728,6 +1744,9 @@ func BenchmarkEncodeWithAnnotations_LongText(b *testing.B) {
 	if err != nil {
 		b.Fatalf("NewFromContent failed: %v", err)
 	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		b.Fatalf("With failed: %v", err)
+	}
 
 	// Generate a longer input
 	input := "this is a test hello world testing "
@@ -1740,3 +1759,2392 @@ func BenchmarkEncodeWithAnnotations_LongText(b *testing.B) {
 		_ = tok.EncodeWithAnnotations(input)
 	}
 }
+
+func TestEncodeReader(t *testing.T) {
+	tok, err := NewFromContent(nil, testBPETokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	input := strings.Repeat("hello world  test<|endoftext|> ", 20)
+	want := tok.Encode(input)
+
+	// Small chunks force words to straddle chunk boundaries.
+	for _, chunkSize := range []int{1, 3, 7, 64} {
+		got, err := tok.encodeReader(iotest.HalfReader(strings.NewReader(input)), chunkSize, 1024)
+		if err != nil {
+			t.Fatalf("encodeReader(chunkSize=%d) failed: %v", chunkSize, err)
+		}
+		if !intSliceEqual(got, want) {
+			t.Errorf("encodeReader(chunkSize=%d) = %v, want %v", chunkSize, got, want)
+		}
+	}
+
+	got, err := tok.EncodeReader(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("EncodeReader failed: %v", err)
+	}
+	if !intSliceEqual(got, want) {
+		t.Errorf("EncodeReader = %v, want %v", got, want)
+	}
+
+	// Read errors are reported.
+	_, err = tok.EncodeReader(iotest.ErrReader(iotest.ErrTimeout))
+	if err == nil {
+		t.Errorf("EncodeReader should have failed on a reader error")
+	}
+}
+
+func TestEncodeReader_ChunkContinuation(t *testing.T) {
+	// Llama-style Metaspace with prepend_scheme "first", an added token with whitespace and an rstrip added token.
+	metaspaceJSON := []byte(`{
+		"added_tokens": [
+			{"id": 7, "content": "<|im_start|> user", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+			{"id": 8, "content": "<mask>", "single_word": false, "lstrip": false, "rstrip": true, "normalized": false, "special": true}
+		],
+		"pre_tokenizer": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "first", "split": false},
+		"model": {"type": "Unigram", "unk_id": 0, "vocab": [
+			["<unk>", 0.0], ["▁", -2.0], ["▁hello", -3.0], ["▁world", -3.0], ["hello", -4.0], ["world", -4.0], ["\n", -2.0],
+			["<|im_start|> user", 0.0], ["<mask>", 0.0]
+		]}
+	}`)
+	// ByteLevel with add_prefix_space, after a normalizer that strips the leading whitespace.
+	byteLevelJSON := []byte(`{
+		"normalizer": {"type": "Strip", "strip_left": true, "strip_right": false},
+		"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": true},
+		"model": {"type": "BPE", "vocab": {"Ġ": 0, "Ċ": 1, "h": 2, "e": 3, "l": 4, "o": 5, "w": 6, "r": 7, "d": 8}, "merges": []}
+	}`)
+	input := strings.Repeat("hello world\nhello\n\nworld <|im_start|> user hello <mask>   world\n ", 5)
+
+	for _, tc := range []struct {
+		name    string
+		content []byte
+	}{
+		{"Metaspace", metaspaceJSON},
+		{"ByteLevel", byteLevelJSON},
+	} {
+		tok, err := NewFromContent(nil, tc.content)
+		if err != nil {
+			t.Fatalf("%s: NewFromContent failed: %v", tc.name, err)
+		}
+		for _, maxLen := range []int{0, 9} {
+			if err := tok.With(api.EncodeOptions{MaxLen: maxLen}); err != nil {
+				t.Fatalf("With failed: %v", err)
+			}
+			want := tok.Encode(input)
+			if maxLen > 0 && len(want) != maxLen {
+				t.Fatalf("%s: len(Encode()) = %d, want %d", tc.name, len(want), maxLen)
+			}
+			for _, chunkSize := range []int{1, 3, 7, 16} {
+				got, err := tok.encodeReader(strings.NewReader(input), chunkSize, 1024)
+				if err != nil {
+					t.Fatalf("%s: encodeReader(chunkSize=%d) failed: %v", tc.name, chunkSize, err)
+				}
+				if !intSliceEqual(got, want) {
+					t.Errorf("%s: encodeReader(chunkSize=%d, MaxLen=%d) = %v, want %v", tc.name, chunkSize, maxLen, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLastWordBoundary(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"hello", 0},
+		{"hello wor", 5},
+		{"hello  wor", 5},
+		{"hello world ", 11},
+		{"   ", 0},
+		{"héllo wörld", 6},
+	}
+	for _, tt := range tests {
+		if got := lastWordBoundary([]byte(tt.input)); got != tt.want {
+			t.Errorf("lastWordBoundary(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestWordPiece_WithMaxInputChars(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// "testing" (7 chars) fits in the default limit.
+	if got, want := tok.Encode("testing"), []int{3, 4}; !intSliceEqual(got, want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+
+	tok.WithMaxInputChars(5)
+	if got, want := tok.Encode("testing is"), []int{100, 106}; !intSliceEqual(got, want) {
+		t.Errorf("Encode with max 5 chars = %v, want %v", got, want)
+	}
+
+	// 0 disables the limit.
+	long := "test" + strings.Repeat("ing", 40) // 124 chars: "test" followed by 40 "##ing".
+	tok.WithMaxInputChars(0)
+	if got := tok.Encode(long); len(got) != 41 || got[0] != 3 || got[40] != 4 {
+		t.Errorf("Encode of a 124 chars word with no limit = %v", got)
+	}
+	tok.WithMaxInputChars(100)
+	if got, want := tok.Encode(long), []int{100}; !intSliceEqual(got, want) {
+		t.Errorf("Encode of a 124 chars word with max 100 chars = %v, want %v", got, want)
+	}
+}
+
+func TestPrecompiledNormalization(t *testing.T) {
+	precompiledTokenizerJSON := []byte(`{
+		"version": "1.0",
+		"normalizer": {
+			"type": "Sequence",
+			"normalizers": [
+				{"type": "Precompiled", "precompiled_charsmap": ""},
+				{"type": "Replace", "pattern": {"Regex": " {2,}"}, "content": " "}
+			]
+		},
+		"pre_tokenizer": {"type": "WhitespaceSplit"},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"vocab": {"[UNK]": 0, "fi": 1, "1": 2, "hello": 3}
+		}
+	}`)
+
+	tok, err := NewFromContent(nil, precompiledTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// NFKC maps the "ﬁ" ligature to "fi" and the fullwidth "１" to "1", and the Replace normalizer collapses the
+	// runs of spaces.
+	if got, want := tok.Normalize("\ufb01 \uff11"), "fi 1"; got != want {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+	text := "\ufb01 \uff11   hello  world"
+	if got, want := tok.Normalize(text), "fi 1 hello world"; got != want {
+		t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+	}
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{1, 2, 3, 0}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	// The words after the collapsed spaces map back to their position in the original text.
+	wantSpans := []api.TokenSpan{{Start: 0, End: 3}, {Start: 4, End: 7}, {Start: 10, End: 15}, {Start: 17, End: 22}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+	if got, want := tok.Encode(text), result.IDs; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	tok, err := NewFromContent(nil, testSimpleBPETokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	batch := [][]int{{12}, {15}, {12, 15}, {8, 9, 4}, nil}
+	want := []string{"hello", "world", "helloworld", "hello", ""}
+	for _, maxParallelization := range []int{0, 2, -1} {
+		got := tok.DecodeBatch(batch, api.DecodeOptions{MaxParallelization: maxParallelization})
+		if !stringSliceEqual(got, want) {
+			t.Errorf("DecodeBatch(MaxParallelization=%d) = %q, want %q", maxParallelization, got, want)
+		}
+	}
+}
+
+func TestByteLevelDecode_InvalidUTF8(t *testing.T) {
+	// "é" is encoded as the bytes 0xC3 0xA9, represented by the byte-level characters "Ã" and "©".
+	content := strings.Replace(string(testBPETokenizerJSON), `"Ġtest": 12`, `"Ġtest": 12, "Ã": 13, "©": 14`, 1)
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	if got := tok.Decode([]int{2, 13, 14}); got != "helloé" {
+		t.Errorf("Decode(complete rune) = %q, want %q", got, "helloé")
+	}
+	if got := tok.Decode([]int{2, 13}); got != "hello�" {
+		t.Errorf("Decode(partial rune) = %q, want %q", got, "hello�")
+	}
+
+	// Accumulating bytes across calls reconstructs the split rune.
+	got := append(tok.DecodeBytes([]int{2, 13}), tok.DecodeBytes([]int{14})...)
+	if string(got) != "helloé" {
+		t.Errorf("accumulated DecodeBytes = %q, want %q", got, "helloé")
+	}
+}
+
+func TestMaxTokenIDAndValidateVocab(t *testing.T) {
+	tok, err := NewFromContent(nil, testBPETokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got := tok.MaxTokenID(); got != 12 {
+		t.Errorf("MaxTokenID() = %d, want 12", got)
+	}
+	if issues := tok.ValidateVocab(); len(issues) != 0 {
+		t.Errorf("ValidateVocab() = %q, want no issues", issues)
+	}
+
+	// Added token reusing the id of "hello", and gaps at ids 1, 13 and 14.
+	content := strings.Replace(string(testBPETokenizerJSON),
+		`{"id": 1, "content": "<|padding|>"`,
+		`{"id": 2, "content": "<|user|>", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+    {"id": 15, "content": "<|padding|>"`, 1)
+	tok, err = NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got := tok.MaxTokenID(); got != 15 {
+		t.Errorf("MaxTokenID() = %d, want 15", got)
+	}
+	want := []string{
+		`added token "<|user|>" has id 2, already used by vocab token "hello"`,
+		"3 ids in [0, 15] have no token, the first ones are [1 13 14]",
+	}
+	if got := tok.ValidateVocab(); !stringSliceEqual(got, want) {
+		t.Errorf("ValidateVocab() = %q, want %q", got, want)
+	}
+}
+
+func TestSequenceNormalizer_StripAccentsSpans(t *testing.T) {
+	tokenizerJSON := []byte(`{
+		"version": "1.0",
+		"added_tokens": [],
+		"normalizer": {
+			"type": "Sequence",
+			"normalizers": [{"type": "NFD"}, {"type": "StripAccents"}, {"type": "Lowercase"}]
+		},
+		"pre_tokenizer": {"type": "BertPreTokenizer"},
+		"post_processor": null,
+		"decoder": {"type": "WordPiece", "prefix": "##"},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"continuing_subword_prefix": "##",
+			"vocab": {"[UNK]": 0, "caf": 1, "##e": 2, "ole": 3}
+		}
+	}`)
+	tok, err := NewFromContent(nil, tokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	for _, text := range []string{
+		"Caf\u00e9 Ol\u00e9",   // Precomposed "é" (2 bytes).
+		"Cafe\u0301 Ole\u0301", // Decomposed "é" (3 bytes).
+	} {
+		result := tok.EncodeWithAnnotations(text)
+		wantIDs := []int{1, 2, 3}
+		if !intSliceEqual(result.IDs, wantIDs) {
+			t.Fatalf("Encode(%q) IDs = %v, want %v", text, result.IDs, wantIDs)
+		}
+		eEnd := strings.Index(text, " ")
+		wantSpans := []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: eEnd}, {Start: eEnd + 1, End: len(text)}}
+		if !spansEqual(result.Spans, wantSpans) {
+			t.Errorf("Encode(%q) spans = %v, want %v", text, result.Spans, wantSpans)
+		}
+		wantTexts := []string{"Caf", text[3:eEnd], text[eEnd+1:]}
+		for i, span := range result.Spans {
+			if got := text[span.Start:span.End]; got != wantTexts[i] {
+				t.Errorf("Encode(%q) token #%d covers %q, want %q", text, i, got, wantTexts[i])
+			}
+		}
+	}
+}
+
+func TestSuggestAddedTokens(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	corpus := []string{
+		"Testing is testing",
+		"tested testing hello",
+		"[CLS] tested",
+		"testinged testinged testinged",
+	}
+	got := tok.SuggestAddedTokens(corpus, 10)
+	want := []string{"testinged", "tested", "testing"} // "Testing" appears only once.
+	if !stringSliceEqual(got, want) {
+		t.Errorf("SuggestAddedTokens() = %v, want %v", got, want)
+	}
+	if got := tok.SuggestAddedTokens(corpus, 1); !stringSliceEqual(got, want[:1]) {
+		t.Errorf("SuggestAddedTokens(maxNew=1) = %v, want %v", got, want[:1])
+	}
+	if got := tok.SuggestAddedTokens(corpus, 0); len(got) != 0 {
+		t.Errorf("SuggestAddedTokens(maxNew=0) = %v, want none", got)
+	}
+}
+
+// Test sentence pairs with the BertProcessing post-processor, as in the bert-base-uncased tokenizer.json.
+func TestEncodePair_BertProcessing(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true, IncludeSpecialTokensMask: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	textA, textB := "Hello world", "testing"
+	result := tok.EncodePair(textA, textB)
+	wantIDs := []int{101, 1, 2, 102, 3, 4, 102} // [CLS] hello world [SEP] test ##ing [SEP]
+	if !intSliceEqual(result.IDs, wantIDs) {
+		t.Errorf("EncodePair IDs = %v, want %v", result.IDs, wantIDs)
+	}
+	wantTypeIDs := []int{0, 0, 0, 0, 1, 1, 1}
+	if !intSliceEqual(result.TypeIDs, wantTypeIDs) {
+		t.Errorf("EncodePair TypeIDs = %v, want %v", result.TypeIDs, wantTypeIDs)
+	}
+	wantSequenceIDs := []int{-1, 0, 0, -1, 1, 1, -1}
+	if !intSliceEqual(result.SequenceIDs, wantSequenceIDs) {
+		t.Errorf("EncodePair SequenceIDs = %v, want %v", result.SequenceIDs, wantSequenceIDs)
+	}
+	wantMask := []int{1, 0, 0, 1, 0, 0, 1}
+	if !intSliceEqual(result.SpecialTokensMask, wantMask) {
+		t.Errorf("EncodePair SpecialTokensMask = %v, want %v", result.SpecialTokensMask, wantMask)
+	}
+	special := api.TokenSpan{Start: -1, End: -1}
+	wantSpans := []api.TokenSpan{special, {Start: 0, End: 5}, {Start: 6, End: 11}, special,
+		{Start: 0, End: 4}, {Start: 4, End: 7}, special}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodePair Spans = %v, want %v", result.Spans, wantSpans)
+	}
+
+	// Single sequences are not affected.
+	if got, want := tok.Encode(textA), []int{101, 1, 2, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+
+	// Without special tokens the sequences are concatenated.
+	if err := tok.With(api.EncodeOptions{}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	result = tok.EncodePair(textA, textB)
+	if want := []int{1, 2, 3, 4}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodePair without special tokens IDs = %v, want %v", result.IDs, want)
+	}
+	if want := []int{0, 0, 1, 1}; !intSliceEqual(result.TypeIDs, want) {
+		t.Errorf("EncodePair without special tokens TypeIDs = %v, want %v", result.TypeIDs, want)
+	}
+}
+
+// Test sentence pairs with BertProcessing, using an excerpt of the tokenizer.json of bert-base-uncased (in the format
+// saved by the tokenizers BertWordPieceTokenizer), with the real token ids of its vocabulary.
+func TestEncodePair_BertProcessingBertBaseUncased(t *testing.T) {
+	tokenizerJSON := []byte(`{
+  "version": "1.0",
+  "truncation": null,
+  "padding": null,
+  "added_tokens": [
+    {"id": 0, "content": "[PAD]", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+    {"id": 100, "content": "[UNK]", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+    {"id": 101, "content": "[CLS]", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+    {"id": 102, "content": "[SEP]", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true},
+    {"id": 103, "content": "[MASK]", "single_word": false, "lstrip": false, "rstrip": false, "normalized": false, "special": true}
+  ],
+  "normalizer": {
+    "type": "BertNormalizer",
+    "clean_text": true,
+    "handle_chinese_chars": true,
+    "strip_accents": null,
+    "lowercase": true
+  },
+  "pre_tokenizer": {
+    "type": "BertPreTokenizer"
+  },
+  "post_processor": {
+    "type": "BertProcessing",
+    "sep": ["[SEP]", 102],
+    "cls": ["[CLS]", 101]
+  },
+  "decoder": {
+    "type": "WordPiece",
+    "prefix": "##",
+    "cleanup": true
+  },
+  "model": {
+    "type": "WordPiece",
+    "unk_token": "[UNK]",
+    "continuing_subword_prefix": "##",
+    "max_input_chars_per_word": 100,
+    "vocab": {
+      "[PAD]": 0, "[UNK]": 100, "[CLS]": 101, "[SEP]": 102, "[MASK]": 103,
+      "!": 999, ",": 1010, "?": 1029, "you": 2017, "are": 2024, "world": 2088, "how": 2129, "hello": 7592
+    }
+  }
+}`)
+	tok, err := NewFromContent(nil, tokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpecialTokensMask: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// Reference: the tokenizers library output for bert-base-uncased.
+	if got, want := tok.Encode("Hello, World!"), []int{101, 7592, 1010, 2088, 999, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+	result := tok.EncodePair("Hello, World!", "How are you?")
+	wantIDs := []int{101, 7592, 1010, 2088, 999, 102, 2129, 2024, 2017, 1029, 102}
+	if !intSliceEqual(result.IDs, wantIDs) {
+		t.Errorf("EncodePair IDs = %v, want %v", result.IDs, wantIDs)
+	}
+	wantTypeIDs := []int{0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1}
+	if !intSliceEqual(result.TypeIDs, wantTypeIDs) {
+		t.Errorf("EncodePair TypeIDs = %v, want %v", result.TypeIDs, wantTypeIDs)
+	}
+	wantMask := []int{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	if !intSliceEqual(result.SpecialTokensMask, wantMask) {
+		t.Errorf("EncodePair SpecialTokensMask = %v, want %v", result.SpecialTokensMask, wantMask)
+	}
+}
+
+// Test sentence pairs with the RobertaProcessing post-processor: "<s> A </s> </s> B </s>", all type ids 0.
+func TestEncodePair_RobertaProcessing(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "RobertaProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	result := tok.EncodePair("hello", "world")
+	if want := []int{101, 1, 102, 102, 2, 102}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodePair IDs = %v, want %v", result.IDs, want)
+	}
+	if want := []int{0, 0, 0, 0, 0, 0}; !intSliceEqual(result.TypeIDs, want) {
+		t.Errorf("EncodePair TypeIDs = %v, want %v", result.TypeIDs, want)
+	}
+	// The type ids don't distinguish the sequences, but the sequence ids do.
+	if want := []int{-1, 0, -1, -1, 1, -1}; !intSliceEqual(result.SequenceIDs, want) {
+		t.Errorf("EncodePair SequenceIDs = %v, want %v", result.SequenceIDs, want)
+	}
+}
+
+// Test that the spans-free path used by Encode returns the same ids as the one with spans.
+func TestEncode_SameIDsWithoutSpans(t *testing.T) {
+	inputs := []string{
+		"Hello world, this is a TEST!",
+		"Café  Olé\tnaïve testing",
+		"hello<|endoftext|>world [CLS] tested",
+		"",
+		"  leading and trailing spaces  ",
+	}
+	for _, fixture := range [][]byte{testWordPieceTokenizerJSON, testBPETokenizerJSON, testSimpleBPETokenizerJSON, testUnigramTokenizerJSON} {
+		tok, err := NewFromContent(nil, fixture)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true}); err != nil {
+			t.Fatalf("With failed: %v", err)
+		}
+		for _, input := range inputs {
+			got := tok.Encode(input)
+			want := tok.EncodeWithAnnotations(input).IDs
+			if !intSliceEqual(got, want) {
+				t.Errorf("Encode(%q) = %v, but EncodeWithAnnotations() IDs = %v", input, got, want)
+			}
+		}
+	}
+}
+
+func TestEncode_SameIDsWithoutSpans_Normalizers(t *testing.T) {
+	const bertNormalizer = `"normalizer": {
+    "type": "BertNormalizer",
+    "lowercase": true
+  }`
+	if !bytes.Contains(testWordPieceTokenizerJSON, []byte(bertNormalizer)) {
+		t.Fatal("WordPiece fixture normalizer not found")
+	}
+	var normalizers []string
+	for _, lowercase := range []bool{false, true} {
+		for _, cleanText := range []bool{false, true} {
+			for _, handleChineseChars := range []bool{false, true} {
+				for _, stripAccents := range []string{"null", "false", "true"} {
+					normalizers = append(normalizers, fmt.Sprintf(
+						`{"type": "BertNormalizer", "lowercase": %v, "clean_text": %v, "handle_chinese_chars": %v, "strip_accents": %s}`,
+						lowercase, cleanText, handleChineseChars, stripAccents))
+				}
+			}
+		}
+	}
+	normalizers = append(normalizers,
+		`null`,
+		`{"type": "Lowercase"}`,
+		`{"type": "NFD"}`,
+		`{"type": "NFKC"}`,
+		`{"type": "StripAccents"}`,
+		`{"type": "Strip", "strip_left": true, "strip_right": true}`,
+		`{"type": "Replace", "pattern": {"String": "o"}, "content": "00"}`,
+		`{"type": "Sequence", "normalizers": [{"type": "NFD"}, {"type": "StripAccents"}, {"type": "Lowercase"}]}`,
+	)
+	inputs := []string{
+		"hello 你好 world",
+		"Héllo\tWÖRLD\u0000 tested\u0007",
+		"  ｈｅｌｌｏ ﬁ test  ",
+		"日本語testing",
+	}
+	for _, normalizer := range normalizers {
+		fixture := bytes.Replace(testWordPieceTokenizerJSON, []byte(bertNormalizer), []byte(`"normalizer": `+normalizer), 1)
+		tok, err := NewFromContent(nil, fixture)
+		if err != nil {
+			t.Fatalf("NewFromContent with normalizer %s failed: %v", normalizer, err)
+		}
+		for _, input := range inputs {
+			got := tok.Encode(input)
+			want := tok.EncodeWithAnnotations(input).IDs
+			if !intSliceEqual(got, want) {
+				t.Errorf("normalizer %s: Encode(%q) = %v, but EncodeWithAnnotations() IDs = %v", normalizer, input, got, want)
+			}
+			if count := tok.CountTokens(input); count != len(want) {
+				t.Errorf("normalizer %s: CountTokens(%q) = %d, want %d", normalizer, input, count, len(want))
+			}
+		}
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tokenizerJSON = strings.Replace(tokenizerJSON, `"type": "BertNormalizer"`, `"type": "FancyNormalizer"`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	var trace bytes.Buffer
+	tok.WithTrace(&trace)
+	ids := tok.Encode("hello [MASK] testing")
+	_ = tok.Decode(ids)
+	log := trace.String()
+	for _, want := range []string{
+		`normalizer "FancyNormalizer": not supported, text left unchanged`,
+		"pre-tokenizer BertPreTokenizer",
+		`model WordPiece: word "testing" -> [3 4]`,
+		`added token "[MASK]" -> 103`,
+		"post-processor BertProcessing",
+		"decoder WordPiece",
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("trace missing %q, got:\n%s", want, log)
+		}
+	}
+
+	// Disabling the trace.
+	trace.Reset()
+	tok.WithTrace(nil)
+	_ = tok.Encode("hello")
+	if trace.Len() != 0 {
+		t.Errorf("trace should be empty after WithTrace(nil), got %q", trace.String())
+	}
+}
+
+func TestTruncation_NumTruncated(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, MaxLen: 4, IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// [CLS] hello world [SEP]: the special tokens are counted, so "test ##ed" are dropped.
+	text := "Hello world tested"
+	if got, want := tok.Encode(text), []int{101, 1, 2, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{101, 1, 2, 102}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations IDs = %v, want %v", result.IDs, want)
+	}
+	if len(result.Spans) != len(result.IDs) {
+		t.Errorf("got %d spans for %d tokens", len(result.Spans), len(result.IDs))
+	}
+	if result.NumTruncated != 2 {
+		t.Errorf("NumTruncated = %d, want 2", result.NumTruncated)
+	}
+	if want := []int{3, 5}; !intSliceEqual(result.Overflowing, want) {
+		t.Errorf("Overflowing = %v, want %v", result.Overflowing, want)
+	}
+
+	// Short enough: nothing truncated.
+	result = tok.EncodeWithAnnotations("hello")
+	if result.NumTruncated != 0 || result.Overflowing != nil {
+		t.Errorf("NumTruncated = %d, Overflowing = %v, want nothing truncated", result.NumTruncated, result.Overflowing)
+	}
+
+	// Pairs are truncated from the longest sequence first: [CLS] hello [SEP] test [SEP].
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, MaxLen: 5}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	result = tok.EncodePair("hello", "tested world")
+	if want := []int{101, 1, 102, 3, 102}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodePair IDs = %v, want %v", result.IDs, want)
+	}
+	if want := []int{0, 0, 0, 1, 1}; !intSliceEqual(result.TypeIDs, want) {
+		t.Errorf("EncodePair TypeIDs = %v, want %v", result.TypeIDs, want)
+	}
+	if result.NumTruncated != 2 {
+		t.Errorf("EncodePair NumTruncated = %d, want 2", result.NumTruncated)
+	}
+	if want := []int{5, 2}; !intSliceEqual(result.Overflowing, want) {
+		t.Errorf("EncodePair Overflowing = %v, want %v", result.Overflowing, want)
+	}
+}
+
+func TestSplitPreTokenizer_Behaviors(t *testing.T) {
+	const text = "a-b--c-"
+	tests := []struct {
+		behavior  string
+		pattern   Pattern
+		invert    bool
+		wantWords []string
+		wantSpans []api.TokenSpan
+	}{
+		{
+			behavior:  "Removed",
+			pattern:   Pattern{String: "-"},
+			wantWords: []string{"a", "b", "c"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 3}, {Start: 5, End: 6}},
+		},
+		{
+			behavior:  "Isolated",
+			pattern:   Pattern{String: "-"},
+			wantWords: []string{"a", "-", "b", "-", "-", "c", "-"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 4},
+				{Start: 4, End: 5}, {Start: 5, End: 6}, {Start: 6, End: 7}},
+		},
+		{
+			behavior:  "MergedWithPrevious",
+			pattern:   Pattern{String: "-"},
+			wantWords: []string{"a-", "b-", "-", "c-"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 2}, {Start: 2, End: 4}, {Start: 4, End: 5}, {Start: 5, End: 7}},
+		},
+		{
+			behavior:  "MergedWithNext",
+			pattern:   Pattern{String: "-"},
+			wantWords: []string{"a", "-b", "-", "-c", "-"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 1, End: 3}, {Start: 3, End: 4}, {Start: 4, End: 6},
+				{Start: 6, End: 7}},
+		},
+		{
+			behavior:  "Contiguous",
+			pattern:   Pattern{String: "-"},
+			wantWords: []string{"a", "-", "b", "--", "c", "-"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 5},
+				{Start: 5, End: 6}, {Start: 6, End: 7}},
+		},
+		{
+			// Inverted: the matches are the words, and what is between them the delimiters.
+			behavior:  "Removed",
+			pattern:   Pattern{Regex: `[a-z]`},
+			invert:    true,
+			wantWords: []string{"a", "b", "c"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 3}, {Start: 5, End: 6}},
+		},
+		{
+			// Empty matches are ignored.
+			behavior:  "Isolated",
+			pattern:   Pattern{Regex: `-*`},
+			wantWords: []string{"a", "-", "b", "--", "c", "-"},
+			wantSpans: []api.TokenSpan{{Start: 0, End: 1}, {Start: 1, End: 2}, {Start: 2, End: 3}, {Start: 3, End: 5},
+				{Start: 5, End: 6}, {Start: 6, End: 7}},
+		},
+	}
+	for _, tc := range tests {
+		pattern := tc.pattern
+		pt := &PreTokenizer{Type: "Split", Pattern: &pattern, Behavior: tc.behavior, Invert: tc.invert}
+		words := splitPreTokenizeWithOffsets(text, identityOffsets(len(text)), pt)
+		var gotWords []string
+		var gotSpans []api.TokenSpan
+		for _, w := range words {
+			gotWords = append(gotWords, w.text)
+			gotSpans = append(gotSpans, api.TokenSpan{Start: w.start, End: w.end})
+		}
+		name := fmt.Sprintf("%s(%+v, invert=%v)", tc.behavior, tc.pattern, tc.invert)
+		if !stringSliceEqual(gotWords, tc.wantWords) {
+			t.Errorf("%s: words = %q, want %q", name, gotWords, tc.wantWords)
+		}
+		if !spansEqual(gotSpans, tc.wantSpans) {
+			t.Errorf("%s: spans = %v, want %v", name, gotSpans, tc.wantSpans)
+		}
+	}
+}
+
+func TestSingleTokenFastPath(t *testing.T) {
+	wordPiece, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if id, ok := wordPiece.singleTokenFastPath("hello"); !ok || id != 1 {
+		t.Errorf("singleTokenFastPath(\"hello\") = (%d, %v), want (1, true)", id, ok)
+	}
+	if id, ok := wordPiece.singleTokenFastPath("[MASK]"); !ok || id != 103 {
+		t.Errorf("singleTokenFastPath(\"[MASK]\") = (%d, %v), want (103, true)", id, ok)
+	}
+	// Upper-case needs the normalizer to lower-case it, and "##ing" would be split by the pre-tokenizer.
+	for _, text := range []string{"Hello", "##ing", "hello world", ""} {
+		if _, ok := wordPiece.singleTokenFastPath(text); ok {
+			t.Errorf("singleTokenFastPath(%q) should not apply", text)
+		}
+	}
+
+	// For all tokenizers, the fast path must give the same results as the full pipeline, which is always used
+	// when tracing.
+	for name, content := range map[string][]byte{
+		"WordPiece": testWordPieceTokenizerJSON,
+		"BPE":       testBPETokenizerJSON,
+		"SimpleBPE": testSimpleBPETokenizerJSON,
+		"Unigram":   testUnigramTokenizerJSON,
+	} {
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("%s: NewFromContent failed: %v", name, err)
+		}
+		reference, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("%s: NewFromContent failed: %v", name, err)
+		}
+		reference.WithTrace(io.Discard)
+		for _, tk := range []*Tokenizer{tok, reference} {
+			if err := tk.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true}); err != nil {
+				t.Fatalf("With failed: %v", err)
+			}
+		}
+		inputs := []string{"Hello world", "HELLO", "hello"}
+		for token := range tok.tokenizer.Model.Vocab {
+			inputs = append(inputs, token)
+		}
+		for _, at := range tok.tokenizer.AddedTokens {
+			inputs = append(inputs, at.Content)
+		}
+		for _, text := range inputs {
+			got, want := tok.EncodeWithAnnotations(text), reference.EncodeWithAnnotations(text)
+			if !intSliceEqual(got.IDs, want.IDs) || !spansEqual(got.Spans, want.Spans) {
+				t.Errorf("%s: EncodeWithAnnotations(%q) = %v %v, want %v %v", name, text, got.IDs, got.Spans, want.IDs, want.Spans)
+			}
+			if got, want := tok.Encode(text), reference.Encode(text); !intSliceEqual(got, want) {
+				t.Errorf("%s: Encode(%q) = %v, want %v", name, text, got, want)
+			}
+		}
+	}
+}
+
+// Test that TemplateProcessing special tokens without ids are resolved with the vocabulary, for single sequences
+// and pairs, and that AddSpecialTokens=false disables the post-processor.
+func TestPostProcessor_TemplateProcessingResolveTokens(t *testing.T) {
+	content := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`, `"post_processor": {
+		"type": "TemplateProcessing",
+		"single": [
+			{"SpecialToken": {"id": "[CLS]", "type_id": 0}},
+			{"Sequence": {"id": "A", "type_id": 0}},
+			{"SpecialToken": {"id": "[SEP]", "type_id": 0}}
+		],
+		"pair": [
+			{"SpecialToken": {"id": "[CLS]", "type_id": 0}},
+			{"Sequence": {"id": "A", "type_id": 0}},
+			{"SpecialToken": {"id": "[SEP]", "type_id": 0}},
+			{"Sequence": {"id": "B", "type_id": 1}},
+			{"SpecialToken": {"id": "[SEP]", "type_id": 1}}
+		],
+		"special_tokens": {
+			"[CLS]": {"id": "[CLS]", "tokens": ["[CLS]"]}
+		}
+	}`, 1)
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	if got, want := tok.Encode("hello world"), []int{101, 1, 2, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode() = %v, want %v", got, want)
+	}
+	pair := tok.EncodePair("hello", "world")
+	if want := []int{101, 1, 102, 2, 102}; !intSliceEqual(pair.IDs, want) {
+		t.Errorf("EncodePair().IDs = %v, want %v", pair.IDs, want)
+	}
+	if want := []int{0, 0, 0, 1, 1}; !intSliceEqual(pair.TypeIDs, want) {
+		t.Errorf("EncodePair().TypeIDs = %v, want %v", pair.TypeIDs, want)
+	}
+
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: false}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	if got, want := tok.Encode("hello world"), []int{1, 2}; !intSliceEqual(got, want) {
+		t.Errorf("Encode() without special tokens = %v, want %v", got, want)
+	}
+}
+
+// Test EncodeWithOptions with and without special tokens, and that it doesn't change the tokenizer options.
+func TestEncodeWithOptions_PerCall(t *testing.T) {
+	content := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	got := tok.EncodeWithOptions("Hello testing", api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true})
+	if want := []int{101, 1, 3, 4, 102}; !intSliceEqual(got.IDs, want) {
+		t.Errorf("EncodeWithOptions(AddSpecialTokens=true).IDs = %v, want %v", got.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: -1, End: -1}, {Start: 0, End: 5}, {Start: 6, End: 10}, {Start: 10, End: 13}, {Start: -1, End: -1}}
+	if !spansEqual(got.Spans, wantSpans) {
+		t.Errorf("EncodeWithOptions(AddSpecialTokens=true).Spans = %v, want %v", got.Spans, wantSpans)
+	}
+
+	got = tok.EncodeWithOptions("Hello testing", api.EncodeOptions{AddSpecialTokens: false})
+	if want := []int{1, 3, 4}; !intSliceEqual(got.IDs, want) {
+		t.Errorf("EncodeWithOptions(AddSpecialTokens=false).IDs = %v, want %v", got.IDs, want)
+	}
+	if got.Spans != nil {
+		t.Errorf("EncodeWithOptions(IncludeSpans=false).Spans = %v, want nil", got.Spans)
+	}
+
+	// The default options, used by Encode, are not affected.
+	if got, want := tok.Encode("Hello testing"), []int{101, 1, 3, 4, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode() = %v, want %v", got, want)
+	}
+}
+
+func TestTruncation_Config(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tokenizerJSON = strings.Replace(tokenizerJSON, `"truncation": null`,
+		`"truncation": {"direction": "Right", "max_length": 4, "strategy": "LongestFirst", "stride": 1}`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	// The tokenizer.json truncation is applied by default, and the stride token ("world") is also in Overflowing.
+	result := tok.EncodeWithAnnotations("Hello world tested")
+	if want := []int{101, 1, 2, 102}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("IDs = %v, want %v", result.IDs, want)
+	}
+	if result.NumTruncated != 2 {
+		t.Errorf("NumTruncated = %d, want 2", result.NumTruncated)
+	}
+	if want := []int{2, 3, 5}; !intSliceEqual(result.Overflowing, want) {
+		t.Errorf("Overflowing = %v, want %v", result.Overflowing, want)
+	}
+
+	// EncodeOptions.MaxLen takes precedence.
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, MaxLen: 3}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	if got, want := tok.Encode("Hello world tested"), []int{101, 1, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode with MaxLen=3 = %v, want %v", got, want)
+	}
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// Pair strategies: [CLS] A [SEP] B [SEP] with 2 tokens left for A and B.
+	tok.WithTruncation(5, "only_first")
+	if got, want := tok.EncodePair("hello world", "test").IDs, []int{101, 1, 102, 3, 102}; !intSliceEqual(got, want) {
+		t.Errorf("only_first: EncodePair IDs = %v, want %v", got, want)
+	}
+	tok.WithTruncation(5, "only_second")
+	if got, want := tok.EncodePair("hello", "tested world").IDs, []int{101, 1, 102, 3, 102}; !intSliceEqual(got, want) {
+		t.Errorf("only_second: EncodePair IDs = %v, want %v", got, want)
+	}
+	tok.WithTruncation(5, "longest_first")
+	if got, want := tok.EncodePair("hello world", "tested").IDs, []int{101, 1, 102, 3, 102}; !intSliceEqual(got, want) {
+		t.Errorf("longest_first: EncodePair IDs = %v, want %v", got, want)
+	}
+
+	// Left direction drops tokens from the start.
+	tok.WithTruncation(4, "")
+	tok.truncation.Direction = "Left"
+	tok.truncation.Stride = 0
+	result = tok.EncodeWithAnnotations("Hello world tested")
+	if want := []int{101, 3, 5, 102}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("Left: IDs = %v, want %v", result.IDs, want)
+	}
+	if want := []int{1, 2}; !intSliceEqual(result.Overflowing, want) {
+		t.Errorf("Left: Overflowing = %v, want %v", result.Overflowing, want)
+	}
+
+	// Disabled.
+	tok.WithTruncation(0, "")
+	if got, want := tok.Encode("Hello world tested"), []int{101, 1, 2, 3, 5, 102}; !intSliceEqual(got, want) {
+		t.Errorf("Encode without truncation = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if encodings := tok.EncodeBatch(nil); encodings != nil {
+		t.Errorf("EncodeBatch(nil) = %v, want nil", encodings)
+	}
+
+	// No padding configuration: pad on the right to the longest sequence.
+	encodings := tok.EncodeBatch([]string{"hello", "hello world tested"})
+	wantIDs := [][]int{{1, 0, 0, 0}, {1, 2, 3, 5}}
+	wantMasks := [][]int{{1, 0, 0, 0}, {1, 1, 1, 1}}
+	for i := range wantIDs {
+		if !intSliceEqual(encodings[i].IDs, wantIDs[i]) || !intSliceEqual(encodings[i].AttentionMask, wantMasks[i]) {
+			t.Errorf("EncodeBatch()[%d] = %v, %v, want %v, %v",
+				i, encodings[i].IDs, encodings[i].AttentionMask, wantIDs[i], wantMasks[i])
+		}
+	}
+
+	// The requested annotations are padded too.
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true, IncludeSpecialTokensMask: true,
+		IncludeTokens: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	encodings = tok.EncodeBatch([]string{"hello world", "world"})
+	second := encodings[1]
+	if want := []int{2, 0}; !intSliceEqual(second.IDs, want) {
+		t.Errorf("EncodeBatch()[1].IDs = %v, want %v", second.IDs, want)
+	}
+	if want := []api.TokenSpan{{Start: 0, End: 5}, {}}; !spansEqual(second.Spans, want) {
+		t.Errorf("EncodeBatch()[1].Spans = %v, want %v", second.Spans, want)
+	}
+	if want := []int{0, 1}; !intSliceEqual(second.SpecialTokensMask, want) {
+		t.Errorf("EncodeBatch()[1].SpecialTokensMask = %v, want %v", second.SpecialTokensMask, want)
+	}
+	if want := []string{"world", "[PAD]"}; !slices.Equal(second.Tokens, want) {
+		t.Errorf("EncodeBatch()[1].Tokens = %v, want %v", second.Tokens, want)
+	}
+
+	tests := []struct {
+		name, padding string
+		texts         []string
+		wantIDs       [][]int
+		wantMasks     [][]int
+	}{
+		{
+			name:      "single element, pad to multiple of",
+			padding:   `{"strategy": "BatchLongest", "direction": "Right", "pad_to_multiple_of": 4, "pad_id": 103, "pad_type_id": 0, "pad_token": "[MASK]"}`,
+			texts:     []string{"hello world"},
+			wantIDs:   [][]int{{1, 2, 103, 103}},
+			wantMasks: [][]int{{1, 1, 0, 0}},
+		},
+		{
+			name:      "longer than pad to multiple of",
+			padding:   `{"strategy": "BatchLongest", "direction": "Right", "pad_to_multiple_of": 3, "pad_id": 103, "pad_type_id": 0, "pad_token": "[MASK]"}`,
+			texts:     []string{"hello", "hello world tested"},
+			wantIDs:   [][]int{{1, 103, 103, 103, 103, 103}, {1, 2, 3, 5, 103, 103}},
+			wantMasks: [][]int{{1, 0, 0, 0, 0, 0}, {1, 1, 1, 1, 0, 0}},
+		},
+		{
+			name:      "fixed length, left",
+			padding:   `{"strategy": {"Fixed": 3}, "direction": "Left", "pad_to_multiple_of": null, "pad_id": 103, "pad_type_id": 0, "pad_token": "[MASK]"}`,
+			texts:     []string{"hello", "world"},
+			wantIDs:   [][]int{{103, 103, 1}, {103, 103, 2}},
+			wantMasks: [][]int{{0, 0, 1}, {0, 0, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := strings.Replace(string(testWordPieceTokenizerJSON), `"padding": null`, `"padding": `+tt.padding, 1)
+			tok, err := NewFromContent(nil, []byte(content))
+			if err != nil {
+				t.Fatalf("NewFromContent failed: %v", err)
+			}
+			encodings := tok.EncodeBatch(tt.texts)
+			if len(encodings) != len(tt.wantIDs) {
+				t.Fatalf("EncodeBatch() = %v, want IDs %v", encodings, tt.wantIDs)
+			}
+			for i := range tt.wantIDs {
+				if !intSliceEqual(encodings[i].IDs, tt.wantIDs[i]) || !intSliceEqual(encodings[i].AttentionMask, tt.wantMasks[i]) {
+					t.Errorf("EncodeBatch()[%d] = %v, %v, want %v, %v",
+						i, encodings[i].IDs, encodings[i].AttentionMask, tt.wantIDs[i], tt.wantMasks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeBatch_Parallel(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	words := []string{"hello", "world", "test", "testing", "tested", "unknownword"}
+	texts := make([]string, 1000)
+	for i := range texts {
+		texts[i] = strings.Join(words[:1+i%len(words)], " ")
+	}
+	serial := tok.WithMaxParallelization(1).EncodeBatch(texts)
+	for _, maxParallelization := range []int{-1, 7} {
+		encodings := tok.WithMaxParallelization(maxParallelization).EncodeBatch(texts)
+		for i := range texts {
+			if !intSliceEqual(encodings[i].IDs, serial[i].IDs) ||
+				!intSliceEqual(encodings[i].AttentionMask, serial[i].AttentionMask) {
+				t.Fatalf("EncodeBatch(MaxParallelization=%d)[%d] = %v, %v, want %v, %v", maxParallelization, i,
+					encodings[i].IDs, encodings[i].AttentionMask, serial[i].IDs, serial[i].AttentionMask)
+			}
+		}
+	}
+}
+
+func benchmarkEncodeBatch(b *testing.B, maxParallelization int) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		b.Fatalf("NewFromContent failed: %v", err)
+	}
+	tok.WithMaxParallelization(maxParallelization)
+	inputs := []string{"hello world", "this is a test", "testing tokenization"}
+	texts := make([]string, 10_000)
+	for i := range texts {
+		texts[i] = inputs[i%len(inputs)]
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = tok.EncodeBatch(texts)
+	}
+}
+
+func BenchmarkEncodeBatch_Serial(b *testing.B)   { benchmarkEncodeBatch(b, 1) }
+func BenchmarkEncodeBatch_Parallel(b *testing.B) { benchmarkEncodeBatch(b, -1) }
+
+func TestEncodeWithAnnotations_TokensAndAttentionMask(t *testing.T) {
+	tokenizerJSON := strings.Replace(string(testWordPieceTokenizerJSON), `"post_processor": null`,
+		`"post_processor": {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}`, 1)
+	tok, err := NewFromContent(nil, []byte(tokenizerJSON))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	// Not requested: not included.
+	result := tok.EncodeWithAnnotations("Hello testing")
+	if result.Tokens != nil || result.AttentionMask != nil {
+		t.Errorf("Tokens = %v, AttentionMask = %v, want nil", result.Tokens, result.AttentionMask)
+	}
+
+	options := api.EncodeOptions{AddSpecialTokens: true, IncludeTokens: true, IncludeAttentionMask: true}
+	result = tok.EncodeWithOptions("Hello testing", options)
+	if want := []string{"[CLS]", "hello", "test", "##ing", "[SEP]"}; !stringSliceEqual(result.Tokens, want) {
+		t.Errorf("Tokens = %q, want %q", result.Tokens, want)
+	}
+	if want := []int{1, 1, 1, 1, 1}; !intSliceEqual(result.AttentionMask, want) {
+		t.Errorf("AttentionMask = %v, want %v", result.AttentionMask, want)
+	}
+
+	if err := tok.With(options); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	result = tok.EncodePair("hello", "world")
+	if want := []string{"[CLS]", "hello", "[SEP]", "world", "[SEP]"}; !stringSliceEqual(result.Tokens, want) {
+		t.Errorf("EncodePair Tokens = %q, want %q", result.Tokens, want)
+	}
+	if want := []int{1, 1, 1, 1, 1}; !intSliceEqual(result.AttentionMask, want) {
+		t.Errorf("EncodePair AttentionMask = %v, want %v", result.AttentionMask, want)
+	}
+}
+
+// Test that Unigram models select the segmentation with the highest total score (Viterbi), and not the
+// greedy longest match.
+func TestUnigram_Viterbi(t *testing.T) {
+	tokenizerJSON := []byte(`{
+  "version": "1.0",
+  "truncation": null,
+  "padding": null,
+  "added_tokens": [],
+  "normalizer": null,
+  "pre_tokenizer": {"type": "WhitespaceSplit"},
+  "post_processor": null,
+  "decoder": null,
+  "model": {
+    "type": "Unigram",
+    "unk_id": 0,
+    "vocab": [
+      ["<unk>", 0.0],
+      ["world", -3.0],
+      ["hell", -2.0],
+      ["o", -6.0],
+      ["he", -3.0],
+      ["llo", -2.5],
+      ["h", -4.0],
+      ["e", -4.0],
+      ["l", -4.0]
+    ]
+  }
+}`)
+	tok, err := NewFromContent(nil, tokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	tests := []struct {
+		text      string
+		wantIDs   []int
+		wantSpans []api.TokenSpan
+	}{
+		// "he" + "llo" (-5.5) beats the greedy "hell" + "o" (-8.0).
+		{"hello", []int{4, 5}, []api.TokenSpan{{Start: 0, End: 2}, {Start: 2, End: 5}}},
+		// "hell" (-2.0) beats "he" + "l" + "l" (-11.0).
+		{"hell", []int{2}, []api.TokenSpan{{Start: 0, End: 4}}},
+		// Unknown characters are fused into one unknown token.
+		{"hexyz", []int{4, 0}, []api.TokenSpan{{Start: 0, End: 2}, {Start: 2, End: 5}}},
+		// Bytes that are not valid UTF-8 are unknown 1-byte characters.
+		{"hell\x80world", []int{2, 0, 1}, []api.TokenSpan{{Start: 0, End: 4}, {Start: 4, End: 5}, {Start: 5, End: 10}}},
+		{"\xe6\x97hell", []int{0, 2}, []api.TokenSpan{{Start: 0, End: 2}, {Start: 2, End: 6}}},
+	}
+	for _, tt := range tests {
+		got := tok.EncodeWithAnnotations(tt.text)
+		if !intSliceEqual(got.IDs, tt.wantIDs) {
+			t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", tt.text, got.IDs, tt.wantIDs)
+		}
+		if !spansEqual(got.Spans, tt.wantSpans) {
+			t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", tt.text, got.Spans, tt.wantSpans)
+		}
+		if ids := tok.Encode(tt.text); !intSliceEqual(ids, tt.wantIDs) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.text, ids, tt.wantIDs)
+		}
+	}
+	// The reference segmentation of "hello" by the test Unigram model is the single token "▁hello".
+	tok, err = NewFromContent(nil, testUnigramTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got, want := tok.Encode("hello"), []int{3}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", "hello", got, want)
+	}
+}
+
+func TestTokenScore(t *testing.T) {
+	tok, err := NewFromContent(nil, testUnigramTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if score, found := tok.TokenScore("▁hello"); !found || score != -5.5 {
+		t.Errorf("TokenScore(%q) = %v, %v, want -5.5, true", "▁hello", score, found)
+	}
+	if score, found := tok.TokenScore("<pad>"); !found || score != 0 {
+		t.Errorf("TokenScore(%q) = %v, %v, want 0, true", "<pad>", score, found)
+	}
+	if _, found := tok.TokenScore("missing"); found {
+		t.Errorf("TokenScore(%q) found, want not found", "missing")
+	}
+
+	// WordPiece vocabularies have no scores.
+	tok, err = NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if _, found := tok.TokenScore("hello"); found {
+		t.Errorf("TokenScore(%q) found for WordPiece, want not found", "hello")
+	}
+}
+
+// referenceBPE is the straightforward BPE algorithm, merging the lowest ranked (and leftmost) pair until no more
+// merges are possible. It returns the final symbols.
+func referenceBPE(mergeRanks map[string]int, word string) []string {
+	var symbols []string
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+	for len(symbols) > 1 {
+		bestRank, bestIdx := -1, -1
+		for i := 0; i < len(symbols)-1; i++ {
+			if rank, ok := mergeRanks[symbols[i]+" "+symbols[i+1]]; ok && (bestRank == -1 || rank < bestRank) {
+				bestRank, bestIdx = rank, i
+			}
+		}
+		if bestIdx == -1 {
+			break
+		}
+		symbols = slices.Concat(symbols[:bestIdx], []string{symbols[bestIdx] + symbols[bestIdx+1]}, symbols[bestIdx+2:])
+	}
+	return symbols
+}
+
+// Test that the priority queue BPE gives the same results as the reference algorithm.
+func TestBPE_MatchesReference(t *testing.T) {
+	// Merges with overlapping pairs and equal symbols, where the order of the merges matters.
+	content := []byte(`{
+  "version": "1.0",
+  "added_tokens": [],
+  "normalizer": null,
+  "pre_tokenizer": null,
+  "post_processor": null,
+  "decoder": null,
+  "model": {
+    "type": "BPE",
+    "vocab": {"a": 0, "b": 1, "c": 2, "aa": 3, "ab": 4, "bc": 5, "aab": 6, "abc": 7, "aaa": 8, "aabc": 9, "ca": 10},
+    "merges": ["a b", "a a", "b c", "aa b", "c a", "a bc", "aa a", "aab c"]
+  }
+}`)
+	words := []string{"a", "aa", "aaa", "aaaa", "aaaaa", "abc", "aabc", "abcabc", "caab", "cacaaabcbc", "bbbb", "cabaabca"}
+	rng := rand.New(rand.NewPCG(42, 0))
+	for range 200 {
+		word := make([]byte, 1+rng.IntN(30))
+		for j := range word {
+			word[j] = "abc"[rng.IntN(3)]
+		}
+		words = append(words, string(word))
+	}
+	for _, fixture := range [][]byte{content, testBPETokenizerJSON, testSimpleBPETokenizerJSON} {
+		tok, err := NewFromContent(nil, fixture)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		for _, word := range append(words, "hello", "world", "helloworld", "hellohello") {
+			// Symbols not in the vocabulary are dropped if there is no unknown token.
+			want := slices.DeleteFunc(referenceBPE(tok.mergeRanks, word), func(symbol string) bool {
+				_, found := tok.tokenizer.Model.Vocab[symbol]
+				return !found && tok.unkID < 0
+			})
+			ids, spans := tok.bpeTokenizeWithSpans(wordWithOffset{text: word, start: 0, end: len(word)}, true)
+			got := make([]string, len(spans))
+			for i, span := range spans {
+				got[i] = word[span.Start:span.End]
+			}
+			if !stringSliceEqual(got, want) {
+				t.Errorf("BPE(%q) = %q, want %q", word, got, want)
+			}
+			if len(ids) != len(spans) {
+				t.Errorf("BPE(%q): %d ids for %d spans", word, len(ids), len(spans))
+			}
+		}
+	}
+}
+
+func BenchmarkBPE_LongWord(b *testing.B) {
+	tok, err := NewFromContent(nil, testSimpleBPETokenizerJSON)
+	if err != nil {
+		b.Fatalf("NewFromContent failed: %v", err)
+	}
+	word := strings.Repeat("helloworld", 200) // 2000 characters.
+	b.Run("PriorityQueue", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = tok.bpeTokenizeWithSpans(wordWithOffset{text: word, start: 0, end: len(word)}, true)
+		}
+	})
+	b.Run("Reference", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = referenceBPE(tok.mergeRanks, word)
+		}
+	})
+}
+
+// Test that with "ignore_merges" words in the vocabulary are not split by the merges.
+func TestBPE_IgnoreMerges(t *testing.T) {
+	// "hello" is in the vocabulary, but the merges can't reach it: they produce "hel" "lo".
+	content := `{
+  "version": "1.0",
+  "added_tokens": [],
+  "normalizer": null,
+  "pre_tokenizer": {"type": "WhitespaceSplit"},
+  "post_processor": null,
+  "decoder": null,
+  "model": {
+    "type": "BPE",
+    "ignore_merges": true,
+    "vocab": {"h": 0, "e": 1, "l": 2, "o": 3, "he": 4, "hel": 5, "lo": 6, "hello": 7},
+    "merges": ["h e", "he l", "l o"]
+  }
+}`
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got, want := tok.Encode("hello hellol"), []int{7, 5, 6, 2}; !intSliceEqual(got, want) {
+		t.Errorf("Encode with ignore_merges = %v, want %v", got, want)
+	}
+
+	tok, err = NewFromContent(nil, []byte(strings.Replace(content, `"ignore_merges": true`, `"ignore_merges": false`, 1)))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got, want := tok.Encode("hello hellol"), []int{5, 6, 5, 6, 2}; !intSliceEqual(got, want) {
+		t.Errorf("Encode without ignore_merges = %v, want %v", got, want)
+	}
+}
+
+// Test that with "byte_fallback" characters not in the vocabulary are encoded as byte tokens, and decoded back.
+func TestByteFallback(t *testing.T) {
+	vocab := []string{`"<unk>": 0`, `"h": 1`, `"i": 2`, `"hi": 3`}
+	for b := range 256 {
+		vocab = append(vocab, fmt.Sprintf(`"<0x%02X>": %d`, b, 4+b))
+	}
+	content := `{
+  "version": "1.0",
+  "added_tokens": [],
+  "normalizer": null,
+  "pre_tokenizer": null,
+  "post_processor": null,
+  "decoder": {"type": "Sequence", "decoders": [{"type": "ByteFallback"}]},
+  "model": {
+    "type": "BPE",
+    "unk_token": "<unk>",
+    "byte_fallback": true,
+    "vocab": {` + strings.Join(vocab, ", ") + `},
+    "merges": ["h i"]
+  }
+}`
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	text := "hi😀"
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{3, 4 + 0xF0, 4 + 0x9F, 4 + 0x98, 4 + 0x80}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	emoji := api.TokenSpan{Start: 2, End: 6}
+	if want := []api.TokenSpan{{Start: 0, End: 2}, emoji, emoji, emoji, emoji}; !spansEqual(result.Spans, want) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, want)
+	}
+	if got := tok.Decode(result.IDs); got != text {
+		t.Errorf("Decode() = %q, want %q", got, text)
+	}
+
+	// Without byte_fallback, the unknown token is used.
+	tok, err = NewFromContent(nil, []byte(strings.Replace(content, `"byte_fallback": true`, `"byte_fallback": false`, 1)))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got, want := tok.Encode(text), []int{3, 0}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) without byte_fallback = %v, want %v", text, got, want)
+	}
+}
+
+// Test that with "fuse_unk" consecutive unknown symbols of a word are fused into one unknown token.
+func TestBPE_FuseUnk(t *testing.T) {
+	content := `{
+  "version": "1.0",
+  "added_tokens": [],
+  "normalizer": null,
+  "pre_tokenizer": {"type": "WhitespaceSplit"},
+  "post_processor": null,
+  "decoder": null,
+  "model": {
+    "type": "BPE",
+    "unk_token": "<unk>",
+    "fuse_unk": true,
+    "vocab": {"<unk>": 0, "a": 1},
+    "merges": []
+  }
+}`
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	// Runs of unknown characters are fused within each word, but not across words.
+	text := "xyzaxy éé"
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{0, 1, 0, 0}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 4}, {Start: 4, End: 6}, {Start: 7, End: 11}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+	if got, want := tok.Encode(text), []int{0, 1, 0, 0}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+	}
+
+	tok, err = NewFromContent(nil, []byte(strings.Replace(content, `"fuse_unk": true`, `"fuse_unk": false`, 1)))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if got, want := tok.Encode(text), []int{0, 0, 0, 1, 0, 0, 0, 0}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) without fuse_unk = %v, want %v", text, got, want)
+	}
+}
+
+func TestReplaceNormalizer(t *testing.T) {
+	content := []byte(`{
+		"version": "1.0",
+		"normalizer": {
+			"type": "Sequence",
+			"normalizers": [
+				{"type": "Replace", "pattern": {"Regex": " {2,}"}, "content": " "},
+				{"type": "Replace", "pattern": {"String": "colour"}, "content": "color"},
+				{"type": "Replace", "pattern": {"String": " "}, "content": "▁"}
+			]
+		},
+		"pre_tokenizer": {"type": "Split", "pattern": {"String": "▁"}, "behavior": "Removed", "invert": false},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"vocab": {"[UNK]": 0, "hello": 1, "color": 2, "world": 3}
+		}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	text := "hello   colour  world"
+	if got, want := tok.Normalize(text), "hello▁color▁world"; got != want {
+		t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+	}
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{1, 2, 3}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 5}, {Start: 8, End: 14}, {Start: 16, End: 21}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+	if got, want := tok.Encode(text), []int{1, 2, 3}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+	}
+}
+
+func TestStripNormalizer(t *testing.T) {
+	content := []byte(`{
+		"version": "1.0",
+		"normalizer": {
+			"type": "Sequence",
+			"normalizers": [
+				{"type": "Strip", "strip_left": true, "strip_right": true},
+				{"type": "Lowercase"}
+			]
+		},
+		"pre_tokenizer": {"type": "Split", "pattern": {"String": "-"}, "behavior": "Isolated", "invert": false},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"vocab": {"[UNK]": 0, "hello": 1, "-": 2, "world": 3, " ": 4}
+		}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	text := " \tHello-World \n"
+	if got, want := tok.Normalize(text), "hello-world"; got != want {
+		t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+	}
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{1, 2, 3}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	// The stripped prefix shifts the offsets, and the stripped suffix is included in the last token, as the
+	// characters removed by normalizers (see alignSpan).
+	wantSpans := []api.TokenSpan{{Start: 2, End: 7}, {Start: 7, End: 8}, {Start: 8, End: 15}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+
+	// Only the right side.
+	tok.tokenizer.Normalizer = &Normalizer{Type: "Strip", StripRight: true}
+	if got, want := tok.Normalize(" a b "), " a b"; got != want {
+		t.Errorf("Normalize with strip_right only = %q, want %q", got, want)
+	}
+}
+
+// buildPrecompiledCharsmap builds a base64 encoded "precompiled_charsmap" for the given rules, with a minimal
+// Darts-clone compatible double-array trie builder.
+func buildPrecompiledCharsmap(rules map[string]string) string {
+	// Pool of null-terminated replacements.
+	keys := slices.Sorted(maps.Keys(rules))
+	var pool []byte
+	values := make(map[string]uint32, len(keys))
+	for _, key := range keys {
+		values[key] = uint32(len(pool))
+		pool = append(pool, rules[key]...)
+		pool = append(pool, 0)
+	}
+
+	// Double-array: each node at position pos has its children (and its value, for label 0) at
+	// pos ^ offset ^ label.
+	units := map[uint32]uint32{0: 0}
+	var build func(pos uint32, prefix string)
+	build = func(pos uint32, prefix string) {
+		labels := map[byte]bool{}
+		_, isLeaf := values[prefix]
+		for _, key := range keys {
+			if len(key) > len(prefix) && strings.HasPrefix(key, prefix) {
+				labels[key[len(prefix)]] = true
+			}
+		}
+		if len(labels) == 0 && !isLeaf {
+			return
+		}
+		offset := uint32(1)
+		for ; ; offset++ {
+			free := true
+			if _, used := units[pos^offset]; used && isLeaf {
+				free = false
+			}
+			for label := range labels {
+				if _, used := units[pos^offset^uint32(label)]; used {
+					free = false
+				}
+			}
+			if free {
+				break
+			}
+		}
+		units[pos] |= offset << 10
+		if isLeaf {
+			units[pos] |= 1 << 8
+			units[pos^offset] = values[prefix] | 1<<31
+		}
+		for label := range labels {
+			units[pos^offset^uint32(label)] = uint32(label)
+		}
+		for _, label := range slices.Sorted(maps.Keys(labels)) {
+			build(pos^offset^uint32(label), prefix+string([]byte{label}))
+		}
+	}
+	build(0, "")
+
+	size := slices.Max(slices.Collect(maps.Keys(units))) + 1
+	blob := binary.LittleEndian.AppendUint32(nil, size*4)
+	for pos := range size {
+		blob = binary.LittleEndian.AppendUint32(blob, units[pos])
+	}
+	blob = append(blob, pool...)
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+func TestPrecompiledCharsmap(t *testing.T) {
+	charsmap := buildPrecompiledCharsmap(map[string]string{
+		"ﬁ":       "fi", // Ligature, as NFKC.
+		"１":       "1",  // Fullwidth digit, as NFKC.
+		"​":       "",   // Zero-width space is removed: not done by NFKC.
+		"　":       " ",  // Ideographic space.
+		"ab":      "X",  // Never matched: "a" and "b" are separate grapheme clusters.
+		"a":       "A",
+		"e\u0301": "\u00e9", // Decomposed "e" with an acute accent: one grapheme cluster.
+	})
+	content := []byte(`{
+		"version": "1.0",
+		"normalizer": {"type": "Precompiled", "precompiled_charsmap": "` + charsmap + `"},
+		"pre_tokenizer": {"type": "WhitespaceSplit"},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"vocab": {"[UNK]": 0, "fi": 1, "1": 2, "hello": 3, "X": 4, "A": 5, "Ac": 6, "Ab": 7}
+		}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	text := "ﬁ　hel​lo ab ac １"
+	if got, want := tok.Normalize(text), "fi hello Ab Ac 1"; got != want {
+		t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+	}
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{1, 3, 7, 6, 2}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 3}, {Start: 6, End: 14}, {Start: 15, End: 17}, {Start: 18, End: 20}, {Start: 21, End: 24}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+
+	// Grapheme clusters shorter than 6 bytes are replaced as a whole by their shortest matching key, as in
+	// HuggingFace; longer ones are replaced character by character.
+	m, err := parsePrecompiledCharsmap(charsmap)
+	if err != nil {
+		t.Fatalf("parsePrecompiledCharsmap failed: %v", err)
+	}
+	for _, tc := range []struct{ text, want string }{
+		{"cafe\u0301", "cAf\u00e9"},
+		{"\ufb01\u0301", "fi"},
+		{"\ufb01\u0301\u0301", "fi\u0301\u0301"},
+		{"a\xffb", "A\ufffdb"},
+	} {
+		if got, _ := m.normalize(tc.text, false); got != tc.want {
+			t.Errorf("normalize(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+
+	// Invalid charsmaps are reported.
+	_, err = NewFromContent(nil, []byte(`{"normalizer": {"type": "Precompiled", "precompiled_charsmap": "AAAA"}, "model": {"type": "WordPiece", "vocab": {}}}`))
+	if err == nil {
+		t.Errorf("NewFromContent with an invalid precompiled_charsmap should fail")
+	}
+}
+
+func TestDecoder_StripAndFuse(t *testing.T) {
+	tests := []struct {
+		name     string
+		decoders string
+		want     string
+	}{
+		{
+			name: "Replace-Fuse-Strip",
+			decoders: `{"type": "Replace", "pattern": {"String": "▁"}, "content": " "},
+				{"type": "Fuse"},
+				{"type": "Strip", "content": " ", "start": 1, "stop": 1}`,
+			want: "hello  world!", // Only the edges of the fused text are stripped.
+		},
+		{
+			name: "Replace-Strip-Fuse",
+			decoders: `{"type": "Replace", "pattern": {"String": "▁"}, "content": " "},
+				{"type": "Strip", "content": " ", "start": 1, "stop": 1},
+				{"type": "Fuse"}`,
+			want: "hello world!",
+		},
+		{
+			name: "Strip at most start/stop",
+			decoders: `{"type": "Replace", "pattern": {"String": "▁"}, "content": " "},
+				{"type": "Strip", "content": " ", "start": 1, "stop": 0}`,
+			want: "hello world! ",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content := []byte(`{
+				"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "▁hello": 1, "▁▁world": 2, "!▁": 3}},
+				"decoder": {"type": "Sequence", "decoders": [` + tc.decoders + `]}
+			}`)
+			tok, err := NewFromContent(nil, content)
+			if err != nil {
+				t.Fatalf("NewFromContent failed: %v", err)
+			}
+			if got := tok.Decode([]int{1, 2, 3}); got != tc.want {
+				t.Errorf("Decode() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitPreTokenizer_Lookahead(t *testing.T) {
+	const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+	content := []byte(`{
+		"pre_tokenizer": {"type": "Sequence", "pretokenizers": [
+			{"type": "Split", "pattern": {"Regex": ` + strconv.Quote(gpt2Pattern) + `}, "behavior": "Isolated", "invert": false}
+		]},
+		"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0}}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	text := "Hello world's  123 test\n\nfoo"
+	words := tok.preTokenizeWithSpans(text, identityOffsets(len(text)), inputStart)
+	var gotWords []string
+	var gotSpans []api.TokenSpan
+	for _, w := range words {
+		gotWords = append(gotWords, w.text)
+		gotSpans = append(gotSpans, api.TokenSpan{Start: w.start, End: w.end})
+	}
+	wantWords := []string{"Hello", " world", "'s", " ", " 123", " test", "\n", "\n", "foo"}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 5}, {Start: 5, End: 11}, {Start: 11, End: 13}, {Start: 13, End: 14},
+		{Start: 14, End: 18}, {Start: 18, End: 23}, {Start: 23, End: 24}, {Start: 24, End: 25}, {Start: 25, End: 28}}
+	if !stringSliceEqual(gotWords, wantWords) {
+		t.Errorf("words = %q, want %q", gotWords, wantWords)
+	}
+	if !spansEqual(gotSpans, wantSpans) {
+		t.Errorf("spans = %v, want %v", gotSpans, wantSpans)
+	}
+
+	// Unsupported patterns are reported when loading the tokenizer.
+	_, err = NewFromContent(nil, []byte(`{
+		"pre_tokenizer": {"type": "Split", "pattern": {"Regex": "(?<=a)b"}, "behavior": "Isolated"},
+		"model": {"type": "WordPiece", "vocab": {}}
+	}`))
+	if err == nil {
+		t.Errorf("NewFromContent with an unsupported Split pattern should fail")
+	}
+}
+
+func TestAddedTokens_LstripRstrip(t *testing.T) {
+	tests := []struct {
+		lstrip, rstrip bool
+		wantSpans      []api.TokenSpan
+	}{
+		{false, false, []api.TokenSpan{{Start: 0, End: 3}, {Start: 4, End: 10}, {Start: 11, End: 14}}},
+		{true, false, []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 10}, {Start: 11, End: 14}}},
+		{false, true, []api.TokenSpan{{Start: 0, End: 3}, {Start: 4, End: 11}, {Start: 11, End: 14}}},
+		{true, true, []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 11}, {Start: 11, End: 14}}},
+	}
+	for _, tc := range tests {
+		content := []byte(fmt.Sprintf(`{
+			"added_tokens": [{"id": 3, "content": "<mask>", "lstrip": %v, "rstrip": %v, "special": true}],
+			"pre_tokenizer": {"type": "WhitespaceSplit"},
+			"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "the": 1, "sat": 2, "<mask>": 3}}
+		}`, tc.lstrip, tc.rstrip))
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+			t.Fatalf("With failed: %v", err)
+		}
+		text := "the <mask> sat"
+		result := tok.EncodeWithAnnotations(text)
+		name := fmt.Sprintf("lstrip=%v, rstrip=%v", tc.lstrip, tc.rstrip)
+		if want := []int{1, 3, 2}; !intSliceEqual(result.IDs, want) {
+			t.Errorf("%s: EncodeWithAnnotations(%q).IDs = %v, want %v", name, text, result.IDs, want)
+		}
+		if !spansEqual(result.Spans, tc.wantSpans) {
+			t.Errorf("%s: EncodeWithAnnotations(%q).Spans = %v, want %v", name, text, result.Spans, tc.wantSpans)
+		}
+		if got := tok.Encode(text); !intSliceEqual(got, result.IDs) {
+			t.Errorf("%s: Encode(%q) = %v, want %v", name, text, got, result.IDs)
+		}
+	}
+}
+
+func TestAddedTokens_SingleWord(t *testing.T) {
+	for _, singleWord := range []bool{false, true} {
+		content := []byte(fmt.Sprintf(`{
+			"added_tokens": [{"id": 1, "content": "cat", "single_word": %v}],
+			"pre_tokenizer": {"type": "WhitespaceSplit"},
+			"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "cat": 1, "con": 2, "enate": 3}}
+		}`, singleWord))
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		text := "concatenate cat, (cat)"
+		// Embedded in "concatenate" the single word "cat" doesn't match, and the WordPiece model can't tokenize it.
+		want := []int{2, 1, 3, 1, 0, 0, 1, 0}
+		if singleWord {
+			want = []int{0, 1, 0, 0, 1, 0}
+		}
+		if got := tok.Encode(text); !intSliceEqual(got, want) {
+			t.Errorf("single_word=%v: Encode(%q) = %v, want %v", singleWord, text, got, want)
+		}
+	}
+}
+
+func TestAddedTokens_Trie(t *testing.T) {
+	content := []byte(`{
+		"added_tokens": [
+			{"id": 1, "content": "<|im_start|>"},
+			{"id": 2, "content": "<|im_start|>user"},
+			{"id": 3, "content": "<|end of turn|>"},
+			{"id": 4, "content": "<|"}
+		],
+		"pre_tokenizer": {"type": "WhitespaceSplit"},
+		"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "hi": 5, "assistant": 6}}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	// The longest added token is matched at each position, and added tokens may contain spaces.
+	text := "<|im_start|>user hi<|end of turn|><|im_start|>assistant <|"
+	result := tok.EncodeWithAnnotations(text)
+	if want := []int{2, 5, 3, 1, 6, 4}; !intSliceEqual(result.IDs, want) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 16}, {Start: 17, End: 19}, {Start: 19, End: 34}, {Start: 34, End: 46},
+		{Start: 46, End: 55}, {Start: 56, End: 58}}
+	if !spansEqual(result.Spans, wantSpans) {
+		t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+	}
+}
+
+func BenchmarkSplitOnAddedTokens(b *testing.B) {
+	// Instruct models often have hundreds of added tokens.
+	var addedTokens []string
+	for i := range 500 {
+		addedTokens = append(addedTokens, fmt.Sprintf(`{"id": %d, "content": "<|reserved_special_token_%d|>"}`, i+1, i))
+	}
+	content := []byte(`{
+		"added_tokens": [` + strings.Join(addedTokens, ",") + `],
+		"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0}}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		b.Fatalf("NewFromContent failed: %v", err)
+	}
+	text := strings.Repeat("The quick brown fox <|reserved_special_token_42|> jumps over the lazy dog. ", 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tok.splitOnAddedTokens(text)
+	}
+}
+
+func TestMetaspace_PrependScheme(t *testing.T) {
+	tests := []struct {
+		prependScheme string
+		want          []int
+		wantDecoded   string
+	}{
+		{"always", []int{1, 4, 1, 3}, "hello<s> hello world"},
+		{"first", []int{1, 4, 2, 3}, "hello<s>hello world"}, // Only the text at the start of the input gets the prefix.
+		{"never", []int{2, 4, 2, 3}, "hello<s>hello world"},
+	}
+	for _, tc := range tests {
+		// The decoder takes the custom replacement character from the pre-tokenizer.
+		content := []byte(`{
+			"added_tokens": [{"id": 4, "content": "<s>"}],
+			"pre_tokenizer": {"type": "Metaspace", "replacement": "_", "prepend_scheme": "` + tc.prependScheme + `", "split": true},
+			"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "_hello": 1, "hello": 2, "_world": 3}},
+			"decoder": {"type": "Metaspace", "prepend_scheme": "` + tc.prependScheme + `"}
+		}`)
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		text := "hello<s>hello world"
+		got := tok.Encode(text)
+		if !intSliceEqual(got, tc.want) {
+			t.Errorf("prepend_scheme=%q: Encode(%q) = %v, want %v", tc.prependScheme, text, got, tc.want)
+		}
+		if decoded := tok.Decode(got); decoded != tc.wantDecoded {
+			t.Errorf("prepend_scheme=%q: Decode(%v) = %q, want %q", tc.prependScheme, got, decoded, tc.wantDecoded)
+		}
+	}
+}
+
+func TestByteLevel_TrimOffsets(t *testing.T) {
+	tests := []struct {
+		trimOffsets bool
+		wantSpans   []api.TokenSpan
+	}{
+		{false, []api.TokenSpan{{Start: 0, End: 5}, {Start: 5, End: 11}, {Start: 11, End: 12}, {Start: 12, End: 13},
+			{Start: 13, End: 14}, {Start: 14, End: 15}}},
+		{true, []api.TokenSpan{{Start: 0, End: 5}, {Start: 6, End: 11}, {Start: 12, End: 12}, {Start: 12, End: 13},
+			{Start: 13, End: 14}, {Start: 14, End: 15}}},
+	}
+	for _, tc := range tests {
+		content := []byte(fmt.Sprintf(`{
+			"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "trim_offsets": %v},
+			"model": {
+				"type": "BPE", "ignore_merges": true, "merges": [],
+				"vocab": {"hello": 1, "Ġworld": 2, "Ġ": 3, "w": 4, "Ã": 5, "©": 6}
+			},
+			"decoder": {"type": "ByteLevel"}
+		}`, tc.trimOffsets))
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+			t.Fatalf("With failed: %v", err)
+		}
+		// "é" is 2 bytes, each one a token: the spans are in bytes of the original text.
+		text := "hello world wé"
+		result := tok.EncodeWithAnnotations(text)
+		if want := []int{1, 2, 3, 4, 5, 6}; !intSliceEqual(result.IDs, want) {
+			t.Errorf("trim_offsets=%v: EncodeWithAnnotations(%q).IDs = %v, want %v", tc.trimOffsets, text, result.IDs, want)
+		}
+		if !spansEqual(result.Spans, tc.wantSpans) {
+			t.Errorf("trim_offsets=%v: EncodeWithAnnotations(%q).Spans = %v, want %v", tc.trimOffsets, text, result.Spans, tc.wantSpans)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content []byte
+	}{
+		{"WordPiece", testWordPieceTokenizerJSON},
+		{"BPE", testBPETokenizerJSON},
+		{"ArrayMergesBPE", testArrayMergesBPETokenizerJSON},
+		{"Unigram", testUnigramTokenizerJSON},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tok, err := NewFromContent(nil, tc.content)
+			if err != nil {
+				t.Fatalf("NewFromContent failed: %v", err)
+			}
+			filePath := filepath.Join(t.TempDir(), "tokenizer.json")
+			if err := tok.Save(filePath); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			loaded, err := NewFromFile(nil, filePath)
+			if err != nil {
+				t.Fatalf("NewFromFile of the saved tokenizer failed: %v", err)
+			}
+			if !maps.Equal(loaded.GetVocab(), tok.GetVocab()) {
+				t.Errorf("vocabulary changed after Save: %v, want %v", loaded.GetVocab(), tok.GetVocab())
+			}
+			if !slices.Equal(loaded.tokenizer.Model.Merges, tok.tokenizer.Model.Merges) {
+				t.Errorf("merges changed after Save: %q, want %q", loaded.tokenizer.Model.Merges, tok.tokenizer.Model.Merges)
+			}
+			if !slices.Equal(loaded.AddedTokensList(), tok.AddedTokensList()) {
+				t.Errorf("added tokens changed after Save: %v, want %v", loaded.AddedTokensList(), tok.AddedTokensList())
+			}
+			for token := range tok.GetVocab() {
+				want, _ := tok.TokenScore(token)
+				if got, _ := loaded.TokenScore(token); got != want {
+					t.Errorf("TokenScore(%q) = %g after Save, want %g", token, got, want)
+				}
+			}
+			for _, text := range []string{"hello world", "Hello, testing worlds!", "<unk> [CLS] héllo"} {
+				if got, want := loaded.Encode(text), tok.Encode(text); !intSliceEqual(got, want) {
+					t.Errorf("Encode(%q) = %v after Save, want %v", text, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddTokens(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	vocabSize := tok.VocabSize()
+	next := tok.MaxTokenID() + 1
+	ids := tok.AddTokens([]AddedToken{
+		{Content: "<patient>", Special: true},
+		{Content: "<drug>", Special: true},
+		{Content: "[SEP]", Special: true}, // Already an added token.
+		{Content: "hello"},                // Already in the vocabulary.
+	})
+	if want := []int{next, next + 1, 102, 1}; !intSliceEqual(ids, want) {
+		t.Fatalf("AddTokens() = %v, want %v", ids, want)
+	}
+	if got, want := tok.VocabSize(), vocabSize+3; got != want {
+		t.Errorf("VocabSize() = %d, want %d", got, want)
+	}
+	if got := tok.GetVocab()["<drug>"]; got != next+1 {
+		t.Errorf("GetVocab()[\"<drug>\"] = %d, want %d", got, next+1)
+	}
+	if token, _ := tok.IDToToken(next); token != "<patient>" {
+		t.Errorf("IDToToken(%d) = %q, want \"<patient>\"", next, token)
+	}
+	text := "<patient>hello<drug>"
+	if got, want := tok.Encode(text), []int{next, 1, next + 1}; !intSliceEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+	}
+
+	// Special tokens are re-resolved.
+	tok, err = NewFromContent(nil, testSimpleBPETokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if _, err := tok.SpecialTokenID(api.TokMask); err == nil {
+		t.Fatalf("SpecialTokenID(TokMask) should fail before adding the mask token")
+	}
+	maskID := tok.AddTokens([]AddedToken{{Content: "<mask>", Special: true}})[0]
+	if id, err := tok.SpecialTokenID(api.TokMask); err != nil || id != maskID {
+		t.Errorf("SpecialTokenID(TokMask) = (%d, %v), want (%d, nil)", id, err, maskID)
+	}
+}
+
+func TestDecodeWithOptions_SkipSpecial(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	// [CLS] hello [SEP] test ##ing [SEP]
+	ids := []int{101, 1, 102, 3, 4, 102}
+	if got, want := tok.DecodeWithOptions(ids, false), "[CLS] hello [SEP] testing [SEP]"; got != want {
+		t.Errorf("DecodeWithOptions(%v, false) = %q, want %q", ids, got, want)
+	}
+	if got, want := tok.Decode(ids), tok.DecodeWithOptions(ids, false); got != want {
+		t.Errorf("Decode(%v) = %q, want %q", ids, got, want)
+	}
+	// The [SEP] in the middle doesn't leave a stray space.
+	if got, want := tok.DecodeWithOptions(ids, true), "hello testing"; got != want {
+		t.Errorf("DecodeWithOptions(%v, true) = %q, want %q", ids, got, want)
+	}
+}
+
+func TestDecode_CleanUpTokenizationSpaces(t *testing.T) {
+	newTokenizer := func(decoder string) *Tokenizer {
+		tok, err := NewFromContent(nil, []byte(`{
+			"model": {"type": "WordPiece", "unk_token": "[UNK]",
+				"vocab": {"[UNK]": 0, "hello": 1, ",": 2, "world": 3, "!": 4, "it": 5, "'s": 6}},
+			"decoder": `+decoder+`
+		}`))
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		return tok
+	}
+	ids := []int{1, 2, 3, 4, 5, 6}
+	tok := newTokenizer(`{"type": "WordPiece", "prefix": "##"}`)
+	if got, want := tok.Decode(ids), "hello, world! it's"; got != want {
+		t.Errorf("Decode(%v) = %q, want %q", ids, got, want)
+	}
+	tok.WithCleanUpTokenizationSpaces(false)
+	if got, want := tok.Decode(ids), "hello , world ! it 's"; got != want {
+		t.Errorf("Decode(%v) without cleanup = %q, want %q", ids, got, want)
+	}
+	tok = newTokenizer(`{"type": "WordPiece", "prefix": "##", "cleanup": false}`)
+	if got, want := tok.Decode(ids), "hello , world ! it 's"; got != want {
+		t.Errorf("Decode(%v) with \"cleanup\": false = %q, want %q", ids, got, want)
+	}
+	tok.WithCleanUpTokenizationSpaces(true)
+	if got, want := tok.Decode(ids), "hello, world! it's"; got != want {
+		t.Errorf("Decode(%v) with cleanup = %q, want %q", ids, got, want)
+	}
+}
+
+func TestNormalizeWithOffsets(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	text := "Héllo Wörld"
+	normalized, offsets := tok.NormalizeWithOffsets(text)
+	if want := tok.Normalize(text); normalized != want {
+		t.Errorf("NormalizeWithOffsets(%q) = %q, want %q", text, normalized, want)
+	}
+	// "é" and "ö" (2 bytes each) become "e" and "o".
+	if want := []int{0, 1, 3, 4, 5, 6, 7, 8, 10, 11, 12}; !intSliceEqual(offsets, want) {
+		t.Errorf("NormalizeWithOffsets(%q) offsets = %v, want %v", text, offsets, want)
+	}
+}
+
+func TestOffsetsApproximate(t *testing.T) {
+	tests := []struct {
+		normalizer string
+		want       bool
+	}{
+		{`null`, false},
+		{`{"type": "Lowercase"}`, false},
+		{`{"type": "NFC"}`, false},
+		{`{"type": "Precompiled"}`, true}, // Without a charsmap, approximated with NFKC.
+		{`{"type": "Sequence", "normalizers": [{"type": "NFD"}, {"type": "Precompiled"}, {"type": "Lowercase"}]}`, true},
+	}
+	for _, tc := range tests {
+		tok, err := NewFromContent(nil, []byte(`{
+			"normalizer": `+tc.normalizer+`,
+			"pre_tokenizer": {"type": "WhitespaceSplit"},
+			"model": {"type": "WordPiece", "unk_token": "[UNK]", "vocab": {"[UNK]": 0, "hello": 1}}
+		}`))
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		text := "hello world"
+		result := tok.EncodeWithOptions(text, api.EncodeOptions{IncludeSpans: true})
+		if result.OffsetsApproximate != tc.want {
+			t.Errorf("normalizer %s: EncodeWithOptions(%q).OffsetsApproximate = %v, want %v",
+				tc.normalizer, text, result.OffsetsApproximate, tc.want)
+		}
+		if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+			t.Fatalf("With failed: %v", err)
+		}
+		if pair := tok.EncodePair(text, text); pair.OffsetsApproximate != tc.want {
+			t.Errorf("normalizer %s: EncodePair().OffsetsApproximate = %v, want %v",
+				tc.normalizer, pair.OffsetsApproximate, tc.want)
+		}
+	}
+}
+
+func TestUnicodeNormalizers_ExactSpans(t *testing.T) {
+	cafeNFC := "caf\u00e9"  // café with precomposed é
+	cafeNFD := "cafe\u0301" // café with e + combining acute accent
+	fiLigature := "\ufb01"  // ﬁ ligature
+	tests := []struct {
+		normalizer string
+		text       string
+		wantIDs    []int
+		wantSpans  []api.TokenSpan
+	}{
+		// Composition: "e" + U+0301 -> "é", the token covers both characters.
+		{"NFC", "x " + cafeNFD + " x", []int{1, 2, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 8}, {Start: 9, End: 10}}},
+		{"NFKC", "x " + cafeNFD + " x", []int{1, 2, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 8}, {Start: 9, End: 10}}},
+		// Decomposition: "é" -> "e" + U+0301.
+		{"NFD", "x " + cafeNFC + " x", []int{1, 3, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 7}, {Start: 8, End: 9}}},
+		{"NFKD", "x " + cafeNFC + " x", []int{1, 3, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 7}, {Start: 8, End: 9}}},
+		// Compatibility: "ﬁ" (3 bytes) -> "fi", "ﬁx" is tokenized as "fi" + "##x".
+		{"NFKC", "x " + fiLigature + "x x", []int{1, 4, 5, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 5},
+			{Start: 5, End: 6}, {Start: 7, End: 8}}},
+		{"NFKD", "x " + fiLigature + "x x", []int{1, 4, 5, 1}, []api.TokenSpan{{Start: 0, End: 1}, {Start: 2, End: 5},
+			{Start: 5, End: 6}, {Start: 7, End: 8}}},
+	}
+	for _, tc := range tests {
+		tok, err := NewFromContent(nil, []byte(`{
+			"normalizer": {"type": "`+tc.normalizer+`"},
+			"pre_tokenizer": {"type": "WhitespaceSplit"},
+			"model": {"type": "WordPiece", "unk_token": "[UNK]",
+				"vocab": {"[UNK]": 0, "x": 1, "café": 2, "café": 3, "fi": 4, "##x": 5}}
+		}`))
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		result := tok.EncodeWithOptions(tc.text, api.EncodeOptions{IncludeSpans: true})
+		if !intSliceEqual(result.IDs, tc.wantIDs) {
+			t.Errorf("%s: EncodeWithOptions(%q).IDs = %v, want %v", tc.normalizer, tc.text, result.IDs, tc.wantIDs)
+		}
+		if !spansEqual(result.Spans, tc.wantSpans) {
+			t.Errorf("%s: EncodeWithOptions(%q).Spans = %v, want %v", tc.normalizer, tc.text, result.Spans, tc.wantSpans)
+		}
+		if result.OffsetsApproximate {
+			t.Errorf("%s: EncodeWithOptions(%q).OffsetsApproximate = true, want false", tc.normalizer, tc.text)
+		}
+	}
+}
+
+// TestCountTokens checks that CountTokens matches len(Encode) for several inputs and options.
+func TestCountTokens(t *testing.T) {
+	for _, content := range [][]byte{testWordPieceTokenizerJSON, testBPETokenizerJSON, testUnigramTokenizerJSON} {
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent failed: %v", err)
+		}
+		texts := []string{"", "hello", "[CLS]", "Hello world testing", "hello [MASK] world, this is a test"}
+		for _, options := range []api.EncodeOptions{
+			{},
+			{AddSpecialTokens: true},
+			{AddSpecialTokens: true, MaxLen: 3},
+			{AddSpecialTokens: true, MaxLen: 1},
+			{MaxLen: 2},
+		} {
+			if err := tok.With(options); err != nil {
+				t.Fatalf("With(%+v) failed: %v", options, err)
+			}
+			for _, text := range texts {
+				if got, want := tok.CountTokens(text), len(tok.Encode(text)); got != want {
+					t.Errorf("With(%+v).CountTokens(%q) = %d, want %d", options, text, got, want)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkCountTokens_LongText(b *testing.B) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		b.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	input := "this is a test hello world testing "
+	for len(input) < 1000 {
+		input += input
+	}
+
+	b.Run("CountTokens", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tok.CountTokens(input)
+		}
+	})
+	b.Run("len(Encode)", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = len(tok.Encode(input))
+		}
+	})
+}
+
+// TestWhitespacePreTokenizer checks that Whitespace splits like `\w+|[^\w\s]+`, separating punctuation from words,
+// while WhitespaceSplit only splits on whitespace.
+func TestWhitespacePreTokenizer(t *testing.T) {
+	newTokenizer := func(preTokenizer string) *Tokenizer {
+		content := []byte(`{
+			"pre_tokenizer": {"type": "` + preTokenizer + `"},
+			"model": {
+				"type": "WordPiece",
+				"vocab": {"[UNK]": 0, "hello": 1, ",": 2, "world": 3, "!?": 4, "hello,": 5, "world!?": 6, "café_1": 7},
+				"unk_token": "[UNK]"
+			}
+		}`)
+		tok, err := NewFromContent(nil, content)
+		if err != nil {
+			t.Fatalf("NewFromContent(%s) failed: %v", preTokenizer, err)
+		}
+		if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+			t.Fatalf("With failed: %v", err)
+		}
+		return tok
+	}
+
+	text := "hello, world!? café_1"
+	got := newTokenizer("Whitespace").EncodeWithAnnotations(text)
+	if want := []int{1, 2, 3, 4, 7}; !intSliceEqual(got.IDs, want) {
+		t.Errorf("Whitespace: IDs = %v, want %v", got.IDs, want)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 5}, {Start: 5, End: 6}, {Start: 7, End: 12}, {Start: 12, End: 14}, {Start: 15, End: 22}}
+	if !spansEqual(got.Spans, wantSpans) {
+		t.Errorf("Whitespace: Spans = %v, want %v", got.Spans, wantSpans)
+	}
+
+	got = newTokenizer("WhitespaceSplit").EncodeWithAnnotations(text)
+	if want := []int{5, 6, 7}; !intSliceEqual(got.IDs, want) {
+		t.Errorf("WhitespaceSplit: IDs = %v, want %v", got.IDs, want)
+	}
+	wantSpans = []api.TokenSpan{{Start: 0, End: 6}, {Start: 7, End: 14}, {Start: 15, End: 22}}
+	if !spansEqual(got.Spans, wantSpans) {
+		t.Errorf("WhitespaceSplit: Spans = %v, want %v", got.Spans, wantSpans)
+	}
+}
+
+// TestGetVocabRef checks that GetVocabRef returns the shared vocabulary, GetVocab a copy, and that both reflect
+// AddTokens.
+func TestGetVocabRef(t *testing.T) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	ref := tok.GetVocabRef()
+	if !maps.Equal(ref, tok.GetVocab()) {
+		t.Errorf("GetVocabRef() = %v, want GetVocab() = %v", ref, tok.GetVocab())
+	}
+	if got := tok.GetVocabRef(); fmt.Sprintf("%p", got) != fmt.Sprintf("%p", ref) {
+		t.Errorf("GetVocabRef() returned a different map on the second call")
+	}
+	vocab := tok.GetVocab()
+	vocab["hello"] = -1
+	if got := tok.GetVocabRef()["hello"]; got != 1 {
+		t.Errorf("GetVocabRef()[\"hello\"] = %d after modifying GetVocab(), want 1", got)
+	}
+
+	ids := tok.AddTokens([]AddedToken{{Content: "<new>"}})
+	if got, found := tok.GetVocabRef()["<new>"]; !found || got != ids[0] {
+		t.Errorf("GetVocabRef()[\"<new>\"] = %d, %v after AddTokens, want %d", got, found, ids[0])
+	}
+	if _, found := ref["<new>"]; found {
+		t.Errorf("previously returned GetVocabRef() was modified by AddTokens")
+	}
+}
+
+func BenchmarkGetVocab(b *testing.B) {
+	tok, err := NewFromContent(nil, testWordPieceTokenizerJSON)
+	if err != nil {
+		b.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	b.Run("GetVocab", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tok.GetVocab()
+		}
+	})
+	b.Run("GetVocabRef", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = tok.GetVocabRef()
+		}
+	})
+}
+
+func TestStreamDecoder(t *testing.T) {
+	// "é" is encoded as the bytes 0xC3 0xA9, represented by the byte-level characters "Ã" (13) and "©" (14).
+	content := strings.Replace(string(testBPETokenizerJSON), `"Ġtest": 12`, `"Ġtest": 12, "Ã": 13, "©": 14`, 1)
+	tok, err := NewFromContent(nil, []byte(content))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	push := func(decoder *StreamDecoder, ids []int) []string {
+		var pieces []string
+		for _, id := range ids {
+			text, err := decoder.Push(id)
+			if err != nil {
+				t.Fatalf("Push(%d) failed: %v", id, err)
+			}
+			pieces = append(pieces, text)
+		}
+		return pieces
+	}
+
+	decoder := tok.NewStreamDecoder()
+	got := push(decoder, []int{2, 13, 14, 11, 0})
+	if want := []string{"hello", "", "é", " world", "<|endoftext|>"}; !stringSliceEqual(got, want) {
+		t.Errorf("Push() = %q, want %q", got, want)
+	}
+	if flushed := decoder.Flush(); flushed != "" {
+		t.Errorf("Flush() = %q, want empty", flushed)
+	}
+
+	// Incomplete characters are only emitted by Flush.
+	decoder = tok.NewStreamDecoder().WithSkipSpecialTokens(true)
+	got = push(decoder, []int{0, 2, 13})
+	if want := []string{"", "hello", ""}; !stringSliceEqual(got, want) {
+		t.Errorf("Push() = %q, want %q", got, want)
+	}
+	if flushed := decoder.Flush(); flushed != "�" {
+		t.Errorf("Flush() = %q, want %q", flushed, "�")
+	}
+	if _, err := decoder.Push(1000); err == nil {
+		t.Errorf("Push(1000) should have failed for an unknown id")
+	}
+
+	// The Metaspace decoder only strips the leading space of the first token.
+	tok, err = NewFromContent(nil, testUnigramTokenizerJSON)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	ids := []int{3, 4, 6, 9, 10}
+	got = push(tok.NewStreamDecoder(), ids)
+	if want := []string{"hello", " world", " ", "test", "ing"}; !stringSliceEqual(got, want) {
+		t.Errorf("Push() = %q, want %q", got, want)
+	}
+	if joined, want := strings.Join(got, ""), tok.Decode(ids); joined != want {
+		t.Errorf("streamed text = %q, want Decode() = %q", joined, want)
+	}
+}
+
+func TestReplaceNormalizer_NoMatchNonASCII(t *testing.T) {
+	content := []byte(`{
+		"version": "1.0",
+		"normalizer": {"type": "Replace", "pattern": {"String": "_"}, "content": " "},
+		"pre_tokenizer": {"type": "WhitespaceSplit"},
+		"model": {
+			"type": "WordPiece",
+			"unk_token": "[UNK]",
+			"vocab": {"[UNK]": 0, "日本語": 1, "本": 2}
+		}
+	}`)
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	tests := []struct {
+		text      string
+		wantIDs   []int
+		wantSpans []api.TokenSpan
+	}{
+		{"日本語", []int{1}, []api.TokenSpan{{Start: 0, End: 9}}},
+		{"日本語 日本語", []int{1, 1}, []api.TokenSpan{{Start: 0, End: 9}, {Start: 10, End: 19}}},
+		{"本 日本語", []int{2, 1}, []api.TokenSpan{{Start: 0, End: 3}, {Start: 4, End: 13}}},
+	}
+	for _, tt := range tests {
+		result := tok.EncodeWithAnnotations(tt.text)
+		if !intSliceEqual(result.IDs, tt.wantIDs) {
+			t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", tt.text, result.IDs, tt.wantIDs)
+		}
+		if !spansEqual(result.Spans, tt.wantSpans) {
+			t.Errorf("EncodeWithAnnotations(%q).Spans = %v, want %v", tt.text, result.Spans, tt.wantSpans)
+		}
+	}
+}
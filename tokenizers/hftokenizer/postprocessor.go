@@ -17,11 +17,14 @@ func (t *Tokenizer) applyPostProcessor(ids []int, spans []api.TokenSpan) ([]int,
 
 	pp := t.tokenizer.PostProcessor
 	if pp != nil {
+		t.tracef("post-processor %s", pp.Type)
 		switch pp.Type {
 		case "TemplateProcessing":
 			outIDs, outSpans, outSpecial = t.applyTemplateProcessing(pp, ids, spans)
 		case "BertProcessing", "RobertaProcessing":
 			outIDs, outSpans, outSpecial = t.applyBertProcessing(pp, ids, spans)
+		default:
+			t.tracef("post-processor %q: not supported, no special tokens added", pp.Type)
 		}
 	}
 
@@ -62,13 +65,11 @@ func (t *Tokenizer) applyTemplateProcessing(pp *PostProcessor, ids []int, spans
 
 	for _, item := range pp.Single {
 		if item.SpecialToken != nil {
-			st, ok := pp.SpecialTokens[item.SpecialToken.ID]
-			if ok && len(st.IDs) > 0 {
-				outIDs = append(outIDs, st.IDs...)
-				for range st.IDs {
-					outSpans = append(outSpans, api.TokenSpan{Start: -1, End: -1})
-					outSpecial = append(outSpecial, 1)
-				}
+			stIDs := t.templateSpecialTokenIDs(pp, item.SpecialToken.ID)
+			outIDs = append(outIDs, stIDs...)
+			for range stIDs {
+				outSpans = append(outSpans, api.TokenSpan{Start: -1, End: -1})
+				outSpecial = append(outSpecial, 1)
 			}
 		} else if item.Sequence != nil {
 			outIDs = append(outIDs, ids...)
@@ -82,6 +83,31 @@ func (t *Tokenizer) applyTemplateProcessing(pp *PostProcessor, ids []int, spans
 	return outIDs, outSpans, outSpecial
 }
 
+// templateSpecialTokenIDs returns the ids of a special token referenced by a TemplateProcessing template.
+//
+// The ids listed in the post-processor "special_tokens" map are used if present. Otherwise, the token strings
+// (or, if none are listed, the template id itself) are resolved with the vocabulary and added tokens.
+func (t *Tokenizer) templateSpecialTokenIDs(pp *PostProcessor, id string) []int {
+	st, found := pp.SpecialTokens[id]
+	if found && len(st.IDs) > 0 {
+		return st.IDs
+	}
+	tokens := st.Tokens
+	if len(tokens) == 0 {
+		tokens = []string{id}
+	}
+	ids := make([]int, 0, len(tokens))
+	for _, token := range tokens {
+		tokenID, ok := t.TokenToID(token)
+		if !ok {
+			t.tracef("post-processor: special token %q not found, not added", token)
+			return nil
+		}
+		ids = append(ids, tokenID)
+	}
+	return ids
+}
+
 // applyBertProcessing handles BertProcessing and RobertaProcessing post-processors.
 // Format: {"type": "BertProcessing", "sep": ["[SEP]", 102], "cls": ["[CLS]", 101]}
 func (t *Tokenizer) applyBertProcessing(pp *PostProcessor, ids []int, spans []api.TokenSpan) (outIDs []int, outSpans []api.TokenSpan, outSpecialMask []int) {
@@ -116,3 +142,102 @@ func (t *Tokenizer) applyBertProcessing(pp *PostProcessor, ids []int, spans []ap
 
 	return outIDs, outSpans, outSpecialMask
 }
+
+// applyPostProcessorPair is the equivalent of applyPostProcessor for sentence pairs: it combines the
+// two sequences adding the special tokens, and returns also the type ids and sequence ids of the tokens.
+//
+// Spans of special tokens are set to {-1, -1}. Without a post-processor, the sequences are simply concatenated.
+func (t *Tokenizer) applyPostProcessorPair(idsA []int, spansA []api.TokenSpan, idsB []int, spansB []api.TokenSpan) pairBuilder {
+	pp := t.tokenizer.PostProcessor
+	if pp != nil {
+		switch pp.Type {
+		case "TemplateProcessing":
+			if len(pp.Pair) > 0 {
+				return t.applyTemplateProcessingPair(pp, idsA, spansA, idsB, spansB)
+			}
+		case "BertProcessing", "RobertaProcessing":
+			if _, hasCLS := parseTokenIDTuple(pp.Cls); hasCLS {
+				return t.applyBertProcessingPair(pp, idsA, spansA, idsB, spansB)
+			}
+		}
+	}
+	return concatenatePair(idsA, spansA, idsB, spansB)
+}
+
+// concatenatePair combines the two sequences without special tokens, with type ids 0 and 1.
+func concatenatePair(idsA []int, spansA []api.TokenSpan, idsB []int, spansB []api.TokenSpan) pairBuilder {
+	b := pairBuilder{}
+	b.appendSequence(idsA, spansA, 0, 0)
+	b.appendSequence(idsB, spansB, 1, 1)
+	return b
+}
+
+// applyTemplateProcessingPair handles the "pair" template of TemplateProcessing post-processors.
+func (t *Tokenizer) applyTemplateProcessingPair(pp *PostProcessor, idsA []int, spansA []api.TokenSpan, idsB []int, spansB []api.TokenSpan) pairBuilder {
+	b := pairBuilder{}
+	for _, item := range pp.Pair {
+		if item.SpecialToken != nil {
+			b.appendSpecial(t.templateSpecialTokenIDs(pp, item.SpecialToken.ID), item.SpecialToken.TypeID)
+		} else if item.Sequence != nil {
+			if item.Sequence.ID == "B" {
+				b.appendSequence(idsB, spansB, 1, item.Sequence.TypeID)
+			} else {
+				b.appendSequence(idsA, spansA, 0, item.Sequence.TypeID)
+			}
+		}
+	}
+	return b
+}
+
+// applyBertProcessingPair handles sentence pairs for BertProcessing ("[CLS] A [SEP] B [SEP]", with type ids 0 for
+// "[CLS] A [SEP]" and 1 for "B [SEP]") and RobertaProcessing ("<s> A </s> </s> B </s>", with all type ids 0).
+func (t *Tokenizer) applyBertProcessingPair(pp *PostProcessor, idsA []int, spansA []api.TokenSpan, idsB []int, spansB []api.TokenSpan) pairBuilder {
+	clsID, _ := parseTokenIDTuple(pp.Cls)
+	sepID, hasSEP := parseTokenIDTuple(pp.Sep)
+	var sep []int
+	if hasSEP {
+		sep = []int{sepID}
+	}
+	secondTypeID := 1
+	if pp.Type == "RobertaProcessing" {
+		secondTypeID = 0
+	}
+
+	b := pairBuilder{}
+	b.appendSpecial([]int{clsID}, 0)
+	b.appendSequence(idsA, spansA, 0, 0)
+	b.appendSpecial(sep, 0)
+	if pp.Type == "RobertaProcessing" {
+		b.appendSpecial(sep, secondTypeID)
+	}
+	b.appendSequence(idsB, spansB, 1, secondTypeID)
+	b.appendSpecial(sep, secondTypeID)
+	return b
+}
+
+// pairBuilder accumulates the outputs of the post-processing of sentence pairs.
+type pairBuilder struct {
+	ids                               []int
+	spans                             []api.TokenSpan
+	specialMask, typeIDs, sequenceIDs []int
+}
+
+func (b *pairBuilder) appendSpecial(ids []int, typeID int) {
+	for _, id := range ids {
+		b.ids = append(b.ids, id)
+		b.spans = append(b.spans, api.TokenSpan{Start: -1, End: -1})
+		b.specialMask = append(b.specialMask, 1)
+		b.typeIDs = append(b.typeIDs, typeID)
+		b.sequenceIDs = append(b.sequenceIDs, -1)
+	}
+}
+
+func (b *pairBuilder) appendSequence(ids []int, spans []api.TokenSpan, sequenceID, typeID int) {
+	b.ids = append(b.ids, ids...)
+	b.spans = append(b.spans, spans...)
+	for range ids {
+		b.specialMask = append(b.specialMask, 0)
+		b.typeIDs = append(b.typeIDs, typeID)
+		b.sequenceIDs = append(b.sequenceIDs, sequenceID)
+	}
+}
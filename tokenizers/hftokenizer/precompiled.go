@@ -0,0 +1,141 @@
+package hftokenizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/rivo/uniseg"
+)
+
+// precompiledCharsmap is the parsed "precompiled_charsmap" of a Precompiled normalizer: the normalization rules
+// compiled by SentencePiece (e.g. "nmt_nfkc"), mapping input byte sequences to their normalized replacement.
+//
+// The serialized format is a little-endian uint32 with the size in bytes of a Darts-clone double-array trie,
+// followed by the trie units (little-endian uint32) and the pool of null-terminated replacement strings.
+// The values stored in the trie are offsets into this pool.
+type precompiledCharsmap struct {
+	trie       []uint32
+	normalized []byte
+}
+
+// parsePrecompiledCharsmap parses the base64 encoded "precompiled_charsmap".
+func parsePrecompiledCharsmap(encoded string) (*precompiledCharsmap, error) {
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode base64 precompiled_charsmap")
+	}
+	if len(blob) < 4 {
+		return nil, errors.Errorf("precompiled_charsmap too short (%d bytes)", len(blob))
+	}
+	trieSize := int(binary.LittleEndian.Uint32(blob))
+	blob = blob[4:]
+	if trieSize%4 != 0 || trieSize > len(blob) {
+		return nil, errors.Errorf("invalid precompiled_charsmap trie size %d for %d bytes", trieSize, len(blob))
+	}
+	m := &precompiledCharsmap{
+		trie:       make([]uint32, trieSize/4),
+		normalized: blob[trieSize:],
+	}
+	for i := range m.trie {
+		m.trie[i] = binary.LittleEndian.Uint32(blob[4*i:])
+	}
+	return m, nil
+}
+
+// Accessors of the Darts-clone double-array units.
+func dartsHasLeaf(unit uint32) bool  { return (unit>>8)&1 == 1 }
+func dartsValue(unit uint32) int     { return int(unit & (1<<31 - 1)) }
+func dartsLabel(unit uint32) uint32  { return unit & (1<<31 | 0xFF) }
+func dartsOffset(unit uint32) uint32 { return (unit >> 10) << ((unit & (1 << 9)) >> 6) }
+
+// transform returns the replacement for the shortest key in the trie that is a prefix of chunk, as HuggingFace
+// does (the first result of its common prefix search). It returns false if no key is a prefix of chunk.
+func (m *precompiledCharsmap) transform(chunk string) (replacement []byte, found bool) {
+	if len(m.trie) == 0 {
+		return nil, false
+	}
+	value := -1
+	nodePos := dartsOffset(m.trie[0])
+	for i := 0; i < len(chunk); i++ {
+		c := chunk[i]
+		if c == 0 {
+			break
+		}
+		nodePos ^= uint32(c)
+		if int(nodePos) >= len(m.trie) {
+			break
+		}
+		unit := m.trie[nodePos]
+		if dartsLabel(unit) != uint32(c) {
+			break
+		}
+		nodePos ^= dartsOffset(unit)
+		if dartsHasLeaf(unit) && int(nodePos) < len(m.trie) {
+			value = dartsValue(m.trie[nodePos])
+			break
+		}
+	}
+	if value < 0 || value >= len(m.normalized) {
+		return nil, false
+	}
+	replacement = m.normalized[value:]
+	if end := bytes.IndexByte(replacement, 0); end >= 0 {
+		replacement = replacement[:end]
+	}
+	return replacement, true
+}
+
+// normalize applies the charsmap rules as HuggingFace does: the text is split in grapheme clusters, and a cluster
+// shorter than 6 bytes with a matching key (see transform) is replaced as a whole. Otherwise, each character of the
+// cluster with a matching key is replaced, and the others are kept (invalid UTF-8 bytes are replaced by U+FFFD).
+//
+// SentencePiece itself replaces the longest key matching at each position instead, regardless of the grapheme
+// clusters: the results only differ for keys that are not whole clusters.
+//
+// If withSpans is set, it also returns the offsets mapping: the bytes of each replacement (or kept character)
+// map to the start of the input they come from.
+func (m *precompiledCharsmap) normalize(text string, withSpans bool) (string, []int) {
+	var result []byte
+	var offsets []int
+	if withSpans {
+		offsets = make([]int, 0, len(text))
+	}
+	appendReplacement := func(replacement []byte, pos int) {
+		result = append(result, replacement...)
+		if withSpans {
+			for range replacement {
+				offsets = append(offsets, pos)
+			}
+		}
+	}
+	state := -1
+	for pos := 0; pos < len(text); {
+		var cluster string
+		cluster, _, _, state = uniseg.FirstGraphemeClusterInString(text[pos:], state)
+		if len(cluster) < 6 {
+			if replacement, found := m.transform(cluster); found {
+				appendReplacement(replacement, pos)
+				pos += len(cluster)
+				continue
+			}
+		}
+		end := pos + len(cluster)
+		for pos < end {
+			r, size := utf8.DecodeRuneInString(text[pos:end])
+			replacement, found := m.transform(text[pos : pos+size])
+			if !found {
+				if r == utf8.RuneError && size == 1 {
+					replacement = []byte(string(utf8.RuneError))
+				} else {
+					replacement = []byte(text[pos : pos+size])
+				}
+			}
+			appendReplacement(replacement, pos)
+			pos += size
+		}
+	}
+	return string(result), offsets
+}
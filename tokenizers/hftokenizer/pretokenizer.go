@@ -4,79 +4,143 @@ import (
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/gomlx/go-huggingface/tokenizers/private/regexsplit"
+	"github.com/pkg/errors"
 )
 
+// textPosition is the position in the input of a text being normalized or pre-tokenized: it decides whether its
+// start is handled as the start of the input (e.g. Metaspace with prepend_scheme "first"), or of a segment.
+type textPosition int
+
+const (
+	// inputStart is the position of a text at the start of the input.
+	inputStart textPosition = iota
+
+	// segmentStart is the position of a text following an added token (or a previous word, within a Sequence
+	// pre-tokenizer).
+	segmentStart
+
+	// chunkContinuation is the position of a text continuing the previous chunk of the input, see EncodeReader:
+	// no prefix space is added to it, and its leading whitespace is not stripped.
+	chunkContinuation
+)
+
+// segmentPosition returns the textPosition of a segment of the input starting at the byte position start.
+func segmentPosition(start int) textPosition {
+	if start == 0 {
+		return inputStart
+	}
+	return segmentStart
+}
+
 // preTokenizeWithSpans splits text into words with their byte spans.
-func (t *Tokenizer) preTokenizeWithSpans(text string, normOffsets []int) []wordWithOffset {
+//
+// pos is the position of text in the input: it is used by the Metaspace pre-tokenizer with prepend_scheme "first",
+// and to skip the prefix space of chunk continuations.
+func (t *Tokenizer) preTokenizeWithSpans(text string, normOffsets []int, pos textPosition) []wordWithOffset {
 	if t.tokenizer.PreTokenizer == nil {
 		// Default: split on whitespace
+		if t.trace != nil {
+			t.tracef("no pre-tokenizer: splitting on whitespace")
+		}
 		return fieldsWithOffsets(text, normOffsets)
 	}
-	return t.applyPreTokenizerWithSpans(text, normOffsets, t.tokenizer.PreTokenizer)
+	return t.applyPreTokenizerWithSpans(text, normOffsets, t.tokenizer.PreTokenizer, pos)
 }
 
 // fieldsWithOffsets splits text on whitespace and returns words with their offsets.
+//
+// The words are slices of text, so bytes that are not valid UTF-8 are preserved.
 func fieldsWithOffsets(text string, normOffsets []int) []wordWithOffset {
 	var words []wordWithOffset
-	var current strings.Builder
 	currentStart := -1
-
-	for i, r := range text {
-		if unicode.IsSpace(r) {
-			if current.Len() > 0 {
-				end := i
-				origStart := 0
-				origEnd := len(text)
-				if currentStart < len(normOffsets) {
-					origStart = normOffsets[currentStart]
-				}
-				if end <= len(normOffsets) && end > 0 {
-					origEnd = normOffsets[end-1] + 1
-				}
-				words = append(words, wordWithOffset{
-					text:  current.String(),
-					start: origStart,
-					end:   origEnd,
-				})
-				current.Reset()
-				currentStart = -1
-			}
-		} else {
-			if currentStart == -1 {
-				currentStart = i
-			}
-			current.WriteRune(r)
-		}
-	}
-
-	if current.Len() > 0 {
+	addWord := func(end int) {
 		origStart := 0
 		origEnd := len(text)
 		if currentStart < len(normOffsets) {
 			origStart = normOffsets[currentStart]
 		}
-		if len(normOffsets) > 0 {
-			origEnd = normOffsets[len(normOffsets)-1] + 1
+		if end <= len(normOffsets) && end > 0 {
+			origEnd = normOffsets[end-1] + 1
 		}
 		words = append(words, wordWithOffset{
-			text:  current.String(),
+			text:  text[currentStart:end],
 			start: origStart,
 			end:   origEnd,
 		})
+		currentStart = -1
 	}
 
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if currentStart >= 0 {
+				addWord(i)
+			}
+		} else if currentStart == -1 {
+			currentStart = i
+		}
+	}
+	if currentStart >= 0 {
+		addWord(len(text))
+	}
+	return words
+}
+
+// whitespacePreTokenizeWithOffsets implements the Whitespace pre-tokenizer, equivalent to the regex `\w+|[^\w\s]+`:
+// it splits on whitespace, and separates the runs of word characters from the runs of the other characters
+// (e.g. "Hello, world!" -> "Hello", ",", "world", "!").
+//
+// As in HuggingFace (Oniguruma), `\w` is Unicode aware: letters, marks, numbers and connector punctuation ("_").
+func whitespacePreTokenizeWithOffsets(text string, normOffsets []int) []wordWithOffset {
+	var words []wordWithOffset
+	start := -1
+	var startIsWord bool
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, makeWord(text, normOffsets, start, i))
+				start = -1
+			}
+			continue
+		}
+		isWord := isWordChar(r)
+		if start >= 0 && isWord != startIsWord {
+			words = append(words, makeWord(text, normOffsets, start, i))
+			start = -1
+		}
+		if start < 0 {
+			start = i
+			startIsWord = isWord
+		}
+	}
+	if start >= 0 {
+		words = append(words, makeWord(text, normOffsets, start, len(text)))
+	}
 	return words
 }
 
+// isWordChar reports whether r matches the Unicode `\w` class.
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) || unicode.Is(unicode.Pc, r)
+}
+
 // applyPreTokenizerWithSpans applies pre-tokenization with offset tracking.
-func (t *Tokenizer) applyPreTokenizerWithSpans(text string, normOffsets []int, pt *PreTokenizer) []wordWithOffset {
+func (t *Tokenizer) applyPreTokenizerWithSpans(text string, normOffsets []int, pt *PreTokenizer, pos textPosition) []wordWithOffset {
+	if t.trace != nil {
+		t.tracef("pre-tokenizer %s", pt.Type)
+	}
 	switch pt.Type {
 	case "BertPreTokenizer":
 		return bertPreTokenizeWithOffsets(text, normOffsets)
-	case "Whitespace", "WhitespaceSplit":
+	case "Whitespace":
+		return whitespacePreTokenizeWithOffsets(text, normOffsets)
+	case "WhitespaceSplit":
 		return fieldsWithOffsets(text, normOffsets)
 	case "ByteLevel":
-		if pt.AddPrefixSpace && len(text) > 0 && text[0] != ' ' {
+		if pt.AddPrefixSpace && pos != chunkContinuation && len(text) > 0 && text[0] != ' ' {
 			// Prepend space - adjust offsets
 			text = " " + text
 			newOffsets := make([]int, len(normOffsets)+1)
@@ -91,7 +155,8 @@ func (t *Tokenizer) applyPreTokenizerWithSpans(text string, normOffsets []int, p
 		if pt.Split != nil {
 			split = *pt.Split
 		}
-		return metaspacePreTokenizeWithOffsets(text, normOffsets, pt.AddPrefixSpace, pt.Replacement, pt.PrependScheme, split)
+		prepend := metaspacePrepends(pt.PrependScheme, pt.AddPrefixSpace, pos)
+		return metaspacePreTokenizeWithOffsets(text, normOffsets, prepend, metaspaceReplacement(pt.Replacement), split)
 	case "Split":
 		return splitPreTokenizeWithOffsets(text, normOffsets, pt)
 	case "Sequence":
@@ -102,13 +167,17 @@ func (t *Tokenizer) applyPreTokenizerWithSpans(text string, normOffsets []int, p
 		for _, child := range pt.PreTokenizers {
 			var newResult []wordWithOffset
 			childCopy := child
-			for _, w := range result {
+			for i, w := range result {
 				// Create sub-offsets for this word
 				subOffsets := make([]int, len(w.text))
 				for i := range subOffsets {
 					subOffsets[i] = w.start + i
 				}
-				subWords := t.applyPreTokenizerWithSpans(w.text, subOffsets, &childCopy)
+				subPos := pos
+				if i > 0 {
+					subPos = segmentStart
+				}
+				subWords := t.applyPreTokenizerWithSpans(w.text, subOffsets, &childCopy, subPos)
 				newResult = append(newResult, subWords...)
 			}
 			result = newResult
@@ -117,6 +186,9 @@ func (t *Tokenizer) applyPreTokenizerWithSpans(text string, normOffsets []int, p
 	case "Punctuation":
 		return punctuationPreTokenizeWithOffsets(text, normOffsets)
 	default:
+		if t.trace != nil {
+			t.tracef("pre-tokenizer %q: not supported, splitting on whitespace", pt.Type)
+		}
 		return fieldsWithOffsets(text, normOffsets)
 	}
 }
@@ -127,10 +199,7 @@ func bertPreTokenizeWithOffsets(text string, normOffsets []int) []wordWithOffset
 	var current strings.Builder
 	currentStart := -1
 
-	runes := []rune(text)
-	for i, r := range runes {
-		bytePos := len(string(runes[:i]))
-
+	for bytePos, r := range text {
 		if isWhitespace(r) {
 			if current.Len() > 0 {
 				origStart := 0
@@ -283,63 +352,56 @@ func punctuationPreTokenizeWithOffsets(text string, normOffsets []int) []wordWit
 func byteLevelPreTokenizeWithOffsets(text string, normOffsets []int) []wordWithOffset {
 	var words []wordWithOffset
 	var current strings.Builder
-	var currentOffsets []int
+	var currentOffsets []int // Position of each rune of current, if normOffsets is given.
+
+	flush := func(end int) {
+		word := wordWithOffset{text: current.String(), start: 0, end: end}
+		if len(currentOffsets) > 0 {
+			word.start = currentOffsets[0]
+			word.end = currentOffsets[len(currentOffsets)-1] + 1
+			word.runeOffsets = currentOffsets
+		}
+		words = append(words, word)
+		current.Reset()
+		currentOffsets = nil
+	}
 
 	for i, r := range text {
-		if r == ' ' {
-			if current.Len() > 0 {
-				origStart := 0
-				origEnd := i
-				if len(currentOffsets) > 0 {
-					origStart = currentOffsets[0]
-					origEnd = currentOffsets[len(currentOffsets)-1] + 1
-				}
-				words = append(words, wordWithOffset{
-					text:  current.String(),
-					start: origStart,
-					end:   origEnd,
-				})
-				current.Reset()
-				currentOffsets = nil
-			}
-			// Start new token with space
-			current.WriteRune(byteToUnicode[' '])
-			if i < len(normOffsets) {
-				currentOffsets = append(currentOffsets, normOffsets[i])
-			}
-		} else {
-			for _, b := range []byte(string(r)) {
-				current.WriteRune(byteToUnicode[b])
-				if i < len(normOffsets) {
-					currentOffsets = append(currentOffsets, normOffsets[i])
-				}
+		if r == ' ' && current.Len() > 0 {
+			flush(i)
+		}
+		// Each byte is mapped to one rune: a space starts a new word with "Ġ".
+		for j, b := range []byte(string(r)) {
+			current.WriteRune(byteToUnicode[b])
+			if i+j < len(normOffsets) {
+				currentOffsets = append(currentOffsets, normOffsets[i+j])
 			}
 		}
 	}
-
 	if current.Len() > 0 {
-		origStart := 0
-		origEnd := len(text)
-		if len(currentOffsets) > 0 {
-			origStart = currentOffsets[0]
-			origEnd = currentOffsets[len(currentOffsets)-1] + 1
-		}
-		words = append(words, wordWithOffset{
-			text:  current.String(),
-			start: origStart,
-			end:   origEnd,
-		})
+		flush(len(text))
 	}
-
 	return words
 }
 
-// metaspacePreTokenizeWithOffsets handles metaspace pre-tokenization with offsets.
-func metaspacePreTokenizeWithOffsets(text string, normOffsets []int, addPrefixSpace bool, replacement string, prependScheme string, split bool) []wordWithOffset {
-	if replacement == "" {
-		replacement = "\u2581"
+// trimByteLevelSpans implements the ByteLevel trim_offsets option: it excludes the leading and trailing spaces
+// (encoded as "Ġ") of the tokens from their spans. The token ids are not changed.
+func (t *Tokenizer) trimByteLevelSpans(ids []int, spans []api.TokenSpan) {
+	space := string(byteToUnicode[' '])
+	for i, id := range ids {
+		token := t.idToToken[id]
+		leading := len(token) - len(strings.TrimLeft(token, space))
+		trailing := len(token) - len(strings.TrimRight(token, space))
+		span := &spans[i]
+		span.Start = min(span.Start+leading/len(space), span.End)
+		span.End = max(span.End-trailing/len(space), span.Start)
 	}
-	if (addPrefixSpace || prependScheme == "always") && len(text) > 0 && text[0] != ' ' {
+}
+
+// metaspacePreTokenizeWithOffsets handles metaspace pre-tokenization with offsets.
+func metaspacePreTokenizeWithOffsets(text string, normOffsets []int, prepend bool, replacement string, split bool) []wordWithOffset {
+	replacementRune, _ := utf8.DecodeRuneInString(replacement)
+	if prepend && len(text) > 0 && text[0] != ' ' && !strings.HasPrefix(text, replacement) {
 		text = " " + text
 		newOffsets := make([]int, len(normOffsets)+1)
 		newOffsets[0] = 0
@@ -353,7 +415,7 @@ func metaspacePreTokenizeWithOffsets(text string, normOffsets []int, addPrefixSp
 	currentStart := -1
 
 	for i, r := range text {
-		if r == ' ' {
+		if r == ' ' || r == replacementRune {
 			if split && current.Len() > 0 {
 				origStart := 0
 				origEnd := i
@@ -402,28 +464,105 @@ func metaspacePreTokenizeWithOffsets(text string, normOffsets []int, addPrefixSp
 	return words
 }
 
+// findPreTokenizer returns the first pre-tokenizer of the given type in pt, including within Sequence
+// pre-tokenizers, or nil if there is none.
+func findPreTokenizer(pt *PreTokenizer, preTokenizerType string) *PreTokenizer {
+	if pt == nil {
+		return nil
+	}
+	if pt.Type == preTokenizerType {
+		return pt
+	}
+	for i := range pt.PreTokenizers {
+		if found := findPreTokenizer(&pt.PreTokenizers[i], preTokenizerType); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// metaspaceReplacement returns the Metaspace replacement character, "▁" (U+2581) by default.
+func metaspaceReplacement(replacement string) string {
+	if replacement == "" {
+		return "\u2581"
+	}
+	return replacement
+}
+
+// metaspacePrepends returns whether Metaspace prepends the replacement character (a space) to the text, according to
+// the prepend_scheme: "always", "never", or "first", only for the text at the start of the input. If no scheme is
+// given, the legacy add_prefix_space is used instead. Chunk continuations are never prepended.
+func metaspacePrepends(prependScheme string, addPrefixSpace bool, pos textPosition) bool {
+	if pos == chunkContinuation {
+		return false
+	}
+	switch prependScheme {
+	case "always":
+		return true
+	case "never":
+		return false
+	case "first":
+		return pos == inputStart
+	default:
+		return addPrefixSpace
+	}
+}
+
+// compilePreTokenizer compiles the patterns of the Split pre-tokenizers, recursively.
+func compilePreTokenizer(pt *PreTokenizer) error {
+	if pt == nil {
+		return nil
+	}
+	if pt.Type == "Split" {
+		compiled, err := compileSplitPattern(pt.Pattern)
+		if err != nil {
+			return err
+		}
+		pt.compiled = compiled
+	}
+	for i := range pt.PreTokenizers {
+		if err := compilePreTokenizer(&pt.PreTokenizers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileSplitPattern compiles the Split pre-tokenizer pattern, see regexsplit.Compile.
+// It returns nil if the pattern is empty.
+func compileSplitPattern(pattern *Pattern) (*regexsplit.Pattern, error) {
+	if pattern == nil {
+		return nil, nil
+	}
+	if pattern.Regex == "" {
+		if pattern.String == "" {
+			return nil, nil
+		}
+		return regexsplit.MustCompile(regexp.QuoteMeta(pattern.String)), nil
+	}
+	compiled, err := regexsplit.Compile(pattern.Regex)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to compile Split pattern")
+	}
+	return compiled, nil
+}
+
 // splitPreTokenizeWithOffsets splits text based on pattern and behavior.
 func splitPreTokenizeWithOffsets(text string, normOffsets []int, pt *PreTokenizer) []wordWithOffset {
 	if len(text) == 0 {
 		return nil
 	}
 
-	var re *regexp.Regexp
-	var err error
-	if pt.Pattern != nil {
-		if pt.Pattern.Regex != "" {
-			re, err = regexp.Compile(pt.Pattern.Regex)
-		} else if pt.Pattern.String != "" {
-			re, err = regexp.Compile(regexp.QuoteMeta(pt.Pattern.String))
-		}
+	pattern := pt.compiled
+	if pattern == nil {
+		pattern, _ = compileSplitPattern(pt.Pattern)
 	}
-
-	if re == nil || err != nil {
+	if pattern == nil {
 		// Fallback: return whole text as a single word
 		return []wordWithOffset{makeWord(text, normOffsets, 0, len(text))}
 	}
 
-	matches := re.FindAllStringIndex(text, -1)
+	matches := pattern.FindAllIndex(text)
 
 	type segment struct {
 		start       int
@@ -435,6 +574,10 @@ func splitPreTokenizeWithOffsets(text string, normOffsets []int, pt *PreTokenize
 	cur := 0
 	for _, m := range matches {
 		sStart, sEnd := m[0], m[1]
+		if sStart == sEnd {
+			// Empty matches don't delimit anything, as in HuggingFace tokenizers.
+			continue
+		}
 		if sStart > cur {
 			segments = append(segments, segment{
 				start:       cur,
@@ -457,7 +600,9 @@ func splitPreTokenizeWithOffsets(text string, normOffsets []int, pt *PreTokenize
 		})
 	}
 
-	behavior := strings.ToLower(pt.Behavior)
+	// HuggingFace uses "Removed", "Isolated", "MergedWithPrevious", "MergedWithNext" and "Contiguous":
+	// matching is case-insensitive, and underscores (e.g. "merged_with_previous") are ignored.
+	behavior := strings.ToLower(strings.ReplaceAll(pt.Behavior, "_", ""))
 	var words []wordWithOffset
 
 	switch behavior {
@@ -492,7 +637,7 @@ func splitPreTokenizeWithOffsets(text string, normOffsets []int, pt *PreTokenize
 			}
 		}
 
-	case "merged_with_previous":
+	case "mergedwithprevious":
 		for i := 0; i < len(segments); {
 			seg := segments[i]
 			if !seg.isDelimiter {
@@ -510,7 +655,7 @@ func splitPreTokenizeWithOffsets(text string, normOffsets []int, pt *PreTokenize
 			}
 		}
 
-	case "merged_with_next":
+	case "mergedwithnext":
 		for i := 0; i < len(segments); {
 			seg := segments[i]
 			if seg.isDelimiter {
@@ -0,0 +1,142 @@
+package hftokenizer
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	// encodeReaderChunkSize is the number of bytes read at a time by EncodeReader.
+	encodeReaderChunkSize = 64 * 1024
+
+	// encodeReaderMaxPending is the maximum number of bytes EncodeReader will buffer while waiting for a
+	// word boundary. Inputs with longer runs without whitespace are cut at a rune boundary instead.
+	encodeReaderMaxPending = 16 * encodeReaderChunkSize
+)
+
+// EncodeReader tokenizes the contents of r, reading it in chunks, so that inputs too large to hold in
+// memory (e.g. multi-gigabyte corpora) can be tokenized.
+//
+// Chunks are only cut at word boundaries: a partial trailing word (and the whitespace preceding it) is
+// carried over to the next chunk, so tokens are never split across chunks, and chunks are never cut through an
+// added token. The chunks after the first are encoded as a continuation of the input, without prefix spaces
+// (Metaspace, ByteLevel add_prefix_space) or stripping their leading whitespace, so the result is the same as
+// Encode on the full contents.
+// If a run of more than 1MB without any whitespace is found, it is cut at a rune boundary, and the tokens
+// around the cut may differ.
+//
+// As in Encode, the ids are truncated to the MaxLen option (or the tokenizer.json truncation), and special
+// tokens (e.g. [CLS]/[SEP]) are added once, around the whole input, if AddSpecialTokens is set.
+func (t *Tokenizer) EncodeReader(r io.Reader) ([]int, error) {
+	return t.encodeReader(r, encodeReaderChunkSize, encodeReaderMaxPending)
+}
+
+func (t *Tokenizer) encodeReader(r io.Reader, chunkSize, maxPending int) ([]int, error) {
+	var ids []int
+	var pending []byte
+	var continued bool
+	maxAddedTokenLen := t.maxAddedTokenLen()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		pending = append(pending, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed reading input to tokenize")
+		}
+		if cut := t.chunkCut(pending, maxAddedTokenLen, maxPending); cut > 0 {
+			ids = t.appendEncodedIDs(ids, string(pending[:cut]), continued)
+			pending = append(pending[:0], pending[cut:]...)
+			continued = true
+		}
+	}
+	if len(pending) > 0 {
+		ids = t.appendEncodedIDs(ids, string(pending), continued)
+	}
+	result := api.AnnotatedEncoding{IDs: ids}
+	t.truncate(&result, t.options)
+	if t.options.AddSpecialTokens {
+		result.IDs, _, _ = t.applyPostProcessor(result.IDs, nil)
+	}
+	return result.IDs, nil
+}
+
+// maxAddedTokenLen returns the length in bytes of the longest added token.
+func (t *Tokenizer) maxAddedTokenLen() int {
+	var maxLen int
+	for content := range t.addedTokens {
+		maxLen = max(maxLen, len(content))
+	}
+	return maxLen
+}
+
+// chunkCut returns the position where EncodeReader cuts the pending data, or 0 if more data is needed.
+//
+// The cut is at the last word boundary (see lastWordBoundary) that leaves at least maxAddedTokenLen bytes after it,
+// so that any added token starting before the cut is complete in data. If such an added token (including the
+// whitespace consumed by lstrip/rstrip) spans the cut, the cut is moved to its start.
+func (t *Tokenizer) chunkCut(data []byte, maxAddedTokenLen, maxPending int) int {
+	limit := len(data) - maxAddedTokenLen
+	if limit <= 0 {
+		return 0
+	}
+	cut := lastWordBoundary(data[:limit])
+	if cut == 0 && len(data) > maxPending {
+		cut = lastRuneBoundary(data[:limit])
+	}
+	if cut == 0 || t.addedTokensTrie == nil {
+		return cut
+	}
+	for _, seg := range t.splitOnAddedTokens(string(data)) {
+		if seg.start >= cut {
+			break
+		}
+		if seg.isAddedToken && seg.end > cut {
+			return seg.start
+		}
+	}
+	return cut
+}
+
+// lastWordBoundary returns the byte position where the whitespace run preceding the last word of
+// data starts, or 0 if there is none.
+// Cutting there keeps the whitespace together with the word that follows it, as byte-level and
+// metaspace pre-tokenizers expect.
+func lastWordBoundary(data []byte) int {
+	pos := len(data)
+	// Skip the trailing (possibly incomplete) word.
+	for pos > 0 {
+		r, size := utf8.DecodeLastRune(data[:pos])
+		if r != utf8.RuneError && unicode.IsSpace(r) {
+			break
+		}
+		pos -= size
+	}
+	// Include the whitespace run preceding it.
+	for pos > 0 {
+		r, size := utf8.DecodeLastRune(data[:pos])
+		if r == utf8.RuneError || !unicode.IsSpace(r) {
+			break
+		}
+		pos -= size
+	}
+	return pos
+}
+
+// lastRuneBoundary returns the byte position of the start of the last, possibly incomplete, UTF-8 rune in data.
+func lastRuneBoundary(data []byte) int {
+	pos := len(data)
+	for pos > 0 && pos > len(data)-utf8.UTFMax {
+		pos--
+		if utf8.RuneStart(data[pos]) {
+			return pos
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,28 @@
+package hftokenizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Save writes the tokenizer configuration (including any changes made to it after loading) to a tokenizer.json
+// file in filePath, that can be loaded back with NewFromFile.
+//
+// Only the fields known to this package are written: fields of the original tokenizer.json that are not parsed
+// are dropped.
+func (t *Tokenizer) Save(filePath string) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false) // Keep special tokens like "<s>" readable.
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(t.tokenizer); err != nil {
+		return errors.Wrapf(err, "failed to serialize tokenizer.json")
+	}
+	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write tokenizer.json file %q", filePath)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package hftokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// StreamDecoder decodes token ids as they are generated, one at a time, emitting the text as soon as it is final.
+//
+// Decoding each token separately doesn't work in general: byte-level and byte-fallback tokens may hold only part
+// of a multibyte UTF-8 character, and decoders like Metaspace strip the leading space of the first token.
+// So StreamDecoder keeps the ids pushed since the last emitted text, along with the token before them, and emits
+// the difference between the decoding of the two only once it is complete UTF-8.
+//
+// It is not safe for concurrent use. Create it with Tokenizer.NewStreamDecoder.
+type StreamDecoder struct {
+	tokenizer   *Tokenizer
+	skipSpecial bool
+
+	// ids holds the token whose text was already emitted (if any), followed by the ids not yet emitted,
+	// which start at readOffset.
+	ids        []int
+	readOffset int
+}
+
+// NewStreamDecoder creates a StreamDecoder for the tokenizer.
+func (t *Tokenizer) NewStreamDecoder() *StreamDecoder {
+	return &StreamDecoder{tokenizer: t}
+}
+
+// WithSkipSpecialTokens sets whether special tokens (e.g. "</s>") are dropped from the decoded text,
+// see Tokenizer.DecodeWithOptions. The default is false.
+// It returns the StreamDecoder itself, for chaining calls.
+func (s *StreamDecoder) WithSkipSpecialTokens(skip bool) *StreamDecoder {
+	s.skipSpecial = skip
+	return s
+}
+
+// Push adds the next generated token id, and returns the text it completes, which may be empty if the token
+// is still incomplete (e.g. the first byte of a multibyte character).
+//
+// It returns an error if the id is not known to the tokenizer.
+func (s *StreamDecoder) Push(id int) (string, error) {
+	if _, found := s.tokenizer.idToToken[id]; !found {
+		return "", errors.Errorf("unknown token id %d", id)
+	}
+	s.ids = append(s.ids, id)
+	prefixText := s.tokenizer.decodeRaw(s.ids[:s.readOffset], s.skipSpecial)
+	newText := s.tokenizer.decodeRaw(s.ids, s.skipSpecial)
+	if len(newText) <= len(prefixText) || !strings.HasPrefix(newText, prefixText) {
+		return "", nil
+	}
+	text := newText[len(prefixText):]
+	if !utf8.ValidString(text) {
+		// Wait for the rest of the character.
+		return "", nil
+	}
+	// Keep only the last token already emitted, as the prefix for the next ones.
+	s.ids = s.ids[s.readOffset:]
+	s.readOffset = len(s.ids)
+	return text, nil
+}
+
+// Flush returns the text of the ids pushed but not yet emitted, with incomplete UTF-8 sequences replaced by U+FFFD,
+// and resets the StreamDecoder to start a new sequence.
+func (s *StreamDecoder) Flush() string {
+	prefixText := s.tokenizer.decodeRaw(s.ids[:s.readOffset], s.skipSpecial)
+	newText := s.tokenizer.decodeRaw(s.ids, s.skipSpecial)
+	s.ids, s.readOffset = nil, 0
+	if len(newText) <= len(prefixText) || !strings.HasPrefix(newText, prefixText) {
+		return ""
+	}
+	return strings.ToValidUTF8(newText[len(prefixText):], "\uFFFD")
+}
@@ -0,0 +1,87 @@
+package hftokenizer
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+)
+
+// suggestMinOccurrences is the minimum number of times a word must appear in the corpus to be suggested
+// by SuggestAddedTokens.
+const suggestMinOccurrences = 2
+
+// SuggestAddedTokens tokenizes the corpus and proposes up to maxNew whole words to be added as tokens:
+// the words that are split in the most subword tokens, weighted by their frequency.
+//
+// Words are scored by the number of tokens that would be saved in the corpus (occurrences × (pieces - 1)),
+// and only words that appear at least twice are considered. The returned words are in the original (not
+// normalized) form, sorted by decreasing score, ready to be added as added tokens.
+//
+// This is not a tokenizer trainer: it reuses the existing encoding pipeline and vocabulary.
+func (t *Tokenizer) SuggestAddedTokens(corpus []string, maxNew int) []string {
+	if maxNew <= 0 {
+		return nil
+	}
+	type wordStats struct {
+		count, pieces int
+	}
+	stats := make(map[string]*wordStats)
+	for _, text := range corpus {
+		for _, seg := range t.splitOnAddedTokens(text) {
+			if seg.isAddedToken {
+				continue
+			}
+			segText := text[seg.start:seg.end]
+			normalized, normOffsets, _ := t.normalizeWithSpans(segText)
+			for _, word := range t.preTokenizeWithSpans(normalized, identityOffsets(len(normalized)), segmentPosition(seg.start)) {
+				ids, _ := t.tokenizeWordWithSpans(word)
+				if len(ids) < 2 {
+					continue
+				}
+				span := alignSpan(api.TokenSpan{Start: word.start, End: word.end}, normOffsets, len(segText))
+				original := strings.TrimSpace(segText[span.Start:span.End])
+				if original == "" {
+					continue
+				}
+				if _, found := t.addedTokens[original]; found {
+					continue
+				}
+				s, found := stats[original]
+				if !found {
+					s = &wordStats{}
+					stats[original] = s
+				}
+				s.count++
+				s.pieces = len(ids)
+			}
+		}
+	}
+
+	type candidate struct {
+		word  string
+		score int
+	}
+	var candidates []candidate
+	for word, s := range stats {
+		if s.count < suggestMinOccurrences {
+			continue
+		}
+		candidates = append(candidates, candidate{word, s.count * (s.pieces - 1)})
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.word, b.word)
+	})
+	if len(candidates) > maxNew {
+		candidates = candidates[:maxNew]
+	}
+	words := make([]string, len(candidates))
+	for i, c := range candidates {
+		words[i] = c.word
+	}
+	return words
+}
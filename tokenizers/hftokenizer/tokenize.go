@@ -1,12 +1,39 @@
 package hftokenizer
 
 import (
+	"container/heap"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
 	"github.com/gomlx/go-huggingface/tokenizers/api"
-	"strings"
 )
 
+// defaultMaxInputCharsPerWord is the WordPiece limit of characters per word used when tokenizer.json doesn't set one.
+const defaultMaxInputCharsPerWord = 100
+
 // tokenizeWordWithSpans tokenizes a single word and returns IDs with their offsets.
 func (t *Tokenizer) tokenizeWordWithSpans(word wordWithOffset) ([]int, []api.TokenSpan) {
+	return t.tokenizeWord(word, true)
+}
+
+// tokenizeWordIDs tokenizes a single word, without computing the offsets of the tokens.
+func (t *Tokenizer) tokenizeWordIDs(word wordWithOffset) []int {
+	ids, _ := t.tokenizeWord(word, false)
+	return ids
+}
+
+// tokenizeWord tokenizes a single word. The offsets of the tokens are only returned if withSpans is set.
+func (t *Tokenizer) tokenizeWord(word wordWithOffset, withSpans bool) ([]int, []api.TokenSpan) {
+	if t.trace != nil {
+		ids, spans := t.tokenizeWordImpl(word, withSpans)
+		t.tracef("model %s: word %q -> %v", t.tokenizer.Model.Type, word.text, ids)
+		return ids, spans
+	}
+	return t.tokenizeWordImpl(word, withSpans)
+}
+
+func (t *Tokenizer) tokenizeWordImpl(word wordWithOffset, withSpans bool) ([]int, []api.TokenSpan) {
 	// First check if word is an added token
 	if id, ok := t.addedTokens[word.text]; ok {
 		return []int{id}, []api.TokenSpan{{Start: word.start, End: word.end}}
@@ -14,13 +41,16 @@ func (t *Tokenizer) tokenizeWordWithSpans(word wordWithOffset) ([]int, []api.Tok
 
 	switch t.tokenizer.Model.Type {
 	case "WordPiece":
-		return t.wordPieceTokenizeWithSpans(word)
+		return t.wordPieceTokenizeWithSpans(word, withSpans)
 	case "BPE":
-		return t.bpeTokenizeWithSpans(word)
+		return t.bpeTokenizeWithSpans(word, withSpans)
 	case "Unigram":
-		return t.unigramTokenizeWithSpans(word)
+		return t.unigramTokenizeWithSpans(word, withSpans)
 	default:
 		// Fallback: try to find word in vocab
+		if t.trace != nil {
+			t.tracef("model %q: not supported, looking up the whole word in the vocabulary", t.tokenizer.Model.Type)
+		}
 		if id, ok := t.tokenizer.Model.Vocab[word.text]; ok {
 			return []int{id}, []api.TokenSpan{{Start: word.start, End: word.end}}
 		}
@@ -31,22 +61,15 @@ func (t *Tokenizer) tokenizeWordWithSpans(word wordWithOffset) ([]int, []api.Tok
 	}
 }
 
-// wordPieceTokenizeWithSpans implements WordPiece tokenization with offset tracking.
-func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset) ([]int, []api.TokenSpan) {
+// wordPieceTokenizeWithSpans implements WordPiece tokenization, with offset tracking if withSpans is set.
+func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset, withSpans bool) ([]int, []api.TokenSpan) {
 	text := word.text
 	if text == "" {
 		return nil, nil
 	}
 
-	maxChars := t.tokenizer.Model.MaxInputCharsPerWord
-	if maxChars == 0 {
-		maxChars = 100
-	}
-	if len(text) > maxChars {
-		if t.unkID >= 0 {
-			return []int{t.unkID}, []api.TokenSpan{{Start: word.start, End: word.end}}
-		}
-		return nil, nil
+	if t.maxInputCharsPerWord > 0 && utf8.RuneCountInString(text) > t.maxInputCharsPerWord {
+		return t.unknownTokens(text, api.TokenSpan{Start: word.start, End: word.end})
 	}
 
 	prefix := t.tokenizer.Model.ContinuingSubwordPrefix
@@ -59,6 +82,10 @@ func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset) ([]int, []ap
 	runes := []rune(text)
 	start := 0
 	charLen := len(runes)
+	var byteOffsets []int
+	if withSpans {
+		byteOffsets = runeByteOffsets(text)
+	}
 
 	for start < charLen {
 		end := charLen
@@ -72,17 +99,12 @@ func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset) ([]int, []ap
 
 			if id, ok := t.tokenizer.Model.Vocab[substr]; ok {
 				ids = append(ids, id)
-
-				// Calculate character offsets for this subword
-				// Map from rune position to byte position within the word
-				startByte := len(string(runes[:start]))
-				endByte := len(string(runes[:end]))
-
-				// Add the word's start offset to get positions in original text
-				origStart := word.start + startByte
-				origEnd := word.start + endByte
-
-				offsets = append(offsets, api.TokenSpan{Start: origStart, End: origEnd})
+				if withSpans {
+					// Map from rune position to byte position within the word, and add the word's start offset
+					// to get positions in original text.
+					offsets = append(offsets, api.TokenSpan{
+						Start: word.start + byteOffsets[start], End: word.start + byteOffsets[end]})
+				}
 				found = true
 				break
 			}
@@ -90,10 +112,7 @@ func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset) ([]int, []ap
 		}
 
 		if !found {
-			if t.unkID >= 0 {
-				return []int{t.unkID}, []api.TokenSpan{{Start: word.start, End: word.end}}
-			}
-			return nil, nil
+			return t.unknownTokens(text, api.TokenSpan{Start: word.start, End: word.end})
 		}
 		start = end
 	}
@@ -101,32 +120,37 @@ func (t *Tokenizer) wordPieceTokenizeWithSpans(word wordWithOffset) ([]int, []ap
 	return ids, offsets
 }
 
-// bpeTokenizeWithSpans implements BPE tokenization with offset tracking.
-func (t *Tokenizer) bpeTokenizeWithSpans(word wordWithOffset) ([]int, []api.TokenSpan) {
+// bpeTokenizeWithSpans implements BPE tokenization, with offset tracking if withSpans is set.
+//
+// The symbols are kept in a doubly-linked list, and the candidate merges in a priority queue ordered by merge rank
+// (and position, so the leftmost pair is merged first on ties), so each merge costs O(log n).
+func (t *Tokenizer) bpeTokenizeWithSpans(word wordWithOffset, withSpans bool) ([]int, []api.TokenSpan) {
 	text := word.text
 	if text == "" {
 		return nil, nil
 	}
-
-	// Convert word to list of symbols with their character positions (rune indices)
-	type symbolWithPos struct {
-		text  string
-		start int // rune position in word
-		end   int // rune position in word
+	if t.tokenizer.Model.IgnoreMerges {
+		if id, ok := t.tokenizer.Model.Vocab[text]; ok {
+			return []int{id}, []api.TokenSpan{{Start: word.start, End: word.end}}
+		}
 	}
 
+	// Convert word to a list of symbols with their character positions (rune indices).
 	runes := []rune(text)
-	symbols := make([]symbolWithPos, len(runes))
+	symbols := make([]bpeSymbol, len(runes))
 	for i, r := range runes {
-		symbols[i] = symbolWithPos{
+		symbols[i] = bpeSymbol{
 			text:  string(r),
 			start: i,
 			end:   i + 1,
+			prev:  i - 1,
+			next:  i + 1,
 		}
 	}
+	symbols[len(symbols)-1].next = -1
 
 	// Add end-of-word suffix if configured
-	if t.tokenizer.Model.EndOfWordSuffix != "" && len(symbols) > 0 {
+	if t.tokenizer.Model.EndOfWordSuffix != "" {
 		symbols[len(symbols)-1].text += t.tokenizer.Model.EndOfWordSuffix
 	}
 
@@ -138,124 +162,287 @@ func (t *Tokenizer) bpeTokenizeWithSpans(word wordWithOffset) ([]int, []api.Toke
 	}
 
 	// Apply BPE merges
-	for len(symbols) > 1 {
-		// Find best pair to merge
-		bestPair := ""
-		bestRank := -1
-		bestIdx := -1
-
-		for i := 0; i < len(symbols)-1; i++ {
-			pair := symbols[i].text + " " + symbols[i+1].text
-			if rank, ok := t.mergeRanks[pair]; ok {
-				if bestRank == -1 || rank < bestRank {
-					bestPair = pair
-					bestRank = rank
-					bestIdx = i
-				}
-			}
+	queue := &bpeMergeQueue{}
+	pushMerge := func(left int) {
+		if left < 0 || symbols[left].next < 0 {
+			return
 		}
-
-		if bestIdx == -1 {
-			break // No more merges possible
+		right := symbols[left].next
+		if rank, ok := t.mergeRanks[symbols[left].text+" "+symbols[right].text]; ok {
+			heap.Push(queue, bpeMerge{rank: rank, left: left, right: right,
+				leftEnd: symbols[left].end, rightEnd: symbols[right].end})
 		}
-
-		// Apply the merge
-		merged := strings.Replace(bestPair, " ", "", 1)
-		newSymbols := make([]symbolWithPos, 0, len(symbols)-1)
-		newSymbols = append(newSymbols, symbols[:bestIdx]...)
-		newSymbols = append(newSymbols, symbolWithPos{
-			text:  merged,
-			start: symbols[bestIdx].start,
-			end:   symbols[bestIdx+1].end,
-		})
-		newSymbols = append(newSymbols, symbols[bestIdx+2:]...)
-		symbols = newSymbols
+	}
+	for i := range len(symbols) - 1 {
+		pushMerge(i)
+	}
+	for queue.Len() > 0 {
+		m := heap.Pop(queue).(bpeMerge)
+		left, right := &symbols[m.left], &symbols[m.right]
+		if left.removed || right.removed || left.next != m.right || left.end != m.leftEnd || right.end != m.rightEnd {
+			continue // Stale: one of the symbols has been merged since.
+		}
+		left.text += right.text
+		left.end = right.end
+		left.next = right.next
+		if right.next >= 0 {
+			symbols[right.next].prev = m.left
+		}
+		right.removed = true
+		pushMerge(left.prev)
+		pushMerge(m.left)
 	}
 
 	// Convert symbols to IDs with offsets
+	var byteOffsets []int
+	if withSpans && word.runeOffsets == nil {
+		byteOffsets = runeByteOffsets(text)
+	}
 	var ids []int
 	var offsets []api.TokenSpan
+	fusable := false // Whether the last token is an unknown token that can be fused with the next one.
+
+	for i := 0; i >= 0; i = symbols[i].next {
+		sym := symbols[i]
+
+		var span api.TokenSpan
+		if withSpans {
+			if word.runeOffsets != nil {
+				span = api.TokenSpan{Start: word.runeOffsets[sym.start], End: word.runeOffsets[sym.end-1] + 1}
+			} else {
+				// Map from rune position to byte position, and add the word's start offset to get positions in
+				// original text.
+				span = api.TokenSpan{Start: word.start + byteOffsets[sym.start], End: word.start + byteOffsets[sym.end]}
+			}
+		}
 
-	for _, sym := range symbols {
 		if id, ok := t.tokenizer.Model.Vocab[sym.text]; ok {
 			ids = append(ids, id)
-		} else if t.unkID >= 0 {
-			ids = append(ids, t.unkID)
-		} else {
+			if withSpans {
+				offsets = append(offsets, span)
+			}
+			fusable = false
+			continue
+		}
+		unkIDs, unkSpans := t.unknownTokens(sym.text, span)
+		isUnk := len(unkIDs) == 1 && unkIDs[0] == t.unkID
+		if isUnk && fusable {
+			// "fuse_unk": extend the previous unknown token.
+			if withSpans {
+				offsets[len(offsets)-1].End = span.End
+			}
 			continue
 		}
+		ids = append(ids, unkIDs...)
+		if withSpans {
+			offsets = append(offsets, unkSpans...)
+		}
+		fusable = isUnk && t.tokenizer.Model.FuseUnk
+	}
 
-		// Calculate offsets - map from rune position to byte position
-		startByte := len(string(runes[:sym.start]))
-		endByte := len(string(runes[:sym.end]))
+	return ids, offsets
+}
 
-		// Add the word's start offset to get positions in original text
-		origStart := word.start + startByte
-		origEnd := word.start + endByte
+// bpeSymbol is a node of the doubly-linked list of symbols of a word being merged by BPE.
+type bpeSymbol struct {
+	text       string
+	start, end int // rune positions in the word
+	prev, next int // indices of the neighbor symbols, -1 if none
+	removed    bool
+}
+
+// bpeMerge is a candidate merge of the symbols left and right. leftEnd and rightEnd identify the symbols at the
+// time it was queued, so stale candidates can be discarded.
+type bpeMerge struct {
+	rank, left, right int
+	leftEnd, rightEnd int
+}
 
-		offsets = append(offsets, api.TokenSpan{Start: origStart, End: origEnd})
+// bpeMergeQueue is a min-heap of candidate merges, by rank and then position.
+type bpeMergeQueue []bpeMerge
+
+func (q bpeMergeQueue) Len() int { return len(q) }
+func (q bpeMergeQueue) Less(i, j int) bool {
+	if q[i].rank != q[j].rank {
+		return q[i].rank < q[j].rank
 	}
+	return q[i].left < q[j].left
+}
+func (q bpeMergeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *bpeMergeQueue) Push(x any)   { *q = append(*q, x.(bpeMerge)) }
+func (q *bpeMergeQueue) Pop() any {
+	old := *q
+	m := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return m
+}
 
-	return ids, offsets
+// unigramUnkPenalty is subtracted from the lowest score in the vocabulary to score unknown characters, as in
+// HuggingFace tokenizers and SentencePiece.
+const unigramUnkPenalty = 10.0
+
+// initUnigram computes the score of unknown characters and the maximum token length for Unigram models.
+func (t *Tokenizer) initUnigram() {
+	minScore := 0.0
+	for _, score := range t.tokenizer.Model.vocabScores {
+		minScore = min(minScore, score)
+	}
+	t.unigramUnkScore = minScore - unigramUnkPenalty
+	for token := range t.tokenizer.Model.Vocab {
+		t.unigramMaxPieceLen = max(t.unigramMaxPieceLen, len(token))
+	}
 }
 
-// unigramTokenizeWithSpans implements Unigram tokenization with offset tracking.
-func (t *Tokenizer) unigramTokenizeWithSpans(word wordWithOffset) ([]int, []api.TokenSpan) {
+// unigramTokenizeWithSpans implements Unigram tokenization, with offset tracking if withSpans is set.
+//
+// It runs the Viterbi algorithm over the lattice of all the vocabulary entries that match the word, selecting
+// the segmentation with the highest total score (sum of log-probabilities). Characters not covered by any entry
+// are scored with a penalty, and consecutive unknown characters are fused into one unknown token (or byte tokens,
+// see unknownTokens).
+func (t *Tokenizer) unigramTokenizeWithSpans(word wordWithOffset, withSpans bool) ([]int, []api.TokenSpan) {
 	text := word.text
 	if text == "" {
 		return nil, nil
 	}
 
+	// charStart[pos] is true if pos is at a character boundary. Bytes that are not part of a valid UTF-8 encoding
+	// are 1-byte characters, so they are handled as unknown characters.
+	charStart := make([]bool, len(text)+1)
+	for pos := 0; pos < len(text); {
+		charStart[pos] = true
+		_, charLen := utf8.DecodeRuneInString(text[pos:])
+		pos += charLen
+	}
+	charStart[len(text)] = true
+
+	// best[pos] is the best segmentation of text[:pos], for pos at character boundaries, which ends with the token
+	// text[best[pos].start:pos].
+	type node struct {
+		score            float64
+		start            int
+		id               int
+		reached, unknown bool
+	}
+	vocab := t.tokenizer.Model.Vocab
+	scores := t.tokenizer.Model.vocabScores
+	best := make([]node, len(text)+1)
+	best[0].reached = true
+	for pos := range len(text) {
+		if !best[pos].reached || !charStart[pos] {
+			continue
+		}
+		_, charLen := utf8.DecodeRuneInString(text[pos:])
+		hasChar := false
+		for end := pos + 1; end <= min(len(text), pos+t.unigramMaxPieceLen); end++ {
+			if !charStart[end] {
+				continue
+			}
+			id, found := vocab[text[pos:end]]
+			if !found {
+				continue
+			}
+			if end == pos+charLen {
+				hasChar = true
+			}
+			score := best[pos].score + scores[text[pos:end]]
+			if !best[end].reached || score > best[end].score {
+				best[end] = node{score: score, start: pos, id: id, reached: true}
+			}
+		}
+		if !hasChar {
+			end := pos + charLen
+			score := best[pos].score + t.unigramUnkScore
+			if !best[end].reached || score > best[end].score {
+				best[end] = node{score: score, start: pos, id: t.unkID, reached: true, unknown: true}
+			}
+		}
+	}
+
+	// Backtrack from the end of the word, fusing consecutive unknown characters (see unknownTokens).
 	var ids []int
 	var offsets []api.TokenSpan
-	runes := []rune(text)
-	start := 0
-	runeLen := len(runes)
-
-	for start < runeLen {
-		end := runeLen
-		found := false
-
-		for end > start {
-			substr := string(runes[start:end])
-			if id, ok := t.tokenizer.Model.Vocab[substr]; ok {
-				ids = append(ids, id)
-
-				// Calculate offsets - map from rune position to byte position
-				startByte := len(string(runes[:start]))
-				endByte := len(string(runes[:end]))
+	unknown := -1 // Start of a run of unknown characters, -1 if none.
+	for end := len(text); end > 0; end = best[end].start {
+		n := best[end]
+		if !n.unknown {
+			ids = append(ids, n.id)
+			if withSpans {
+				offsets = append(offsets, api.TokenSpan{Start: word.start + n.start, End: word.start + end})
+			}
+			continue
+		}
+		if unknown < 0 {
+			unknown = end
+		}
+		if prev := best[n.start]; n.start == 0 || !prev.unknown {
+			// Start of the run of unknown characters.
+			unkIDs, unkSpans := t.unknownTokens(text[n.start:unknown],
+				api.TokenSpan{Start: word.start + n.start, End: word.start + unknown})
+			for i := len(unkIDs) - 1; i >= 0; i-- {
+				ids = append(ids, unkIDs[i])
+				if withSpans {
+					offsets = append(offsets, unkSpans[i])
+				}
+			}
+			unknown = -1
+		}
+	}
+	slices.Reverse(ids)
+	slices.Reverse(offsets)
+	return ids, offsets
+}
 
-				// Add the word's start offset to get positions in original text
-				origStart := word.start + startByte
-				origEnd := word.start + endByte
+// runeByteOffsets returns the byte offset in text of each of its runes, followed by len(text).
+func runeByteOffsets(text string) []int {
+	offsets := make([]int, 0, len(text)+1)
+	for pos := range text {
+		offsets = append(offsets, pos)
+	}
+	return append(offsets, len(text))
+}
 
-				offsets = append(offsets, api.TokenSpan{Start: origStart, End: origEnd})
-				found = true
-				start = end
+// unknownTokens returns the tokens for text (with the given span) not found in the vocabulary.
+//
+// If the model has "byte_fallback" set, and the vocabulary has the "<0xNN>" byte tokens, text is encoded as the
+// tokens of its UTF-8 bytes, all with the same span. Otherwise, it returns the unknown token, or nothing if there
+// is no unknown token.
+func (t *Tokenizer) unknownTokens(text string, span api.TokenSpan) ([]int, []api.TokenSpan) {
+	if t.byteTokenIDs != nil {
+		ids := make([]int, 0, len(text))
+		for i := 0; i < len(text); i++ {
+			id := t.byteTokenIDs[text[i]]
+			if id < 0 {
+				ids = nil
 				break
 			}
-			end--
+			ids = append(ids, id)
 		}
-
-		if !found {
-			// Single character fallback
-			char := string(runes[start])
-			startByte := len(string(runes[:start]))
-			endByte := len(string(runes[:start+1]))
-
-			// Add the word's start offset to get positions in original text
-			origStart := word.start + startByte
-			origEnd := word.start + endByte
-
-			if id, ok := t.tokenizer.Model.Vocab[char]; ok {
-				ids = append(ids, id)
-			} else if t.unkID >= 0 {
-				ids = append(ids, t.unkID)
+		if ids != nil {
+			spans := make([]api.TokenSpan, len(ids))
+			for i := range spans {
+				spans[i] = span
 			}
-			offsets = append(offsets, api.TokenSpan{Start: origStart, End: origEnd})
-			start++
+			return ids, spans
 		}
 	}
+	if t.unkID < 0 {
+		return nil, nil
+	}
+	return []int{t.unkID}, []api.TokenSpan{span}
+}
 
-	return ids, offsets
+// initByteFallback builds the table of the "<0xNN>" byte tokens used by models with "byte_fallback" set.
+// Bytes without a token in the vocabulary are mapped to -1.
+func (t *Tokenizer) initByteFallback() {
+	if !t.tokenizer.Model.ByteFallback {
+		return
+	}
+	t.byteTokenIDs = make([]int, 256)
+	for b := range t.byteTokenIDs {
+		id, found := t.tokenizer.Model.Vocab[fmt.Sprintf("<0x%02X>", b)]
+		if !found {
+			id = -1
+		}
+		t.byteTokenIDs[b] = id
+	}
 }
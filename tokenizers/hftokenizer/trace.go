@@ -0,0 +1,26 @@
+package hftokenizer
+
+import (
+	"fmt"
+	"io"
+)
+
+// WithTrace enables logging of the tokenization pipeline to w: which normalizer, pre-tokenizer, model,
+// post-processor and decoder cases are used, and whenever a default or approximate fallback is used (e.g. an
+// unsupported normalizer type that leaves the text unchanged).
+//
+// It is meant for debugging tokenizer.json files that don't behave as expected. Set it to nil (the default)
+// to disable tracing, in which case it has no cost. It returns the tokenizer itself, for chaining calls.
+func (t *Tokenizer) WithTrace(w io.Writer) *Tokenizer {
+	t.trace = w
+	return t
+}
+
+// tracef writes a line to the trace writer. Callers in hot paths should check t.trace != nil first, to avoid
+// the cost of boxing the arguments.
+func (t *Tokenizer) tracef(format string, args ...any) {
+	if t.trace == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(t.trace, "hftokenizer: "+format+"\n", args...)
+}
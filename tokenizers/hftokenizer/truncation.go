@@ -0,0 +1,150 @@
+package hftokenizer
+
+import (
+	"strings"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+)
+
+// Truncation strategies for sentence pairs, normalized to lower-case without underscores, so both the
+// tokenizer.json ("LongestFirst") and the Python ("longest_first") names are accepted.
+const (
+	truncateLongestFirst = "longestfirst"
+	truncateOnlyFirst    = "onlyfirst"
+	truncateOnlySecond   = "onlysecond"
+)
+
+// WithTruncation sets the maximum length of the encodings (including special tokens) and the strategy used to
+// truncate sentence pairs: "longest_first" (the default if empty), "only_first" or "only_second".
+// It overrides the "truncation" configuration of the tokenizer.json file, if any, but keeps its stride and
+// direction. A maxLen <= 0 disables truncation.
+//
+// EncodeOptions.MaxLen, if set, takes precedence over maxLen.
+//
+// It returns the tokenizer itself, for chaining calls.
+func (t *Tokenizer) WithTruncation(maxLen int, strategy string) *Tokenizer {
+	if maxLen <= 0 {
+		t.truncation = nil
+		return t
+	}
+	var truncation Truncation
+	if t.truncation != nil {
+		truncation = *t.truncation
+	}
+	truncation.MaxLength = maxLen
+	truncation.Strategy = strategy
+	t.truncation = &truncation
+	return t
+}
+
+// truncationConfig returns the maximum length (0 if no truncation is to be applied) and the truncation
+// configuration for the given options.
+func (t *Tokenizer) truncationConfig(options api.EncodeOptions) (maxLen int, truncation Truncation) {
+	if t.truncation != nil {
+		truncation = *t.truncation
+	}
+	maxLen = truncation.MaxLength
+	if options.MaxLen > 0 {
+		maxLen = options.MaxLen
+	}
+	return max(maxLen, 0), truncation
+}
+
+// initNumSpecialTokens counts the special tokens the post-processor adds to a single sequence and to a pair of
+// sequences, used by numSpecialTokensToAdd. It is called once the special tokens are resolved.
+func (t *Tokenizer) initNumSpecialTokens() {
+	ids, _, _ := t.applyPostProcessor(nil, nil)
+	t.numSpecialTokens = len(ids)
+	t.numSpecialTokensPair = len(t.applyPostProcessorPair(nil, nil, nil, nil).ids)
+}
+
+// numSpecialTokensToAdd returns the number of special tokens the post-processor adds to a single sequence,
+// or to a pair of sequences, if addSpecialTokens is set.
+func (t *Tokenizer) numSpecialTokensToAdd(pair, addSpecialTokens bool) int {
+	if !addSpecialTokens {
+		return 0
+	}
+	if pair {
+		return t.numSpecialTokensPair
+	}
+	return t.numSpecialTokens
+}
+
+// truncate cuts the encoding (before post-processing) so that, with the special tokens added by the post-processor,
+// it has at most options.MaxLen tokens (or the length configured with WithTruncation). Tokens are dropped from the
+// end (or the start, if the truncation direction is "Left"), and recorded in NumTruncated and Overflowing.
+//
+// It is a no-op if no maximum length is set.
+func (t *Tokenizer) truncate(enc *api.AnnotatedEncoding, options api.EncodeOptions) {
+	maxLen, truncation := t.truncationConfig(options)
+	if maxLen == 0 {
+		return
+	}
+	truncateTo(enc, max(maxLen-t.numSpecialTokensToAdd(false, options.AddSpecialTokens), 0), truncation)
+}
+
+// truncatePair cuts the pair of encodings (before post-processing) so that, with the special tokens added by the
+// post-processor, together they have at most options.MaxLen tokens (or the length configured with WithTruncation).
+//
+// With the default HuggingFace "longest_first" strategy, tokens are removed from the longest sequence first.
+// With "only_first" or "only_second" only the corresponding sequence is truncated, and the pair may remain longer
+// than the maximum length if that is not enough.
+//
+// It is a no-op if no maximum length is set.
+func (t *Tokenizer) truncatePair(a, b *api.AnnotatedEncoding, options api.EncodeOptions) {
+	maxLen, truncation := t.truncationConfig(options)
+	if maxLen == 0 {
+		return
+	}
+	maxLen = max(maxLen-t.numSpecialTokensToAdd(true, options.AddSpecialTokens), 0)
+	lenA, lenB := len(a.IDs), len(b.IDs)
+	if lenA+lenB <= maxLen {
+		return
+	}
+	strategy := strings.ToLower(strings.ReplaceAll(truncation.Strategy, "_", ""))
+	switch strategy {
+	case truncateOnlyFirst:
+		lenA = max(maxLen-lenB, 0)
+	case truncateOnlySecond:
+		lenB = max(maxLen-lenA, 0)
+	default:
+		if strategy != "" && strategy != truncateLongestFirst {
+			t.tracef("truncation strategy %q: not supported, using \"longest_first\"", truncation.Strategy)
+		}
+		switch {
+		case lenB <= maxLen/2:
+			lenA = maxLen - lenB
+		case lenA <= maxLen/2:
+			lenB = maxLen - lenA
+		default:
+			lenB = maxLen / 2
+			lenA = maxLen - lenB
+		}
+	}
+	truncateTo(a, lenA, truncation)
+	truncateTo(b, lenB, truncation)
+}
+
+// truncateTo drops the tokens of enc beyond the first n -- or before the last n, if the truncation direction
+// is "Left". The dropped tokens, plus truncation.Stride tokens kept next to them, are appended to Overflowing.
+func truncateTo(enc *api.AnnotatedEncoding, n int, truncation Truncation) {
+	numDropped := len(enc.IDs) - n
+	if numDropped <= 0 {
+		return
+	}
+	stride := min(max(truncation.Stride, 0), n)
+	enc.NumTruncated += numDropped
+	if strings.EqualFold(truncation.Direction, "left") {
+		enc.Overflowing = append(enc.Overflowing, enc.IDs[:numDropped+stride]...)
+		enc.IDs = enc.IDs[numDropped:]
+		if len(enc.Spans) > n {
+			enc.Spans = enc.Spans[len(enc.Spans)-n:]
+		}
+		return
+	}
+	enc.Overflowing = append(enc.Overflowing, enc.IDs[n-stride:]...)
+	enc.IDs = enc.IDs[:n]
+	if len(enc.Spans) > n {
+		enc.Spans = enc.Spans[:n]
+	}
+}
@@ -2,21 +2,91 @@ package hftokenizer
 
 import (
 	"encoding/json"
-	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"io"
 	"regexp"
+	"sync"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/gomlx/go-huggingface/tokenizers/private/regexsplit"
+	"github.com/pkg/errors"
 )
 
 // TokenizerJSON represents the structure of HuggingFace's tokenizer.json file.
 type TokenizerJSON struct {
-	Version       string          `json:"version"`
-	Truncation    json.RawMessage `json:"truncation"`
-	Padding       json.RawMessage `json:"padding"`
-	AddedTokens   []AddedToken    `json:"added_tokens"`
-	Normalizer    *Normalizer     `json:"normalizer"`
-	PreTokenizer  *PreTokenizer   `json:"pre_tokenizer"`
-	PostProcessor *PostProcessor  `json:"post_processor"`
-	Decoder       *Decoder        `json:"decoder"`
-	Model         Model           `json:"model"`
+	Version       string         `json:"version"`
+	Truncation    *Truncation    `json:"truncation"`
+	Padding       *Padding       `json:"padding"`
+	AddedTokens   []AddedToken   `json:"added_tokens"`
+	Normalizer    *Normalizer    `json:"normalizer"`
+	PreTokenizer  *PreTokenizer  `json:"pre_tokenizer"`
+	PostProcessor *PostProcessor `json:"post_processor"`
+	Decoder       *Decoder       `json:"decoder"`
+	Model         Model          `json:"model"`
+}
+
+// Truncation represents the truncation configuration.
+// It is null in most tokenizer.json files, in which case no truncation is applied by default.
+type Truncation struct {
+	MaxLength int `json:"max_length"`
+
+	// Strategy used when truncating pairs: "LongestFirst" (the default), "OnlyFirst" or "OnlySecond".
+	// The Python names ("longest_first", "only_first", "only_second") are also accepted.
+	Strategy string `json:"strategy"`
+
+	// Stride is the number of tokens kept in the encoding that are also included in the overflowing tokens.
+	Stride int `json:"stride"`
+
+	// Direction from which tokens are dropped: "Right" (the default) or "Left".
+	Direction string `json:"direction"`
+}
+
+// Padding represents the padding configuration, used by Tokenizer.EncodeBatch.
+type Padding struct {
+	Strategy PaddingStrategy `json:"strategy"`
+
+	// Direction where the padding is added: "Right" (the default) or "Left".
+	Direction string `json:"direction"`
+
+	// PadToMultipleOf, if > 0, rounds up the padded length to a multiple of it.
+	PadToMultipleOf int `json:"pad_to_multiple_of"`
+
+	PadID     int    `json:"pad_id"`
+	PadTypeID int    `json:"pad_type_id"`
+	PadToken  string `json:"pad_token"`
+}
+
+// PaddingStrategy is either "BatchLongest" (pad to the longest sequence in the batch), represented by Fixed == 0,
+// or {"Fixed": length}.
+type PaddingStrategy struct {
+	Fixed int
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the two formats of the padding strategy.
+func (s *PaddingStrategy) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		if name != "BatchLongest" {
+			return errors.Errorf("unknown padding strategy %q", name)
+		}
+		s.Fixed = 0
+		return nil
+	}
+	var fixed struct {
+		Fixed int `json:"Fixed"`
+	}
+	if err := json.Unmarshal(data, &fixed); err != nil {
+		return errors.Wrapf(err, "failed to parse padding strategy %s", data)
+	}
+	s.Fixed = fixed.Fixed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing the same format read by UnmarshalJSON.
+func (s PaddingStrategy) MarshalJSON() ([]byte, error) {
+	if s.Fixed == 0 {
+		return json.Marshal("BatchLongest")
+	}
+	return json.Marshal(map[string]int{"Fixed": s.Fixed})
 }
 
 // AddedToken represents a special token added to the vocabulary.
@@ -41,6 +111,14 @@ type Normalizer struct {
 	Pattern            *Pattern     `json:"pattern"`
 	Normalizers        []Normalizer `json:"normalizers"`
 	Content            string       `json:"content"`
+	StripLeft          bool         `json:"strip_left"`
+	StripRight         bool         `json:"strip_right"`
+
+	// PrecompiledCharsmap is the base64 encoded SentencePiece normalization rules of Precompiled normalizers.
+	PrecompiledCharsmap string `json:"precompiled_charsmap"`
+
+	compiled *regexp.Regexp       // Compiled Pattern.Regex of Replace normalizers, see compileNormalizer.
+	charsmap *precompiledCharsmap // Parsed PrecompiledCharsmap, see compileNormalizer.
 }
 
 // Pattern for regex-based operations.
@@ -60,6 +138,12 @@ type PreTokenizer struct {
 	Replacement    string         `json:"replacement"`
 	PrependScheme  string         `json:"prepend_scheme"`
 	Split          *bool          `json:"split"`
+
+	// TrimOffsets is used by ByteLevel pre-tokenizers: if set, the spans of the tokens exclude their leading and
+	// trailing spaces ("Ġ").
+	TrimOffsets bool `json:"trim_offsets"`
+
+	compiled *regexsplit.Pattern // Compiled Pattern of Split pre-tokenizers, see compilePreTokenizer.
 }
 
 // PostProcessor represents the post-processor configuration.
@@ -107,6 +191,15 @@ type Decoder struct {
 	Replacement   string         `json:"replacement"`
 	PrependScheme string         `json:"prepend_scheme"`
 	Split         bool           `json:"split"`
+
+	// Cleanup is used by the WordPiece decoder: whether to remove the spaces before punctuation and in
+	// contractions. It defaults to true.
+	Cleanup *bool `json:"cleanup"`
+
+	// Start and Stop are used by the Strip decoder: the maximum number of Content characters removed from the
+	// start and the end of each token.
+	Start int `json:"start"`
+	Stop  int `json:"stop"`
 }
 
 // Model represents the tokenizer model (WordPiece, BPE, or Unigram).
@@ -121,6 +214,16 @@ type Model struct {
 	ByteFallback            bool           `json:"byte_fallback"`
 	Dropout                 *float64       `json:"dropout"`
 	EndOfWordSuffix         string         `json:"end_of_word_suffix"`
+
+	// IgnoreMerges is used by BPE models: if set, words that are in the vocabulary are emitted directly, without
+	// applying the merges.
+	IgnoreMerges bool `json:"ignore_merges"`
+
+	// UnkID is the id of the unknown token, used by Unigram models instead of UnkToken.
+	UnkID *int `json:"unk_id"`
+
+	// vocabScores are the log-probabilities of the tokens of Unigram models, used by the Viterbi segmentation.
+	vocabScores map[string]float64
 }
 
 // Tokenizer implements the api.Tokenizer interface for HuggingFace tokenizer.json files.
@@ -142,9 +245,55 @@ type Tokenizer struct {
 	// Added tokens lookup (content -> id)
 	addedTokens map[string]int
 
+	// cleanUpSpaces, if set, overrides whether Decode cleans up the tokenization spaces.
+	// See WithCleanUpTokenizationSpaces.
+	cleanUpSpaces *bool
+
+	// specialIDs holds the ids of the added tokens marked as special, skipped by DecodeWithOptions.
+	specialIDs map[int]bool
+
 	options api.EncodeOptions
 
-	// addedTokensSorted lists added tokens sorted longest-first for greedy
-	// matching when splitting input text. Derived from addedTokens at construction.
-	addedTokensSorted []addedTokenEntry
+	// truncation configuration, used when options.MaxLen is not set. See WithTruncation.
+	truncation *Truncation
+
+	// maxInputCharsPerWord is the WordPiece limit of characters per word, above which the word is
+	// mapped to the unknown token. 0 means unlimited. See WithMaxInputChars.
+	maxInputCharsPerWord int
+
+	// addedTokensTrie is used to find the added tokens when splitting input text. Derived from
+	// addedTokens at construction, and nil if there are no added tokens.
+	addedTokensTrie *addedTokensTrie
+
+	// vocabFastPath indicates that inputs that are a single vocabulary entry can skip the full pipeline.
+	// See supportsVocabFastPath.
+	vocabFastPath bool
+
+	// numSpecialTokens and numSpecialTokensPair are the number of special tokens the post-processor adds to a single
+	// sequence and to a pair of sequences. See initNumSpecialTokens.
+	numSpecialTokens, numSpecialTokensPair int
+
+	// trimOffsets is set if the ByteLevel pre-tokenizer has trim_offsets set, see trimByteLevelSpans.
+	trimOffsets bool
+
+	// maxParallelization is the maximum number of texts encoded in parallel by EncodeBatch.
+	// See WithMaxParallelization.
+	maxParallelization int
+
+	// unigramUnkScore is the score of an unknown character for Unigram models, and unigramMaxPieceLen the length
+	// in bytes of its longest vocabulary entry. See initUnigram.
+	unigramUnkScore    float64
+	unigramMaxPieceLen int
+
+	// byteTokenIDs maps bytes to the ids of their "<0xNN>" tokens, for models with "byte_fallback" set.
+	// See initByteFallback.
+	byteTokenIDs []int
+
+	// trace, if not nil, is where the pipeline log is written. See WithTrace.
+	trace io.Writer
+
+	// vocab is the merged vocabulary (model vocabulary and added tokens) returned by GetVocabRef, built lazily
+	// with vocabOnce. Reset by AddTokens.
+	vocabOnce sync.Once
+	vocab     map[string]int
 }
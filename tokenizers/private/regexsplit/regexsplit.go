@@ -0,0 +1,209 @@
+// Package regexsplit finds the matches of the regular expressions used to split text by tokenizers (e.g. the
+// Split pre-tokenizer of HuggingFace tokenizers, or the tiktoken patterns), emulating the trailing negative
+// lookaheads that Go's regexp doesn't support.
+package regexsplit
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Pattern matches a regular expression used to split text.
+//
+// Go's regexp (RE2) doesn't support lookarounds, but the GPT-2 and Llama 3 patterns use a trailing negative lookahead
+// in one of their alternatives (`\s+(?!\S)`). For those patterns the top-level alternatives are matched one at a time,
+// in order, at each position (the leftmost-first semantics of the original PCRE/Oniguruma regex), and the
+// lookahead is emulated by backtracking the alternative to shorter matches until the lookahead doesn't match.
+type Pattern struct {
+	// re is used when the pattern is supported by Go's regexp.
+	re *regexp.Regexp
+
+	// alternatives are used otherwise.
+	alternatives []splitAlternative
+}
+
+// splitAlternative is one top-level alternative of an emulated Pattern.
+type splitAlternative struct {
+	body      *regexp.Regexp // Anchored at the start.
+	exact     *regexp.Regexp // Anchored at both ends, used when backtracking: only set with a lookahead.
+	lookahead *regexp.Regexp // The trailing negative lookahead, anchored at the start, or nil.
+}
+
+// Compile compiles the regular expression.
+//
+// Go's regexp syntax is supported, plus trailing negative lookaheads ("(?!...)") at the end of top-level
+// alternatives.
+func Compile(regex string) (*Pattern, error) {
+	re, err := regexp.Compile(regex)
+	if err == nil {
+		return &Pattern{re: re}, nil
+	}
+	if !strings.Contains(regex, "(?!") {
+		return nil, errors.Wrapf(err, "failed to compile pattern %q", regex)
+	}
+
+	// Emulate the trailing negative lookaheads.
+	p := &Pattern{}
+	for _, alternative := range splitTopLevelAlternatives(regex) {
+		var lookahead string
+		if start := strings.LastIndex(alternative, "(?!"); start >= 0 {
+			depths := patternDepths(alternative)
+			if depths[start] == 0 && groupEnd(alternative, depths, start) == len(alternative)-1 {
+				lookahead = alternative[start+3 : len(alternative)-1]
+				alternative = alternative[:start]
+			}
+		}
+		var alt splitAlternative
+		if alt.body, err = regexp.Compile(`^(?:` + alternative + `)`); err != nil {
+			return nil, errors.Wrapf(err, "failed to compile pattern %q: only trailing negative lookaheads (?!...) are supported", regex)
+		}
+		if lookahead != "" {
+			if alt.lookahead, err = regexp.Compile(`^(?:` + lookahead + `)`); err != nil {
+				return nil, errors.Wrapf(err, "failed to compile lookahead of pattern %q", regex)
+			}
+			alt.exact = regexp.MustCompile(`^(?:` + alternative + `)$`)
+		}
+		p.alternatives = append(p.alternatives, alt)
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile, but panics on errors.
+func MustCompile(regex string) *Pattern {
+	p, err := Compile(regex)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// FindAllIndex returns the [start, end) of all successive non-overlapping matches in text, like
+// regexp.Regexp.FindAllStringIndex.
+func (p *Pattern) FindAllIndex(text string) [][]int {
+	if p.re != nil {
+		return p.re.FindAllStringIndex(text, -1)
+	}
+	var matches [][]int
+	for pos := 0; pos < len(text); {
+		if end := p.matchAt(text, pos); end > pos {
+			matches = append(matches, []int{pos, end})
+			pos = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		pos += size
+	}
+	return matches
+}
+
+// matchAt returns the end of the match starting at pos, or -1 if there is none.
+func (p *Pattern) matchAt(text string, pos int) int {
+	for _, alt := range p.alternatives {
+		loc := alt.body.FindStringIndex(text[pos:])
+		if loc == nil {
+			continue
+		}
+		end := pos + loc[1]
+		if alt.lookahead == nil {
+			return end
+		}
+		for {
+			if !alt.lookahead.MatchString(text[end:]) {
+				return end
+			}
+			if end == pos {
+				break
+			}
+			// Backtrack to the next shorter match.
+			for end > pos {
+				_, size := utf8.DecodeLastRuneInString(text[pos:end])
+				end -= size
+				if alt.exact.MatchString(text[pos:end]) {
+					break
+				}
+			}
+			if end == pos && !alt.exact.MatchString("") {
+				break
+			}
+		}
+	}
+	return -1
+}
+
+// patternDepths returns the parentheses nesting depth of each byte of the regular expression, or -1 for bytes that
+// are escaped or within a character class, and hence not structural.
+func patternDepths(pattern string) []int {
+	depths := make([]int, len(pattern))
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			depths[i] = -1
+			if i+1 < len(pattern) {
+				i++
+				depths[i] = -1
+			}
+			continue
+		case '[':
+			// Skip the character class, including a leading "^", a leading literal "]", escapes and
+			// "[:name:]" classes.
+			start := i
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				i++
+			}
+			if i < len(pattern) && pattern[i] == ']' {
+				i++
+			}
+			for i < len(pattern) && pattern[i] != ']' {
+				if pattern[i] == '\\' {
+					i++
+				} else if strings.HasPrefix(pattern[i:], "[:") {
+					if end := strings.Index(pattern[i:], ":]"); end >= 0 {
+						i += end + 1
+					}
+				}
+				i++
+			}
+			for j := start; j <= i && j < len(pattern); j++ {
+				depths[j] = -1
+			}
+			continue
+		case '(':
+			depths[i] = depth
+			depth++
+			continue
+		case ')':
+			depth--
+		}
+		depths[i] = depth
+	}
+	return depths
+}
+
+// groupEnd returns the position of the parenthesis closing the group opened at start, or -1.
+func groupEnd(pattern string, depths []int, start int) int {
+	for i := start + 1; i < len(pattern); i++ {
+		if pattern[i] == ')' && depths[i] == depths[start] {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitTopLevelAlternatives splits the regular expression on its top-level "|".
+func splitTopLevelAlternatives(pattern string) []string {
+	depths := patternDepths(pattern)
+	var alternatives []string
+	start := 0
+	for i := range len(pattern) {
+		if pattern[i] == '|' && depths[i] == 0 {
+			alternatives = append(alternatives, pattern[start:i])
+			start = i + 1
+		}
+	}
+	return append(alternatives, pattern[start:])
+}
@@ -4,9 +4,11 @@ import (
 	"github.com/gomlx/go-huggingface/tokenizers/api"
 )
 
-// applyPostProcessor applies the post_processor to add special tokens.
-// It injects configured bos/eos tokens from tokenizer_config.json.
-func (t *Tokenizer) applyPostProcessor(ids []int, spans []api.TokenSpan) ([]int, []api.TokenSpan, []int) {
+// applyPostProcessor adds the BOS and EOS tokens, if requested and defined by the model, unless already present.
+// Their spans are empty, at the start and at the end (textLen) of the text.
+//
+// It also returns the special tokens mask.
+func (t *Tokenizer) applyPostProcessor(ids []int, spans []api.TokenSpan, textLen int, addBOS, addEOS bool) ([]int, []api.TokenSpan, []int) {
 	outIDs := ids
 	outSpans := spans
 	var outSpecial []int
@@ -16,24 +18,22 @@ func (t *Tokenizer) applyPostProcessor(ids []int, spans []api.TokenSpan) ([]int,
 		outSpecial = make([]int, len(outIDs))
 	}
 
-	if t.config != nil {
-		if t.config.AddBosToken && t.Info.BeginningOfSentenceID >= 0 {
-			if len(outIDs) == 0 || outIDs[0] != t.Info.BeginningOfSentenceID {
-				outIDs = append([]int{t.Info.BeginningOfSentenceID}, outIDs...)
-				if spans != nil {
-					outSpans = append([]api.TokenSpan{{Start: -1, End: -1}}, outSpans...)
-				}
-				outSpecial = append([]int{1}, outSpecial...)
+	if addBOS && t.Info.BeginningOfSentenceID >= 0 {
+		if len(outIDs) == 0 || outIDs[0] != t.Info.BeginningOfSentenceID {
+			outIDs = append([]int{t.Info.BeginningOfSentenceID}, outIDs...)
+			if spans != nil {
+				outSpans = append([]api.TokenSpan{{Start: 0, End: 0}}, outSpans...)
 			}
+			outSpecial = append([]int{1}, outSpecial...)
 		}
-		if t.config.AddEosToken && t.Info.EndOfSentenceID >= 0 {
-			if len(outIDs) == 0 || outIDs[len(outIDs)-1] != t.Info.EndOfSentenceID {
-				outIDs = append(outIDs, t.Info.EndOfSentenceID)
-				if spans != nil {
-					outSpans = append(outSpans, api.TokenSpan{Start: -1, End: -1})
-				}
-				outSpecial = append(outSpecial, 1)
+	}
+	if addEOS && t.Info.EndOfSentenceID >= 0 {
+		if len(outIDs) == 0 || outIDs[len(outIDs)-1] != t.Info.EndOfSentenceID {
+			outIDs = append(outIDs, t.Info.EndOfSentenceID)
+			if spans != nil {
+				outSpans = append(outSpans, api.TokenSpan{Start: textLen, End: textLen})
 			}
+			outSpecial = append(outSpecial, 1)
 		}
 	}
 
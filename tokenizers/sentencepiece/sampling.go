@@ -0,0 +1,183 @@
+package sentencepiece
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	esentencepiece "github.com/eliben/go-sentencepiece"
+	"github.com/gomlx/go-huggingface/tokenizers/sentencepiece/private/protos"
+)
+
+// EncodeSample is like Encode, but samples one of the possible segmentations of the text, as SentencePiece's
+// subword regularization (enable_sampling): for robustness when training, the same text is presented with
+// different segmentations.
+//
+// The underlying Processor only supports BPE models and has no sampling API, so this is implemented here as
+// BPE-dropout, what SentencePiece itself does for BPE models: each merge is skipped with probability alpha.
+// With alpha <= 0 the result is the same as Encode, with alpha >= 1 the text is split into characters
+// (or user-defined symbols).
+//
+// The sampling is deterministic for a given rng state, so seed it for reproducibility. If rng is nil, the global
+// random source is used.
+func (t *Tokenizer) EncodeSample(text string, alpha float64, rng *rand.Rand) []int {
+	if alpha <= 0 {
+		return t.Encode(text)
+	}
+	t.samplerOnce.Do(t.buildSampler)
+	float64Fn := rand.Float64
+	if rng != nil {
+		float64Fn = rng.Float64
+	}
+	sample := func(segment string) []esentencepiece.Token {
+		return t.sampler.encode(segment, alpha, float64Fn)
+	}
+	ids, _, _ := t.encodeCore(text, false, sample)
+	return ids
+}
+
+// bpeSampler implements BPE encoding with dropout, following the same rules as Processor.Encode.
+type bpeSampler struct {
+	pieces       map[string]int // Normal, user-defined and unused pieces.
+	reserved     map[string]int // Everything else, e.g. control, unknown and byte pieces.
+	scores       []float32
+	userDefined  []string // Sorted longest-first.
+	unknownID    int
+	byteFallback bool
+	byteIDs      [256]int
+	byteTexts    [256]string
+}
+
+// buildSampler is called once, at the first call to EncodeSample.
+func (t *Tokenizer) buildSampler() {
+	s := &bpeSampler{
+		pieces:       make(map[string]int),
+		reserved:     make(map[string]int),
+		scores:       t.scores,
+		unknownID:    t.Info.UnknownID,
+		byteFallback: t.model.GetTrainerSpec().GetByteFallback(),
+	}
+	for id, piece := range t.model.GetPieces() {
+		switch piece.GetType() {
+		case protos.ModelProto_SentencePiece_NORMAL, protos.ModelProto_SentencePiece_UNUSED:
+			s.pieces[piece.GetPiece()] = id
+		case protos.ModelProto_SentencePiece_USER_DEFINED:
+			s.pieces[piece.GetPiece()] = id
+			s.userDefined = append(s.userDefined, piece.GetPiece())
+		case protos.ModelProto_SentencePiece_BYTE:
+			s.reserved[piece.GetPiece()] = id
+			if b, ok := parseBytePiece(piece.GetPiece()); ok {
+				s.byteIDs[b] = id
+				s.byteTexts[b] = piece.GetPiece()
+			}
+		default:
+			s.reserved[piece.GetPiece()] = id
+		}
+	}
+	slices.SortStableFunc(s.userDefined, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	t.sampler = s
+}
+
+// parseBytePiece parses byte pieces of the form "<0xXY>".
+func parseBytePiece(piece string) (byte, bool) {
+	if len(piece) != 6 || !strings.HasPrefix(piece, "<0x") || piece[5] != '>' {
+		return 0, false
+	}
+	var b byte
+	for _, c := range piece[3:5] {
+		b <<= 4
+		switch {
+		case c >= '0' && c <= '9':
+			b |= byte(c - '0')
+		case c >= 'A' && c <= 'F':
+			b |= byte(c-'A') + 10
+		case c >= 'a' && c <= 'f':
+			b |= byte(c-'a') + 10
+		default:
+			return 0, false
+		}
+	}
+	return b, true
+}
+
+// encode the text (without control symbols) with BPE-dropout: the best (highest score, leftmost) merge is
+// applied at each step, except that each candidate merge is dropped with probability alpha.
+// A dropped merge is only reconsidered if one of its symbols changes.
+//
+// This is quadratic on the length of the text, which is fine for the sentence-sized inputs used in training.
+func (s *bpeSampler) encode(text string, alpha float64, float64Fn func() float64) []esentencepiece.Token {
+	text = strings.ReplaceAll(text, " ", "▁")
+	type symbol struct {
+		text    string
+		noMerge bool // User-defined symbols are never merged.
+		dropped bool // Merge with the next symbol was dropped.
+	}
+	var symbols []symbol
+	for len(text) > 0 {
+		sym := symbol{}
+		for _, userDefined := range s.userDefined {
+			if strings.HasPrefix(text, userDefined) {
+				sym.text, sym.noMerge = userDefined, true
+				break
+			}
+		}
+		if sym.text == "" {
+			_, size := utf8.DecodeRuneInString(text)
+			sym.text = text[:size]
+		}
+		symbols = append(symbols, sym)
+		text = text[len(sym.text):]
+	}
+
+	for {
+		best, bestScore := -1, float32(0)
+		for i := 0; i+1 < len(symbols); i++ {
+			left, right := symbols[i], symbols[i+1]
+			if left.noMerge || right.noMerge || left.dropped {
+				continue
+			}
+			id, found := s.pieces[left.text+right.text]
+			if !found {
+				continue
+			}
+			if best < 0 || s.scores[id] > bestScore {
+				best, bestScore = i, s.scores[id]
+			}
+		}
+		if best < 0 {
+			break
+		}
+		if float64Fn() < alpha {
+			symbols[best].dropped = true
+			continue
+		}
+		symbols[best].text += symbols[best+1].text
+		symbols[best].dropped = false
+		if best > 0 {
+			symbols[best-1].dropped = false
+		}
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+
+	tokens := make([]esentencepiece.Token, 0, len(symbols))
+	for _, sym := range symbols {
+		id, found := s.reserved[sym.text]
+		if !found {
+			id, found = s.pieces[sym.text]
+		}
+		if !found && s.byteFallback {
+			for i := 0; i < len(sym.text); i++ {
+				b := sym.text[i]
+				tokens = append(tokens, esentencepiece.Token{ID: s.byteIDs[b], Text: s.byteTexts[b]})
+			}
+			continue
+		}
+		if !found {
+			id = s.unknownID
+		}
+		tokens = append(tokens, esentencepiece.Token{ID: id, Text: sym.text})
+	}
+	return tokens
+}
@@ -2,18 +2,33 @@
 package sentencepiece
 
 import (
+	"bytes"
+	"cmp"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 
 	esentencepiece "github.com/eliben/go-sentencepiece"
 	"github.com/gomlx/go-huggingface/hub"
 	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/gomlx/go-huggingface/tokenizers/sentencepiece/private/protos"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
 )
 
+func init() {
+	api.RegisterTokenizer("sentencepiece", func(repo *hub.Repo) bool { return repo.HasFile("tokenizer.model") }, New)
+}
+
 // New creates a SentencePiece tokenizer based on the "tokenizer.model" file, which must be a
 // SentencePiece Model proto (see protos.Model).
 //
-// It implements a tokenizer.TokenizerConstructor function signature.
+// The config (parsed from "tokenizer_config.json") controls whether BOS/EOS are added when encoding with
+// AddSpecialTokens: e.g. T5-style models append EOS without BOS (see api.Config.AddEosToken).
+// If config is nil, no special tokens are added.
+//
+// It implements the api.TokenizerConstructor function signature, and is registered with api.RegisterTokenizer.
 func New(config *api.Config, repo *hub.Repo) (api.Tokenizer, error) {
 	if !repo.HasFile("tokenizer.model") {
 		return nil, errors.Errorf("\"tokenizer.model\" file not found in repo")
@@ -22,18 +37,64 @@ func New(config *api.Config, repo *hub.Repo) (api.Tokenizer, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't download tokenizer.json file")
 	}
-	proc, err := esentencepiece.NewProcessorFromPath(tokenizerFile)
+	content, err := os.ReadFile(tokenizerFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read %q", tokenizerFile)
+	}
+	return NewFromContent(config, content)
+}
+
+// NewFromContent creates a SentencePiece tokenizer from the contents of a "tokenizer.model" file.
+// See New for details.
+func NewFromContent(config *api.Config, content []byte) (*Tokenizer, error) {
+	proc, err := esentencepiece.NewProcessor(bytes.NewReader(content))
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't create sentencepiece tokenizer")
 	}
-	return &Tokenizer{
+	var model protos.ModelProto
+	if err := proto.Unmarshal(content, &model); err != nil {
+		return nil, errors.Wrapf(err, "can't parse sentencepiece model proto")
+	}
+	t := &Tokenizer{
 		Processor: proc,
 		Info:      proc.ModelInfo(),
 		options: api.EncodeOptions{
 			AddSpecialTokens: true,
 		},
 		config: config,
-	}, nil
+		model:  &model,
+	}
+	t.scores = make([]float32, len(model.GetPieces()))
+	t.pieceToID = make(map[string]int, len(model.GetPieces()))
+	for id, piece := range model.GetPieces() {
+		t.scores[id] = piece.GetScore()
+		if _, found := t.pieceToID[piece.GetPiece()]; !found {
+			t.pieceToID[piece.GetPiece()] = id
+		}
+		switch piece.GetType() {
+		case protos.ModelProto_SentencePiece_USER_DEFINED:
+			t.addedTokens = append(t.addedTokens, AddedToken{ID: id, Content: piece.GetPiece()})
+		case protos.ModelProto_SentencePiece_CONTROL:
+			t.addedTokens = append(t.addedTokens, AddedToken{ID: id, Content: piece.GetPiece(), Special: true})
+			if piece.GetPiece() != "" {
+				t.controlSymbols = append(t.controlSymbols, AddedToken{ID: id, Content: piece.GetPiece(), Special: true})
+			}
+		}
+	}
+	// Longest first, for greedy matching.
+	slices.SortStableFunc(t.controlSymbols, func(a, b AddedToken) int {
+		return cmp.Compare(len(b.Content), len(a.Content))
+	})
+	return t, nil
+}
+
+// AddedToken is a SentencePiece user-defined or control symbol, the equivalent of the HuggingFace added tokens.
+type AddedToken struct {
+	ID      int
+	Content string
+
+	// Special is true for control symbols (e.g. "<s>", "</s>"), and false for user-defined symbols.
+	Special bool
 }
 
 // Tokenizer implements tokenizers.Tokenizer interface based on SentencePiece tokenizer by Google.
@@ -42,6 +103,34 @@ type Tokenizer struct {
 	Info      *esentencepiece.ModelInfo
 	options   api.EncodeOptions
 	config    *api.Config
+
+	// addedTokens are the user-defined and control symbols, sorted by id.
+	addedTokens []AddedToken
+
+	// controlSymbols are matched verbatim in the input text, sorted longest-first.
+	// User-defined symbols are matched by the Processor itself.
+	controlSymbols []AddedToken
+
+	// scores of the pieces, indexed by id.
+	scores []float32
+
+	// pieceToID maps all pieces of the model to their ids.
+	pieceToID map[string]int
+
+	// model is the parsed "tokenizer.model", used to build the sampler.
+	model *protos.ModelProto
+
+	// sampler is used by EncodeSample, and built on first use.
+	samplerOnce sync.Once
+	sampler     *bpeSampler
+}
+
+// AddedTokensList returns the user-defined and control symbols of the model, sorted by id.
+//
+// They are matched verbatim when encoding, and never split: e.g. "</s>" in the input text is encoded as the
+// EOS id. Notice that control symbols are not emitted by Decode, as in SentencePiece.
+func (t *Tokenizer) AddedTokensList() []AddedToken {
+	return slices.Clone(t.addedTokens)
 }
 
 // Compile time assert that sentencepiece.Tokenizer implements tokenizers.Tokenizer interface.
@@ -50,13 +139,13 @@ var _ api.Tokenizer = &Tokenizer{}
 // Encode returns the text encoded into a sequence of ids.
 // It implements sampler.Vocabulary.
 func (t *Tokenizer) Encode(text string) []int {
-	ids, _, _ := t.encodeCore(text, false)
+	ids, _, _ := t.encodeCore(text, false, t.Processor.Encode)
 	return ids
 }
 
 // EncodeWithAnnotations returns the encoded text along with requested annotations.
 func (t *Tokenizer) EncodeWithAnnotations(text string) api.AnnotatedEncoding {
-	ids, spans, specialTokensMask := t.encodeCore(text, t.options.IncludeSpans)
+	ids, spans, specialTokensMask := t.encodeCore(text, t.options.IncludeSpans, t.Processor.Encode)
 
 	res := api.AnnotatedEncoding{
 		IDs:               ids,
@@ -68,8 +157,96 @@ func (t *Tokenizer) EncodeWithAnnotations(text string) api.AnnotatedEncoding {
 	return res
 }
 
-func (t *Tokenizer) encodeCore(text string, includeSpans bool) ([]int, []api.TokenSpan, []int) {
-	tokens := t.Processor.Encode(text)
+// EncodeWithOptions is like Encode, but it adds the BOS token (Info.BeginningOfSentenceID) at the start if addBOS
+// is set, and the EOS token (Info.EndOfSentenceID) at the end if addEOS is set, unless already present,
+// regardless of the configuration and of EncodeOptions.AddSpecialTokens.
+//
+// With EncodeWithAnnotations, the BOS and EOS tokens added from the configuration have empty spans at the start and
+// at the end of the text.
+func (t *Tokenizer) EncodeWithOptions(text string, addBOS, addEOS bool) []int {
+	ids, spans := t.encodeSegments(text, false, t.Processor.Encode)
+	ids, _, _ = t.applyPostProcessor(ids, spans, len(text), addBOS, addEOS)
+	return ids
+}
+
+// EncodeWithScores is like Encode, but also returns the score of each token in the model: for Unigram models it
+// is the log-probability of the piece, for BPE models it is its merge priority (higher is merged first).
+//
+// Control symbols (e.g. "<s>") and user-defined symbols have usually a score of 0.
+func (t *Tokenizer) EncodeWithScores(text string) ([]int, []float32) {
+	ids := t.Encode(text)
+	scores := make([]float32, len(ids))
+	for i, id := range ids {
+		if id >= 0 && id < len(t.scores) {
+			scores[i] = t.scores[id]
+		}
+	}
+	return ids, scores
+}
+
+// encodeCore encodes the text using the given segment encoder (usually Processor.Encode), and adds the special
+// tokens if configured.
+func (t *Tokenizer) encodeCore(text string, includeSpans bool, encode segmentEncoder) ([]int, []api.TokenSpan, []int) {
+	ids, spans := t.encodeSegments(text, includeSpans, encode)
+	if !t.options.AddSpecialTokens {
+		return ids, spans, nil
+	}
+	addBOS := t.config != nil && t.config.AddBosToken
+	addEOS := t.config != nil && t.config.AddEosToken
+	return t.applyPostProcessor(ids, spans, len(text), addBOS, addEOS)
+}
+
+// encodeSegments encodes the text, with its control symbols, without adding BOS or EOS.
+// If includeSpans is set, spans is not nil, even if empty.
+func (t *Tokenizer) encodeSegments(text string, includeSpans bool, encode segmentEncoder) ([]int, []api.TokenSpan) {
+	var ids []int
+	var spans []api.TokenSpan
+	if includeSpans {
+		spans = make([]api.TokenSpan, 0)
+	}
+	segmentStart := 0
+	for pos := 0; pos < len(text); {
+		symbol, found := t.matchControlSymbol(text[pos:])
+		if !found {
+			pos++
+			continue
+		}
+		segmentIDs, segmentSpans := t.encodeSegment(text[segmentStart:pos], segmentStart, includeSpans, encode)
+		ids = append(ids, segmentIDs...)
+		spans = append(spans, segmentSpans...)
+		ids = append(ids, symbol.ID)
+		if includeSpans {
+			spans = append(spans, api.TokenSpan{Start: pos, End: pos + len(symbol.Content)})
+		}
+		pos += len(symbol.Content)
+		segmentStart = pos
+	}
+	segmentIDs, segmentSpans := t.encodeSegment(text[segmentStart:], segmentStart, includeSpans, encode)
+	ids = append(ids, segmentIDs...)
+	spans = append(spans, segmentSpans...)
+	return ids, spans
+}
+
+// matchControlSymbol returns the longest control symbol that text starts with, if any.
+func (t *Tokenizer) matchControlSymbol(text string) (AddedToken, bool) {
+	for _, symbol := range t.controlSymbols {
+		if strings.HasPrefix(text, symbol.Content) {
+			return symbol, true
+		}
+	}
+	return AddedToken{}, false
+}
+
+// segmentEncoder encodes a piece of text without control symbols, e.g. Processor.Encode.
+type segmentEncoder func(text string) []esentencepiece.Token
+
+// encodeSegment encodes a piece of text without control symbols using encode.
+// Spans are shifted by offset, the position of the segment in the original text.
+func (t *Tokenizer) encodeSegment(text string, offset int, includeSpans bool, encode segmentEncoder) ([]int, []api.TokenSpan) {
+	if text == "" {
+		return nil, nil
+	}
+	tokens := encode(text)
 	ids := make([]int, len(tokens))
 	for i, tok := range tokens {
 		ids[i] = tok.ID
@@ -77,72 +254,66 @@ func (t *Tokenizer) encodeCore(text string, includeSpans bool) ([]int, []api.Tok
 
 	var spans []api.TokenSpan
 	if includeSpans {
-		spans = make([]api.TokenSpan, len(tokens))
-
-		// Track position in original text by matching token pieces
-		pos := 0
-		for i, tok := range tokens {
-			piece := tok.Text
-
-			// SentencePiece uses U+2581 (lower one eighth block) as the space replacement
-			// We need to handle this when matching back to original text
-			matchPiece := piece
-			hasLeadingSpace := false
-			if len(matchPiece) > 0 && matchPiece[0] == '\xe2' && len(matchPiece) >= 3 &&
-				matchPiece[1] == '\x96' && matchPiece[2] == '\x81' {
-				// Remove the U+2581 metaspace character for matching
-				matchPiece = matchPiece[3:]
-				hasLeadingSpace = true
-			}
+		spans = t.alignSpans(text, tokens)
+		for i := range spans {
+			spans[i].Start += offset
+			spans[i].End += offset
+		}
+	}
+	return ids, spans
+}
 
-			// Skip any whitespace in the original text before this token
-			if hasLeadingSpace {
-				for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t' || text[pos] == '\n' || text[pos] == '\r') {
-					pos++
-				}
-			}
+// metaspace is the SentencePiece replacement for spaces (U+2581).
+const metaspace = "▁"
 
-			// Find where this piece starts in the original text
-			start := pos
-
-			// Advance position by the length of the actual content
-			if matchPiece == "" {
-				// Empty piece after removing metaspace (token represents just the space)
-				// Check if there was actually a space character
-				if hasLeadingSpace && start > 0 {
-					start = start - 1
-					spans[i] = api.TokenSpan{Start: start, End: pos}
-				} else {
-					spans[i] = api.TokenSpan{Start: pos, End: pos}
-				}
-			} else {
-				// Find the piece in the text starting from current position
-				foundAt := findSubstring(text, matchPiece, pos)
-				if foundAt >= 0 {
-					start = foundAt
-					pos = foundAt + len(matchPiece)
-				} else {
-					// Fallback: advance by piece length
-					pos += len(matchPiece)
-					if pos > len(text) {
-						pos = len(text)
-					}
-				}
-				spans[i] = api.TokenSpan{Start: start, End: pos}
-			}
+// alignSpans returns the spans of the tokens in text, the segment they were encoded from.
+//
+// The Processor's only normalization is to replace spaces by metaspaces, so the tokens' pieces concatenated
+// are the normalized text, except byte-fallback tokens, which stand for one byte each. Hence, the spans are
+// found by advancing strictly monotonically over the normalized text, with the length of each piece, and
+// mapping the normalized positions back to the original text.
+//
+// Leading metaspaces are not included in the span of a token (" hi" spans only "hi"), unless the piece is
+// only made of metaspaces.
+func (t *Tokenizer) alignSpans(text string, tokens []esentencepiece.Token) []api.TokenSpan {
+	// normalizedToOriginal maps positions in the normalized text to positions in text. Positions in the middle
+	// of a metaspace map to the start of its space.
+	normalizedToOriginal := make([]int, 0, len(text)+2*strings.Count(text, " ")+1)
+	for pos := 0; pos < len(text); pos++ {
+		normalizedToOriginal = append(normalizedToOriginal, pos)
+		if text[pos] == ' ' {
+			normalizedToOriginal = append(normalizedToOriginal, pos, pos)
 		}
 	}
+	normalizedToOriginal = append(normalizedToOriginal, len(text))
+	toOriginal := func(normalizedPos int) int {
+		return normalizedToOriginal[min(normalizedPos, len(normalizedToOriginal)-1)]
+	}
 
-	if t.options.AddSpecialTokens {
-		return t.applyPostProcessor(ids, spans)
+	spans := make([]api.TokenSpan, len(tokens))
+	pos := 0 // In the normalized text.
+	for i, tok := range tokens {
+		pieceLen, leadingLen := len(tok.Text), 0
+		if t.isBytePiece(tok.ID) {
+			pieceLen = 1
+		} else if trimmed := strings.TrimLeft(tok.Text, metaspace); trimmed != "" {
+			leadingLen = len(tok.Text) - len(trimmed)
+		}
+		spans[i] = api.TokenSpan{Start: toOriginal(pos + leadingLen), End: toOriginal(pos + pieceLen)}
+		pos += pieceLen
 	}
+	return spans
+}
 
-	return ids, spans, nil
+// isBytePiece returns whether id is a byte-fallback piece (e.g. "<0x41>").
+func (t *Tokenizer) isBytePiece(id int) bool {
+	pieces := t.model.GetPieces()
+	return id >= 0 && id < len(pieces) && pieces[id].GetType() == protos.ModelProto_SentencePiece_BYTE
 }
 
 // With applies options to a tokenizer.
 func (t *Tokenizer) With(options api.EncodeOptions) error {
-	if options.IncludeSpecialTokensMask || options.MaxLen > 0 {
+	if options.IncludeSpecialTokensMask || options.MaxLen > 0 || options.IncludeTokens || options.IncludeAttentionMask {
 		return api.ErrNotImplemented
 	}
 
@@ -156,44 +327,98 @@ func (t *Tokenizer) Normalize(text string) string {
 	return text
 }
 
-// findSubstring finds the first occurrence of substr in s starting from position start.
-// Returns the byte position of the match, or -1 if not found.
-func findSubstring(s, substr string, start int) int {
-	if start >= len(s) {
-		return -1
-	}
-	idx := strings.Index(s[start:], substr)
-	if idx < 0 {
-		return -1
-	}
-	return start + idx
-}
-
 // Decode returns the text from a sequence of ids.
 // It implements sampler.Vocabulary.
 func (t *Tokenizer) Decode(ids []int) string {
 	return t.Processor.Decode(ids)
 }
 
+// DecodeBatch returns the text from many sequences of ids, optionally in parallel (see api.DecodeOptions).
+func (t *Tokenizer) DecodeBatch(batch [][]int, opts api.DecodeOptions) []string {
+	return api.DecodeBatch(t.Decode, batch, opts)
+}
+
 // SpecialTokenID returns the token for the given symbol, or an error if not known.
+//
+// The unknown, pad, BOS and EOS tokens are defined by the model. The mask and classification tokens are taken
+// from the config (e.g. its MaskToken) if set, or otherwise looked up among the control and user-defined
+// symbols of the model by their usual names (e.g. "<mask>" and "<cls>").
 func (t *Tokenizer) SpecialTokenID(token api.SpecialToken) (int, error) {
+	id := -1
 	switch token {
 	case api.TokUnknown:
-		return t.Info.UnknownID, nil
+		id = t.Info.UnknownID
 	case api.TokPad:
-		return t.Info.PadID, nil
+		id = t.Info.PadID
 	case api.TokBeginningOfSentence:
-		return t.Info.BeginningOfSentenceID, nil
+		id = t.Info.BeginningOfSentenceID
 	case api.TokEndOfSentence:
-		return t.Info.EndOfSentenceID, nil
-	default:
+		id = t.Info.EndOfSentenceID
+	case api.TokMask:
+		id = t.addedTokenID(t.configToken(token), "<mask>", "[MASK]")
+	case api.TokClassification:
+		id = t.addedTokenID(t.configToken(token), "<cls>", "[CLS]")
+	}
+	if id < 0 {
 		return 0, errors.Errorf("unknown special token: %s (%d)", token, int(token))
 	}
+	return id, nil
+}
+
+// configToken returns the content of the special token defined in the config, or "" if not defined.
+func (t *Tokenizer) configToken(token api.SpecialToken) string {
+	if t.config == nil {
+		return ""
+	}
+	switch token {
+	case api.TokMask:
+		return t.config.MaskToken
+	case api.TokClassification:
+		return t.config.ClsToken
+	}
+	return ""
+}
+
+// addedTokenID returns the id of the first of the given contents that is a control or user-defined symbol,
+// or -1 if none is.
+func (t *Tokenizer) addedTokenID(contents ...string) int {
+	for _, content := range contents {
+		if content == "" {
+			continue
+		}
+		for _, added := range t.addedTokens {
+			if added.Content == content {
+				return added.ID
+			}
+		}
+	}
+	return -1
+}
+
+// TokenToID returns the id of the piece token, which can be any piece of the model, including the
+// control and user-defined symbols.
+func (t *Tokenizer) TokenToID(token string) (int, bool) {
+	id, found := t.pieceToID[token]
+	return id, found
+}
+
+// IDToToken returns the piece of the given id, as stored in the model (e.g. with "▁" for spaces).
+func (t *Tokenizer) IDToToken(id int) (string, bool) {
+	pieces := t.model.GetPieces()
+	if id < 0 || id >= len(pieces) {
+		return "", false
+	}
+	return pieces[id].GetPiece(), true
 }
 
 // VocabSize returns the total number of tokens in the vocabulary.
 func (t *Tokenizer) VocabSize() int {
-	return 0 // TODO: implement
+	return t.Info.VocabularySize
+}
+
+// MaxTokenID returns the highest token id. SentencePiece ids are contiguous, so it is VocabSize()-1.
+func (t *Tokenizer) MaxTokenID() int {
+	return t.Info.VocabularySize - 1
 }
 
 func (t *Tokenizer) Config() *api.Config {
@@ -1,10 +1,13 @@
 package sentencepiece
 
 import (
+	"math/rand/v2"
 	"testing"
 
 	"github.com/gomlx/go-huggingface/hub"
 	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/gomlx/go-huggingface/tokenizers/sentencepiece/private/protos"
+	"google.golang.org/protobuf/proto"
 )
 
 // TestEncodeWithSpans_MatchesEncode verifies that EncodeWithSpans produces the same IDs as Encode.
@@ -180,3 +183,330 @@ func intSliceEqual(a, b []int) bool {
 	}
 	return true
 }
+
+// buildTestModel returns a minimal BPE SentencePiece model proto with control and user-defined symbols.
+func buildTestModel(t *testing.T) []byte {
+	t.Helper()
+	piece := func(p string, pieceType protos.ModelProto_SentencePiece_Type, score ...float32) *protos.ModelProto_SentencePiece {
+		return &protos.ModelProto_SentencePiece{Piece: proto.String(p), Score: proto.Float32(append(score, 0)[0]), Type: pieceType.Enum()}
+	}
+	model := &protos.ModelProto{
+		Pieces: []*protos.ModelProto_SentencePiece{
+			piece("<unk>", protos.ModelProto_SentencePiece_UNKNOWN),       // 0
+			piece("<s>", protos.ModelProto_SentencePiece_CONTROL),         // 1
+			piece("</s>", protos.ModelProto_SentencePiece_CONTROL),        // 2
+			piece("<mask>", protos.ModelProto_SentencePiece_USER_DEFINED), // 3
+			piece("h", protos.ModelProto_SentencePiece_NORMAL, -4),        // 4
+			piece("i", protos.ModelProto_SentencePiece_NORMAL, -5),        // 5
+			piece("hi", protos.ModelProto_SentencePiece_NORMAL, -1),       // 6
+			piece("▁", protos.ModelProto_SentencePiece_NORMAL, -3),        // 7
+			piece("▁hi", protos.ModelProto_SentencePiece_NORMAL, -2),      // 8
+		},
+		TrainerSpec: &protos.TrainerSpec{ModelType: protos.TrainerSpec_BPE.Enum()},
+		NormalizerSpec: &protos.NormalizerSpec{
+			AddDummyPrefix:         proto.Bool(false),
+			RemoveExtraWhitespaces: proto.Bool(false),
+		},
+	}
+	content, err := proto.Marshal(model)
+	if err != nil {
+		t.Fatalf("failed to marshal test model: %v", err)
+	}
+	return content
+}
+
+func TestAddedTokens(t *testing.T) {
+	tok, err := NewFromContent(nil, buildTestModel(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+
+	want := []AddedToken{
+		{ID: 1, Content: "<s>", Special: true},
+		{ID: 2, Content: "</s>", Special: true},
+		{ID: 3, Content: "<mask>"},
+	}
+	got := tok.AddedTokensList()
+	if len(got) != len(want) {
+		t.Fatalf("AddedTokensList() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("AddedTokensList()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Control and user-defined symbols embedded in the text are encoded as single tokens.
+	text := "<s>hi<mask> hi</s>"
+	ids := tok.Encode(text)
+	wantIDs := []int{1, 6, 3, 8, 2}
+	if !intSliceEqual(ids, wantIDs) {
+		t.Errorf("Encode(%q) = %v, want %v", text, ids, wantIDs)
+	}
+
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	enc := tok.EncodeWithAnnotations(text)
+	if !intSliceEqual(enc.IDs, wantIDs) {
+		t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, enc.IDs, wantIDs)
+	}
+	wantSpans := []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 5}, {Start: 5, End: 11}, {Start: 12, End: 14}, {Start: 14, End: 18}}
+	if len(enc.Spans) != len(wantSpans) {
+		t.Fatalf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, enc.Spans, wantSpans)
+	}
+	for i := range wantSpans {
+		if enc.Spans[i] != wantSpans[i] {
+			t.Errorf("Spans[%d] = %v, want %v", i, enc.Spans[i], wantSpans[i])
+		}
+	}
+
+	// As in SentencePiece, control symbols are not decoded, but user-defined symbols are.
+	if decoded := tok.Decode(ids); decoded != "hi<mask> hi" {
+		t.Errorf("Decode(%v) = %q, want %q", ids, decoded, "hi<mask> hi")
+	}
+}
+
+func TestEncodeWithScores(t *testing.T) {
+	tok, err := NewFromContent(nil, buildTestModel(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	text := "<s>hi hi"
+	ids, scores := tok.EncodeWithScores(text)
+	wantIDs := []int{1, 6, 8}
+	if !intSliceEqual(ids, wantIDs) {
+		t.Errorf("EncodeWithScores(%q) ids = %v, want %v", text, ids, wantIDs)
+	}
+	if !intSliceEqual(ids, tok.Encode(text)) {
+		t.Errorf("EncodeWithScores(%q) ids = %v, want Encode() = %v", text, ids, tok.Encode(text))
+	}
+	wantScores := []float32{0, -1, -2}
+	if len(scores) != len(wantScores) {
+		t.Fatalf("EncodeWithScores(%q) scores = %v, want %v", text, scores, wantScores)
+	}
+	for i := range wantScores {
+		if scores[i] != wantScores[i] {
+			t.Errorf("EncodeWithScores(%q) scores[%d] = %g, want %g", text, i, scores[i], wantScores[i])
+		}
+	}
+}
+
+// buildTestModelWithBOS extends buildTestModel with the "<bos>" (9) and "<eos>" (10) control symbols, which
+// define Info.BeginningOfSentenceID and Info.EndOfSentenceID.
+func buildTestModelWithBOS(t *testing.T) []byte {
+	t.Helper()
+	model := &protos.ModelProto{}
+	if err := proto.Unmarshal(buildTestModel(t), model); err != nil {
+		t.Fatalf("failed to unmarshal test model: %v", err)
+	}
+	for _, p := range []string{"<bos>", "<eos>"} {
+		model.Pieces = append(model.Pieces, &protos.ModelProto_SentencePiece{
+			Piece: proto.String(p), Score: proto.Float32(0), Type: protos.ModelProto_SentencePiece_CONTROL.Enum()})
+	}
+	content, err := proto.Marshal(model)
+	if err != nil {
+		t.Fatalf("failed to marshal test model: %v", err)
+	}
+	return content
+}
+
+func TestEncodeWithOptions(t *testing.T) {
+	tok, err := NewFromContent(nil, buildTestModelWithBOS(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	testCases := []struct {
+		text           string
+		addBOS, addEOS bool
+		want           []int
+	}{
+		{"hi", false, false, []int{6}},
+		{"hi", true, false, []int{9, 6}},
+		{"hi", false, true, []int{6, 10}},
+		{"hi", true, true, []int{9, 6, 10}},
+		{"<bos>hi<eos>", true, true, []int{9, 6, 10}},
+		{"", true, true, []int{9, 10}},
+	}
+	for _, tc := range testCases {
+		got := tok.EncodeWithOptions(tc.text, tc.addBOS, tc.addEOS)
+		if !intSliceEqual(got, tc.want) {
+			t.Errorf("EncodeWithOptions(%q, %v, %v) = %v, want %v", tc.text, tc.addBOS, tc.addEOS, got, tc.want)
+		}
+	}
+	if got := tok.Encode("hi"); !intSliceEqual(got, []int{6}) {
+		t.Errorf("Encode(%q) = %v, want [6]", "hi", got)
+	}
+
+	// BOS and EOS added from the configuration get zero-width spans at the edges of the text.
+	tok, err = NewFromContent(&api.Config{AddBosToken: true, AddEosToken: true}, buildTestModelWithBOS(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{AddSpecialTokens: true, IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+	for _, text := range []string{"hi", ""} {
+		result := tok.EncodeWithAnnotations(text)
+		wantIDs := []int{9, 6, 10}
+		wantSpans := []api.TokenSpan{{Start: 0, End: 0}, {Start: 0, End: 2}, {Start: 2, End: 2}}
+		if text == "" {
+			wantIDs = []int{9, 10}
+			wantSpans = []api.TokenSpan{{Start: 0, End: 0}, {Start: 0, End: 0}}
+		}
+		if !intSliceEqual(result.IDs, wantIDs) {
+			t.Errorf("EncodeWithAnnotations(%q).IDs = %v, want %v", text, result.IDs, wantIDs)
+		}
+		if len(result.Spans) != len(wantSpans) {
+			t.Fatalf("EncodeWithAnnotations(%q).Spans = %v, want %v", text, result.Spans, wantSpans)
+		}
+		for i := range wantSpans {
+			if result.Spans[i] != wantSpans[i] {
+				t.Errorf("EncodeWithAnnotations(%q).Spans[%d] = %v, want %v", text, i, result.Spans[i], wantSpans[i])
+			}
+		}
+	}
+}
+
+func TestEncodeSample(t *testing.T) {
+	tok, err := NewFromContent(nil, buildTestModel(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	text := "hi hi<mask>hi"
+	greedy := tok.Encode(text)
+	if got := tok.EncodeSample(text, 0, rand.New(rand.NewPCG(1, 2))); !intSliceEqual(got, greedy) {
+		t.Errorf("EncodeSample(%q, alpha=0) = %v, want Encode() = %v", text, got, greedy)
+	}
+
+	// Same seed, same samples.
+	const numSamples = 20
+	rng1, rng2 := rand.New(rand.NewPCG(42, 7)), rand.New(rand.NewPCG(42, 7))
+	numDifferent := 0
+	for range numSamples {
+		sample1 := tok.EncodeSample(text, 0.5, rng1)
+		sample2 := tok.EncodeSample(text, 0.5, rng2)
+		if !intSliceEqual(sample1, sample2) {
+			t.Fatalf("EncodeSample(%q) with the same seed returned %v and %v", text, sample1, sample2)
+		}
+		if tok.Decode(sample1) != tok.Decode(greedy) {
+			t.Errorf("Decode(EncodeSample(%q)) = %q, want %q", text, tok.Decode(sample1), tok.Decode(greedy))
+		}
+		if !intSliceEqual(sample1, greedy) {
+			numDifferent++
+		}
+	}
+	if numDifferent == 0 {
+		t.Errorf("EncodeSample(%q, alpha=0.5) always returned the greedy segmentation %v", text, greedy)
+	}
+
+	// With alpha=1 no merges are applied, and the user-defined symbol is kept.
+	if got, want := tok.EncodeSample(text, 1, nil), []int{4, 5, 7, 4, 5, 3, 4, 5}; !intSliceEqual(got, want) {
+		t.Errorf("EncodeSample(%q, alpha=1) = %v, want %v", text, got, want)
+	}
+}
+
+func TestSpecialTokensAndPieces(t *testing.T) {
+	tok, err := NewFromContent(nil, buildTestModelWithBOS(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	testCases := []struct {
+		token api.SpecialToken
+		want  int
+	}{
+		{api.TokUnknown, 0},
+		{api.TokBeginningOfSentence, 9},
+		{api.TokEndOfSentence, 10},
+		{api.TokMask, 3},
+	}
+	for _, tc := range testCases {
+		id, err := tok.SpecialTokenID(tc.token)
+		if err != nil || id != tc.want {
+			t.Errorf("SpecialTokenID(%s) = (%d, %v), want %d", tc.token, id, err, tc.want)
+		}
+	}
+	for _, token := range []api.SpecialToken{api.TokPad, api.TokClassification} {
+		if id, err := tok.SpecialTokenID(token); err == nil {
+			t.Errorf("SpecialTokenID(%s) = %d, want error", token, id)
+		}
+	}
+
+	// The config can define the mask and classification tokens.
+	tok, err = NewFromContent(&api.Config{MaskToken: "<s>", ClsToken: "</s>"}, buildTestModelWithBOS(t))
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if id, err := tok.SpecialTokenID(api.TokMask); err != nil || id != 1 {
+		t.Errorf("SpecialTokenID(TokMask) = (%d, %v), want 1", id, err)
+	}
+	if id, err := tok.SpecialTokenID(api.TokClassification); err != nil || id != 2 {
+		t.Errorf("SpecialTokenID(TokClassification) = (%d, %v), want 2", id, err)
+	}
+
+	for id, piece := range map[int]string{0: "<unk>", 3: "<mask>", 8: "▁hi", 10: "<eos>"} {
+		if got, found := tok.TokenToID(piece); !found || got != id {
+			t.Errorf("TokenToID(%q) = (%d, %v), want %d", piece, got, found, id)
+		}
+		if got, found := tok.IDToToken(id); !found || got != piece {
+			t.Errorf("IDToToken(%d) = (%q, %v), want %q", id, got, found, piece)
+		}
+	}
+	if id, found := tok.TokenToID("xyz"); found {
+		t.Errorf("TokenToID(%q) = %d, want not found", "xyz", id)
+	}
+	if piece, found := tok.IDToToken(11); found {
+		t.Errorf("IDToToken(11) = %q, want not found", piece)
+	}
+}
+
+func TestEncodeWithSpans_RepeatedWords(t *testing.T) {
+	model := &protos.ModelProto{
+		Pieces: []*protos.ModelProto_SentencePiece{
+			{Piece: proto.String("<unk>"), Type: protos.ModelProto_SentencePiece_UNKNOWN.Enum()},
+		},
+		TrainerSpec:    &protos.TrainerSpec{ModelType: protos.TrainerSpec_BPE.Enum()},
+		NormalizerSpec: &protos.NormalizerSpec{AddDummyPrefix: proto.Bool(false), RemoveExtraWhitespaces: proto.Bool(false)},
+	}
+	for i, p := range []string{"t", "h", "e", "▁", "th", "the", "▁the"} {
+		model.Pieces = append(model.Pieces, &protos.ModelProto_SentencePiece{
+			Piece: proto.String(p), Score: proto.Float32(float32(i)), Type: protos.ModelProto_SentencePiece_NORMAL.Enum()})
+	}
+	content, err := proto.Marshal(model)
+	if err != nil {
+		t.Fatalf("failed to marshal test model: %v", err)
+	}
+	tok, err := NewFromContent(nil, content)
+	if err != nil {
+		t.Fatalf("NewFromContent failed: %v", err)
+	}
+	if err := tok.With(api.EncodeOptions{IncludeSpans: true}); err != nil {
+		t.Fatalf("With failed: %v", err)
+	}
+
+	testCases := []struct {
+		text      string
+		wantSpans []api.TokenSpan
+	}{
+		{"the the the", []api.TokenSpan{{Start: 0, End: 3}, {Start: 4, End: 7}, {Start: 8, End: 11}}},
+		{"the  the", []api.TokenSpan{{Start: 0, End: 3}, {Start: 3, End: 4}, {Start: 5, End: 8}}},
+		{"the  the  the", []api.TokenSpan{
+			{Start: 0, End: 3}, {Start: 3, End: 4}, {Start: 5, End: 8}, {Start: 8, End: 9}, {Start: 10, End: 13}}},
+	}
+	for _, tc := range testCases {
+		result := tok.EncodeWithAnnotations(tc.text)
+		if len(result.Spans) != len(tc.wantSpans) {
+			t.Fatalf("EncodeWithAnnotations(%q).Spans = %v, want %v", tc.text, result.Spans, tc.wantSpans)
+		}
+		end := 0
+		for i, span := range result.Spans {
+			if span != tc.wantSpans[i] {
+				t.Errorf("EncodeWithAnnotations(%q).Spans[%d] = %v, want %v", tc.text, i, span, tc.wantSpans[i])
+			}
+			if span.Start < end || span.End < span.Start {
+				t.Errorf("EncodeWithAnnotations(%q).Spans[%d] = %v overlaps or is not monotonic", tc.text, i, span)
+			}
+			end = span.End
+		}
+	}
+}
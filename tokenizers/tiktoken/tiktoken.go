@@ -0,0 +1,389 @@
+// Package tiktoken implements a tokenizers.Tokenizer based on tiktoken BPE rank files ("*.tiktoken"), used by
+// OpenAI models (e.g. "cl100k_base", "o200k_base") and some open models.
+//
+// A rank file has one token per line: its bytes encoded in base64, and its rank, which is also its id. Text is
+// split with a regular expression, and each piece is encoded with byte-level BPE: the adjacent parts with the
+// lowest rank are merged first.
+package tiktoken
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/go-huggingface/hub"
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/gomlx/go-huggingface/tokenizers/private/regexsplit"
+	"github.com/pkg/errors"
+)
+
+// Patterns used to split the text before the BPE encoding.
+const (
+	// Cl100kBasePattern is the pattern of the "cl100k_base" encoding (GPT-3.5 and GPT-4). It is the default.
+	Cl100kBasePattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+	// O200kBasePattern is the pattern of the "o200k_base" encoding (GPT-4o).
+	O200kBasePattern = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+func init() {
+	api.RegisterTokenizer("tiktoken", func(repo *hub.Repo) bool { return findRankFile(repo) != "" }, New)
+}
+
+// New creates a tiktoken tokenizer from the first "*.tiktoken" file of the repo, using Cl100kBasePattern.
+//
+// The special tokens are taken from the "added_tokens_decoder" of the config (parsed from "tokenizer_config.json").
+//
+// It implements the api.TokenizerConstructor function signature, and is registered with api.RegisterTokenizer.
+func New(config *api.Config, repo *hub.Repo) (api.Tokenizer, error) {
+	rankFile := findRankFile(repo)
+	if rankFile == "" {
+		return nil, errors.Errorf("\"*.tiktoken\" file not found in repo")
+	}
+	localFile, err := repo.DownloadFile(rankFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't download %s file", rankFile)
+	}
+	content, err := os.ReadFile(localFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read %q", localFile)
+	}
+	var specialTokens map[string]int
+	if config != nil {
+		specialTokens = make(map[string]int, len(config.AddedTokensDecoder))
+		for id, token := range config.AddedTokensDecoder {
+			specialTokens[token.Content] = id
+		}
+	}
+	return NewFromContent(config, content, specialTokens, "")
+}
+
+// findRankFile returns the name of the first "*.tiktoken" file of the repo, or "" if there is none.
+func findRankFile(repo *hub.Repo) string {
+	for fileName, err := range repo.IterFileNamesMatching("*.tiktoken") {
+		if err != nil {
+			return ""
+		}
+		return fileName
+	}
+	return ""
+}
+
+// NewFromContent creates a tiktoken tokenizer from the contents of a rank file, the special tokens (content to id),
+// and the pattern used to split the text (Cl100kBasePattern if empty).
+//
+// The config is optional: if it sets AddBosToken or AddEosToken, the BosToken and EosToken are added when encoding
+// with AddSpecialTokens.
+func NewFromContent(config *api.Config, content []byte, specialTokens map[string]int, pattern string) (*Tokenizer, error) {
+	ranks, err := parseRanks(content)
+	if err != nil {
+		return nil, err
+	}
+	for b := range 256 {
+		if _, found := ranks[string([]byte{byte(b)})]; !found {
+			return nil, errors.Errorf("tiktoken rank file has no token for the byte 0x%02x", b)
+		}
+	}
+	if pattern == "" {
+		pattern = Cl100kBasePattern
+	}
+	compiled, err := regexsplit.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tokenizer{
+		ranks:         ranks,
+		idToToken:     make(map[int]string, len(ranks)+len(specialTokens)),
+		specialTokens: make(map[string]int, len(specialTokens)),
+		pattern:       compiled,
+		config:        config,
+		options:       api.EncodeOptions{AddSpecialTokens: true},
+	}
+	for token, id := range ranks {
+		t.idToToken[id] = token
+	}
+	for token, id := range specialTokens {
+		if token == "" {
+			continue
+		}
+		t.specialTokens[token] = id
+		t.idToToken[id] = token
+		t.specialsByLength = append(t.specialsByLength, token)
+	}
+	// Longest first, for greedy matching.
+	slices.SortFunc(t.specialsByLength, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	return t, nil
+}
+
+// parseRanks parses the lines "<base64 token> <rank>" of a rank file.
+func parseRanks(content []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		encoded, rankStr, found := strings.Cut(line, " ")
+		if !found {
+			return nil, errors.Errorf("invalid tiktoken rank file line %d: %q", lineNum, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid base64 token in tiktoken rank file line %d", lineNum)
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(rankStr))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rank in tiktoken rank file line %d", lineNum)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read tiktoken rank file")
+	}
+	return ranks, nil
+}
+
+// Tokenizer implements tokenizers.Tokenizer interface based on tiktoken BPE rank files.
+type Tokenizer struct {
+	// ranks maps the bytes of the tokens to their rank, which is also their id.
+	ranks     map[string]int
+	idToToken map[int]string
+
+	// specialTokens are matched verbatim in the input text, and never split. specialsByLength are their contents,
+	// sorted longest-first.
+	specialTokens    map[string]int
+	specialsByLength []string
+
+	pattern *regexsplit.Pattern
+	options api.EncodeOptions
+	config  *api.Config
+}
+
+// Compile time assert that tiktoken.Tokenizer implements tokenizers.Tokenizer interface.
+var _ api.Tokenizer = &Tokenizer{}
+
+// Encode returns the text encoded into a sequence of ids.
+func (t *Tokenizer) Encode(text string) []int {
+	ids, _, _ := t.encodeCore(text, false)
+	return ids
+}
+
+// EncodeWithAnnotations returns the encoded text along with requested annotations.
+//
+// Spans are exact: tokens are byte sequences of the original text. Notice that a token may end (or start) in
+// the middle of a multibyte character.
+func (t *Tokenizer) EncodeWithAnnotations(text string) api.AnnotatedEncoding {
+	ids, spans, specialTokensMask := t.encodeCore(text, t.options.IncludeSpans)
+	res := api.AnnotatedEncoding{IDs: ids}
+	if t.options.IncludeSpans {
+		res.Spans = spans
+	}
+	if t.options.IncludeSpecialTokensMask {
+		res.SpecialTokensMask = specialTokensMask
+	}
+	return res
+}
+
+func (t *Tokenizer) encodeCore(text string, includeSpans bool) ([]int, []api.TokenSpan, []int) {
+	var ids []int
+	var spans []api.TokenSpan
+	var specialTokensMask []int
+	segmentStart := 0
+	for pos := 0; pos < len(text); {
+		special, found := t.matchSpecialToken(text[pos:])
+		if !found {
+			pos++
+			continue
+		}
+		ids, spans = t.encodeSegment(ids, spans, text[segmentStart:pos], segmentStart, includeSpans)
+		specialTokensMask = append(specialTokensMask, make([]int, len(ids)-len(specialTokensMask))...)
+		ids = append(ids, t.specialTokens[special])
+		if includeSpans {
+			spans = append(spans, api.TokenSpan{Start: pos, End: pos + len(special)})
+		}
+		specialTokensMask = append(specialTokensMask, 1)
+		pos += len(special)
+		segmentStart = pos
+	}
+	ids, spans = t.encodeSegment(ids, spans, text[segmentStart:], segmentStart, includeSpans)
+	specialTokensMask = append(specialTokensMask, make([]int, len(ids)-len(specialTokensMask))...)
+
+	if t.options.AddSpecialTokens && t.config != nil {
+		if id, found := t.configTokenID(t.config.BosToken); found && t.config.AddBosToken {
+			ids = append([]int{id}, ids...)
+			if includeSpans {
+				spans = append([]api.TokenSpan{{Start: -1, End: -1}}, spans...)
+			}
+			specialTokensMask = append([]int{1}, specialTokensMask...)
+		}
+		if id, found := t.configTokenID(t.config.EosToken); found && t.config.AddEosToken {
+			ids = append(ids, id)
+			if includeSpans {
+				spans = append(spans, api.TokenSpan{Start: -1, End: -1})
+			}
+			specialTokensMask = append(specialTokensMask, 1)
+		}
+	}
+	return ids, spans, specialTokensMask
+}
+
+// matchSpecialToken returns the longest special token that text starts with, if any.
+func (t *Tokenizer) matchSpecialToken(text string) (string, bool) {
+	for _, special := range t.specialsByLength {
+		if strings.HasPrefix(text, special) {
+			return special, true
+		}
+	}
+	return "", false
+}
+
+// encodeSegment appends the ids (and spans) of a piece of text without special tokens.
+// Spans are shifted by offset, the position of the segment in the original text.
+func (t *Tokenizer) encodeSegment(ids []int, spans []api.TokenSpan, text string, offset int, includeSpans bool) ([]int, []api.TokenSpan) {
+	for _, match := range t.pattern.FindAllIndex(text) {
+		piece := text[match[0]:match[1]]
+		if rank, found := t.ranks[piece]; found {
+			ids = append(ids, rank)
+			if includeSpans {
+				spans = append(spans, api.TokenSpan{Start: offset + match[0], End: offset + match[1]})
+			}
+			continue
+		}
+		boundaries := t.bytePairMerge(piece)
+		for i := range len(boundaries) - 1 {
+			ids = append(ids, t.ranks[piece[boundaries[i]:boundaries[i+1]]])
+			if includeSpans {
+				start := offset + match[0]
+				spans = append(spans, api.TokenSpan{Start: start + boundaries[i], End: start + boundaries[i+1]})
+			}
+		}
+	}
+	return ids, spans
+}
+
+// bytePairMerge splits piece into tokens, starting from its bytes and repeatedly merging the adjacent pair of
+// parts with the lowest rank. It returns the boundaries of the tokens.
+func (t *Tokenizer) bytePairMerge(piece string) []int {
+	boundaries := make([]int, len(piece)+1)
+	for i := range boundaries {
+		boundaries[i] = i
+	}
+	for {
+		minRank, minIdx := math.MaxInt, -1
+		for i := 0; i+2 < len(boundaries); i++ {
+			if rank, found := t.ranks[piece[boundaries[i]:boundaries[i+2]]]; found && rank < minRank {
+				minRank, minIdx = rank, i
+			}
+		}
+		if minIdx < 0 {
+			return boundaries
+		}
+		boundaries = slices.Delete(boundaries, minIdx+1, minIdx+2)
+	}
+}
+
+// configTokenID returns the id of a special token from the config.
+func (t *Tokenizer) configTokenID(token string) (int, bool) {
+	if token == "" {
+		return 0, false
+	}
+	if id, found := t.specialTokens[token]; found {
+		return id, true
+	}
+	id, found := t.ranks[token]
+	return id, found
+}
+
+// With applies options to a tokenizer.
+func (t *Tokenizer) With(options api.EncodeOptions) error {
+	if options.MaxLen > 0 || options.IncludeTokens || options.IncludeAttentionMask {
+		return api.ErrNotImplemented
+	}
+	t.options = options
+	return nil
+}
+
+// Normalize returns its input: tiktoken doesn't normalize the text.
+func (t *Tokenizer) Normalize(text string) string {
+	return text
+}
+
+// Decode returns the text from a sequence of ids. Invalid UTF-8 (e.g. a multibyte character split across the
+// given tokens) is replaced by U+FFFD, see DecodeBytes to get the raw bytes. Unknown ids are ignored.
+func (t *Tokenizer) Decode(ids []int) string {
+	return strings.ToValidUTF8(string(t.DecodeBytes(ids)), "\uFFFD")
+}
+
+// DecodeBytes returns the concatenated bytes of the tokens, which may not be valid UTF-8 if the tokens split a
+// multibyte character.
+func (t *Tokenizer) DecodeBytes(ids []int) []byte {
+	var decoded []byte
+	for _, id := range ids {
+		decoded = append(decoded, t.idToToken[id]...)
+	}
+	return decoded
+}
+
+// DecodeBatch returns the text from many sequences of ids, optionally in parallel (see api.DecodeOptions).
+func (t *Tokenizer) DecodeBatch(batch [][]int, opts api.DecodeOptions) []string {
+	return api.DecodeBatch(t.Decode, batch, opts)
+}
+
+// SpecialTokenID returns the token for the given symbol, or an error if not known.
+//
+// The special tokens are defined by the config (e.g. its EosToken). If it doesn't define the end of sentence
+// token, "<|endoftext|>" is used, if present.
+func (t *Tokenizer) SpecialTokenID(token api.SpecialToken) (int, error) {
+	var content string
+	if t.config != nil {
+		switch token {
+		case api.TokBeginningOfSentence:
+			content = t.config.BosToken
+		case api.TokEndOfSentence:
+			content = t.config.EosToken
+		case api.TokUnknown:
+			content = t.config.UnkToken
+		case api.TokPad:
+			content = t.config.PadToken
+		case api.TokMask:
+			content = t.config.MaskToken
+		case api.TokClassification:
+			content = t.config.ClsToken
+		}
+	}
+	if content == "" && token == api.TokEndOfSentence {
+		content = "<|endoftext|>"
+	}
+	if id, found := t.configTokenID(content); found {
+		return id, nil
+	}
+	return 0, errors.Errorf("unknown special token: %s (%d)", token, int(token))
+}
+
+// VocabSize returns the total number of tokens in the vocabulary, including the special tokens.
+func (t *Tokenizer) VocabSize() int {
+	return len(t.idToToken)
+}
+
+// MaxTokenID returns the highest token id, including the special tokens.
+func (t *Tokenizer) MaxTokenID() int {
+	maxID := -1
+	for id := range t.idToToken {
+		maxID = max(maxID, id)
+	}
+	return maxID
+}
+
+// Config returns the HuggingFace tokenizer configuration, if given.
+func (t *Tokenizer) Config() *api.Config {
+	return t.config
+}
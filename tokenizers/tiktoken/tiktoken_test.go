@@ -0,0 +1,98 @@
+package tiktoken
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/go-huggingface/tokenizers/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRankFile returns a rank file with all the bytes (rank = byte value), followed by the given merged tokens.
+func testRankFile(tokens ...string) []byte {
+	var sb strings.Builder
+	for b := range 256 {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(b)}), b)
+	}
+	for i, token := range tokens {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	return []byte(sb.String())
+}
+
+func newTestTokenizer(t *testing.T, config *api.Config) *Tokenizer {
+	// Ranks: he=256, ll=257, llo=258, hello=259, " w"=260, or=261, ld=262, " wor"=263.
+	content := testRankFile("he", "ll", "llo", "hello", " w", "or", "ld", " wor")
+	tok, err := NewFromContent(config, content, map[string]int{"<|endoftext|>": 264, "<|end|>": 265}, "")
+	require.NoError(t, err)
+	return tok
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tok := newTestTokenizer(t, nil)
+	require.NoError(t, tok.With(api.EncodeOptions{IncludeSpans: true, IncludeSpecialTokensMask: true}))
+
+	text := "hello world<|endoftext|>"
+	got := tok.EncodeWithAnnotations(text)
+	// "hello" is a token, " world" is merged by rank: " w", "or", "ld" and then " wor".
+	assert.Equal(t, []int{259, 263, 262, 264}, got.IDs)
+	assert.Equal(t, []api.TokenSpan{{Start: 0, End: 5}, {Start: 5, End: 9}, {Start: 9, End: 11}, {Start: 11, End: 24}}, got.Spans)
+	assert.Equal(t, []int{0, 0, 0, 1}, got.SpecialTokensMask)
+	assert.Equal(t, got.IDs, tok.Encode(text))
+	assert.Equal(t, text, tok.Decode(got.IDs))
+
+	// The whitespace before a word is kept with it: "\s+(?!\S)" leaves the last space.
+	ids := tok.Encode("a  b")
+	assert.Equal(t, []int{'a', ' ', ' ', 'b'}, ids)
+	assert.Equal(t, "a  b", tok.Decode(ids))
+
+	// Multibyte characters split in bytes, and partial characters are replaced when decoding.
+	ids = tok.Encode("é")
+	assert.Equal(t, []int{0xC3, 0xA9}, ids)
+	assert.Equal(t, "\uFFFD", tok.Decode(ids[:1]))
+	assert.Equal(t, []byte{0xC3}, tok.DecodeBytes(ids[:1]))
+
+	assert.Equal(t, 266, tok.VocabSize())
+	assert.Equal(t, 265, tok.MaxTokenID())
+}
+
+func TestSpecialTokens(t *testing.T) {
+	config := &api.Config{BosToken: "<|end|>", AddBosToken: true}
+	tok := newTestTokenizer(t, config)
+	assert.Equal(t, []int{265, 259}, tok.Encode("hello"))
+	require.NoError(t, tok.With(api.EncodeOptions{}))
+	assert.Equal(t, []int{259}, tok.Encode("hello"))
+
+	id, err := tok.SpecialTokenID(api.TokBeginningOfSentence)
+	require.NoError(t, err)
+	assert.Equal(t, 265, id)
+	id, err = tok.SpecialTokenID(api.TokEndOfSentence)
+	require.NoError(t, err)
+	assert.Equal(t, 264, id)
+	_, err = tok.SpecialTokenID(api.TokPad)
+	require.Error(t, err)
+}
+
+func TestNewFromContent_Errors(t *testing.T) {
+	_, err := NewFromContent(nil, []byte("aGVsbG8= 0\n"), nil, "")
+	require.ErrorContains(t, err, "no token for the byte")
+	_, err = NewFromContent(nil, []byte("aGVsbG8=\n"), nil, "")
+	require.Error(t, err)
+	_, err = NewFromContent(nil, testRankFile(), nil, "(")
+	require.Error(t, err)
+}
+
+func TestPatterns(t *testing.T) {
+	// "World's" is a single piece with O200kBasePattern, but "World" and "'s" with Cl100kBasePattern.
+	content := testRankFile("World's", "World", "'s")
+	o200k, err := NewFromContent(nil, content, nil, O200kBasePattern)
+	require.NoError(t, err)
+	assert.Equal(t, []int{256}, o200k.Encode("World's"))
+
+	cl100k, err := NewFromContent(nil, content, nil, Cl100kBasePattern)
+	require.NoError(t, err)
+	assert.Equal(t, []int{257, 258}, cl100k.Encode("World's"))
+}
@@ -10,8 +10,9 @@ import (
 	"github.com/gomlx/go-huggingface/tokenizers/hftokenizer"
 	"github.com/pkg/errors"
 
-	// Blank import.
+	// Blank imports: register the SentencePiece and tiktoken tokenizers.
 	_ "github.com/gomlx/go-huggingface/tokenizers/sentencepiece"
+	_ "github.com/gomlx/go-huggingface/tokenizers/tiktoken"
 )
 
 // Tokenizer interface allows one convert test to "tokens" (integer ids) and back.
@@ -41,8 +42,8 @@ const (
 
 // New creates a new tokenizer from the given HuggingFace repo (see hub.New).
 //
-// Currently, it only supports "SentencePiece" encoders, and it attempts to download details from
-// the repo files "tokenizer_config.json" and "tokenizer.json".
+// It uses the tokenizer class of the repo configuration (see GetConfig) to select the implementation, and falls
+// back to the repo "tokenizer.json" file.
 //
 // If it fails to load those files, or create a tokenizer, it returns an error.
 func New(repo *hub.Repo) (Tokenizer, error) {
@@ -70,21 +71,33 @@ func New(repo *hub.Repo) (Tokenizer, error) {
 	return tok, err
 }
 
-// GetConfig returns the parsed "tokenizer_config.json" Config object for the repo.
-func GetConfig(repo *hub.Repo) (*api.Config, error) {
+// AutoTokenizer creates a tokenizer for the given HuggingFace repo (see hub.New), choosing the implementation from
+// the files available in the repo, regardless of the tokenizer class (see api.FromRepo):
+//
+//   - "tokenizer.json": uses the HuggingFace tokenizer (see package hftokenizer).
+//   - "tokenizer.model": uses the SentencePiece tokenizer (see package sentencepiece).
+//   - "*.tiktoken": uses the tiktoken tokenizer (see package tiktoken).
+//
+// Other implementations can be added with api.RegisterTokenizer.
+//
+// The configuration from "tokenizer_config.json" and "special_tokens_map.json", if present, is passed to the
+// tokenizer. See GetConfig.
+func AutoTokenizer(repo *hub.Repo) (Tokenizer, error) {
 	err := repo.DownloadInfo(false)
 	if err != nil {
 		return nil, err
 	}
-	localConfigFile, err := repo.DownloadFile("tokenizer_config.json")
-	if err != nil {
-		return nil, err
-	}
-	config, err := api.ParseConfigFile(localConfigFile) // tokenizer_config.json
+	config, err := GetConfig(repo)
 	if err != nil {
 		return nil, err
 	}
-	return config, nil
+	return api.FromRepo(repo, config)
+}
+
+// GetConfig returns the Config object for the repo, parsed from its "tokenizer_config.json" and
+// "special_tokens_map.json" files. See api.LoadConfig.
+func GetConfig(repo *hub.Repo) (*api.Config, error) {
+	return api.LoadConfig(repo)
 }
 
 // Config struct to hold HuggingFace's tokenizer_config.json contents.
@@ -96,7 +109,7 @@ type Config = api.Config
 
 // TokenizerConstructor is used by Tokenizer implementations to provide implementations for different
 // tokenizer classes.
-type TokenizerConstructor func(config *api.Config, repo *hub.Repo) (api.Tokenizer, error)
+type TokenizerConstructor = api.TokenizerConstructor
 
 // RegisterTokenizerClass used by Tokenizer implementations.
 func RegisterTokenizerClass(name string, constructor TokenizerConstructor) {